@@ -2,9 +2,14 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	botgolang "github.com/mail-ru-im/bot-golang"
 
@@ -14,92 +19,583 @@ import (
 const (
 	// internalError is common intrnal error message
 	internalError = "internal error"
+	// commandTimeout is a maximum duration of a command handling.
+	commandTimeout = 30 * time.Second
+	// auditLimit is a number of the latest user's settings changes in /audit response.
+	auditLimit = 10
 )
 
 var (
-	// knownHandlers is a map of known handling functions.
-	knownHandlers = map[string]func(Sender, *Package) error{
-		"/get":   Get,
-		"/set":   Set,
-		"/start": Start,
-		"/stop":  Stop,
-	}
-	// publicErrors is map of internal errors to public users' messages.
-	publicErrors = map[error]string{
-		db.ErrUnknownUser: "not started",
-		db.ErrKnownUser:   "already started",
-		db.ErrSetUser:     "oops, no params, use space separated integers",
+	// errRoleParams is an error when role command was called with failed arguments.
+	errRoleParams = errors.New("role params")
+	// errDebugParams is an error when debug command was called with failed arguments.
+	errDebugParams = errors.New("debug params")
+	// errAuditParams is an error when audit command was called with failed arguments.
+	errAuditParams = errors.New("audit params")
+	// errSimulateParams is an error when simulate command was called with failed arguments.
+	errSimulateParams = errors.New("simulate params")
+	// errMigrateParams is an error when migrate command was called with failed arguments.
+	errMigrateParams = errors.New("migrate params")
+	// errMaintenanceParams is an error when maintenance command was called with failed arguments.
+	errMaintenanceParams = errors.New("maintenance params")
+	// errImportParams is an error when import command was called with failed arguments.
+	errImportParams = errors.New("import params")
+	// errGroupAdmin is an error when group chat's command was called not by the chat's administrator.
+	errGroupAdmin = errors.New("not group chat admin")
+
+	// knownHandlers is a map of known commands.
+	knownHandlers = map[string]command{
+		"/get":         {handler: Get, description: "show your notifications"},
+		"/set":         {handler: Set, description: "set delays in minutes or durations, negative ones are after the start, for example: /set 5 1h30m -30m or only for one event: /set <event> 15"},
+		"/start":       {handler: Start, description: "start notifications"},
+		"/stop":        {handler: Stop, description: "pause notifications keeping your settings"},
+		"/resume":      {handler: Resume, description: "resume paused notifications"},
+		"/events":      {handler: Events, description: "show events and your subscriptions"},
+		"/subscribe":   {handler: Subscribe, description: "subscribe to events by numbers or tags: /subscribe 1 3, /subscribe football or /subscribe all"},
+		"/join":        {handler: Join, description: "subscribe to one more event by its number: /join 2"},
+		"/find":        {handler: Find, description: "show event's card with subscribe button: /find standup or @bot standup"},
+		"/timezone":    {handler: TimeZone, description: "set your time zone, for example: /timezone Europe/Berlin or /timezone event"},
+		"/plain":       {handler: Plain, description: "messages without formatting, emoji and buttons for screen readers: /plain <on|off>"},
+		"/vacation":    {handler: Vacation, description: "skip notifications during dates keeping settings: /vacation 2024-07-01 2024-07-14 or /vacation off"},
+		"/remind":      {handler: Remind, description: "add your personal reminder: /remind Thursday 19:00 weekly Running or /remind 2024-07-01 09:00 Dentist"},
+		"/reminders":   {handler: Reminders, description: "show your personal reminders"},
+		"/forget":      {handler: Forget, description: "remove your personal reminder by its number: /forget 2"},
+		"/cancel":      {handler: Forget, description: "cancel your personal reminder by its number, the same as /forget: /cancel 2"},
+		"/groupevent":  {handler: GroupEvent, description: "group chat admins add events for its members: /groupevent Thursday 19:00 weekly Running"},
+		"/groupevents": {handler: GroupEvents, description: "show group chat's events"},
+		"/groupforget": {handler: GroupForget, description: "group chat admins remove its event by number: /groupforget 2"},
+		"/feedback":    {handler: Feedback, description: "send a message to event's owner by its number: /feedback 2 <text>"},
+		"/role":        {handler: Role, description: "assign user's role: /role <chat_id> <role>", role: db.RoleAdmin},
+		"/debug":       {handler: Debug, description: "chat's debug logging: /debug <chat_id> <on|off>", role: db.RoleAdmin},
+		"/audit":       {handler: Audit, description: "user's latest settings changes: /audit <chat_id>", role: db.RoleAdmin},
+		"/simulate":    {handler: Simulate, description: "user's notifications during an hour: /simulate <chat_id> <2006-01-02T15:04 UTC or RFC3339>", role: db.RoleAdmin},
+		"/migrate":     {handler: Migrate, description: "move user's settings and history to the new chat: /migrate <old_chat_id> <new_chat_id>", role: db.RoleAdmin},
+		"/maintenance": {handler: Maintenance, description: "suspend notifications and users' commands: /maintenance <on|off>", role: db.RoleAdmin},
+		"/disable":     {handler: Disable, description: "disable event's notifications: /disable <event_number> [last date like 2024-07-31]", role: db.RoleEditor},
+		"/enable":      {handler: Enable, description: "enable disabled event's notifications: /enable <event_number>", role: db.RoleEditor},
+		"/import":      {handler: Import, description: "subscribe group chat's members to the event: /import <group_chat_id> <event_number>", role: db.RoleEditor},
+		"/ack":         {handler: Ack, description: "acknowledge event's notification by its button"},
+		"/help":        {handler: Help, description: "show this help"},
 	}
+	// usage is a commands' help generated from knownHandlers.
+	usage string
 )
 
+// command is bot command's metadata.
+type command struct {
+	handler     func(context.Context, Sender, *Package) error // a returned error is the command's reply
+	description string
+	role        db.Role // minimal required role
+}
+
+// permits returns true if the role is enough to run the command.
+func (c command) permits(role db.Role) bool {
+	return role >= c.role
+}
+
+func init() {
+	names := make([]string, 0, len(knownHandlers))
+	for name := range knownHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		c := knownHandlers[name]
+		lines[i] = fmt.Sprintf("%s - %s", name, c.description)
+		if c.role > db.RoleUser {
+			lines[i] += fmt.Sprintf(" (%s)", c.role)
+		}
+	}
+	usage = strings.Join(lines, "\n")
+}
+
 // Package contains parameters from bot.
 type Package struct {
-	ChatID string
-	Text   string
-	params string
+	ChatID   string
+	UserID   string // command's author, it differs from ChatID in group chats
+	MsgID    string
+	Text     string
+	Callback bool // command from a button
+	params   string
+	reply    response
 }
 
 // String is a string representation of Package.
 func (p *Package) String() string {
-	return fmt.Sprintf("[%s] %s", p.ChatID, p.Text)
+	return fmt.Sprintf("[%s] %s", p.ChatID, db.LogContent(p.Text))
+}
+
+// key returns the command identifier, an edited message with new text is a new command.
+func (p *Package) key() string {
+	if p.MsgID == "" {
+		return ""
+	}
+	return p.MsgID + ":" + p.Text
 }
 
 // Sender is interface to send a command response.
 type Sender interface {
-	Send(err error, chatID, text string) error
-	Get(p *Package) (string, error)
-	Set(p *Package) error
-	Start(p *Package) error
-	Stop(p *Package) error
+	Reply(p *Package, text string)
+	ReplyPresets(p *Package, text string)
+	Get(ctx context.Context, p *Package) (string, error)
+	Set(ctx context.Context, p *Package) error
+	Start(ctx context.Context, p *Package) error
+	Stop(ctx context.Context, p *Package) error
+	Resume(ctx context.Context, p *Package) error
+	Events(ctx context.Context, p *Package) (string, error)
+	Subscribe(ctx context.Context, p *Package) error
+	Join(ctx context.Context, p *Package) error
+	Ack(p *Package) error
+	Find(p *Package) (*db.EventCard, error)
+	ReplyCard(p *Package, card *db.EventCard)
+	ReplyConfirm(p *Package, text, command string)
+	SetTimeZone(ctx context.Context, p *Package) error
+	SetPlain(ctx context.Context, p *Package) error
+	SetVacation(ctx context.Context, p *Package) error
+	AddReminder(ctx context.Context, p *Package) error
+	RemoveReminder(ctx context.Context, p *Package) error
+	Reminders(ctx context.Context, p *Package) (string, error)
+	AddGroupEvent(ctx context.Context, p *Package) error
+	RemoveGroupEvent(ctx context.Context, p *Package) error
+	GroupEvents(ctx context.Context, p *Package) (string, error)
+	Feedback(p *Package) error
+	SetRole(ctx context.Context, p *Package) error
+	DebugChat(p *Package) error
+	Audit(p *Package) (string, error)
+	Simulate(p *Package) (string, error)
+	Migrate(ctx context.Context, p *Package) error
+	SetMaintenance(p *Package) error
+	DisableEvent(p *Package) (string, error)
+	EnableEvent(p *Package) (string, error)
+	Import(ctx context.Context, p *Package) (string, string, error)
 	Log(info bool, format string, v ...interface{})
 }
 
 // Settings is a serve settings.
 type Settings struct {
 	*db.Logger
-	Storage *db.Storage
-	Bot     *botgolang.Bot
-	Workers int
+	Storage   *db.Storage
+	Bot       *botgolang.Bot
+	Workers   int
+	DedupTTL  time.Duration // time to remember processed commands
+	Presets   []Preset      // suggested delays after start
+	queue     *replyQueue
+	processed *processedStore
+}
+
+// Reply is a method to implement Sender interface.
+// It sets the command's reply text, it is sent after the handler's end.
+func (st *Settings) Reply(p *Package, text string) {
+	p.reply.set(text, nil)
 }
 
-// Send is a method to implement Sender interface.
-// It sends an error or success reply.
-func (st *Settings) Send(err error, chatID, text string) error {
+// ReplyPresets is a method to implement Sender interface.
+// It sets the command's reply with delays' presets buttons if they are configured.
+func (st *Settings) ReplyPresets(p *Package, text string) {
+	if len(st.Presets) == 0 {
+		p.reply.set(text, nil)
+		return
+	}
+	keyboard := presetsKeyboard(st.Presets)
+	p.reply.set(text, &keyboard)
+}
+
+// send sends the command's reply, errors' replies contain their codes.
+// A not sent reply is queued for retry without its keyboard.
+func (st *Settings) send(p *Package) error {
+	text, err := p.reply.text, p.reply.err
+	if text == "" {
+		text = "OK" // the handler has nothing to say
+	}
 	if err != nil {
-		errMsg, ok := publicErrors[err]
+		code, errMsg, ok := errorCode(err)
 		if ok {
-			text = errMsg
+			st.Info.Printf("chat=%s, code=%s: %v", p.ChatID, code, err)
+			text = code + ": " + errMsg
 		} else {
-			st.Error.Printf("chat=%s, response='%s': %v", chatID, text, err)
-			text = "ERROR: " + text
+			st.Error.Printf("chat=%s, code=%s, response='%s': %v", p.ChatID, code, db.LogContent(text), err)
+			text = "ERROR " + code + ": " + text
 		}
 	}
-	message := st.Bot.NewTextMessage(chatID, text)
-	return message.Send()
+	keyboard := p.reply.keyboard
+	if st.Storage.Plain(p.ChatID) {
+		// buttons are inlined as text
+		text, keyboard = db.PlainText(text, keyboard), nil
+	}
+	message := st.Bot.NewTextMessage(p.ChatID, text)
+	if keyboard != nil {
+		message.AttachInlineKeyboard(*keyboard)
+	}
+	if err = message.Send(); err != nil {
+		st.queue.push(p.ChatID, text)
+		return fmt.Errorf("reply is queued for retry: %w", err)
+	}
+	return nil
 }
 
 // Get is a method to implement Sender interface.
 // It gets storage info by p Package.
-func (st *Settings) Get(p *Package) (string, error) {
-	return st.Storage.Get(p.ChatID)
+func (st *Settings) Get(ctx context.Context, p *Package) (string, error) {
+	return st.Storage.Get(ctx, p.ChatID)
 }
 
 // Set is a method to implement Sender interface.
 // It updates storage info p Package.
-func (st *Settings) Set(p *Package) error {
-	return st.Storage.Set(p.ChatID, p.params)
+func (st *Settings) Set(ctx context.Context, p *Package) error {
+	return st.Storage.Set(ctx, p.ChatID, p.params)
 }
 
 // Start is a method to implement Sender interface.
 // It does storage start call.
-func (st *Settings) Start(p *Package) error {
-	return st.Storage.Start(p.ChatID)
+func (st *Settings) Start(ctx context.Context, p *Package) error {
+	return st.Storage.Start(ctx, p.ChatID)
 }
 
 // Stop is a method to implement Sender interface.
-// It removes info from the storage.
-func (st *Settings) Stop(p *Package) error {
-	return st.Storage.Stop(p.ChatID)
+// It pauses user's notifications.
+func (st *Settings) Stop(ctx context.Context, p *Package) error {
+	return st.Storage.Stop(ctx, p.ChatID)
+}
+
+// Resume is a method to implement Sender interface.
+// It resumes paused user's notifications.
+func (st *Settings) Resume(ctx context.Context, p *Package) error {
+	return st.Storage.Resume(ctx, p.ChatID)
+}
+
+// Events is a method to implement Sender interface.
+// It returns events with marked user's subscriptions.
+func (st *Settings) Events(ctx context.Context, p *Package) (string, error) {
+	return st.Storage.Subscriptions(ctx, p.ChatID)
+}
+
+// Subscribe is a method to implement Sender interface.
+// It updates user's subscriptions by p Package parameters.
+func (st *Settings) Subscribe(ctx context.Context, p *Package) error {
+	return st.Storage.Subscribe(ctx, p.ChatID, p.params)
+}
+
+// Join is a method to implement Sender interface.
+// It adds events from p Package parameters to user's subscriptions.
+func (st *Settings) Join(ctx context.Context, p *Package) error {
+	return st.Storage.Join(ctx, p.ChatID, p.params)
+}
+
+// Ack is a method to implement Sender interface.
+// It acknowledges the latest user's notification of event's variant from p Package parameters.
+func (st *Settings) Ack(p *Package) error {
+	return st.Storage.Ack(p.ChatID, p.params)
+}
+
+// Find is a method to implement Sender interface.
+// It returns a card of the event found by p Package parameters.
+func (st *Settings) Find(p *Package) (*db.EventCard, error) {
+	return st.Storage.FindEvent(p.params)
+}
+
+// ReplyCard is a method to implement Sender interface.
+// It sets the command's reply with event's card and subscribe button.
+func (st *Settings) ReplyCard(p *Package, card *db.EventCard) {
+	keyboard := cardKeyboard(card)
+	p.reply.set(card.Text, &keyboard)
+}
+
+// ReplyConfirm is a method to implement Sender interface.
+// It sets the command's reply with a button which sends the confirmed command.
+func (st *Settings) ReplyConfirm(p *Package, text, command string) {
+	keyboard := confirmKeyboard(command)
+	p.reply.set(text, &keyboard)
+}
+
+// SetTimeZone is a method to implement Sender interface.
+// It sets user's time zone from p Package parameters.
+func (st *Settings) SetTimeZone(ctx context.Context, p *Package) error {
+	return st.Storage.SetTimeZone(ctx, p.ChatID, p.params)
+}
+
+// SetPlain is a method to implement Sender interface.
+// It sets user's plain mode by p Package parameters.
+func (st *Settings) SetPlain(ctx context.Context, p *Package) error {
+	plain, err := db.ParsePlain(p.params)
+	if err != nil {
+		return err
+	}
+	return st.Storage.SetPlain(ctx, p.ChatID, plain)
+}
+
+// SetVacation is a method to implement Sender interface.
+// It sets or cancels user's vacation by p Package parameters.
+func (st *Settings) SetVacation(ctx context.Context, p *Package) error {
+	return st.Storage.SetVacation(ctx, p.ChatID, p.params)
+}
+
+// AddReminder is a method to implement Sender interface.
+// It adds user's personal reminder by p Package parameters.
+func (st *Settings) AddReminder(ctx context.Context, p *Package) error {
+	return st.Storage.AddReminder(ctx, p.ChatID, p.params)
+}
+
+// RemoveReminder is a method to implement Sender interface.
+// It removes user's personal reminder by its number from p Package parameters.
+func (st *Settings) RemoveReminder(ctx context.Context, p *Package) error {
+	return st.Storage.RemoveReminder(ctx, p.ChatID, p.params)
+}
+
+// Reminders is a method to implement Sender interface.
+// It returns a list of user's personal reminders.
+func (st *Settings) Reminders(ctx context.Context, p *Package) (string, error) {
+	return st.Storage.Reminders(ctx, p.ChatID)
+}
+
+// groupAdmin checks that the command's author is the group chat's administrator.
+func (st *Settings) groupAdmin(p *Package) error {
+	admins, err := st.Bot.GetChatAdmins(p.ChatID)
+	if err != nil {
+		return fmt.Errorf("admins of chat=%s: %w", p.ChatID, err)
+	}
+	for _, a := range admins {
+		if (p.UserID != "") && (a.ID == p.UserID) {
+			return nil
+		}
+	}
+	return errGroupAdmin
+}
+
+// chatMembers returns IDs of group chat's members without the bot.
+func (st *Settings) chatMembers(chatID string) ([]string, error) {
+	members, err := st.Bot.GetChatMembers(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("members of chat=%s: %w", chatID, err)
+	}
+	names := make([]string, 0, len(members))
+	for _, m := range members {
+		if (m.ID != "") && ((st.Bot.Info == nil) || (m.ID != st.Bot.Info.ID)) {
+			names = append(names, m.ID)
+		}
+	}
+	return names, nil
+}
+
+// AddGroupEvent is a method to implement Sender interface.
+// It adds group chat's event by p Package parameters and updates the group's members.
+func (st *Settings) AddGroupEvent(ctx context.Context, p *Package) error {
+	if err := st.groupAdmin(p); err != nil {
+		return err
+	}
+	members, err := st.chatMembers(p.ChatID)
+	if err != nil {
+		return err
+	}
+	if err = st.Storage.AddGroupEvent(ctx, p.ChatID, members, p.params); err != nil {
+		return err
+	}
+	st.Info.Printf("group event is added to chat=%s by %s for %d members", p.ChatID, p.UserID, len(members))
+	return nil
+}
+
+// RemoveGroupEvent is a method to implement Sender interface.
+// It removes group chat's event by its number from p Package parameters.
+func (st *Settings) RemoveGroupEvent(ctx context.Context, p *Package) error {
+	if err := st.groupAdmin(p); err != nil {
+		return err
+	}
+	return st.Storage.RemoveGroupEvent(ctx, p.ChatID, p.params)
+}
+
+// GroupEvents is a method to implement Sender interface.
+// It returns a list of group chat's events.
+func (st *Settings) GroupEvents(ctx context.Context, p *Package) (string, error) {
+	return st.Storage.GroupEvents(ctx, p.ChatID)
+}
+
+// Feedback is a method to implement Sender interface.
+// It sends user's feedback from p Package parameters to event's owner.
+func (st *Settings) Feedback(p *Package) error {
+	owner, text, err := st.Storage.Feedback(p.ChatID, p.params)
+	if err != nil {
+		return err
+	}
+	if err = st.Bot.NewTextMessage(owner, text).Send(); err != nil {
+		return fmt.Errorf("feedback to owner=%s: %w", owner, err)
+	}
+	return nil
+}
+
+// SetRole is a method to implement Sender interface.
+// It assigns a role to the user from p Package parameters.
+func (st *Settings) SetRole(ctx context.Context, p *Package) error {
+	values := strings.Fields(p.params)
+	if len(values) != 2 {
+		return errRoleParams
+	}
+	role, err := db.ParseRole(values[1])
+	if err != nil {
+		return err
+	}
+	return st.Storage.SetRole(ctx, values[0], role)
+}
+
+// DebugChat is a method to implement Sender interface.
+// It enables or disables debug logging for the chat from p Package parameters.
+func (st *Settings) DebugChat(p *Package) error {
+	values := strings.Fields(p.params)
+	if len(values) != 2 {
+		return errDebugParams
+	}
+	switch values[1] {
+	case "on":
+		st.SetTrace(values[0], true)
+	case "off":
+		st.SetTrace(values[0], false)
+	default:
+		return errDebugParams
+	}
+	st.Info.Printf("debug logging for chat=%s is %s by %s", values[0], values[1], p.ChatID)
+	return nil
+}
+
+// Audit is a method to implement Sender interface.
+// It returns the latest settings changes of the user from p Package parameters.
+func (st *Settings) Audit(p *Package) (string, error) {
+	values := strings.Fields(p.params)
+	if len(values) != 1 {
+		return "", errAuditParams
+	}
+	records, err := st.Storage.Audit(values[0], auditLimit)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "There are no settings changes", nil
+	}
+	lines := make([]string, len(records))
+	for i, r := range records {
+		lines[i] = fmt.Sprintf("%s %s: %s -> %s", r.Time.Format(time.RFC3339), r.Action, r.Old, r.New)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Simulate is a method to implement Sender interface.
+// It returns notifications which would be sent to the user at the time from p Package parameters.
+func (st *Settings) Simulate(p *Package) (string, error) {
+	const layout = "2006-01-02T15:04"
+	values := strings.Fields(p.params)
+	if len(values) != 2 {
+		return "", errSimulateParams
+	}
+	at, err := time.Parse(time.RFC3339, values[1])
+	if err != nil {
+		if at, err = time.Parse(layout, values[1]); err != nil {
+			return "", errSimulateParams
+		}
+	}
+	items, err := st.Storage.Simulate(values[0], at)
+	if err != nil {
+		if errors.Is(err, db.ErrPaused) {
+			return "Notifications are paused", nil
+		}
+		return "", err
+	}
+	if len(items) == 0 {
+		return fmt.Sprintf("There are no notifications during %v after %s", db.SimulateWindow, at.Format(time.RFC3339)), nil
+	}
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = fmt.Sprintf(
+			"%s %s: delay=%v, start=%s",
+			item.Timestamp.Format(time.RFC3339), item.Event, item.Delay, item.Start.Format(time.RFC3339),
+		)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Migrate is a method to implement Sender interface.
+// It moves the user's settings to the new chat ID from p Package parameters.
+func (st *Settings) Migrate(ctx context.Context, p *Package) error {
+	values := strings.Fields(p.params)
+	if len(values) != 2 {
+		return errMigrateParams
+	}
+	if err := st.Storage.Migrate(ctx, values[0], values[1]); err != nil {
+		return err
+	}
+	st.Info.Printf("user=%s is migrated to chat=%s by %s", values[0], values[1], p.ChatID)
+	return nil
+}
+
+// SetMaintenance is a method to implement Sender interface.
+// It enables or disables maintenance mode from p Package parameters.
+func (st *Settings) SetMaintenance(p *Package) error {
+	values := strings.Fields(p.params)
+	if len(values) != 1 {
+		return errMaintenanceParams
+	}
+	switch values[0] {
+	case "on":
+		st.Storage.SetMaintenance(true)
+	case "off":
+		st.Storage.SetMaintenance(false)
+	default:
+		return errMaintenanceParams
+	}
+	st.Info.Printf("maintenance mode is %s by %s", values[0], p.ChatID)
+	return nil
+}
+
+// DisableEvent is a method to implement Sender interface.
+// It disables event's notifications by p Package parameters and returns the event's title.
+func (st *Settings) DisableEvent(p *Package) (string, error) {
+	title, err := st.Storage.DisableEvent(p.params, time.Now())
+	if err != nil {
+		return "", err
+	}
+	st.Info.Printf("event %q is disabled by %s", title, p.ChatID)
+	return title, nil
+}
+
+// EnableEvent is a method to implement Sender interface.
+// It enables event's notifications by p Package parameters and returns the event's title.
+func (st *Settings) EnableEvent(p *Package) (string, error) {
+	title, err := st.Storage.EnableEvent(p.params)
+	if err != nil {
+		return "", err
+	}
+	st.Info.Printf("event %q is enabled by %s", title, p.ChatID)
+	return title, nil
+}
+
+// Import is a method to implement Sender interface.
+// It reads group chat's members from p Package parameters, without the confirmation
+// it returns the import's description and the confirmed command, otherwise members are subscribed.
+func (st *Settings) Import(ctx context.Context, p *Package) (string, string, error) {
+	const confirm = "confirm"
+	values := strings.Fields(p.params)
+	if (len(values) < 2) || (len(values) > 3) || ((len(values) == 3) && (values[2] != confirm)) {
+		return "", "", errImportParams
+	}
+	number, err := strconv.Atoi(values[1])
+	if err != nil {
+		return "", "", errImportParams
+	}
+	title, err := st.Storage.EventTitle(number)
+	if err != nil {
+		return "", "", err
+	}
+	names, err := st.chatMembers(values[0])
+	if err != nil {
+		return "", "", err
+	}
+	if len(values) == 2 {
+		text := fmt.Sprintf("Subscribe %d members of %s to %q?", len(names), values[0], title)
+		return text, "/import " + strings.Join(append(values, confirm), " "), nil
+	}
+	created, joined, err := st.Storage.Import(ctx, names, values[1])
+	if err != nil {
+		return "", "", err
+	}
+	st.Info.Printf("imported members of chat=%s to event=%q by %s: created=%d, joined=%d", values[0], title, p.ChatID, created, joined)
+	return fmt.Sprintf("%q: %d new users, %d subscribed users", title, created, joined), "", nil
 }
 
 // Log is a method to implement Sender interface.
@@ -114,73 +610,350 @@ func (st *Settings) Log(info bool, format string, v ...interface{}) {
 
 // SendError sends err as a bot response.
 func (st *Settings) SendError(chatID string, err error) error {
-	response := fmt.Sprintf("ERROR: %s", err.Error())
+	code, _, _ := errorCode(err)
+	response := fmt.Sprintf("ERROR %s: %s", code, err.Error())
 	message := st.Bot.NewTextMessage(chatID, response)
 	return message.Send()
 }
 
 // Get is a handler when user gets its notifications.
-func Get(s Sender, p *Package) error {
-	response, err := s.Get(p)
+func Get(ctx context.Context, s Sender, p *Package) error {
+	response, err := s.Get(ctx, p)
 	if err != nil {
 		s.Log(false, "get error: %v", err)
-		return s.Send(err, p.ChatID, internalError)
+		return err
 	}
-	return s.Send(nil, p.ChatID, response)
+	s.Reply(p, response)
+	return nil
 }
 
 // Set is a handler when user sends notifications scheduler.
-func Set(s Sender, p *Package) error {
-	err := s.Set(p)
+func Set(ctx context.Context, s Sender, p *Package) error {
+	if p.Callback && (p.params == "") {
+		// custom preset button
+		s.Reply(p, customHint)
+		return nil
+	}
+	err := s.Set(ctx, p)
 	if err != nil {
 		s.Log(false, "set error: %v", err)
-		return s.Send(err, p.ChatID, internalError)
+		return err
 	}
-	return s.Send(nil, p.ChatID, "OK")
+	s.Reply(p, "OK")
+	return nil
 }
 
 // Start is a handler for new user adding.
-func Start(s Sender, p *Package) error {
-	err := s.Start(p)
+func Start(ctx context.Context, s Sender, p *Package) error {
+	err := s.Start(ctx, p)
 	if err != nil {
 		s.Log(false, "start error: %v", err)
-		return s.Send(err, p.ChatID, internalError)
+		return err
 	}
-	return s.Send(nil, p.ChatID, "started")
+	s.ReplyPresets(p, "started")
+	return nil
 }
 
-// Stop is a handler user removing.
-func Stop(s Sender, p *Package) error {
-	err := s.Stop(p)
+// Stop is a handler for user's notifications pausing.
+func Stop(ctx context.Context, s Sender, p *Package) error {
+	err := s.Stop(ctx, p)
 	if err != nil {
 		s.Log(false, "stop error: %v", err)
-		return s.Send(err, p.ChatID, internalError)
+		return err
+	}
+	s.Reply(p, "stopped, use /resume to continue")
+	return nil
+}
+
+// Resume is a handler for paused user's notifications resuming.
+func Resume(ctx context.Context, s Sender, p *Package) error {
+	err := s.Resume(ctx, p)
+	if err != nil {
+		s.Log(false, "resume error: %v", err)
+		return err
+	}
+	s.Reply(p, "resumed")
+	return nil
+}
+
+// Events is a handler to show events and user's subscriptions.
+func Events(ctx context.Context, s Sender, p *Package) error {
+	response, err := s.Events(ctx, p)
+	if err != nil {
+		s.Log(false, "events error: %v", err)
+		return err
+	}
+	s.Reply(p, response)
+	return nil
+}
+
+// Subscribe is a handler for user's subscriptions changing.
+func Subscribe(ctx context.Context, s Sender, p *Package) error {
+	err := s.Subscribe(ctx, p)
+	if err != nil {
+		s.Log(false, "subscribe error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Join is a handler for one more user's subscription.
+func Join(ctx context.Context, s Sender, p *Package) error {
+	err := s.Join(ctx, p)
+	if err != nil {
+		s.Log(false, "join error: %v", err)
+		return err
+	}
+	s.Reply(p, "subscribed")
+	return nil
+}
+
+// Ack is a handler of notification's acknowledgement button.
+func Ack(_ context.Context, s Sender, p *Package) error {
+	if err := s.Ack(p); err != nil {
+		s.Log(false, "ack error: %v", err)
+		return err
+	}
+	s.Reply(p, "thanks")
+	return nil
+}
+
+// Find is a handler to show event's card, it is also used for bot's mentions.
+func Find(_ context.Context, s Sender, p *Package) error {
+	card, err := s.Find(p)
+	if err != nil {
+		s.Log(false, "find error: %v", err)
+		return err
+	}
+	s.ReplyCard(p, card)
+	return nil
+}
+
+// TimeZone is a handler for user's time zone changing.
+func TimeZone(ctx context.Context, s Sender, p *Package) error {
+	err := s.SetTimeZone(ctx, p)
+	if err != nil {
+		s.Log(false, "time zone error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Plain is a handler for user's plain mode changing.
+func Plain(ctx context.Context, s Sender, p *Package) error {
+	err := s.SetPlain(ctx, p)
+	if err != nil {
+		s.Log(false, "plain mode error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Vacation is a handler for user's vacation setting.
+func Vacation(ctx context.Context, s Sender, p *Package) error {
+	err := s.SetVacation(ctx, p)
+	if err != nil {
+		s.Log(false, "vacation error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Remind is a handler to add user's personal reminder.
+func Remind(ctx context.Context, s Sender, p *Package) error {
+	err := s.AddReminder(ctx, p)
+	if err != nil {
+		s.Log(false, "remind error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Reminders is a handler to show user's personal reminders.
+func Reminders(ctx context.Context, s Sender, p *Package) error {
+	response, err := s.Reminders(ctx, p)
+	if err != nil {
+		s.Log(false, "reminders error: %v", err)
+		return err
+	}
+	s.Reply(p, response)
+	return nil
+}
+
+// Forget is a handler to remove user's personal reminder.
+func Forget(ctx context.Context, s Sender, p *Package) error {
+	err := s.RemoveReminder(ctx, p)
+	if err != nil {
+		s.Log(false, "forget error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// GroupEvent is a handler to add group chat's event.
+func GroupEvent(ctx context.Context, s Sender, p *Package) error {
+	err := s.AddGroupEvent(ctx, p)
+	if err != nil {
+		s.Log(false, "group event error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// GroupEvents is a handler to show group chat's events.
+func GroupEvents(ctx context.Context, s Sender, p *Package) error {
+	response, err := s.GroupEvents(ctx, p)
+	if err != nil {
+		s.Log(false, "group events error: %v", err)
+		return err
 	}
-	return s.Send(nil, p.ChatID, "stopped")
+	s.Reply(p, response)
+	return nil
 }
 
-// filter checks s is valid command value.
-// It returns command and its parameters.
-func filter(s string) (string, string) {
-	s = strings.Trim(s, " ")
-	if !strings.HasPrefix(s, "/") {
-		return "", ""
+// GroupForget is a handler to remove group chat's event.
+func GroupForget(ctx context.Context, s Sender, p *Package) error {
+	err := s.RemoveGroupEvent(ctx, p)
+	if err != nil {
+		s.Log(false, "group forget error: %v", err)
+		return err
 	}
-	values := strings.SplitN(s, " ", 2)
-	switch len(values) {
-	case 2:
-		return values[0], values[1]
-	case 1:
-		return values[0], ""
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Feedback is a handler for user's message to event's owner.
+func Feedback(_ context.Context, s Sender, p *Package) error {
+	err := s.Feedback(p)
+	if err != nil {
+		s.Log(false, "feedback error: %v", err)
+		return err
 	}
-	return "", ""
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Role is a handler for user's role assignment.
+func Role(ctx context.Context, s Sender, p *Package) error {
+	err := s.SetRole(ctx, p)
+	if err != nil {
+		s.Log(false, "role error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Debug is a handler for chat's debug logging switching.
+func Debug(_ context.Context, s Sender, p *Package) error {
+	err := s.DebugChat(p)
+	if err != nil {
+		s.Log(false, "debug error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Audit is a handler to show user's settings changes.
+func Audit(_ context.Context, s Sender, p *Package) error {
+	response, err := s.Audit(p)
+	if err != nil {
+		s.Log(false, "audit error: %v", err)
+		return err
+	}
+	s.Reply(p, response)
+	return nil
+}
+
+// Simulate is a handler to show user's notifications at the time.
+func Simulate(_ context.Context, s Sender, p *Package) error {
+	response, err := s.Simulate(p)
+	if err != nil {
+		s.Log(false, "simulate error: %v", err)
+		return err
+	}
+	s.Reply(p, response)
+	return nil
+}
+
+// Migrate is a handler to move user's settings to the new chat ID.
+func Migrate(ctx context.Context, s Sender, p *Package) error {
+	err := s.Migrate(ctx, p)
+	if err != nil {
+		s.Log(false, "migrate error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Maintenance is a handler for maintenance mode switching.
+func Maintenance(_ context.Context, s Sender, p *Package) error {
+	err := s.SetMaintenance(p)
+	if err != nil {
+		s.Log(false, "maintenance error: %v", err)
+		return err
+	}
+	s.Reply(p, "OK")
+	return nil
+}
+
+// Disable is a handler to disable event's notifications.
+func Disable(_ context.Context, s Sender, p *Package) error {
+	title, err := s.DisableEvent(p)
+	if err != nil {
+		s.Log(false, "disable error: %v", err)
+		return err
+	}
+	s.Reply(p, fmt.Sprintf("Event %q is disabled", title))
+	return nil
+}
+
+// Enable is a handler to enable event's notifications.
+func Enable(_ context.Context, s Sender, p *Package) error {
+	title, err := s.EnableEvent(p)
+	if err != nil {
+		s.Log(false, "enable error: %v", err)
+		return err
+	}
+	s.Reply(p, fmt.Sprintf("Event %q is enabled", title))
+	return nil
+}
+
+// Import is a handler to subscribe group chat's members to the event after the confirmation.
+func Import(ctx context.Context, s Sender, p *Package) error {
+	response, command, err := s.Import(ctx, p)
+	if err != nil {
+		s.Log(false, "import error: %v", err)
+		return err
+	}
+	if command != "" {
+		s.ReplyConfirm(p, response, command)
+		return nil
+	}
+	s.Reply(p, response)
+	return nil
+}
+
+// Help is a handler to show known commands.
+func Help(_ context.Context, s Sender, p *Package) error {
+	s.Reply(p, usage)
+	return nil
 }
 
 // handle validates input string command and runs the handler.
-func handle(st *Settings, p Package) error {
-	c, v := filter(p.Text)
+func handle(ctx context.Context, st *Settings, p Package) error {
+	c, v := db.ParseCommand(p.Text)
 	if c == "" {
-		st.Info.Printf("not command [%s]: %s", p.ChatID, p.Text)
+		st.Info.Printf("not command [%s]: %s", p.ChatID, db.LogContent(p.Text))
 		return nil
 	}
 	f, ok := knownHandlers[c]
@@ -188,21 +961,70 @@ func handle(st *Settings, p Package) error {
 		st.Info.Printf(" unknown command [%s]: %s", p.ChatID, c)
 		return nil
 	}
+	role := st.Storage.Role(p.ChatID)
+	if !f.permits(role) {
+		st.Info.Printf("permission denied [%s] role=%v: %s", p.ChatID, role, c)
+		p.reply.fail(db.ErrPermission)
+		return st.send(&p)
+	}
+	if (role < db.RoleAdmin) && st.Storage.Maintenance() {
+		// administrators' commands are handled to finish the maintenance
+		st.Info.Printf("maintenance mode [%s]: %s", p.ChatID, c)
+		p.reply.fail(db.ErrMaintenance)
+		return st.send(&p)
+	}
+	if key := p.key(); !st.processed.claim(key) {
+		st.Info.Printf("already processed command [%s]: %s", p.ChatID, key)
+		return nil
+	}
 	p.params = v
-	return f(st, &p)
+	st.Trace(p.ChatID, "command %s params=%q callback=%v", c, v, p.Callback)
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	err := f.handler(ctx, st, &p)
+	st.Trace(p.ChatID, "command %s is handled: %v", c, err)
+	if err != nil {
+		p.reply.fail(err)
+	}
+	// the only reply of the command, even if the handler failed after setting another one
+	return st.send(&p)
 }
 
 // Serve runs command handling workers.
 // To initiate stop of handlers a closing of "commands" should be used.
-// A returned waitGroup can be used to wait of handlers graceful stopping.
-func Serve(st Settings, commands <-chan Package) *sync.WaitGroup {
-	var wg sync.WaitGroup
+// A returned waitGroup can be used to wait of handlers graceful stopping,
+// not sent replies are passed to the storage after it, so they can be saved by its closing.
+// The ctx cancellation interrupts long-running storage operations.
+func Serve(ctx context.Context, st Settings, commands <-chan Package) *sync.WaitGroup {
+	var (
+		wg      sync.WaitGroup
+		done    sync.WaitGroup // workers' stopping and replies' keeping
+		stopped = make(chan struct{})
+	)
+	st.queue = newReplyQueue(st.Storage.TakeReplies())
+	st.processed = newProcessedStore(st.DedupTTL)
+	done.Add(1)
+	go func() {
+		ticker := time.NewTicker(retryPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				st.Storage.KeepReplies(st.queue.drain())
+				done.Done()
+				return
+			case <-ticker.C:
+				st.queue.retry(st.Bot, st.Error.Printf)
+				st.processed.cleanup()
+			}
+		}
+	}()
 	wg.Add(st.Workers)
 	for i := 0; i < st.Workers; i++ {
 		go func(j int) {
 			for p := range commands {
 				st.Info.Printf("cmd worker=%d got p=%s", j, p.String())
-				if err := handle(&st, p); err != nil {
+				if err := handle(ctx, &st, p); err != nil {
 					st.Error.Printf("failed handler command '%s', worker=%d: %v", p.String(), j, err)
 				} else {
 					st.Debug.Printf("worker=%d done", j)
@@ -211,5 +1033,9 @@ func Serve(st Settings, commands <-chan Package) *sync.WaitGroup {
 			wg.Done()
 		}(i)
 	}
-	return &wg
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	return &done
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/z0rr0/mtbot/db"
+)
+
+func TestCommandPermits(t *testing.T) {
+	cases := []struct {
+		name     string
+		role     db.Role
+		expected bool
+	}{
+		{name: "/get", role: db.RoleUser, expected: true},
+		{name: "/disable", role: db.RoleUser, expected: false},
+		{name: "/disable", role: db.RoleEditor, expected: true},
+		{name: "/enable", role: db.RoleEditor, expected: true},
+		{name: "/import", role: db.RoleEditor, expected: true},
+		{name: "/role", role: db.RoleEditor, expected: false},
+		{name: "/migrate", role: db.RoleEditor, expected: false},
+		{name: "/role", role: db.RoleAdmin, expected: true},
+		{name: "/disable", role: db.RoleAdmin, expected: true},
+	}
+	for i, c := range cases {
+		f, ok := knownHandlers[c.name]
+		if !ok {
+			t.Fatalf("case [%d]: unknown command %s", i, c.name)
+		}
+		if p := f.permits(c.role); p != c.expected {
+			t.Errorf("case [%d]: command %s for role=%v: %v != %v", i, c.name, c.role, p, c.expected)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// processedStore keeps identifiers of processed commands during ttl period,
+// so redelivered bot events don't repeat commands' side effects.
+type processedStore struct {
+	sync.Mutex
+	ttl  time.Duration
+	keys map[string]time.Time
+}
+
+// newProcessedStore returns new processed commands store.
+func newProcessedStore(ttl time.Duration) *processedStore {
+	return &processedStore{ttl: ttl, keys: make(map[string]time.Time)}
+}
+
+// claim marks the command as processed and returns false if it was already done.
+// Commands without identifier are always processed.
+func (ps *processedStore) claim(key string) bool {
+	if key == "" {
+		return true
+	}
+	now := time.Now()
+	ps.Lock()
+	defer ps.Unlock()
+	if t, ok := ps.keys[key]; ok && now.Sub(t) <= ps.ttl {
+		return false
+	}
+	ps.keys[key] = now
+	return true
+}
+
+// cleanup removes expired identifiers.
+func (ps *processedStore) cleanup() {
+	now := time.Now()
+	ps.Lock()
+	defer ps.Unlock()
+	for key, t := range ps.keys {
+		if now.Sub(t) > ps.ttl {
+			delete(ps.keys, key)
+		}
+	}
+}
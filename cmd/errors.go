@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/z0rr0/mtbot/db"
+)
+
+// internalCode is a code of not public errors.
+const internalCode = "E000"
+
+// publicErr is a known error with a stable code and a public users' message.
+type publicErr struct {
+	code string
+	err  error
+	msg  string
+}
+
+// publicErrors is a list of known errors, codes should never be changed or reused.
+var publicErrors = []publicErr{
+	{code: "E001", err: db.ErrDelay, msg: "invalid delay, use space separated minutes or durations like 1h30m, negative ones like -30m are after the start"},
+	{code: "E002", err: db.ErrLimit, msg: "limit is exceeded"},
+	{code: "E003", err: db.ErrUnknownUser, msg: "not started"},
+	{code: "E004", err: db.ErrKnownUser, msg: "already started"},
+	{code: "E005", err: db.ErrSetUser, msg: "oops, no params, use space separated minutes or durations like 1h30m, negative ones like -30m are after the start"},
+	{code: "E006", err: db.ErrRole, msg: "unknown role, use user, editor or admin"},
+	{code: "E007", err: db.ErrPermission, msg: "permission denied"},
+	{code: "E008", err: errRoleParams, msg: "use: /role <chat_id> <user|editor|admin>"},
+	{code: "E009", err: errDebugParams, msg: "use: /debug <chat_id> <on|off>"},
+	{code: "E010", err: db.ErrSubscription, msg: "unknown events, use numbers or tags from /events or all"},
+	{code: "E011", err: db.ErrTimeZone, msg: "unknown time zone, use IANA name like Europe/Berlin or event"},
+	{code: "E012", err: db.ErrPaused, msg: "already stopped, use /resume"},
+	{code: "E013", err: db.ErrNotPaused, msg: "not stopped"},
+	{code: "E014", err: errAuditParams, msg: "use: /audit <chat_id>"},
+	{code: "E015", err: db.ErrEvent, msg: "event is not found"},
+	{code: "E016", err: errSimulateParams, msg: "use: /simulate <chat_id> <2006-01-02T15:04>"},
+	{code: "E017", err: errMigrateParams, msg: "use: /migrate <old_chat_id> <new_chat_id>"},
+	{code: "E018", err: db.ErrMaintenance, msg: "maintenance, try later"},
+	{code: "E019", err: errMaintenanceParams, msg: "use: /maintenance <on|off>"},
+	{code: "E020", err: errImportParams, msg: "use: /import <group_chat_id> <event_number>"},
+	{code: "E021", err: db.ErrAck, msg: "already acknowledged"},
+	{code: "E022", err: db.ErrPlain, msg: "use: /plain <on|off>"},
+	{code: "E023", err: db.ErrVacation, msg: "use: /vacation <from> <to> with dates like 2024-07-01 or /vacation off"},
+	{code: "E024", err: db.ErrFeedback, msg: "use: /feedback <event_number> <text>"},
+	{code: "E025", err: db.ErrOwner, msg: "the event has no owner"},
+	{code: "E026", err: db.ErrDisable, msg: "use: /disable <event_number> [last date like 2024-07-31] or /enable <event_number>"},
+	{code: "E027", err: db.ErrReminder, msg: "use: /remind <weekday|daily|2024-07-01> <19:00> [once|daily|weekly] <title> or /forget <number> (/cancel <number>)"},
+	{code: "E028", err: db.ErrGroupEvent, msg: "use in a group chat: /groupevent <weekday|daily|2024-07-01> <19:00> [once|daily|weekly] <title> or /groupforget <number>"},
+	{code: "E029", err: errGroupAdmin, msg: "only group chat's administrators can change its events"},
+}
+
+// errorCode returns the code and public message for the err or its wrapped error.
+// The last result is false for internal errors.
+func errorCode(err error) (string, string, bool) {
+	for _, e := range publicErrors {
+		if errors.Is(err, e.err) {
+			return e.code, e.msg, true
+		}
+	}
+	return internalCode, internalError, false
+}
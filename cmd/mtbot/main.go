@@ -0,0 +1,195 @@
+// Package main is MtBot's command line program.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/z0rr0/mtbot"
+	"github.com/z0rr0/mtbot/config"
+	"github.com/z0rr0/mtbot/db"
+)
+
+// Config is default configuration file name.
+const Config = "config.toml"
+
+var (
+	// Version is git version
+	Version = ""
+	// Revision is revision number
+	Revision = ""
+	// BuildDate is build date
+	BuildDate = ""
+	// GoVersion is runtime Go language version
+	GoVersion = runtime.Version()
+)
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "abnormal termination [%v]: %v\n%v", Version, r, string(debug.Stack()))
+		}
+	}()
+	if (len(os.Args) > 1) && (os.Args[1] == "seed") {
+		seed(os.Args[2:])
+		return
+	}
+	if (len(os.Args) > 1) && (os.Args[1] == "ctl") {
+		ctl(os.Args[2:])
+		return
+	}
+	version := flag.Bool("version", false, "show version")
+	cfg := flag.String("config", Config, "configuration file")
+	restore := flag.String("restore", "", "replace users by ones from the backup file before the start")
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%v: %v %v %v %v\n", mtbot.Name, Version, Revision, GoVersion, BuildDate)
+		flag.PrintDefaults()
+		return
+	}
+	c, err := config.New(*cfg)
+	if err != nil {
+		panic(err)
+	}
+	for i, e := range c.Events {
+		c.Debug.Printf("e [%d] = %v", i, e)
+	}
+	if *restore != "" {
+		n, err := db.Restore(context.Background(), *restore, c.M.Database, c.A)
+		if err != nil {
+			panic(err)
+		}
+		c.Info.Printf("restored %d users from %s", n, *restore)
+	}
+
+	c.Debug.Println("build new engine")
+	engine, err := mtbot.New(c)
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go signals(ctx, cancel, c, engine)
+
+	if err = engine.Run(ctx); err != nil {
+		c.Error.Printf("failed stop: %v", err)
+	}
+}
+
+// signals stops the engine by interrupt signals and runs configured actions of control ones.
+func signals(ctx context.Context, cancel context.CancelFunc, c *config.Config, engine *mtbot.Engine) {
+	var (
+		sigint  = make(chan os.Signal, 1)
+		control = make(chan os.Signal, 1)
+		actions = controlSignals(c)
+	)
+	defer func() {
+		signal.Stop(sigint)
+		signal.Stop(control)
+		cancel()
+	}()
+	signal.Notify(sigint, os.Interrupt, os.Signal(syscall.SIGTERM), os.Signal(syscall.SIGQUIT))
+	for sig, action := range actions {
+		if action == config.SignalNone {
+			signal.Ignore(sig)
+		} else {
+			signal.Notify(control, sig)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigint:
+			c.Info.Printf("taken signal %v", sig)
+			return
+		case sig := <-control:
+			action := actions[sig]
+			c.Info.Printf("taken signal %v, action=%s", sig, action)
+			if err := runAction(ctx, c, engine, action); err != nil {
+				c.Error.Printf("failed %s by signal %v: %v", action, sig, err)
+			}
+		}
+	}
+}
+
+// runAction runs the control signal's action.
+func runAction(ctx context.Context, c *config.Config, engine *mtbot.Engine, action string) error {
+	switch action {
+	case config.SignalReload:
+		return engine.Reload(ctx)
+	case config.SignalReopen:
+		return c.OpenFile("")
+	case config.SignalDump:
+		return engine.Dump(c.S.DumpFile)
+	}
+	return nil
+}
+
+// seed is "seed" subcommand, it generates a storage with synthetic users.
+func seed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	cfg := fs.String("config", Config, "configuration file")
+	n := fs.Int("n", 1, "number of users")
+	output := fs.String("output", "", "users storage file (default is config database)")
+	_ = fs.Parse(args) // ExitOnError
+
+	c, err := config.Read(*cfg)
+	if err != nil {
+		panic(err)
+	}
+	if *output == "" {
+		*output = c.M.Database
+	}
+	if err = db.Seed(*output, *n, c.L); err != nil {
+		panic(err)
+	}
+	c.Info.Printf("seeded %d users to %s", *n, *output)
+}
+
+// ctl is "ctl" subcommand, it sends a command to the running bot's control socket and prints the result.
+func ctl(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "usage: %s ctl [-config file] [-socket path] %s|%s|%s [limit]|%s|%s <chat_id> <from> <to>\n",
+			os.Args[0], mtbot.ControlStatus, mtbot.ControlUsers, mtbot.ControlQueue, mtbot.ControlReload, mtbot.ControlReport)
+		fs.PrintDefaults()
+	}
+	cfg := fs.String("config", Config, "configuration file")
+	socket := fs.String("socket", "", "control socket (default is config control_socket)")
+	_ = fs.Parse(args) // ExitOnError
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *socket == "" {
+		c, err := config.Read(*cfg)
+		if err != nil {
+			panic(err)
+		}
+		*socket = c.M.ControlSocket
+	}
+	if *socket == "" {
+		_, _ = fmt.Fprintln(os.Stderr, "control socket is not configured")
+		os.Exit(1)
+	}
+	result, err := mtbot.Control(*socket, fs.Arg(0), fs.Args()[1:]...)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var out bytes.Buffer
+	if err = json.Indent(&out, result, "", "  "); err != nil {
+		panic(err)
+	}
+	fmt.Println(out.String())
+}
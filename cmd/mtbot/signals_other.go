@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/z0rr0/mtbot/config"
+)
+
+// controlSignals returns configured actions of control signals, other platforms have no user-defined ones.
+func controlSignals(c *config.Config) map[os.Signal]string {
+	return map[os.Signal]string{syscall.SIGHUP: c.S.HUP}
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/z0rr0/mtbot/config"
+)
+
+// controlSignals returns configured actions of control signals.
+func controlSignals(c *config.Config) map[os.Signal]string {
+	return map[os.Signal]string{
+		syscall.SIGHUP:  c.S.HUP,
+		syscall.SIGUSR1: c.S.USR1,
+		syscall.SIGUSR2: c.S.USR2,
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+
+	"github.com/z0rr0/mtbot/db"
+)
+
+const (
+	// customPreset is a label of the button with custom delays' hint.
+	customPreset = "Custom"
+	// customHint is a reply to custom preset button.
+	customHint = "send /set with space separated delays in minutes or durations, for example: /set 5 1h30m"
+	// subscribeButton is a label of event card's button.
+	subscribeButton = "Subscribe"
+	// confirmButton is a label of the button which repeats a command with its confirmation.
+	confirmButton = "Confirm"
+)
+
+// Preset is a suggested delays' option, it is shown as a button after /start command.
+type Preset struct {
+	Label  string `toml:"label"`
+	Delays string `toml:"delays"`
+}
+
+// cardKeyboard returns inline keyboard with event's subscribe button, its callback data is /join command.
+func cardKeyboard(card *db.EventCard) botgolang.Keyboard {
+	keyboard := botgolang.NewKeyboard()
+	keyboard.AddRow(botgolang.NewCallbackButton(subscribeButton, fmt.Sprintf("/join %d", card.Number)))
+	return keyboard
+}
+
+// confirmKeyboard returns inline keyboard with a confirmation button, its callback data is the command.
+func confirmKeyboard(command string) botgolang.Keyboard {
+	keyboard := botgolang.NewKeyboard()
+	keyboard.AddRow(botgolang.NewCallbackButton(confirmButton, command))
+	return keyboard
+}
+
+// presetsKeyboard returns inline keyboard with presets' buttons, one per row.
+// Buttons' callback data are /set commands.
+func presetsKeyboard(presets []Preset) botgolang.Keyboard {
+	keyboard := botgolang.NewKeyboard()
+	for _, p := range presets {
+		keyboard.AddRow(botgolang.NewCallbackButton(p.Label, "/set "+p.Delays))
+	}
+	keyboard.AddRow(botgolang.NewCallbackButton(customPreset, "/set"))
+	return keyboard
+}
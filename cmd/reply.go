@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+
+	"github.com/z0rr0/mtbot/db"
+)
+
+const (
+	// retryPeriod is a period of failed replies sending.
+	retryPeriod = 10 * time.Second
+	// maxRetries is a maximum number of attempts to send a reply.
+	maxRetries = 30
+)
+
+// reply is a not sent command response.
+type reply struct {
+	chatID   string
+	text     string
+	attempts int
+}
+
+// replyQueue keeps failed replies for retries.
+type replyQueue struct {
+	sync.Mutex
+	replies []reply
+}
+
+// newReplyQueue returns a queue with not sent replies of the previous run.
+func newReplyQueue(replies []db.QueuedReply) *replyQueue {
+	q := &replyQueue{replies: make([]reply, len(replies))}
+	for i, r := range replies {
+		q.replies[i] = reply{chatID: r.ChatID, text: r.Text, attempts: r.Attempts}
+	}
+	return q
+}
+
+// drain returns and forgets all queued replies.
+func (q *replyQueue) drain() []db.QueuedReply {
+	q.Lock()
+	defer q.Unlock()
+
+	result := make([]db.QueuedReply, len(q.replies))
+	for i, r := range q.replies {
+		result[i] = db.QueuedReply{ChatID: r.chatID, Text: r.text, Attempts: r.attempts}
+	}
+	q.replies = nil
+	return result
+}
+
+// push adds failed reply to the queue.
+func (q *replyQueue) push(chatID, text string) {
+	q.Lock()
+	q.replies = append(q.replies, reply{chatID: chatID, text: text})
+	q.Unlock()
+}
+
+// retry tries to send all queued replies, failed ones stay in the queue until maxRetries attempts.
+func (q *replyQueue) retry(b *botgolang.Bot, l func(format string, v ...interface{})) {
+	q.Lock()
+	replies := q.replies
+	q.replies = nil
+	q.Unlock()
+
+	failed := make([]reply, 0)
+	for _, r := range replies {
+		if err := b.NewTextMessage(r.chatID, r.text).Send(); err != nil {
+			if r.attempts++; r.attempts < maxRetries {
+				failed = append(failed, r)
+			} else {
+				l("drop reply chat=%s after %d attempts: %v", r.chatID, r.attempts, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		q.Lock()
+		q.replies = append(q.replies, failed...)
+		q.Unlock()
+	}
+}
+
+// response is a command's reply builder, handlers fill it and it is sent only once after the handler's end.
+type response struct {
+	text     string
+	err      error
+	keyboard *botgolang.Keyboard
+}
+
+// set replaces the reply text and keyboard if there is no error yet.
+func (r *response) set(text string, keyboard *botgolang.Keyboard) {
+	if r.err == nil {
+		r.text, r.keyboard = text, keyboard
+	}
+}
+
+// fail replaces any reply by the error one, the first error is kept.
+func (r *response) fail(err error) {
+	if r.err == nil {
+		r.text, r.err, r.keyboard = internalError, err, nil
+	}
+}
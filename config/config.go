@@ -10,6 +10,7 @@ import (
 	"github.com/BurntSushi/toml"
 	botgolang "github.com/mail-ru-im/bot-golang"
 
+	"github.com/z0rr0/mtbot/cmd"
 	"github.com/z0rr0/mtbot/db"
 )
 
@@ -19,7 +20,106 @@ type Main struct {
 	BotToken string `toml:"bot_token"`
 	Database string `toml:"database"`
 	Period   int    `toml:"period"`
-	Debug     bool   `toml:"debug"`
+	ErrorLog int    `toml:"error_log"`
+	DedupTTL int    `toml:"dedup_ttl"`
+	// MaxSkew is maximum allowed clock skew with the bot API server (seconds), 0 disables checks.
+	MaxSkew    int `toml:"max_skew"`
+	SkewPeriod int `toml:"skew_period"`
+	// SmearWindow spreads sending of the same occurrence's notifications (seconds), 0 disables it.
+	SmearWindow int `toml:"smear_window"`
+	// Jitter is a maximum random delay of every notification (seconds), 0 disables it.
+	Jitter int `toml:"jitter"`
+	// EventsURL is an optional JSON, TOML or iCalendar events source, they are polled every EventsPeriod seconds.
+	EventsURL    string `toml:"events_url"`
+	EventsPeriod int    `toml:"events_period"`
+	// Calendar is an optional iCalendar file of events added to the configured ones.
+	Calendar string `toml:"calendar"`
+	// EventsDir is an optional directory of *.toml files with additional events, for example, one file per team.
+	EventsDir string `toml:"events_dir"`
+	// GoogleCalendar is an optional Google Calendar ID polled every EventsPeriod seconds instead of EventsURL,
+	// it is read by the service account of GoogleCredentials key file.
+	GoogleCalendar    string `toml:"google_calendar"`
+	GoogleCredentials string `toml:"google_credentials"`
+	// CalDAVURL is an optional CalDAV calendar collection polled every EventsPeriod seconds,
+	// CalDAVUser and CalDAVPassword are its basic authentication credentials.
+	CalDAVURL      string `toml:"caldav_url"`
+	CalDAVUser     string `toml:"caldav_user"`
+	CalDAVPassword string `toml:"caldav_password"`
+	// EventChanges enables messages to subscribers about changed and cancelled remote events.
+	EventChanges bool `toml:"event_changes"`
+	// WatchUsers enables merging of the users CSV file or Redis external changes.
+	WatchUsers bool `toml:"watch_users"`
+	// Timer enables waiting for the nearest notification instead of the checks every Period.
+	Timer bool `toml:"timer"`
+	// Metrics is an optional address of HTTP server with Prometheus metrics.
+	Metrics string `toml:"metrics"`
+	// ProbePeriod is a period of users' reachability checks (days), 0 disables them.
+	ProbePeriod int `toml:"probe_period"`
+	// QueuesFile is an optional JSON file of not sent replies and deferred notifications kept between runs.
+	QueuesFile string `toml:"queues_file"`
+	// ControlSocket is an optional unix socket of operators' commands by "mtbot ctl".
+	ControlSocket string `toml:"control_socket"`
+	// LogFile is an optional logs file instead of standard outputs.
+	LogFile string `toml:"log_file"`
+	Debug   bool   `toml:"debug"`
+	// RedactChats is a mode of chat IDs' redaction in logs: "mask", "hash" or empty to keep them.
+	RedactChats string `toml:"redact_chats"`
+	// RedactContents is the most verbose logs level with message contents: "none", "error", "info" or "debug".
+	RedactContents string `toml:"redact_contents"`
+	// Holidays are dates "MM-DD" or "YYYY-MM-DD" without occurrences of all events.
+	Holidays []string `toml:"holidays"`
+}
+
+// secretEnv is an environment variable of users' data encryption secret, it overrides the configuration value.
+const secretEnv = "MTBOT_SECRET"
+
+const (
+	// defaultErrorLog is a summary period of suppressed send errors (seconds) if main.error_log is not set.
+	defaultErrorLog = 3600
+	// defaultDedupTTL is a time to remember processed commands (seconds) if main.dedup_ttl is not set.
+	defaultDedupTTL = 600
+)
+
+// Signals' actions.
+const (
+	SignalNone   = "none"   // the signal is ignored
+	SignalReload = "reload" // reload users
+	SignalReopen = "reopen" // reopen logs file
+	SignalDump   = "dump"   // dump scheduler state to DumpFile
+)
+
+// Signals is a mapping of control signals to their actions.
+type Signals struct {
+	HUP      string `toml:"hup"`
+	USR1     string `toml:"usr1"`
+	USR2     string `toml:"usr2"`
+	DumpFile string `toml:"dump_file"`
+}
+
+// init sets default actions of not configured signals and checks known ones.
+func (s *Signals) init() error {
+	defaults := []struct {
+		name   string
+		action *string
+		value  string
+	}{
+		{"hup", &s.HUP, SignalReload},
+		{"usr1", &s.USR1, SignalReopen},
+		{"usr2", &s.USR2, SignalDump},
+	}
+	for _, d := range defaults {
+		switch *d.action {
+		case "":
+			*d.action = d.value
+		case SignalNone, SignalReload, SignalReopen, SignalDump:
+		default:
+			return fmt.Errorf("unknown action of signals.%s=%q", d.name, *d.action)
+		}
+	}
+	if s.DumpFile == "" {
+		s.DumpFile = filepath.Join(os.TempDir(), "mtbot-state.json")
+	}
+	return nil
 }
 
 // Workers is a struct of workers settings.
@@ -31,17 +131,38 @@ type Workers struct {
 // Config is common configuration struct.
 type Config struct {
 	*db.Logger
-	M Main      `toml:"main"`
-	L db.Limits `toml:"limits"`
-	W Workers   `toml:"workers"`
-	Events  []*db.Event `toml:"events"`
-	B       *botgolang.Bot
-	Timeout time.Duration
-	Period  time.Duration
+	M        Main         `toml:"main"`
+	L        db.Limits    `toml:"limits"`
+	W        Workers      `toml:"workers"`
+	S        Signals      `toml:"signals"`
+	A        db.Access    `toml:"access"`
+	K        db.Backups   `toml:"backup"`
+	Events   []*db.Event  `toml:"events"`
+	Presets  []cmd.Preset `toml:"presets"`
+	B        *botgolang.Bot
+	Timeout  time.Duration
+	Period   time.Duration
+	ErrorLog time.Duration
+	// FileName is the configuration file's full path.
+	FileName string
 }
 
-// New returns new configuration.
+// New returns new configuration with initialized bot.
 func New(fileName string) (*Config, error) {
+	c, err := Read(fileName)
+	if err != nil {
+		return nil, err
+	}
+	bot, err := botgolang.NewBot(c.M.BotToken, botgolang.BotDebug(c.M.Debug), botgolang.BotApiURL(c.M.BotURL))
+	if err != nil {
+		return nil, fmt.Errorf("can not init bot: %w", err)
+	}
+	c.B = bot
+	return c, nil
+}
+
+// decode parses configuration file with events of its iCalendar file, the result is not validated.
+func decode(fileName string) (*Config, error) {
 	fullPath, err := filepath.Abs(strings.Trim(fileName, " "))
 	if err != nil {
 		return nil, fmt.Errorf("config file: %w", err)
@@ -54,30 +175,113 @@ func New(fileName string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("config read: %w", err)
 	}
-	c := &Config{}
+	c := &Config{FileName: fullPath}
 	if err = toml.Unmarshal(data, c); err != nil {
 		return nil, fmt.Errorf("config parsing: %w", err)
 	}
-	if err = c.isValid(); err != nil {
+	if err = c.loadCalendar(); err != nil {
+		return nil, fmt.Errorf("config calendar: %w", err)
+	}
+	if err = c.loadEventsDir(); err != nil {
+		return nil, fmt.Errorf("config events_dir: %w", err)
+	}
+	return c, nil
+}
+
+// ReadEvents reads and initializes events of configuration file with its common holidays,
+// other settings are ignored.
+func ReadEvents(fileName string) ([]*db.Event, error) {
+	c, err := decode(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.initEvents(); err != nil {
 		return nil, fmt.Errorf("config validation: %w", err)
 	}
-	c.Period = time.Duration(c.M.Period) * time.Second
+	return c.Events, nil
+}
 
-	bot, err := botgolang.NewBot(c.M.BotToken, botgolang.BotDebug(c.M.Debug), botgolang.BotApiURL(c.M.BotURL))
+// Read reads and validates configuration file without the bot initialization.
+func Read(fileName string) (*Config, error) {
+	c, err := decode(fileName)
 	if err != nil {
-		return nil, fmt.Errorf("can not init bot: %w", err)
+		return nil, err
 	}
-	c.B = bot
+	if secret := os.Getenv(secretEnv); secret != "" {
+		c.A.Secret = secret
+	}
+	if err = c.isValid(); err != nil {
+		return nil, fmt.Errorf("config validation: %w", err)
+	}
+	c.Period = time.Duration(c.M.Period) * time.Second
+	c.ErrorLog = time.Duration(c.M.ErrorLog) * time.Second
 	c.Logger = db.NewLogger(c.M.Debug)
+	if err = c.Logger.SetRedaction(c.M.BotToken, c.M.RedactChats, c.M.RedactContents); err != nil {
+		return nil, fmt.Errorf("config logs: %w", err)
+	}
+	if err = c.Logger.OpenFile(c.M.LogFile); err != nil {
+		return nil, fmt.Errorf("config logs: %w", err)
+	}
 	return c, nil
 }
 
+// loadCalendar adds events of iCalendar file.
+func (c *Config) loadCalendar() error {
+	if c.M.Calendar == "" {
+		return nil
+	}
+	f, err := os.Open(c.M.Calendar)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	events, err := db.ParseICalendar(f)
+	if err != nil {
+		return err
+	}
+	c.Events = append(c.Events, events...)
+	return nil
+}
+
+// loadEventsDir adds events of TOML files in events directory, the files are read in names' order.
+// Every file has the same "events" array of tables as the configuration file.
+func (c *Config) loadEventsDir() error {
+	if c.M.EventsDir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(c.M.EventsDir, "*.toml"))
+	if err != nil {
+		return err
+	}
+	// the result is sorted
+	for _, name := range files {
+		var doc struct {
+			Events []*db.Event `toml:"events"`
+		}
+		if _, err = toml.DecodeFile(name, &doc); err != nil {
+			return fmt.Errorf("file %s: %w", name, err)
+		}
+		c.Events = append(c.Events, doc.Events...)
+	}
+	return nil
+}
+
 func (c *Config) initEvents() error {
+	titles := make(map[string]bool, len(c.Events))
 	for i := range c.Events {
+		if title := c.Events[i].Title; titles[title] {
+			return fmt.Errorf("event [%d]: duplicate title %q", i, title)
+		}
+		titles[c.Events[i].Title] = true
 		err := c.Events[i].Init()
 		if err != nil {
 			return fmt.Errorf("event [%d]: %w", i, err)
 		}
+		if err = c.Events[i].AddHolidays(c.M.Holidays); err != nil {
+			return fmt.Errorf("main.holidays: %w", err)
+		}
 	}
 	return nil
 }
@@ -87,9 +291,57 @@ func (c *Config) isValid() error {
 	err := c.initEvents()
 	err = isGreaterOrEqualThan(c.L.Users, 1, "limits.max_users", err)
 	err = isGreaterOrEqualThan(c.L.Delays, 1, "limits.delays", err)
-	err = isGreaterOrEqualThan(c.L.MinDelay, 1, "limits.min_delay", err)
+	err = isGreaterOrEqualThan(c.L.MinDelay, 0, "limits.min_delay", err)
+	err = isGreaterOrEqualThan(c.L.MaxAfter, 0, "limits.max_after", err)
+	err = isGreaterOrEqualThan(c.L.MaxDelay, 1, "limits.max_delay", err)
 	err = isGreaterOrEqualThan(c.L.MaxDelay, c.L.MinDelay, "limits.max_delay", err)
+	err = isGreaterOrEqualThan(c.L.Quota, 0, "limits.daily_quota", err)
+	err = isGreaterOrEqualThan(c.L.Merge, 0, "limits.merge_window", err)
+	err = isGreaterOrEqualThan(c.L.Bounce, 0, "limits.bounce_weeks", err)
+	err = isGreaterOrEqualThan(c.L.Budget, 0, "limits.latency_budget", err)
+	err = isGreaterOrEqualThan(c.L.Reminders, 0, "limits.reminders", err)
+	err = isGreaterOrEqualThan(c.L.ReminderLength, 0, "limits.reminder_length", err)
+	err = isGreaterOrEqualThan(c.L.GroupEvents, 0, "limits.group_events", err)
+	if c.K.Dir != "" {
+		err = isGreaterOrEqualThan(c.K.Period, 1, "backup.period", err)
+		err = isGreaterOrEqualThan(c.K.Keep, 1, "backup.keep", err)
+	}
+	if (err == nil) && (c.L.BounceAction != "") && (c.L.BounceAction != db.BouncePause) && (c.L.BounceAction != db.BounceRemove) {
+		err = fmt.Errorf("unknown limits.bounce_action=%q", c.L.BounceAction)
+	}
 	err = isGreaterOrEqualThan(c.M.Period, 1, "main.period", err)
+	if c.M.ErrorLog == 0 {
+		c.M.ErrorLog = defaultErrorLog
+	}
+	err = isGreaterOrEqualThan(c.M.ErrorLog, 1, "main.error_log", err)
+	if c.M.DedupTTL == 0 {
+		c.M.DedupTTL = defaultDedupTTL
+	}
+	err = isGreaterOrEqualThan(c.M.DedupTTL, 1, "main.dedup_ttl", err)
+	err = isGreaterOrEqualThan(c.M.SmearWindow, 0, "main.smear_window", err)
+	err = isGreaterOrEqualThan(c.M.Jitter, 0, "main.jitter", err)
+	if c.M.MaxSkew > 0 {
+		err = isGreaterOrEqualThan(c.M.SkewPeriod, 1, "main.skew_period", err)
+	}
+	if err == nil {
+		if _, err = db.ParseSecret(c.A.Secret); err != nil {
+			err = fmt.Errorf("access.secret: %w", err)
+		} else if c.A.Secret != "" {
+			err = db.ValidSealed(c.M.Database)
+		}
+	}
+	if err == nil {
+		err = c.validEventsSource()
+	}
+	if err == nil {
+		err = c.validPresets()
+	}
+	if err == nil {
+		err = c.validDefaultDelays()
+	}
+	if err == nil {
+		err = c.S.init()
+	}
 	err = isGreaterOrEqualThan(c.W.User, 1, "workers.user", err)
 	err = isGreaterOrEqualThan(c.W.Notify, 1, "workers.notify", err)
 	if err != nil {
@@ -98,6 +350,55 @@ func (c *Config) isValid() error {
 	return nil
 }
 
+// validEventsSource checks remote events source's settings, only one source can be used.
+func (c *Config) validEventsSource() error {
+	var sources int
+	for _, value := range []string{c.M.EventsURL, c.M.GoogleCalendar, c.M.CalDAVURL} {
+		if value != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return nil
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of main.events_url, main.google_calendar and main.caldav_url can be used")
+	}
+	if (c.M.GoogleCalendar != "") && (c.M.GoogleCredentials == "") {
+		return fmt.Errorf("main.google_credentials is required for main.google_calendar")
+	}
+	return isGreaterOrEqualThan(c.M.EventsPeriod, 1, "main.events_period", nil)
+}
+
+// validDefaultDelays checks new users' delays are allowed by limits.
+func (c *Config) validDefaultDelays() error {
+	if n := len(c.L.DefaultDelays); n > c.L.Delays {
+		return fmt.Errorf("limits.default_delays has %d values, maximum is %d", n, c.L.Delays)
+	}
+	for i, d := range c.L.DefaultDelays {
+		if (d < 0) && (-d > c.L.MaxAfter) {
+			return fmt.Errorf("limits.default_delays [%d]=%d is more than limits.max_after=%d", i, d, c.L.MaxAfter)
+		}
+		if (d >= 0) && ((d < c.L.MinDelay) || (d > c.L.MaxDelay)) {
+			return fmt.Errorf("limits.default_delays [%d]=%d is out of range %d-%d", i, d, c.L.MinDelay, c.L.MaxDelay)
+		}
+	}
+	return nil
+}
+
+// validPresets checks delays' presets have labels and values.
+func (c *Config) validPresets() error {
+	for i, p := range c.Presets {
+		if strings.TrimSpace(p.Label) == "" {
+			return fmt.Errorf("presets [%d] empty label", i)
+		}
+		if len(strings.Fields(p.Delays)) == 0 {
+			return fmt.Errorf("presets [%d] empty delays", i)
+		}
+	}
+	return nil
+}
+
 // isGreaterOrEqualThan returns error if err is already error or x is less than y.
 func isGreaterOrEqualThan(x, y int, name string, err error) error {
 	if err != nil {
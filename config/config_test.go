@@ -0,0 +1,39 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/mtbot/db"
+)
+
+func TestIsValidDelays(t *testing.T) {
+	cases := []struct {
+		name   string
+		limits db.Limits
+		err    string
+	}{
+		{name: "valid", limits: db.Limits{Users: 1, Delays: 1, MinDelay: 1, MaxDelay: 60, DefaultDelays: []int{5}}},
+		{name: "post-event", limits: db.Limits{Users: 1, Delays: 1, MaxDelay: 60, MaxAfter: 30, DefaultDelays: []int{-30}}},
+		{name: "no max_delay", limits: db.Limits{Users: 1, Delays: 1, MinDelay: 1}, err: "limits.max_delay"},
+		{name: "no max_delay defaults", limits: db.Limits{Users: 1, Delays: 1, DefaultDelays: []int{5}}, err: "limits.max_delay"},
+		{name: "min after max", limits: db.Limits{Users: 1, Delays: 1, MinDelay: 61, MaxDelay: 60}, err: "limits.max_delay"},
+		{name: "negative min_delay", limits: db.Limits{Users: 1, Delays: 1, MinDelay: -1, MaxDelay: 60}, err: "limits.min_delay"},
+		{name: "late default", limits: db.Limits{Users: 1, Delays: 1, MaxDelay: 60, DefaultDelays: []int{-5}}, err: "limits.max_after"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			cfg := &Config{M: Main{Period: 1}, L: c.limits, W: Workers{User: 1, Notify: 1}}
+			err := cfg.isValid()
+			if c.err == "" {
+				if err != nil {
+					tt.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if (err == nil) || !strings.Contains(err.Error(), c.err) {
+				tt.Errorf("expected %s error, got %v", c.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package mtbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlTimeout is a timeout of control socket's requests.
+const controlTimeout = 30 * time.Second
+
+// Control commands of the running engine.
+const (
+	ControlStatus = "status" // users' counters and scheduler state
+	ControlUsers  = "users"  // active and paused users' settings
+	ControlQueue  = "queue"  // upcoming notifications, an optional argument limits their number
+	ControlReload = "reload" // read users from the configured database and events from the configuration file again
+	ControlReport = "report" // user's notifications discrepancies during the period "<chat_id> <from> <to>"
+)
+
+// ErrControl is an error of control command's handling by the running engine.
+var ErrControl = errors.New("control command failed")
+
+// Status is the running engine's state.
+type Status struct {
+	Started     time.Time `json:"started"`
+	Maintenance bool      `json:"maintenance"`
+	Users       int       `json:"users"`   // active not paused users
+	Paused      int       `json:"paused"`  // users with paused notifications
+	Removed     int       `json:"removed"` // soft deleted users
+	Unreachable int       `json:"unreachable"`
+	Events      int       `json:"events"`
+	Items       int       `json:"items"` // scheduled notifications
+}
+
+// controlResponse is a control socket's response, Error is not empty if the command failed.
+type controlResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Control sends the command with its arguments to the engine's control socket and returns JSON result.
+func Control(socket, command string, args ...string) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("unix", socket, controlTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("control connect: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err = conn.SetDeadline(time.Now().Add(controlTimeout)); err != nil {
+		return nil, fmt.Errorf("control deadline: %w", err)
+	}
+	request := strings.Join(append([]string{command}, args...), " ")
+	if _, err = fmt.Fprintln(conn, request); err != nil {
+		return nil, fmt.Errorf("control request: %w", err)
+	}
+	var response controlResponse
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("control response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrControl, response.Error)
+	}
+	return response.Result, nil
+}
+
+// status returns the engine's state.
+func (e *Engine) status(started time.Time) *Status {
+	s := e.storage
+	stats := s.Stats()
+	return &Status{
+		Started:     started,
+		Maintenance: s.Maintenance(),
+		Users:       stats.Users,
+		Paused:      stats.Paused,
+		Removed:     stats.Removed,
+		Unreachable: stats.Unreachable,
+		Events:      len(stats.Events),
+		Items:       len(s.UpcomingItems(0)),
+	}
+}
+
+// control handles the control command and returns its result.
+func (e *Engine) control(ctx context.Context, started time.Time, request string) (interface{}, error) {
+	fields := strings.Fields(request)
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	command, args := fields[0], fields[1:]
+	switch command {
+	case ControlStatus:
+		return e.status(started), nil
+	case ControlUsers:
+		return e.storage.Users(), nil
+	case ControlQueue:
+		var limit int
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if (err != nil) || (n < 0) {
+				return nil, fmt.Errorf("invalid queue limit %q", args[0])
+			}
+			limit = n
+		}
+		return e.storage.UpcomingItems(limit), nil
+	case ControlReport:
+		if len(args) != 3 {
+			return nil, fmt.Errorf("expected report arguments <chat_id> <from> <to>")
+		}
+		from, err := parseControlTime(args[1])
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseControlTime(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return e.storage.ReconstructSchedule(args[0], from, to)
+	case ControlReload:
+		if err := e.Reload(ctx); err != nil {
+			return nil, err
+		}
+		return e.status(started), nil
+	}
+	return nil, fmt.Errorf("unknown command %q", command)
+}
+
+// parseControlTime parses RFC3339 time or UTC date "2006-01-02".
+func parseControlTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected 2006-01-02 or RFC3339", value)
+	}
+	return t, nil
+}
+
+// handleControl reads one command from the connection and writes its JSON response.
+func (e *Engine) handleControl(ctx context.Context, started time.Time, conn net.Conn) {
+	c := e.cfg
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.Error.Printf("failed close control connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(controlTimeout)); err != nil {
+		c.Error.Printf("failed set control deadline: %v", err)
+		return
+	}
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		c.Error.Printf("failed read control command: %v", err)
+		return
+	}
+	request = strings.TrimSpace(request)
+	c.Info.Printf("control command %q", request)
+
+	var response controlResponse
+	result, err := e.control(ctx, started, request)
+	if err == nil {
+		response.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		c.Error.Printf("failed control command %q: %v", request, err)
+		response = controlResponse{Error: err.Error()}
+	}
+	if err = json.NewEncoder(conn).Encode(response); err != nil {
+		c.Error.Printf("failed write control response: %v", err)
+	}
+}
+
+// serveControl handles commands of the unix socket until ctx is done.
+func (e *Engine) serveControl(ctx context.Context, socket string) error {
+	// a socket file can be left by the previous not graceful stop
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("control socket cleanup: %w", err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("control listen: %w", err)
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("control socket mode: %w", err)
+	}
+	c, started := e.cfg, time.Now()
+	go func() {
+		<-ctx.Done()
+		if err := listener.Close(); err != nil {
+			c.Error.Printf("failed close control socket: %v", err)
+		}
+		c.Info.Println("control socket ctx done")
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					c.Error.Printf("control socket: %v", err)
+				}
+				return
+			}
+			go e.handleControl(ctx, started, conn)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// anonymousPrefix is a prefix of anonymized chat IDs.
+const anonymousPrefix = "anon-"
+
+// anonymizer replaces chat IDs by their HMAC hashes with the local key, nil anonymizer keeps them.
+type anonymizer struct {
+	key []byte
+}
+
+// newAnonymizer returns an anonymizer by the key, it is nil for empty key.
+func newAnonymizer(key string) *anonymizer {
+	if key == "" {
+		return nil
+	}
+	return &anonymizer{key: []byte(key)}
+}
+
+// id returns anonymized chat ID, it is the same for the same key and chat ID.
+func (a *anonymizer) id(chatID string) string {
+	if (a == nil) || (chatID == "") {
+		return chatID
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(chatID))
+	return anonymousPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// users anonymizes users' snapshots.
+func (a *anonymizer) users(users []UserSnapshot) {
+	for i := range users {
+		users[i].Name = a.id(users[i].Name)
+	}
+}
+
+// items anonymizes notifications' snapshots.
+func (a *anonymizer) items(items []ItemSnapshot) {
+	for i := range items {
+		items[i].User = a.id(items[i].User)
+	}
+}
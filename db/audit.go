@@ -0,0 +1,163 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audited actions.
+const (
+	AuditStart  = "start"
+	AuditStop   = "stop"
+	AuditResume = "resume"
+	AuditSet    = "set"
+	// the user's settings were moved from another chat ID
+	AuditMigrate = "migrate"
+	// the user was subscribed with group chat's members
+	AuditImport = "import"
+	// automatic actions after users' consecutive send errors
+	AuditBouncePause  = "bounce_pause"
+	AuditBounceRemove = "bounce_remove"
+)
+
+// AuditRecord is a user's settings change.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`   // chat ID which requested the change, its hash in the anonymized mode
+	Action string    `json:"action"` // one of Audit* constants
+	Old    string    `json:"old"`
+	New    string    `json:"new"`
+}
+
+// auditLog is an append-only JSON lines file of settings changes.
+type auditLog struct {
+	sync.Mutex
+	name string
+	file *os.File
+}
+
+// openAudit opens the audit file for appending, it returns nil if the audit is disabled.
+func openAudit(name string) (*auditLog, error) {
+	if name == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &auditLog{name: name, file: f}, nil
+}
+
+// write appends the record to the file, nil audit log ignores it.
+func (a *auditLog) write(r *AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	if _, err = a.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	return nil
+}
+
+// read returns up to limit the latest user's records, newest first.
+func (a *auditLog) read(userName string, limit int) ([]AuditRecord, error) {
+	result := make([]AuditRecord, 0)
+	if a == nil {
+		return result, nil
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	f, err := os.Open(a.name)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("parse audit record: %w", err)
+		}
+		if r.User == userName {
+			result = append(result, r)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit file: %w", err)
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	if (limit > 0) && (len(result) > limit) {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// close closes the audit file, nil audit log ignores it.
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// auditState returns user's settings description for audit records.
+func (u *user) auditState() string {
+	switch {
+	case u == nil:
+		return "none"
+	case !u.deleted.IsZero():
+		return "deleted"
+	}
+	state := "delays=" + u.stringDelays()
+	if len(u.eventDelays) > 0 {
+		state += " events=" + u.stringEventDelays()
+	}
+	if u.plain {
+		state += " plain"
+	}
+	if u.vacation != nil {
+		state += " vacation=" + u.vacation.value()
+	}
+	if n := len(u.reminders); n > 0 {
+		// reminders' titles are users' contents, so only their number is audited
+		state += fmt.Sprintf(" reminders=%d", n)
+	}
+	if n := len(u.groupEvents); n > 0 {
+		state += fmt.Sprintf(" group_events=%d members=%d", n, len(u.members))
+	}
+	if !u.paused.IsZero() {
+		state = "paused " + state
+	}
+	return state
+}
+
+// audit saves user's settings change. The caller should hold persist lock.
+func (s *Storage) audit(u *user, action, old string) error {
+	r := &AuditRecord{Time: u.updated, User: s.anon.id(u.name), Action: action, Old: old, New: u.auditState()}
+	if err := s.auditLog.write(r); err != nil {
+		return fmt.Errorf("audit user=%s: %w", u.name, err)
+	}
+	return nil
+}
+
+// Audit returns up to limit the latest settings changes of the user, newest first.
+// Zero limit means all records. Records' chat IDs are hashes in the anonymized mode.
+func (s *Storage) Audit(userName string, limit int) ([]AuditRecord, error) {
+	return s.auditLog.read(s.anon.id(userName), limit)
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backend is a persistent storage of users' data.
+// The method save replaces all data and marks it by the current schema version,
+// update persists only changed and removed users.
+type backend interface {
+	version() (int, error)
+	load() ([]*user, error)
+	save(ctx context.Context, users []*user) error
+	update(ctx context.Context, changed []*user, removed []string) error
+	close() error
+}
+
+// pendingBackend is a backend which also keeps notifications that were scheduled but not sent yet.
+type pendingBackend interface {
+	loadPending() ([]pendingMsg, error)
+	addPending(p pendingMsg) error
+	removePending(p pendingMsg) error
+}
+
+// pendingMsg is a persistent info about not sent notification.
+type pendingMsg struct {
+	User  string     `json:"user"`
+	Event string     `json:"event"`
+	Delay delayValue `json:"delay"`
+	Start time.Time  `json:"start"`
+}
+
+// userRecord is a user's record for key-value backends.
+type userRecord struct {
+	Delays        string   `json:"delays"`
+	Role          string   `json:"role,omitempty"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	TimeZone      string   `json:"timezone,omitempty"`
+	EventDelays   string   `json:"event_delays,omitempty"`
+	Paused        int64    `json:"paused,omitempty"`
+	Deleted       int64    `json:"deleted,omitempty"`
+	Plain         bool     `json:"plain,omitempty"`
+	Vacation      string   `json:"vacation,omitempty"`
+	Reminders     []string `json:"reminders,omitempty"`
+	GroupEvents   []string `json:"group_events,omitempty"`
+	Members       []string `json:"members,omitempty"`
+}
+
+// encodeUser returns serialized user's record.
+func encodeUser(u *user) ([]byte, error) {
+	r := userRecord{
+		Delays: u.stringDelays(), Subscriptions: u.subscriptions,
+		TimeZone: u.zoneName(), EventDelays: u.stringEventDelays(), Plain: u.plain,
+		Vacation: u.vacation.value(), Reminders: u.reminderValues(),
+		GroupEvents: u.groupEventValues(), Members: u.members,
+	}
+	if u.role != RoleUser {
+		r.Role = u.role.String()
+	}
+	if !u.paused.IsZero() {
+		r.Paused = u.paused.Unix()
+	}
+	if !u.deleted.IsZero() {
+		r.Deleted = u.deleted.Unix()
+	}
+	return json.Marshal(r)
+}
+
+// decodeUser returns a user by its name and serialized record.
+func decodeUser(name string, value []byte) (*user, error) {
+	var (
+		r    userRecord
+		role = RoleUser
+	)
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, fmt.Errorf("user=%s: %w", name, err)
+	}
+	if r.Role != "" {
+		ur, err := ParseRole(r.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user=%s: %w", name, err)
+		}
+		role = ur
+	}
+	zone, err := parseZone(r.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("user=%s: %w", name, err)
+	}
+	eventDelays, err := parseEventDelays(r.EventDelays)
+	if err != nil {
+		return nil, fmt.Errorf("user=%s: %w", name, err)
+	}
+	vacation, err := parseVacationValue(r.Vacation)
+	if err != nil {
+		return nil, fmt.Errorf("user=%s: %w", name, err)
+	}
+	reminders, err := parseReminders(r.Reminders)
+	if err != nil {
+		return nil, fmt.Errorf("user=%s: %w", name, err)
+	}
+	groupEvents, err := parseReminders(r.GroupEvents)
+	if err != nil {
+		return nil, fmt.Errorf("user=%s group events: %w", name, err)
+	}
+	// ignore delay limit during reading data
+	name, delays, err := parseUserRow([]string{name, r.Delays}, 0, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	u := &user{
+		name: name, delays: delays, role: role, subscriptions: sortedTitles(r.Subscriptions),
+		zone: zone, eventDelays: eventDelays, plain: r.Plain, vacation: vacation, reminders: reminders,
+		groupEvents: ownGroupEvents(name, groupEvents), members: sortedTitles(r.Members),
+	}
+	if r.Paused > 0 {
+		u.paused = time.Unix(r.Paused, 0)
+	}
+	if r.Deleted > 0 {
+		u.deleted = time.Unix(r.Deleted, 0)
+	}
+	return u, nil
+}
+
+// sortedTitles returns sorted unique not empty events' titles or nil if there are no such ones.
+func sortedTitles(titles []string) []string {
+	known := make(map[string]bool, len(titles))
+	result := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if (title != "") && !known[title] {
+			known[title] = true
+			result = append(result, title)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	sort.Strings(result)
+	return result
+}
+
+// key returns unique pending notification identifier.
+func (p *pendingMsg) key() string {
+	return fmt.Sprintf("%s/%s/%s/%d", p.User, p.Event, formatDelay(p.Delay.Duration()), p.Start.Unix())
+}
+
+// openBackend returns a storage backend by source URL scheme or file extension,
+// MemorySource returns a not persistent backend.
+func openBackend(source string) (backend, error) {
+	source = strings.Trim(source, " ")
+	if source == MemorySource {
+		return newMemoryBackend(), nil
+	}
+	if strings.HasPrefix(source, "redis://") || strings.HasPrefix(source, "rediss://") {
+		return newRedisBackend(source)
+	}
+	fullPath, err := filepath.Abs(source)
+	if err != nil {
+		return nil, fmt.Errorf("users log file: %w", err)
+	}
+	switch filepath.Ext(fullPath) {
+	case ".db", ".bolt":
+		return newBoltBackend(fullPath)
+	case ".json":
+		return newJSONBackend(fullPath)
+	default:
+		return newCSVBackend(fullPath)
+	}
+}
+
+// sortedUsers returns users from all groups sorted by name.
+func sortedUsers(groups ...map[string]*user) []*user {
+	result := make([]*user, 0, len(groups[0]))
+	for _, users := range groups {
+		for _, u := range users {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
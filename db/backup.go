@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupPrefix is a prefix of users' backup files.
+	backupPrefix = "users-"
+	// backupLayout is a layout of backup files' time, their names are sorted by it.
+	backupLayout = "20060102T150405"
+)
+
+// Backups is a settings of periodic users' backups, they are CSV files for any storage backend.
+type Backups struct {
+	Dir    string `toml:"dir"`    // backups directory, empty - disabled
+	Period int    `toml:"period"` // hours between backups
+	Keep   int    `toml:"keep"`   // number of the latest backups to keep
+}
+
+// Backup writes all users including soft deleted ones to a new timestamped CSV file in dir
+// and returns its name. The file is encrypted if the storage has a secret.
+func (s *Storage) Backup(ctx context.Context, dir string, now time.Time) (string, error) {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	groups := make([]map[string]*user, 0, 2*shardsCount)
+	for _, sh := range s.shards {
+		sh.RLock()
+		groups = append(groups, sh.users, sh.removed)
+		sh.RUnlock()
+	}
+	// users are not modified without persist lock
+	users := sortedUsers(groups...)
+	fileName := filepath.Join(dir, backupPrefix+now.UTC().Format(backupLayout)+".csv")
+	b := &csvBackend{fileName: fileName, aead: s.aead}
+	if err := b.save(ctx, users); err != nil {
+		return "", fmt.Errorf("backup: %w", err)
+	}
+	return fileName, nil
+}
+
+// rotateBackups removes the oldest backup files in dir except keep latest ones.
+// It returns the removed files' names.
+func rotateBackups(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("backups list: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, ".csv") {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= keep {
+		return nil, nil
+	}
+	sort.Strings(names)
+	removed := names[:len(names)-keep]
+	for i, name := range removed {
+		removed[i] = filepath.Join(dir, name)
+		if err = os.Remove(removed[i]); err != nil {
+			return nil, fmt.Errorf("backup remove: %w", err)
+		}
+	}
+	return removed, nil
+}
+
+// RunBackups writes users' backups every period and keeps only the latest ones. It stops when ctx is done.
+func RunBackups(ctx context.Context, s *Storage, b Backups, l *Logger) {
+	run := func() {
+		fileName, err := s.Backup(ctx, b.Dir, time.Now())
+		if err != nil {
+			l.Error.Printf("failed users backup: %v", err)
+			return
+		}
+		l.Info.Printf("users backup: %s", fileName)
+		removed, err := rotateBackups(b.Dir, b.Keep)
+		if err != nil {
+			l.Error.Printf("failed backups rotation: %v", err)
+			return
+		}
+		if len(removed) > 0 {
+			l.Info.Printf("removed old backups: %v", removed)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(b.Period) * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				l.Info.Println("users backups ctx done")
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+// Restore replaces users of usersSource by ones from the CSV backup file, it returns a number of restored users.
+// It should be called before the storage opening, the backup and the source are encrypted by the access secret.
+func Restore(ctx context.Context, backupFile, usersSource string, a Access) (int, error) {
+	aead, err := newCipher(a.Secret)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = os.Stat(backupFile); err != nil {
+		return 0, fmt.Errorf("restore: %w", err)
+	}
+	records, _, err := readRows(backupFile, false, aead)
+	if err != nil {
+		return 0, fmt.Errorf("restore: %w", err)
+	}
+	version, records, err := splitVersion(records)
+	if err != nil {
+		return 0, fmt.Errorf("restore: %w", err)
+	}
+	if version > schemaVersion {
+		return 0, fmt.Errorf("restore: backup version %d is newer than %d", version, schemaVersion)
+	}
+	users := make(map[string]*user, len(records))
+	for _, userItem := range records {
+		u, err := parseCSVRow(userItem)
+		if err != nil {
+			return 0, fmt.Errorf("restore: %w", err)
+		}
+		users[u.name] = u
+	}
+	b, err := openSealedBackend(usersSource, aead)
+	if err != nil {
+		return 0, fmt.Errorf("restore: %w", err)
+	}
+	err = b.save(ctx, sortedUsers(users))
+	if errClose := b.close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return 0, fmt.Errorf("restore: %w", err)
+	}
+	return len(users), nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxBlackoutSkips limits skipped quiet periods during an occurrence search,
+// so yearly periods covering the whole year can't hang it.
+const maxBlackoutSkips = 100
+
+// Blackout is event's quiet period without occurrences, its dates are inclusive.
+// Dates "MM-DD" repeat every year and "12-20" - "01-05" period passes the year's end,
+// "YYYY-MM-DD" dates are a single period.
+type Blackout struct {
+	From   string `toml:"from" json:"from"`
+	To     string `toml:"to" json:"to"`
+	from   int    // date key YYYYMMDD or MMDD for yearly periods
+	to     int
+	yearly bool
+}
+
+// dateKey returns a comparable date key, yearly keys have no year.
+func dateKey(year int, month time.Month, day int) int {
+	return year*10000 + int(month)*100 + day
+}
+
+// parse sets period's date keys.
+func (b *Blackout) parse() error {
+	const (
+		fullLayout  = "2006-01-02"
+		shortLayout = "01-02"
+	)
+	from, errFrom := time.Parse(fullLayout, b.From)
+	to, errTo := time.Parse(fullLayout, b.To)
+	if (errFrom == nil) && (errTo == nil) {
+		b.from, b.to, b.yearly = dateKey(from.Date()), dateKey(to.Date()), false
+		if b.from > b.to {
+			return fmt.Errorf("blackout from=%s is after to=%s", b.From, b.To)
+		}
+		return nil
+	}
+	from, errFrom = time.Parse(shortLayout, b.From)
+	to, errTo = time.Parse(shortLayout, b.To)
+	if (errFrom != nil) || (errTo != nil) {
+		return fmt.Errorf("blackout dates from=%q to=%q, expected both MM-DD or YYYY-MM-DD", b.From, b.To)
+	}
+	b.from, b.to, b.yearly = dateKey(0, from.Month(), from.Day()), dateKey(0, to.Month(), to.Day()), true
+	return nil
+}
+
+// end returns the beginning of the day after the period containing t, t should be in the period.
+func (b *Blackout) end(t time.Time) time.Time {
+	if !b.yearly {
+		return time.Date(b.to/10000, time.Month(b.to/100%100), b.to%100+1, 0, 0, 0, 0, t.Location())
+	}
+	year := t.Year()
+	if dateKey(0, t.Month(), t.Day()) > b.to {
+		// the period passes the year's end
+		year++
+	}
+	return time.Date(year, time.Month(b.to/100), b.to%100+1, 0, 0, 0, 0, t.Location())
+}
+
+// contains returns true if the date of t is in the period.
+func (b *Blackout) contains(t time.Time) bool {
+	year, month, day := t.Date()
+	if !b.yearly {
+		key := dateKey(year, month, day)
+		return (b.from <= key) && (key <= b.to)
+	}
+	key := dateKey(0, month, day)
+	if b.from <= b.to {
+		return (b.from <= key) && (key <= b.to)
+	}
+	return (key >= b.from) || (key <= b.to)
+}
+
+// validateBlackouts parses event's quiet periods and holidays.
+func (e *Event) validateBlackouts() error {
+	for i := range e.Blackouts {
+		if err := e.Blackouts[i].parse(); err != nil {
+			return fmt.Errorf("blackout [%d] of event=%s: %w", i, e.Title, err)
+		}
+	}
+	e.holidays = nil
+	if err := e.AddHolidays(e.Holidays); err != nil {
+		return fmt.Errorf("holidays of event=%s: %w", e.Title, err)
+	}
+	return nil
+}
+
+// AddHolidays adds dates without event's occurrences, "MM-DD" dates repeat every year.
+// It is used for common holidays of all events after their initialization.
+func (e *Event) AddHolidays(dates []string) error {
+	for _, date := range dates {
+		b := Blackout{From: date, To: date}
+		if err := b.parse(); err != nil {
+			return fmt.Errorf("holiday %q: %w", date, err)
+		}
+		e.holidays = append(e.holidays, b)
+	}
+	return nil
+}
+
+// blackout returns the end of event's quiet period or holiday containing the occurrence started at start time.
+// The result is false if the occurrence is not in quiet periods.
+func (e *Event) blackout(start time.Time) (time.Time, bool) {
+	local := start.In(e.zone)
+	for _, periods := range [][]Blackout{e.Blackouts, e.holidays} {
+		for i := range periods {
+			if b := &periods[i]; b.contains(local) {
+				return b.end(local), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// usersBucket is a bucket of users' delays keyed by chat ID.
+	usersBucket = []byte("users")
+	// pendingBucket is a bucket of not sent notifications.
+	pendingBucket = []byte("notifications")
+	// ledgerBucket is a bucket of sent notifications' keys with events' start Unix time.
+	ledgerBucket = []byte("ledger")
+	// metaBucket is a bucket of storage's service values.
+	metaBucket = []byte("meta")
+	// versionKey is a key of users' data version in metaBucket.
+	versionKey = []byte("version")
+)
+
+// boltBackend is a users' storage in BoltDB file.
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// newBoltBackend opens BoltDB file and prepares its buckets.
+func newBoltBackend(fileName string) (*boltBackend, error) {
+	db, err := bolt.Open(fileName, 0640, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("bolt open: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, pendingBucket, ledgerBucket, metaBucket} {
+			if _, e := tx.CreateBucketIfNotExists(name); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("bolt buckets: %w", err)
+	}
+	return &boltBackend{db: db}, nil
+}
+
+// version returns users' data version, it is 0 if it was not saved yet.
+func (b *boltBackend) version() (int, error) {
+	var version int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metaBucket).Get(versionKey)
+		if value == nil {
+			return nil
+		}
+		v, err := strconv.Atoi(string(value))
+		if err != nil {
+			return err
+		}
+		version = v
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("bolt users version: %w", err)
+	}
+	return version, nil
+}
+
+// load reads all users from BoltDB.
+func (b *boltBackend) load() ([]*user, error) {
+	users := make([]*user, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			u, err := decodeUser(string(k), v)
+			if err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt load users: %w", err)
+	}
+	return users, nil
+}
+
+// save replaces all users in BoltDB and saves data version.
+func (b *boltBackend) save(ctx context.Context, users []*user) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("bolt save users: %w", err)
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(usersBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(usersBucket)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			value, err := encodeUser(u)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(u.name), value); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(versionKey, []byte(strconv.Itoa(schemaVersion)))
+	})
+	if err != nil {
+		return fmt.Errorf("bolt save users: %w", err)
+	}
+	return nil
+}
+
+// update puts changed users and deletes removed ones.
+func (b *boltBackend) update(ctx context.Context, changed []*user, removed []string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("bolt update users: %w", err)
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		for _, u := range changed {
+			value, err := encodeUser(u)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(u.name), value); err != nil {
+				return err
+			}
+		}
+		for _, name := range removed {
+			if err := bucket.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("bolt update users: %w", err)
+	}
+	return nil
+}
+
+// close closes BoltDB file.
+func (b *boltBackend) close() error {
+	return b.db.Close()
+}
+
+// loadPending returns all not sent notifications.
+func (b *boltBackend) loadPending() ([]pendingMsg, error) {
+	result := make([]pendingMsg, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(k, v []byte) error {
+			var p pendingMsg
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("pending key=%s: %w", k, err)
+			}
+			result = append(result, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt load pending: %w", err)
+	}
+	return result, nil
+}
+
+// addPending saves not sent notification.
+func (b *boltBackend) addPending(p pendingMsg) error {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("bolt pending marshal: %w", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).Put([]byte(p.key()), value)
+	})
+}
+
+// removePending deletes sent notification.
+func (b *boltBackend) removePending(p pendingMsg) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).Delete([]byte(p.key()))
+	})
+}
+
+// loadLedger returns sent notifications' keys of events started since the time.
+func (b *boltBackend) loadLedger(since time.Time) (map[string]time.Time, error) {
+	result := make(map[string]time.Time)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(k, v []byte) error {
+			ts, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("ledger key=%s: %w", k, err)
+			}
+			if start := time.Unix(ts, 0); !start.Before(since) {
+				result[string(k)] = start
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt load ledger: %w", err)
+	}
+	return result, nil
+}
+
+// addLedger saves sent notification's key.
+func (b *boltBackend) addLedger(p pendingMsg) error {
+	value := strconv.FormatInt(p.Start.Unix(), 10)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).Put([]byte(p.key()), []byte(value))
+	})
+}
+
+// purgeLedger deletes sent notifications' keys of events started before the time.
+func (b *boltBackend) purgeLedger(before time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ledgerBucket)
+		keys := make([][]byte, 0)
+		err := bucket.ForEach(func(k, v []byte) error {
+			ts, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil || time.Unix(ts, 0).Before(before) {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err = bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
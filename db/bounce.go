@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Bounced users' actions.
+const (
+	BouncePause  = "pause"
+	BounceRemove = "remove"
+)
+
+// bounces keeps the first time of users' consecutive send errors.
+type bounces struct {
+	sync.Mutex
+	since map[string]time.Time
+}
+
+// newBounces returns new users' send errors tracker.
+func newBounces() *bounces {
+	return &bounces{since: make(map[string]time.Time)}
+}
+
+// add saves the send result of the user and returns the time of its first consecutive error,
+// it is zero after the successful sending.
+func (b *bounces) add(userName string, failed bool, now time.Time) time.Time {
+	b.Lock()
+	defer b.Unlock()
+
+	if !failed {
+		delete(b.since, userName)
+		return time.Time{}
+	}
+	since, ok := b.since[userName]
+	if !ok {
+		since = now
+		b.since[userName] = now
+	}
+	return since
+}
+
+// forget removes the user's send errors.
+func (b *bounces) forget(userName string) {
+	b.Lock()
+	delete(b.since, userName)
+	b.Unlock()
+}
+
+// bounce saves the notification's send result and pauses or removes the user,
+// if its messages have been failing for limits.Bounce weeks. It returns the applied action or empty string.
+func (s *Storage) bounce(ctx context.Context, m *userMsg, sendErr error, now time.Time) (string, error) {
+	if s.limits.Bounce <= 0 {
+		return "", nil
+	}
+	since := s.bounces.add(m.user, sendErr != nil, now)
+	if since.IsZero() || (now.Sub(since) < time.Duration(s.limits.Bounce)*7*24*time.Hour) {
+		return "", nil
+	}
+	s.bounces.forget(m.user)
+	action, audited := BouncePause, AuditBouncePause
+	if s.limits.BounceAction == BounceRemove {
+		action, audited = BounceRemove, AuditBounceRemove
+	}
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	u, ok := s.user(m.user)
+	if !ok || ((action == BouncePause) && !u.paused.IsZero()) {
+		return "", nil
+	}
+	sh := s.shard(u.name)
+	sh.Lock()
+	old := u.auditState()
+	s.unschedItems(sh.userIdx[u.name])
+	u.updated = now
+	if action == BounceRemove {
+		u.deleted = now
+		delete(sh.users, u.name)
+		delete(sh.userIdx, u.name)
+		sh.removed[u.name] = u
+	} else {
+		u.paused = now
+		sh.userIdx[u.name] = make([]*userEvent, 0)
+	}
+	sh.Unlock()
+
+	if (action == BounceRemove) && (len(u.groupEvents) > 0) {
+		s.regroup(u.name, nil)
+	}
+	if err := s.flushUsers(ctx, u.name); err != nil {
+		return "", fmt.Errorf("bounce user=%s: %w", u.name, err)
+	}
+	return action, s.audit(u, audited, old)
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudget is an error when the notification is sent later than its latency budget allows.
+var ErrBudget = errors.New("latency budget breach")
+
+// budget returns the maximum delay of notifications' sending after their scheduled time, 0 - disabled.
+func (s *Storage) budget() time.Duration {
+	return time.Duration(s.limits.Budget) * time.Second
+}
+
+// breach checks the notification's deadline and counts it if the deadline is missed at now time.
+// It returns the lateness after the deadline, deferred by quota notifications have no deadline.
+func (s *Storage) breach(m *userMsg, now time.Time) (time.Duration, bool) {
+	budget := s.budget()
+	if (budget <= 0) || m.due.IsZero() {
+		return 0, false
+	}
+	late := now.Sub(m.due.Add(budget))
+	if late <= 0 {
+		return 0, false
+	}
+	atomic.AddInt64(&s.breaches, 1)
+	return late, true
+}
+
+// escalate sends the notification's latency budget breach to administrators.
+func (s *Storage) escalate(m *userMsg, late time.Duration) error {
+	if m.bot == nil {
+		return nil
+	}
+	text := fmt.Sprintf(
+		"Notification of %q for %s scheduled at %s missed its latency budget %v by %v",
+		m.event, m.user, m.due.Format(time.RFC3339), s.budget(), late.Truncate(time.Second),
+	)
+	var errs []error
+	for _, admin := range s.adminNames() {
+		if err := m.bot.NewTextMessage(admin, text).Send(); err != nil {
+			errs = append(errs, fmt.Errorf("admin=%s: %w", admin, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("escalate %w: %v", ErrBudget, errs)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// calDAVReport is a calendar-query of VEVENT objects which end after the start time.
+	calDAVReport = `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><C:calendar-data/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="%s"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+	// calDAVLayout is a layout of UTC times in CalDAV filters.
+	calDAVLayout = "20060102T150405Z"
+)
+
+// calDAVMultistatus is a WebDAV multi-status response of the calendar-query.
+type calDAVMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Data   string `xml:"prop>calendar-data"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// calDAVObject is a calendar object resource.
+type calDAVObject struct {
+	href string
+	data string
+}
+
+// CalDAVSource is a remote events source of CalDAV calendar collection, for example, Nextcloud one.
+// Calendar objects are converted like iCalendar documents, the source is modified if any of them is changed.
+type CalDAVSource struct {
+	url      string
+	user     string
+	password string
+	version  string // hash of calendar objects of the latest fetch
+}
+
+// NewCalDAVSource returns a source of calendar collection's events by its URL,
+// the basic authentication is used if the user is not empty.
+func NewCalDAVSource(url, user, password string) *CalDAVSource {
+	return &CalDAVSource{url: url, user: user, password: password}
+}
+
+// objects requests calendar objects with events which are not finished before now time.
+func (cs *CalDAVSource) objects(ctx context.Context, now time.Time) ([]calDAVObject, error) {
+	body := fmt.Sprintf(calDAVReport, now.UTC().Format(calDAVLayout))
+	req, err := http.NewRequestWithContext(ctx, "REPORT", cs.url, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if cs.user != "" {
+		req.SetBasicAuth(cs.user, cs.password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("response: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("status: %d", resp.StatusCode)
+	}
+	var ms calDAVMultistatus
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, maxSourceSize)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	objects := make([]calDAVObject, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstat {
+			// only found properties have values
+			if strings.Contains(ps.Status, " 200 ") && (ps.Data != "") {
+				objects = append(objects, calDAVObject{href: r.Href, data: ps.Data})
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].href < objects[j].href
+	})
+	return objects, nil
+}
+
+// Fetch requests upcoming calendar's events, it returns false if they were not modified.
+func (cs *CalDAVSource) Fetch(ctx context.Context) ([]*Event, bool, error) {
+	objects, err := cs.objects(ctx, time.Now())
+	if err != nil {
+		return nil, false, fmt.Errorf("caldav %w", err)
+	}
+	h := sha256.New()
+	for _, obj := range objects {
+		fmt.Fprintf(h, "%s\n%s\n", obj.href, obj.data)
+	}
+	version := hex.EncodeToString(h.Sum(nil))
+	if version == cs.version {
+		return nil, false, nil
+	}
+	var events []*Event
+	for _, obj := range objects {
+		items, err := ParseICalendar(strings.NewReader(obj.data))
+		if err != nil {
+			return nil, false, fmt.Errorf("caldav object %s: %w", obj.href, err)
+		}
+		for _, e := range items {
+			if err = e.Init(); err != nil {
+				return nil, false, fmt.Errorf("caldav object %s: %w", obj.href, err)
+			}
+		}
+		events = append(events, items...)
+	}
+	cs.version = version
+	return events, true, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cardLayout is a layout of event's start time in its card.
+const cardLayout = "Mon, 02 Jan 2006 15:04 MST"
+
+// ErrEvent is an error when an event is not found by users' query.
+var ErrEvent = errors.New("unknown event")
+
+// EventCard is a short event's info found by users' query.
+type EventCard struct {
+	Number int // event's number in /events list
+	Title  string
+	Text   string
+}
+
+// FindEvent returns a card of the first event which title contains query, it is case-insensitive.
+func (s *Storage) FindEvent(query string) (*EventCard, error) {
+	query = strings.ToLower(normalize(query))
+	if query == "" {
+		return nil, ErrEvent
+	}
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+
+	for i, e := range s.events {
+		if !strings.Contains(strings.ToLower(normalize(e.Title)), query) {
+			continue
+		}
+		start := e.nextIn(time.Now(), nil)
+		card := &EventCard{
+			Number: i + 1,
+			Title:  e.Title,
+			Text:   fmt.Sprintf("%s\n\nNext: %s", e.text(start), nextText(start)),
+		}
+		if e.Owner != "" {
+			card.Text += fmt.Sprintf("\nContact: %s", e.Owner)
+		}
+		return card, nil
+	}
+	return nil, fmt.Errorf("event %q: %w", query, ErrEvent)
+}
+
+// EventTitle returns a title of the event by its number in /events list.
+func (s *Storage) EventTitle(number int) (string, error) {
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+
+	if (number < 1) || (number > len(s.events)) {
+		return "", fmt.Errorf("event number %d: %w", number, ErrEvent)
+	}
+	return s.events[number-1].Title, nil
+}
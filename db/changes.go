@@ -0,0 +1,120 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// EventChange is a changed or cancelled event's message for its subscribers.
+type EventChange struct {
+	Title string
+	Text  string
+	tags  []string // event's tags before the change
+}
+
+// clock returns event's start times as "15:04" separated by commas.
+func (e *Event) clock() string {
+	values := make([]string, len(e.starts))
+	for i, start := range e.starts {
+		values[i] = fmt.Sprintf("%02d:%02d", int(start.Hours()), int(start.Minutes())%60)
+	}
+	return strings.Join(values, ",")
+}
+
+// days returns periodic event's weekdays as a string.
+func (e *Event) days() string {
+	names := make([]string, len(e.weekdays))
+	for i, d := range e.weekdays {
+		names[i] = d.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// changes returns descriptions of event's schedule and location changes in the updated one.
+func (e *Event) changes(updated *Event) []string {
+	var result []string
+	add := func(name, old, value string) {
+		if old != value {
+			result = append(result, fmt.Sprintf("%s %s -> %s", name, old, value))
+		}
+	}
+	add("date", e.Date, updated.Date)
+	add("cron", e.Cron, updated.Cron)
+	add("monthly", e.Monthly, updated.Monthly)
+	add("once", e.Once, updated.Once)
+	add("until", e.Until, updated.Until)
+	if (updated.Date == "") && (updated.Cron == "") && (updated.Monthly == "") && (updated.Once == "") {
+		add("weekdays", e.days(), updated.days())
+		add("period", e.Period, updated.Period)
+	}
+	if updated.Cron == "" {
+		add("time", e.clock(), updated.clock())
+	}
+	add("time zone", e.TimeZone, updated.TimeZone)
+	add("location", e.Location, updated.Location)
+	return result
+}
+
+// diffEvents returns messages about changed and cancelled events, new events are not reported.
+func diffEvents(old, events []*Event, now time.Time) []EventChange {
+	known := make(map[string]*Event, len(old))
+	for _, e := range old {
+		known[e.Title] = e
+	}
+	result := make([]EventChange, 0)
+	for _, e := range events {
+		prev, ok := known[e.Title]
+		if !ok {
+			continue
+		}
+		delete(known, e.Title)
+		if changes := prev.changes(e); len(changes) > 0 {
+			text := fmt.Sprintf(
+				"%s is changed: %s\n\nNext: %s",
+				e.Title, strings.Join(changes, ", "), nextText(e.nextIn(now, nil)),
+			)
+			result = append(result, EventChange{Title: e.Title, Text: text, tags: prev.tags})
+		}
+	}
+	for _, e := range old {
+		if _, ok := known[e.Title]; ok {
+			result = append(result, EventChange{Title: e.Title, Text: e.Title + " is cancelled", tags: e.tags})
+		}
+	}
+	return result
+}
+
+// subscribers returns sorted names of active users which are subscribed to the event by its title or tags.
+func (s *Storage) subscribers(title string, tags []string) []string {
+	names := make([]string, 0)
+	for _, sh := range s.shards {
+		sh.RLock()
+		for name, u := range sh.users {
+			if u.paused.IsZero() && u.subscribed(title, tags) {
+				names = append(names, name)
+			}
+		}
+		sh.RUnlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NotifyChanges sends events' changes to their subscribers, it returns a number of sent messages.
+func (s *Storage) NotifyChanges(b *botgolang.Bot, changes []EventChange, l *Logger) int {
+	n := 0
+	for _, c := range changes {
+		for _, name := range s.subscribers(c.Title, c.tags) {
+			if err := b.NewTextMessage(name, c.Text).Send(); err != nil {
+				l.Error.Printf("failed send event=%q change to user=%s: %v", c.Title, name, err)
+				continue
+			}
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// clockSkew returns a difference between local time and the server time from HTTP "Date" header.
+// The header has seconds precision, so a half of the request duration is used as a correction.
+func clockSkew(ctx context.Context, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("time request: %w", err)
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("time response: %w", err)
+	}
+	_ = resp.Body.Close()
+	finish := time.Now()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("time header: %w", err)
+	}
+	local := start.Add(finish.Sub(start) / 2)
+	return local.Sub(serverTime), nil
+}
+
+// absDuration returns absolute value of d.
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// checkClock compares local and server time and returns true if the skew is acceptable.
+// Failed requests don't pause notifications.
+func checkClock(ctx context.Context, st *Settings) bool {
+	skew, err := clockSkew(ctx, st.TimeURL)
+	if err != nil {
+		st.Error.Printf("failed check clock skew: %v", err)
+		return true
+	}
+	if absDuration(skew) > st.MaxSkew {
+		st.Error.Printf("CLOCK SKEW %v exceeds %v, notifications are paused", skew, st.MaxSkew)
+		return false
+	}
+	st.Debug.Printf("clock skew %v", skew)
+	return true
+}
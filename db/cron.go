@@ -0,0 +1,165 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears is a maximum period of the next cron occurrence search.
+const cronSearchYears = 5
+
+// cronField is a cron expression's field limits and names of its values.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of values starting from min
+}
+
+var (
+	cronMonths = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	cronDays   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	// cronFields are fields of the standard 5 fields expression, 7 is also Sunday in weekdays
+	cronFields = [5]cronField{
+		{name: "minute", min: 0, max: 59},
+		{name: "hour", min: 0, max: 23},
+		{name: "day", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: cronMonths},
+		{name: "weekday", min: 0, max: 7, names: cronDays},
+	}
+)
+
+// cronSchedule is a parsed cron expression "minute hour day month weekday", fields are bit sets of allowed values.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday are true for "*" fields, if both fields are restricted, any of them matches
+	anyDay, anyWeekday bool
+}
+
+// value parses the field's single value as a number or a name.
+func (f *cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || (v < f.min) || (v > f.max) {
+		return 0, fmt.Errorf("invalid %s value %q", f.name, s)
+	}
+	return v, nil
+}
+
+// parse returns a bit set of the field's values, it supports "*", lists, ranges and steps.
+func (f *cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || (n < 1) {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, item)
+			}
+			item, step = item[:i], n
+		}
+		from, to := f.min, f.max
+		switch i := strings.Index(item, "-"); {
+		case item == "*":
+		case i > 0:
+			var err error
+			if from, err = f.value(item[:i]); err != nil {
+				return 0, err
+			}
+			if to, err = f.value(item[i+1:]); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, item)
+			}
+		default:
+			v, err := f.value(item)
+			if err != nil {
+				return 0, err
+			}
+			from = v
+			if step == 1 {
+				// "5/10" means from 5 to the maximum every 10
+				to = v
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCron returns a schedule of the standard 5 fields cron expression.
+func parseCron(expr string) (*cronSchedule, error) {
+	values := strings.Fields(expr)
+	if len(values) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q has %d fields instead of %d", expr, len(values), len(cronFields))
+	}
+	var bits [len(cronFields)]uint64
+	for i := range cronFields {
+		b, err := cronFields[i].parse(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1 // Sunday
+	}
+	c := &cronSchedule{
+		minutes: bits[0], hours: bits[1], days: bits[2], months: bits[3], weekdays: bits[4],
+		anyDay: values[2] == "*", anyWeekday: values[4] == "*",
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", expr)
+	}
+	return c, nil
+}
+
+// matchDay returns true if the date is allowed by days and weekdays fields.
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// next returns the first schedule's time at or after dt in its location,
+// zero time is returned if there is no one during cronSearchYears.
+// Times in skipped DST periods are shifted like time.Date does it.
+func (c *cronSchedule) next(dt time.Time) time.Time {
+	loc := dt.Location()
+	day := time.Date(dt.Year(), dt.Month(), dt.Day(), 0, 0, 0, 0, loc)
+	for limit := day.AddDate(cronSearchYears, 0, 0); day.Before(limit); day = day.AddDate(0, 0, 1) {
+		if (c.months&(1<<uint(day.Month())) == 0) || !c.matchDay(day) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if c.hours&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if c.minutes&(1<<uint(m)) == 0 {
+					continue
+				}
+				if t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc); !t.Before(dt) {
+					return t
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// sealPrefix starts every encrypted chunk line of users' files.
+const sealPrefix = "#sealed:"
+
+// ErrSecret is an error when encrypted users' data can't be read.
+var ErrSecret = errors.New("invalid users data secret")
+
+// secretSize is a size of AES-256 key.
+const secretSize = 32
+
+// ParseSecret returns users data encryption key from base64 secret, it should have 32 bytes.
+// Empty secret returns nil key, the encryption is disabled then.
+func ParseSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("secret is not base64: %w", ErrSecret)
+	}
+	if n := len(key); n != secretSize {
+		return nil, fmt.Errorf("secret has %d bytes, %d are expected: %w", n, secretSize, ErrSecret)
+	}
+	return key, nil
+}
+
+// newCipher returns AES-GCM cipher with the base64 secret key, empty secret disables encryption.
+func newCipher(secret string) (cipher.AEAD, error) {
+	key, err := ParseSecret(secret)
+	if (err != nil) || (key == nil) {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("users data cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns encrypted data as a single chunk line, nil aead returns data as is.
+func seal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("users data nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, data, nil)
+	return []byte(sealPrefix + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// unseal returns decrypted data of chunk lines. Not encrypted data is returned as is,
+// so plain users' files are read and encrypted by the next saving.
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(sealPrefix)) {
+		return data, nil
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("encrypted users data without secret: %w", ErrSecret)
+	}
+	var result []byte
+	for i, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte(sealPrefix)) {
+			return nil, fmt.Errorf("users data chunk [%d] is not encrypted: %w", i, ErrSecret)
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(line[len(sealPrefix):]))
+		if err != nil || (len(sealed) < aead.NonceSize()) {
+			return nil, fmt.Errorf("users data chunk [%d] decode: %w", i, ErrSecret)
+		}
+		n := aead.NonceSize()
+		if result, err = aead.Open(result, sealed[:n], sealed[n:], nil); err != nil {
+			return nil, fmt.Errorf("users data chunk [%d] decrypt: %w", i, ErrSecret)
+		}
+	}
+	return result, nil
+}
+
+// ValidSealed returns an error if users data of source can't be encrypted,
+// only CSV and JSON files support the encryption.
+func ValidSealed(source string) error {
+	source = strings.Trim(source, " ")
+	if (source == MemorySource) || strings.HasPrefix(source, "redis://") || strings.HasPrefix(source, "rediss://") {
+		return fmt.Errorf("users data encryption supports only CSV and JSON files, source=%s", source)
+	}
+	if ext := filepath.Ext(source); (ext == ".db") || (ext == ".bolt") {
+		return fmt.Errorf("users data encryption supports only CSV and JSON files, source=%s", source)
+	}
+	return nil
+}
+
+// openSealedBackend returns a storage backend which files are encrypted by aead.
+func openSealedBackend(source string, aead cipher.AEAD) (backend, error) {
+	if aead == nil {
+		return openBackend(source)
+	}
+	if err := ValidSealed(source); err != nil {
+		return nil, err
+	}
+	b, err := openBackend(source)
+	if err != nil {
+		return nil, err
+	}
+	switch sb := b.(type) {
+	case *csvBackend:
+		sb.aead = aead
+	case *jsonBackend:
+		sb.aead = aead
+	}
+	return b, nil
+}
@@ -0,0 +1,381 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// csvLogSuffix is a suffix of users' changes log file.
+	csvLogSuffix = ".log"
+	// csvVersionField is the first field of CSV file header row with data version.
+	csvVersionField = "#version"
+)
+
+// csvBackend is a users' storage in CSV file.
+// Changes are appended to a log file which is merged into the main file during compaction.
+type csvBackend struct {
+	fileName string
+	lock     *os.File    // exclusive lock until the backend closing
+	aead     cipher.AEAD // files' encryption, nil - plain files
+}
+
+// newCSVBackend returns CSV file storage, it is locked, so other processes can't use the same file.
+func newCSVBackend(fileName string) (*csvBackend, error) {
+	lock, err := lockFile(fileName + lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &csvBackend{fileName: fileName, lock: lock}, nil
+}
+
+// logName returns changes log file name.
+func (b *csvBackend) logName() string {
+	return b.fileName + csvLogSuffix
+}
+
+// readRows reads all CSV rows from the file, a missing file has no rows.
+// Encrypted files are decrypted by aead, the second result is true for them.
+func readRows(fileName string, create bool, aead cipher.AEAD) ([][]string, bool, error) {
+	flags := os.O_RDONLY
+	if create {
+		flags |= os.O_CREATE
+	}
+	f, err := os.OpenFile(fileName, flags, 0640)
+	if err != nil {
+		if !create && errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("users log open: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("users log read: %w", err)
+	}
+	sealed := bytes.HasPrefix(data, []byte(sealPrefix))
+	if data, err = unseal(aead, data); err != nil {
+		return nil, false, err
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, false, fmt.Errorf("users log parse: %w", err)
+	}
+	return records, sealed, nil
+}
+
+// splitVersion returns data version from the optional header row and other rows.
+func splitVersion(records [][]string) (int, [][]string, error) {
+	if len(records) == 0 || records[0][0] != csvVersionField {
+		return 0, records, nil
+	}
+	if len(records[0]) != 2 {
+		return 0, nil, fmt.Errorf("users version row %v", records[0])
+	}
+	version, err := strconv.Atoi(records[0][1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("users version parse: %w", err)
+	}
+	return version, records[1:], nil
+}
+
+// version returns data version of CSV file, a missing file has version 0.
+func (b *csvBackend) version() (int, error) {
+	records, _, err := readRows(b.fileName, false, b.aead)
+	if err != nil {
+		return 0, err
+	}
+	version, _, err := splitVersion(records)
+	return version, err
+}
+
+// load loads users' names and delays form a source CSV file and applies its changes log.
+func (b *csvBackend) load() ([]*user, error) {
+	records, sealed, err := readRows(b.fileName, true, b.aead)
+	if err != nil {
+		return nil, err
+	}
+	if _, records, err = splitVersion(records); err != nil {
+		return nil, err
+	}
+	changes, _, err := readRows(b.logName(), false, b.aead)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*user, len(records))
+	for _, userItem := range append(records, changes...) {
+		if len(userItem) == 1 {
+			// removed user's record
+			delete(users, userItem[0])
+			continue
+		}
+		u, err := parseCSVRow(userItem)
+		if err != nil {
+			return nil, err
+		}
+		users[u.name] = u
+	}
+	userRecords := sortedUsers(users)
+	if (len(changes) > 0) || ((b.aead != nil) && !sealed) {
+		// compaction of the changes log or encryption of the plain file
+		if err = b.save(context.Background(), userRecords); err != nil {
+			return nil, err
+		}
+	}
+	return userRecords, nil
+}
+
+// parseCSVRow returns a user from CSV row.
+func parseCSVRow(userItem []string) (*user, error) {
+	var (
+		role          = RoleUser
+		subscriptions []string
+		zone          *time.Location
+		eventDelays   map[string][]time.Duration
+		paused        time.Time
+		deleted       time.Time
+		plain         bool
+		vacation      *Vacation
+		reminders     []*reminder
+		groupEvents   []*reminder
+		members       []string
+		err           error
+	)
+	if len(userItem) > 12 {
+		// optional group chat's members column
+		members = sortedTitles(strings.Split(userItem[12], subscriptionsSeparator))
+		userItem = userItem[:12]
+	}
+	if len(userItem) > 11 {
+		// optional group chat's events column
+		if groupEvents, err = parseRemindersColumn(userItem[11]); err != nil {
+			return nil, fmt.Errorf("users row group events parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:11]
+	}
+	if len(userItem) > 10 {
+		// optional personal reminders column
+		if reminders, err = parseRemindersColumn(userItem[10]); err != nil {
+			return nil, fmt.Errorf("users row reminders parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:10]
+	}
+	if len(userItem) > 9 {
+		// optional vacation column
+		if vacation, err = parseVacationValue(userItem[9]); err != nil {
+			return nil, fmt.Errorf("users row vacation parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:9]
+	}
+	if len(userItem) > 8 {
+		// optional plain mode column
+		plain = userItem[8] == plainColumn
+		userItem = userItem[:8]
+	}
+	if len(userItem) > 7 {
+		// optional events' own delays column
+		if eventDelays, err = parseEventDelays(userItem[7]); err != nil {
+			return nil, fmt.Errorf("users row event delays parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:7]
+	}
+	if len(userItem) > 6 {
+		// optional pause time column
+		if userItem[6] != "" {
+			if paused, err = time.Parse(time.RFC3339, userItem[6]); err != nil {
+				return nil, fmt.Errorf("users row paused parse %v: %w", userItem, err)
+			}
+		}
+		userItem = userItem[:6]
+	}
+	if len(userItem) > 5 {
+		// optional time zone column
+		if zone, err = parseZone(userItem[5]); err != nil {
+			return nil, fmt.Errorf("users row time zone parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:5]
+	}
+	if len(userItem) > 4 {
+		// optional subscriptions column
+		if userItem[4] != "" {
+			subscriptions = sortedTitles(strings.Split(userItem[4], subscriptionsSeparator))
+		}
+		userItem = userItem[:4]
+	}
+	if len(userItem) > 3 {
+		// optional soft deletion time column, it can be empty if next columns are set
+		if userItem[3] != "" {
+			if deleted, err = time.Parse(time.RFC3339, userItem[3]); err != nil {
+				return nil, fmt.Errorf("users row deleted parse %v: %w", userItem, err)
+			}
+		}
+		userItem = userItem[:3]
+	}
+	if len(userItem) == 3 {
+		// optional role column
+		if role, err = ParseRole(userItem[2]); err != nil {
+			return nil, fmt.Errorf("users row role parse %v: %w", userItem, err)
+		}
+		userItem = userItem[:2]
+	}
+	// ignore delay limit during reading file data
+	name, delays, err := parseUserRow(userItem, 0, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("users row parse: %w", err)
+	}
+	u := &user{
+		name: name, delays: delays, role: role, subscriptions: subscriptions,
+		zone: zone, paused: paused, deleted: deleted, eventDelays: eventDelays, plain: plain,
+		vacation: vacation, reminders: reminders, groupEvents: ownGroupEvents(name, groupEvents), members: members,
+	}
+	return u, nil
+}
+
+// csvRow returns CSV row of the user, empty optional columns are omitted from the end.
+func csvRow(u *user) []string {
+	row := []string{
+		u.name, u.stringDelays(), u.role.String(), "",
+		strings.Join(u.subscriptions, subscriptionsSeparator), u.zoneName(), "", u.stringEventDelays(), "",
+		u.vacation.value(), u.remindersColumn(), u.groupEventsColumn(), strings.Join(u.members, subscriptionsSeparator),
+	}
+	if !u.deleted.IsZero() {
+		row[3] = u.deleted.Format(time.RFC3339)
+	}
+	if !u.paused.IsZero() {
+		row[6] = u.paused.Format(time.RFC3339)
+	}
+	if u.plain {
+		row[8] = plainColumn
+	}
+	n := len(row)
+	for (n > 3) && (row[n-1] == "") {
+		n--
+	}
+	if (n == 3) && (u.role == RoleUser) {
+		n = 2
+	}
+	return row[:n]
+}
+
+// writeRows writes rows to f and syncs it, not nil aead encrypts them as a single chunk.
+func writeRows(f *os.File, rows [][]string, aead cipher.AEAD) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("users log write: %w", err)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("users log flush: %w", err)
+	}
+	data, err := seal(aead, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		return fmt.Errorf("users log write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("users log sync: %w", err)
+	}
+	return nil
+}
+
+// save rewrites users CSV file with version header row and removes the changes log.
+func (b *csvBackend) save(ctx context.Context, users []*user) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("users save: %w", err)
+	}
+	rows := make([][]string, 0, len(users)+1)
+	rows = append(rows, []string{csvVersionField, strconv.Itoa(schemaVersion)})
+	for _, u := range users {
+		rows = append(rows, csvRow(u))
+	}
+	err := writeFile(b.fileName, func(f *os.File) error {
+		return writeRows(f, rows, b.aead)
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(b.logName()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("users changes log remove: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file by the write function and replaces fileName by it,
+// so a failure can't destroy saved users. The original file mode is kept.
+func writeFile(fileName string, write func(f *os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("users log temporary file: %w", err)
+	}
+	tmpName := f.Name()
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(tmpName) // it is already renamed in success case
+	}()
+	if err = write(f); err != nil {
+		return err
+	}
+	mode := os.FileMode(0640)
+	if info, err := os.Stat(fileName); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = f.Chmod(mode); err != nil {
+		return fmt.Errorf("users log chmod: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("users log close: %w", err)
+	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("users log rename: %w", err)
+	}
+	return nil
+}
+
+// update appends changed and removed users' records to the changes log.
+func (b *csvBackend) update(ctx context.Context, changed []*user, removed []string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("users update: %w", err)
+	}
+	f, err := os.OpenFile(b.logName(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("users changes log open: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	rows := make([][]string, 0, len(changed)+len(removed))
+	for _, u := range changed {
+		rows = append(rows, csvRow(u))
+	}
+	for _, name := range removed {
+		rows = append(rows, []string{name})
+	}
+	return writeRows(f, rows, b.aead)
+}
+
+// close releases the file lock.
+func (b *csvBackend) close() error {
+	if b.lock == nil {
+		return nil
+	}
+	err := unlockFile(b.lock)
+	b.lock = nil
+	return err
+}
@@ -1,23 +1,33 @@
 package db
 
 import (
+	"container/heap"
 	"context"
-	"encoding/csv"
+	"crypto/cipher"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
-	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	botgolang "github.com/mail-ru-im/bot-golang"
 )
 
+const (
+	// defaultButton is a default label of event's URL button.
+	defaultButton = "URL"
+	// compactUpdates is a number of incremental users' updates before full saving.
+	compactUpdates = 1000
+)
+
 var (
 	// ErrUnknownUser is an error when a request was gotten from unknown user.
 	ErrUnknownUser = errors.New("unknown user")
@@ -25,6 +35,15 @@ var (
 	ErrKnownUser = errors.New("known user")
 	// ErrSetUser is error when set method was called with failed arguments.
 	ErrSetUser = errors.New("no params")
+	// ErrDelay is an error when user's delay value is invalid.
+	ErrDelay = errors.New("invalid delay")
+	// ErrLimit is an error when users' limits are exceeded.
+	ErrLimit = errors.New("limit exceeded")
+	// ErrZoneInfo is an error when IANA time zones database is not available.
+	ErrZoneInfo = errors.New("time zones database is not available, install tzdata or build with -tags timetzdata")
+
+	// httpClient is a client for external events' requests.
+	httpClient = &http.Client{Timeout: 10 * time.Second}
 )
 
 // Limits stores users' limits.
@@ -33,61 +52,537 @@ type Limits struct {
 	Delays   int `toml:"delays"`
 	MinDelay int `toml:"min_delay"`
 	MaxDelay int `toml:"max_delay"`
+	MaxAfter int `toml:"max_after"`    // minutes after the start for negative delays, 0 - disabled
+	Grace    int `toml:"grace_period"` // hours to keep soft deleted users' settings
+	Lookback int `toml:"lookback"`     // minutes to check missed notifications, 0 - disabled
+	History  int `toml:"history"`      // number of the latest deliveries' records, 0 - disabled
+	Quota    int `toml:"daily_quota"`  // chat's notifications per UTC day, urgent events' ones are not limited, 0 - disabled
+	Merge    int `toml:"merge_window"` // minutes to merge notifications of the same event's occurrence, 0 - disabled
+	Bounce   int `toml:"bounce_weeks"` // weeks of user's consecutive send errors before BounceAction, 0 - disabled
+	// Budget is seconds to send notifications after their scheduled time before admins' escalation, 0 - disabled
+	Budget int `toml:"latency_budget"`
+	// Reminders is a maximum number of user's personal reminders, 0 - disabled
+	Reminders int `toml:"reminders"`
+	// ReminderLength is a maximum length of personal reminders' and group events' titles, 0 - unlimited
+	ReminderLength int `toml:"reminder_length"`
+	// GroupEvents is a maximum number of group chat's events, 0 - disabled
+	GroupEvents int `toml:"group_events"`
+	// BounceAction is BouncePause or BounceRemove, the removed users' settings are kept for the grace period
+	BounceAction string `toml:"bounce_action"`
+	// DefaultDelays are new users' delays in minutes after /start, empty - no notifications until /set
+	DefaultDelays []int `toml:"default_delays"`
+}
+
+// defaultDelays returns sorted unique new users' delays.
+func (l *Limits) defaultDelays() []time.Duration {
+	values := make([]delayValue, len(l.DefaultDelays))
+	for i, minutes := range l.DefaultDelays {
+		values[i] = delayValue(time.Duration(minutes) * time.Minute)
+	}
+	return fromDelayValues(values)
 }
 
 // Logger is common struct for loggers by levels.
 type Logger struct {
-	Debug *log.Logger
-	Info  *log.Logger
-	Error *log.Logger
+	Debug    *log.Logger
+	Info     *log.Logger
+	Error    *log.Logger
+	trace    *log.Logger
+	mu       sync.RWMutex
+	traced   map[string]bool // chats with enabled debug logging
+	debug    bool
+	fileName string   // logs file, empty for standard outputs
+	file     *os.File // opened logs file
+	// redaction is *redaction of secrets in logs, it is nil if disabled
+	redaction atomic.Value
 }
 
 // NewLogger returns new logger struct.
 func NewLogger(debug bool) *Logger {
-	logger := new(Logger)
-	logger.Error = log.New(os.Stderr, "ERROR ", log.Ldate|log.Ltime|log.Lshortfile)
-	logger.Info = log.New(os.Stdout, "INFO  ", log.LstdFlags)
+	logger := &Logger{debug: debug}
+	logger.Error = log.New(logger.writer(os.Stderr, levelError), "ERROR ", log.Ldate|log.Ltime|log.Lshortfile)
+	logger.Info = log.New(logger.writer(os.Stdout, levelInfo), "INFO  ", log.LstdFlags)
 	if debug {
-		logger.Debug = log.New(os.Stdout, "DEBUG ", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+		logger.Debug = log.New(logger.writer(os.Stdout, levelDebug), "DEBUG ", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
 	} else {
 		logger.Debug = log.New(ioutil.Discard, "DEBUG ", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
 	}
+	logger.trace = log.New(logger.writer(os.Stdout, levelDebug), "TRACE ", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
 	return logger
 }
 
+// OpenFile writes logs to fileName file instead of standard outputs and closes the previous file.
+// Empty fileName reopens the current file, for example, after its rotation.
+func (l *Logger) OpenFile(fileName string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if fileName == "" {
+		fileName = l.fileName
+	}
+	if fileName == "" {
+		return nil
+	}
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("open logs file: %w", err)
+	}
+	for logger, level := range map[*log.Logger]int{l.Info: levelInfo, l.Error: levelError, l.trace: levelDebug} {
+		if logger != nil {
+			logger.SetOutput(l.writer(f, level))
+		}
+	}
+	if l.debug && (l.Debug != nil) {
+		l.Debug.SetOutput(l.writer(f, levelDebug))
+	}
+	prev := l.file
+	l.fileName, l.file = fileName, f
+	if prev != nil {
+		if err = prev.Close(); err != nil {
+			return fmt.Errorf("close previous logs file: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetTrace enables or disables debug logging only for the chat.
+func (l *Logger) SetTrace(chatID string, enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !enabled {
+		delete(l.traced, chatID)
+		return
+	}
+	if l.traced == nil {
+		l.traced = make(map[string]bool)
+	}
+	l.traced[chatID] = true
+}
+
+// Trace writes debug message if the debug logging is enabled for the chat.
+func (l *Logger) Trace(chatID, format string, v ...interface{}) {
+	l.mu.RLock()
+	enabled := l.traced[chatID]
+	l.mu.RUnlock()
+
+	if enabled && (l.trace != nil) {
+		_ = l.trace.Output(2, fmt.Sprintf("[%s] ", chatID)+fmt.Sprintf(format, v...))
+	}
+}
+
 // Event is a notification event's settings.
 type Event struct {
-	Title     string       `toml:"title"`
-	URL       string       `toml:"url"`
-	Message   string       `toml:"message"`
-	Weekday   time.Weekday `toml:"weekday"`
-	Period    string       `toml:"period"`
-	StartHour string       `toml:"time"`
-	TimeZone  string       `toml:"timezone"`
+	Title     string        `toml:"title" json:"title"`
+	URL       string        `toml:"url" json:"url"`
+	URLSource string        `toml:"url_source" json:"url_source"`
+	CheckURL  string        `toml:"check_url" json:"check_url"`
+	Button    string        `toml:"button" json:"button"` // URL button label template
+	Message   string        `toml:"message" json:"message"`
+	Location  string        `toml:"location" json:"location"`
+	MapURL    string        `toml:"map_url" json:"map_url"`
+	Pin       bool          `toml:"pin" json:"pin"`   // pin notifications in group chats until the event start
+	Date      string        `toml:"date" json:"date"` // yearly event date "MM-DD" or "YYYY-MM-DD"
+	Weekday   time.Weekday  `toml:"weekday" json:"weekday"`
+	Period    string        `toml:"period" json:"period"`
+	StartHour string        `toml:"time" json:"time"`
+	TimeZone  string        `toml:"timezone" json:"timezone"`
+	Keyboard  [][]KeyButton `toml:"keyboard" json:"keyboard"`         // custom buttons' rows instead of URL and map ones
+	Expire    string        `toml:"expire_after" json:"expire_after"` // late notifications' lifetime after the start
+	Blackouts []Blackout    `toml:"blackouts" json:"blackouts"`       // quiet periods without occurrences
+	Urgent    bool          `toml:"urgent" json:"urgent"`             // notifications ignore the daily quota
+	Cron      string        `toml:"cron" json:"cron"`                 // schedule instead of weekday, period and time
+	Weekdays  []string      `toml:"weekdays" json:"weekdays"`         // several weekdays' names instead of weekday
+	Times     []string      `toml:"times" json:"times"`               // several start times instead of time
+	Monthly   string        `toml:"monthly" json:"monthly"`           // day of every month instead of weekday and period
+	Window    string        `toml:"window" json:"window"`             // notifications' hours "9h-18h", others are shifted
+	Variants  []Variant     `toml:"variants" json:"variants"`         // random alternative messages by weights
+	AckButton string        `toml:"ack_button" json:"ack_button"`     // label of variants' acknowledgement button
+	Once      string        `toml:"once" json:"once"`                 // single occurrence "2006-01-02T15:04" without recurrence
+	Until     string        `toml:"until" json:"until"`               // last date "2006-01-02" of occurrences
+	Holidays  []string      `toml:"holidays" json:"holidays"`         // dates "MM-DD" or "YYYY-MM-DD" without occurrences
+	RRule     string        `toml:"rrule" json:"rrule"`               // iCalendar recurrence rule instead of weekdays, period, monthly and date
+	// CalendarButton is a label of "add to calendar" link button of notifications, empty - disabled
+	CalendarButton string `toml:"calendar_button" json:"calendar_button"`
+	// Duration is an occurrence's duration in calendar links, one hour by default
+	Duration string `toml:"duration" json:"duration"`
+	// Owner is a chat ID of the responsible person, who gets feedback and delivery failures
+	Owner string `toml:"owner" json:"owner"`
+	// Tags are events' categories, users can subscribe to all events with a tag
+	Tags      []string `toml:"tags" json:"tags"`
 	offset    time.Duration
 	alarm     time.Time // next event datetime
+	zone      *time.Location
+	start     time.Duration // start time offset from the day beginning
+	month     time.Month    // yearly event's month
+	day       int           // yearly event's day
+	year      int           // yearly event's first year, 0 if unknown
+	lateness  time.Duration // maximum notifications' lateness after the start
+	expires   bool          // notifications are dropped after the maximum lateness
+	urlTmpl   *template.Template
+	checkTmpl *template.Template
+	labelTmpl *template.Template
+	cron      *cronSchedule // nil if the event is not scheduled by cron expression
+	weekdays  []time.Weekday
+	starts    []time.Duration // start times' offsets from the day beginning, the first one is start
+	alarms    []time.Time     // periodic event's alarms of every weekday and start time
+	// monthly is nil if the event does not repeat every month
+	monthly *monthlyRule
+	// window is nil if notifications are sent at any time
+	window *deliveryWindow
+	// variantsWeight is a total weight of message variants
+	variantsWeight int
+	// once is one-shot event's occurrence, zero for recurring events
+	once time.Time
+	// until is the end of event's last date, zero if the event repeats forever
+	until time.Time
+	// holidays are event's and common dates without occurrences
+	holidays []Blackout
+	// duration is an occurrence's duration in calendar links
+	duration time.Duration
+	// group is a group chat of the event for its members, empty for common events
+	group string
+	// tags are sorted event's tags in lower case
+	tags []string
+}
+
+// templateData is a data for event's URL and label templates.
+type templateData struct {
+	Date  string
+	Time  string
+	Start time.Time
 }
 
 func (e *Event) validate() (*time.Location, time.Duration, error) {
-	const dayHours = time.Hour * 24
-	location, err := time.LoadLocation(e.TimeZone)
+	// users' own events' delays are saved as "title=delays|title=delays"
+	if strings.ContainsAny(e.Title, eventDelaysSeparator+eventDelaysAssign) {
+		return nil, 0, fmt.Errorf("event title %q contains %q or %q", e.Title, eventDelaysSeparator, eventDelaysAssign)
+	}
+	location, err := loadLocation(e.TimeZone)
 	if err != nil {
 		return nil, 0, fmt.Errorf("parse zone=%s of event=%s: %w", e.TimeZone, e.Title, err)
 	}
-	offset, err := time.ParseDuration(e.Period)
-	if err != nil {
+	if err = e.applyRRule(location); err != nil {
+		return nil, 0, err
+	}
+	var startOffset time.Duration
+	if e.Once != "" {
+		if (e.Date != "") || (e.Cron != "") || (e.Monthly != "") {
+			return nil, 0, fmt.Errorf("event=%s has once with date, cron or monthly", e.Title)
+		}
+		if err = e.parseOnce(location); err != nil {
+			return nil, 0, fmt.Errorf("parse event=%s: %w", e.Title, err)
+		}
+		startOffset = e.once.Sub(time.Date(e.once.Year(), e.once.Month(), e.once.Day(), 0, 0, 0, 0, location))
+		e.starts = []time.Duration{startOffset}
+	} else if e.Cron != "" {
+		if e.Date != "" {
+			return nil, 0, fmt.Errorf("event=%s has both date and cron", e.Title)
+		}
+		if e.Monthly != "" {
+			return nil, 0, fmt.Errorf("event=%s has both monthly and cron", e.Title)
+		}
+		if e.cron, err = parseCron(e.Cron); err != nil {
+			return nil, 0, fmt.Errorf("parse event=%s: %w", e.Title, err)
+		}
+	} else {
+		if e.Date != "" {
+			if e.Monthly != "" {
+				return nil, 0, fmt.Errorf("event=%s has both date and monthly", e.Title)
+			}
+			err = e.parseDate()
+		} else if e.Monthly != "" {
+			e.monthly, err = parseMonthly(e.Monthly)
+		} else if e.offset, err = time.ParseDuration(e.Period); err == nil {
+			e.weekdays, err = parseWeekdays(e.Weekdays, e.Weekday)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("parse event=%s: %w", e.Title, err)
+		}
+		if e.starts, err = parseStarts(e.Times, e.StartHour); err != nil {
+			return nil, 0, fmt.Errorf("time of event=%s: %w", e.Title, err)
+		}
+		startOffset = e.starts[0]
+	}
+	if e.urlTmpl, err = parseTemplate(e.Title, e.URL); err != nil {
+		return nil, 0, fmt.Errorf("url of event=%s: %w", e.Title, err)
+	}
+	if e.checkTmpl, err = parseTemplate(e.Title, e.CheckURL); err != nil {
+		return nil, 0, fmt.Errorf("check url of event=%s: %w", e.Title, err)
+	}
+	if i := strings.Index(e.Message, "{{"); i >= 0 {
+		return nil, 0, fmt.Errorf("message of event=%s: templates are not supported, {{ at %s", e.Title, textPosition(e.Message, i))
+	}
+	if e.Button == "" {
+		e.Button = defaultButton
+	}
+	if e.labelTmpl, err = parseTemplate(e.Title, e.Button); err != nil {
+		return nil, 0, fmt.Errorf("button of event=%s: %w", e.Title, err)
+	}
+	if err = e.validateKeyboard(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.validateBlackouts(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.validateWindow(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.validateVariants(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.validateInvite(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.validateTags(); err != nil {
+		return nil, 0, err
+	}
+	if err = e.parseUntil(location); err != nil {
 		return nil, 0, fmt.Errorf("parse event=%s: %w", e.Title, err)
 	}
-	e.offset = offset
+	if e.Expire != "" {
+		if e.lateness, err = time.ParseDuration(e.Expire); err != nil {
+			return nil, 0, fmt.Errorf("expire_after of event=%s: %w", e.Title, err)
+		}
+		if e.lateness < 0 {
+			return nil, 0, fmt.Errorf("negative expire_after of event=%s: %v", e.Title, e.lateness)
+		}
+		e.expires = true
+	}
+	return location, startOffset, nil
+}
 
-	startOffset, err := time.ParseDuration(e.StartHour)
+// parseDate sets yearly event's date fields.
+func (e *Event) parseDate() error {
+	const (
+		fullLayout  = "2006-01-02"
+		shortLayout = "01-02"
+	)
+	d, err := time.Parse(fullLayout, e.Date)
+	if err == nil {
+		e.year, e.month, e.day = d.Year(), d.Month(), d.Day()
+		return nil
+	}
+	d, err = time.Parse(shortLayout, e.Date)
 	if err != nil {
-		return nil, 0, fmt.Errorf("parse time of event=%s: %w", e.Title, err)
+		return fmt.Errorf("parse date=%s: %w", e.Date, err)
+	}
+	e.year, e.month, e.day = 0, d.Month(), d.Day()
+	return nil
+}
+
+// yearDate returns the beginning of yearly event's day in the year,
+// February 29 is moved to February 28 in common years.
+func (e *Event) yearDate(year int) time.Time {
+	day := e.day
+	if last := time.Date(year, e.month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+		day = last
 	}
-	if (startOffset < 0) || (startOffset > dayHours) {
-		return nil, 0, fmt.Errorf("invalid time of event=%s: %v", e.Title, startOffset)
+	return time.Date(year, e.month, day, 0, 0, 0, 0, e.zone)
+}
+
+// parseWeekdays returns weekdays by their names, for example "Monday" or "mon",
+// the weekday is used if there are no names.
+func parseWeekdays(names []string, weekday time.Weekday) ([]time.Weekday, error) {
+	if len(names) == 0 {
+		return []time.Weekday{weekday}, nil
 	}
-	return location, startOffset, nil
+	result := make([]time.Weekday, 0, len(names))
+	known := make(map[time.Weekday]bool, len(names))
+	for _, name := range names {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			full := d.String()
+			if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+				if !known[d] {
+					known[d] = true
+					result = append(result, d)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown weekday %q", name)
+		}
+	}
+	return result, nil
+}
+
+// parseStarts returns start times' offsets from the day beginning, the value is used if there are no times.
+func parseStarts(times []string, value string) ([]time.Duration, error) {
+	const dayHours = time.Hour * 24
+	if len(times) == 0 {
+		times = []string{value}
+	}
+	result := make([]time.Duration, 0, len(times))
+	known := make(map[time.Duration]bool, len(times))
+	for _, t := range times {
+		start, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", t, err)
+		}
+		if (start < 0) || (start > dayHours) {
+			return nil, fmt.Errorf("invalid %v", start)
+		}
+		if !known[start] {
+			known[start] = true
+			result = append(result, start)
+		}
+	}
+	return result, nil
+}
+
+// yearly returns true if the event repeats every year.
+func (e *Event) yearly() bool {
+	return e.month > 0
+}
+
+// calendar returns true if the event is scheduled by calendar rules instead of the period.
+func (e *Event) calendar() bool {
+	return e.yearly() || (e.cron != nil) || (e.monthly != nil) || !e.once.IsZero()
+}
+
+// next returns next event's alarm time after dt or dt itself if it is equal to an alarm.
+func (e *Event) next(dt time.Time) time.Time {
+	if !e.once.IsZero() {
+		if e.done(dt) {
+			return never
+		}
+		return e.once
+	}
+	if e.cron != nil {
+		return e.cron.next(dt.In(e.zone))
+	}
+	if e.monthly != nil {
+		return e.monthly.next(dt.In(e.zone), e.starts)
+	}
+	if !e.yearly() {
+		return e.nearest(func(alarm time.Time) time.Time {
+			return nextAlarm(alarm, dt, e.offset)
+		})
+	}
+	dt = dt.In(e.zone)
+	var result time.Time
+	for i, start := range e.starts {
+		alarm := e.yearDate(dt.Year()).Add(start)
+		if alarm.Before(dt) {
+			alarm = e.yearDate(dt.Year() + 1).Add(start)
+		}
+		if (i == 0) || alarm.Before(result) {
+			result = alarm
+		}
+	}
+	return result
+}
+
+// since returns the first occurrence at or after dt out of event's quiet periods.
+func (e *Event) since(dt time.Time) time.Time {
+	start := e.sinceAny(dt)
+	for i := 0; i < maxBlackoutSkips; i++ {
+		end, ok := e.blackout(start)
+		if !ok {
+			break
+		}
+		start = e.sinceAny(end)
+	}
+	if !e.until.IsZero() && !start.Before(e.until) {
+		return never
+	}
+	return start
+}
+
+// sinceAny returns the first occurrence at or after dt, unlike next it can return
+// periodic event's occurrences before its initialization.
+func (e *Event) sinceAny(dt time.Time) time.Time {
+	if e.calendar() {
+		return e.next(dt)
+	}
+	return e.nearest(func(alarm time.Time) time.Time {
+		if !alarm.After(dt) {
+			return nextAlarm(alarm, dt, e.offset)
+		}
+		periods := alarm.Sub(dt)/e.offset + 1
+		return nextAlarm(alarm.Add(-e.offset*periods), dt, e.offset)
+	})
+}
+
+// nearest returns the earliest occurrence of periodic event's alarms by occurrence function.
+func (e *Event) nearest(occurrence func(alarm time.Time) time.Time) time.Time {
+	var result time.Time
+	for i, alarm := range e.alarms {
+		if t := occurrence(alarm); (i == 0) || t.Before(result) {
+			result = t
+		}
+	}
+	return result
+}
+
+// nextIn returns the first occurrence at or after dt by wall clock of loc location.
+// Event's local date and time are kept, so users in different time zones get
+// notifications by their clocks. Nil loc means event's time zone.
+func (e *Event) nextIn(dt time.Time, loc *time.Location) time.Time {
+	if (loc == nil) || (loc.String() == e.zone.String()) {
+		return e.since(dt)
+	}
+	const maxShift = 27 * time.Hour // maximum difference of time zones' offsets
+	for start := e.since(dt.Add(-maxShift)); ; start = e.since(start.Add(time.Nanosecond)) {
+		if local := wallClock(start.In(e.zone), loc); !local.Before(dt) {
+			return local
+		}
+	}
+}
+
+// wallClock returns the time with the same date and clock in loc location.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// link returns event's URL for the occurrence started at start time.
+func (e *Event) link(start time.Time) (string, error) {
+	return executeTemplate(e.urlTmpl, e.URL, start)
+}
+
+// label returns event's URL button label for the occurrence started at start time.
+func (e *Event) label(start time.Time) (string, error) {
+	return executeTemplate(e.labelTmpl, e.Button, start)
+}
+
+// checkLink returns event's check URL for the occurrence started at start time.
+func (e *Event) checkLink(start time.Time) (string, error) {
+	return executeTemplate(e.checkTmpl, e.CheckURL, start)
+}
+
+// parseTemplate parses and verifies event template.
+// Unbalanced braces and unknown placeholders are reported with their positions.
+func parseTemplate(name, value string) (*template.Template, error) {
+	if err := lintBraces(value); err != nil {
+		return nil, fmt.Errorf("lint template: %w", err)
+	}
+	tmpl, err := template.New(name).Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	if err = lintFields(tmpl.Tree.Root, value); err != nil {
+		return nil, fmt.Errorf("lint template: %w", err)
+	}
+	if _, err = executeTemplate(tmpl, value, time.Now()); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// executeTemplate returns value by tmpl for the start time, or raw value if the template is not set.
+func executeTemplate(tmpl *template.Template, value string, start time.Time) (string, error) {
+	if tmpl == nil {
+		return value, nil
+	}
+	var b strings.Builder
+	data := templateData{Date: start.Format("2006-01-02"), Time: start.Format("15:04"), Start: start}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
 // Init validates event's parameters and sets internal time fields.
@@ -96,13 +591,23 @@ func (e *Event) Init() error {
 	if err != nil {
 		return err
 	}
+	e.zone, e.start = location, startOffset
 	now := time.Now().UTC().In(location)
+	if e.calendar() {
+		e.alarm = e.next(now)
+		return nil
+	}
 	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
-	alarmTime := today.Add(startOffset)
-
-	w := alarmTime.Weekday()
-	addDays := int(e.Weekday - w)
-	e.alarm = nextAlarm(alarmTime.AddDate(0, 0, addDays), now, e.offset)
+	e.alarms = make([]time.Time, 0, len(e.weekdays)*len(e.starts))
+	for _, start := range e.starts {
+		alarmTime := today.Add(start)
+		w := alarmTime.Weekday()
+		for _, weekday := range e.weekdays {
+			addDays := int(weekday - w)
+			e.alarms = append(e.alarms, nextAlarm(alarmTime.AddDate(0, 0, addDays), now, e.offset))
+		}
+	}
+	e.alarm = e.next(now)
 	return nil
 }
 
@@ -114,38 +619,152 @@ func (e *Event) String() string {
 	)
 }
 
-// text returns full notification string message.
-func (e *Event) text() string {
-	return fmt.Sprintf("%s\n\n%s", e.Title, e.Message)
+// text returns full notification string message for the occurrence started at start time.
+func (e *Event) text(start time.Time) string {
+	return e.textOf(start, e.Message)
+}
+
+// textOf returns full notification string with the message for the occurrence started at start time.
+func (e *Event) textOf(start time.Time, message string) string {
+	msg := fmt.Sprintf("%s\n\n%s", e.Title, message)
+	if e.year > 0 {
+		msg += fmt.Sprintf("\n\n%d years", start.In(e.zone).Year()-e.year)
+	}
+	if e.Location != "" {
+		msg += fmt.Sprintf("\n\nLocation: %s", e.Location)
+	}
+	return msg
 }
 
 // userMsg is a struct for user event message.
 type userMsg struct {
-	user  string
-	text  string
-	url   string
-	start string
-	bot   *botgolang.Bot
+	user      string
+	text      string
+	url       string
+	label     string
+	urlSource string
+	checkURL  string
+	mapURL    string
+	keyboard  [][]msgButton // custom keyboard's buttons
+	event     string
+	delay     time.Duration
+	pin       bool
+	msgID     string // sent message ID
+	start     time.Time
+	expire    time.Time // the notification is pointless after it, zero - never
+	urgent    bool      // the daily quota is not applied
+	sendAt    time.Time // smeared and jittered sending time, zero - immediately
+	variant   string    // event's message variant, empty for the main message
+	ack       msgButton // variant's acknowledgement button, empty if it is not configured
+	plain     bool      // the message is sent as plain text without keyboard
+	invite    msgButton // "add to calendar" button, empty if it is not configured
+	owner     string    // event's owner chat ID, empty if it is not set
+	due       time.Time // scheduled sending time of the latency budget, zero - no deadline
+	bot       *botgolang.Bot
+}
+
+// String returns the notification's details for logs, its text is marked as a message content.
+func (m *userMsg) String() string {
+	return fmt.Sprintf("user=%s event=%q start=%s text=%s", m.user, m.event, m.start.Format(time.RFC3339), LogContent(m.text))
+}
+
+// fetchURL requests the actual event's URL from urlSource endpoint if it is set.
+// The event's templated URL is kept on any error.
+func (m *userMsg) fetchURL() error {
+	if m.urlSource == "" {
+		return nil
+	}
+	resp, err := httpClient.Get(m.urlSource)
+	if err != nil {
+		return fmt.Errorf("url source request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("url source status: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("url source read: %w", err)
+	}
+	if u := strings.TrimSpace(string(body)); u != "" {
+		m.url = u
+	}
+	return nil
+}
+
+// Allowed requests checkURL endpoint and returns true if the notification should be sent.
+// Only 200 or 204 HTTP status codes allow the occurrence, any check is passed if checkURL is empty.
+func (m *userMsg) Allowed() (bool, error) {
+	if m.checkURL == "" {
+		return true, nil
+	}
+	resp, err := httpClient.Get(m.checkURL)
+	if err != nil {
+		return true, fmt.Errorf("check request: %w", err)
+	}
+	_ = resp.Body.Close()
+	return (resp.StatusCode == http.StatusOK) || (resp.StatusCode == http.StatusNoContent), nil
+}
+
+// checkExpiry returns true if the notification is pointless at now time.
+// Late but not expired notification's text is marked that the event has already started,
+// post-event reminders' texts always contain the time since the start.
+func (m *userMsg) checkExpiry(now time.Time) bool {
+	if !m.expire.IsZero() && now.After(m.expire) {
+		return true
+	}
+	switch {
+	case m.delay < 0:
+		m.text += "\n\nStarted " + sinceStart(now.Sub(m.start)) + " ago"
+	case !m.expire.IsZero() && now.After(m.start):
+		m.text += fmt.Sprintf("\n\nAlready started %d minutes ago", int(now.Sub(m.start).Minutes()))
+	}
+	return false
+}
+
+// sinceStart returns a time after the event's start in minutes or seconds if it is less than a minute.
+func sinceStart(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
+
+// pending returns persistent info about the notification.
+func (m *userMsg) pending() pendingMsg {
+	return pendingMsg{User: m.user, Event: m.event, Delay: delayValue(m.delay), Start: m.start}
 }
 
 // Send prepares and sends notification to the user.
 func (m *userMsg) Send() error {
-	message := m.bot.NewTextMessage(m.user, m.text)
-	btn := botgolang.NewURLButton("URL", m.url)
-
-	keyboard := botgolang.NewKeyboard()
-	keyboard.AddRow(btn)
-	message.AttachInlineKeyboard(keyboard)
-	return message.Send()
+	text := m.text
+	keyboard, ok := buildKeyboard(m.buttons())
+	if m.plain {
+		// buttons are inlined as text
+		text, ok = PlainText(text, &keyboard), false
+	}
+	message := m.bot.NewTextMessage(m.user, text)
+	if ok {
+		message.AttachInlineKeyboard(keyboard)
+	}
+	if err := message.Send(); err != nil {
+		return err
+	}
+	m.msgID = message.ID
+	return nil
 }
 
 // userEvent is user's alarm record.
 type userEvent struct {
-	user        string
-	event       *Event
-	delay       int
-	delayOffset time.Duration
-	timestamp   time.Time
+	user      string
+	event     *Event
+	delay     time.Duration // notification's offset before the event start, negative ones are after it
+	timestamp time.Time
+	zone      *time.Location // user's time zone, nil for event's one
+	created   time.Time      // time of user's settings changes, zero for loaded ones
+	index     int            // position in the storage's items queue
 }
 
 // String is a string representation of user's event.
@@ -155,48 +774,126 @@ func (ue *userEvent) String() string {
 
 // Message returns prepared user's event message.
 func (ue *userEvent) Message(b *botgolang.Bot) userMsg {
+	start := ue.timestamp.Add(ue.delay)
+	// templates are checked during event init
+	url, err := ue.event.link(start)
+	if err != nil {
+		url = ue.event.URL
+	}
+	checkURL, err := ue.event.checkLink(start)
+	if err != nil {
+		checkURL = ue.event.CheckURL
+	}
+	label, err := ue.event.label(start)
+	if err != nil {
+		label = ue.event.Button
+	}
+	var expire time.Time
+	if ue.event.expires {
+		// post-event reminders' lateness is counted from their own time
+		expire = start.Add(ue.event.lateness)
+		if ue.delay < 0 {
+			expire = ue.timestamp.Add(ue.event.lateness)
+		}
+	}
+	text, variant, ack := ue.event.text(start), "", msgButton{}
+	if v := ue.event.randomVariant(); v != nil {
+		text, variant = ue.event.textOf(start, v.Message), v.Name
+		if ue.event.AckButton != "" {
+			ack = ue.event.ackButton(v.Name)
+		}
+	}
 	return userMsg{
-		user:  ue.user,
-		text:  ue.event.text(),
-		url:   ue.event.URL,
-		start: ue.timestamp.Add(ue.delayOffset).Format(time.RFC3339),
-		bot:   b,
+		user:      ue.user,
+		text:      text,
+		url:       url,
+		label:     label,
+		urlSource: ue.event.URLSource,
+		checkURL:  checkURL,
+		mapURL:    ue.event.MapURL,
+		keyboard:  ue.event.keyboard(start),
+		event:     ue.event.Title,
+		delay:     ue.delay,
+		pin:       ue.event.Pin,
+		start:     start,
+		expire:    expire,
+		urgent:    ue.event.Urgent,
+		variant:   variant,
+		ack:       ack,
+		invite:    ue.event.inviteButton(start),
+		owner:     ue.event.Owner,
+		due:       ue.due(),
+		bot:       b,
 	}
 }
 
 // user is a client info struct.
 type user struct {
-	name   string
-	delays []int
+	name          string
+	delays        []time.Duration
+	role          Role
+	subscriptions []string                   // sorted events' titles, empty for all events
+	eventDelays   map[string][]time.Duration // events' own delays by their titles instead of common ones
+	zone          *time.Location             // time zone of events' clock, nil for events' zones
+	paused        time.Time                  // notifications' pause time, zero for not paused users
+	deleted       time.Time                  // soft deletion time, zero for active users
+	updated       time.Time                  // last in-memory change time, it isn't saved
+	// plain is user's mode of messages without markdown, emoji and keyboards
+	plain bool
+	// vacation is a period of skipped notifications, nil if it is not set
+	vacation *Vacation
+	// reminders are user's personal events
+	reminders []*reminder
+	// groupEvents are group chat's events for its members
+	groupEvents []*reminder
+	// members are sorted group chat's members, who get its events
+	members []string
 }
 
 // stringDelays returns space-separated user's details as a string.
 func (u *user) stringDelays() string {
-	delays := make([]string, len(u.delays))
-	for i, d := range u.delays {
-		delays[i] = strconv.Itoa(d)
-	}
-	return strings.Join(delays, " ")
+	return joinDelays(u.delays)
 }
 
 // init prepares user's event items.
 func (u *user) init(events []*Event) []*userEvent {
+	if !u.paused.IsZero() {
+		return make([]*userEvent, 0)
+	}
 	items := make([]*userEvent, 0, len(events)*len(u.delays))
 	now := time.Now()
 	for j, e := range events {
-		na := nextAlarm(e.alarm, now, e.offset)
-		for _, d := range u.delays {
-			offset := time.Duration(d) * time.Minute
+		if (e.group == "") && !u.subscribed(e.Title, e.tags) {
+			// group chats' events don't depend on subscriptions
+			continue
+		}
+		na := e.nextIn(now, u.zone)
+		for _, d := range u.delaysOf(e.Title) {
+			start := na
+			if d < 0 {
+				// a reminder after the already started occurrence can be ahead
+				start = e.nextIn(now.Add(d), u.zone)
+			}
+			if isNever(start) {
+				// one-shot event is already done
+				continue
+			}
 			i := &userEvent{
-				user:        u.name,
-				event:       events[j],
-				delay:       d,
-				delayOffset: offset,
-				timestamp:   na.Add(-offset),
+				user:      u.name,
+				event:     events[j],
+				delay:     d,
+				timestamp: start.Add(-d),
+				zone:      u.zone,
 			}
 			items = append(items, i)
 		}
 	}
+	for _, r := range u.reminders {
+		// personal reminders are sent at their start time
+		if start := r.event.nextIn(now, u.zone); !isNever(start) {
+			items = append(items, &userEvent{user: u.name, event: r.event, timestamp: start, zone: u.zone})
+		}
+	}
 	sort.Slice(items, func(i, j int) bool {
 		return items[i].timestamp.Before(items[j].timestamp)
 	})
@@ -204,162 +901,505 @@ func (u *user) init(events []*Event) []*userEvent {
 }
 
 // Storage is a main data storage struct.
+// Locks order is: persist, users' shard, sched.
 type Storage struct {
-	sync.RWMutex
-	events    []*Event
-	items     []*userEvent // items sorted by timestamp
-	limits    Limits
-	users     map[string]*user
-	usersFile string                  // user log file
-	userIdx   map[string][]*userEvent // user's items index
+	persist  sync.Mutex   // serializes users' modifications and saving
+	sched    sync.RWMutex // protects events, items and restored
+	pinMu    sync.Mutex   // protects pins
+	shards   [shardsCount]*shard
+	events   []*Event
+	items    itemsQueue // items ordered by timestamp
+	limits   Limits
+	backend  backend              // users' persistent storage
+	source   string               // users' storage source
+	pins     map[pinKey]pinnedMsg // pinned messages in group chats
+	restored []pendingMsg         // not sent notifications from the previous run
+	admins   map[string]bool      // permanent administrators
+	updates  int                  // number of incremental updates after full saving
+	dropped  map[string]time.Time // removed users' deletion time after full saving
+	wake     chan struct{}        // signals about new scheduled items
+	lookback time.Duration        // window to check missed notifications
+	ledger   *ledger              // handled notifications, nil if lookback is disabled
+	// unreachable are users which chats were not available during the last probe
+	unreachable map[string]time.Time
+	history     *history  // the latest deliveries, nil if it is disabled
+	auditLog    *auditLog // settings changes, nil if it is disabled
+	quota       *quota    // chats' daily notifications, nil if it is disabled
+	merger      *merger   // close notifications' deduplication, nil if it is disabled
+	bounces     *bounces  // users' consecutive send errors
+	// aead is users' files encryption, nil - plain files
+	aead cipher.AEAD
+	// maintenance is not zero if notifications' dispatch is suspended, it is used atomically
+	maintenance int32
+	// queues is a file of not sent replies and deferred notifications between runs, empty - disabled
+	queues string
+	// replies are not sent commands' replies of the previous run or for the next one
+	replies []QueuedReply
+	// variants are events' message variants counters
+	variants *variantStats
+	// anon hashes chat IDs of the audit log and exports, it is nil if the anonymized mode is disabled
+	anon *anonymizer
+	// breaches is a number of notifications sent after their latency budget, it is used atomically
+	breaches int64
+	// sources serializes updates of static events from the configuration file and remote ones
+	sources sync.Mutex
+	static  []*Event
+	remote  []*Event
+	// disabled are events' titles without notifications until the time, zero - until enabling, sched protects it
+	disabled map[string]time.Time
+	// groupEvents and groupMembers are an index of group chats' events and members, sched protects it
+	groupEvents  map[groupKey]*Event
+	groupMembers map[string][]string
 }
 
 // New reads usersSource file, combines them with events and creates a new Storage object.
-func New(usersSource string, events []*Event, l Limits) (*Storage, error) {
-	users, usersFile, err := loadUsers(usersSource)
+// The CSV file is used by default, files with ".db" or ".bolt" extensions are BoltDB storages,
+// ".json" files are JSON arrays of users.
+func New(usersSource string, events []*Event, l Limits, a Access) (*Storage, error) {
+	aead, err := newCipher(a.Secret)
+	if err != nil {
+		return nil, err
+	}
+	b, err := openSealedBackend(usersSource, aead)
+	if err != nil {
+		return nil, err
+	}
+	users, err := migrate(b)
 	if err != nil {
+		_ = b.close()
 		return nil, err
 	}
-	s := &Storage{events: events, usersFile: usersFile, limits: l}
-	s.init(users)
+	s := &Storage{
+		events:      events,
+		static:      events,
+		backend:     b,
+		source:      usersSource,
+		limits:      l,
+		pins:        make(map[pinKey]pinnedMsg),
+		admins:      make(map[string]bool, len(a.Admins)),
+		wake:        make(chan struct{}, 1),
+		dropped:     make(map[string]time.Time),
+		unreachable: make(map[string]time.Time),
+		disabled:    make(map[string]time.Time),
+		history:     newHistory(l.History),
+		quota:       newQuota(l.Quota),
+		merger:      newMerger(l.Merge),
+		bounces:     newBounces(),
+		variants:    newVariantStats(),
+		anon:        newAnonymizer(a.Anonymize),
+		aead:        aead,
+		lookback:    time.Duration(l.Lookback) * time.Minute,
+	}
+	for _, admin := range a.Admins {
+		s.admins[admin] = true
+	}
+	if pb, ok := b.(pendingBackend); ok {
+		if s.restored, err = pb.loadPending(); err != nil {
+			_ = b.close()
+			return nil, err
+		}
+	}
+	if s.lookback > 0 {
+		var keys map[string]time.Time
+		if lb, ok := b.(ledgerBackend); ok {
+			if keys, err = lb.loadLedger(time.Now().Add(-s.lookback)); err != nil {
+				_ = b.close()
+				return nil, err
+			}
+		}
+		s.ledger = newLedger(keys)
+	}
+	if s.auditLog, err = openAudit(a.Audit); err != nil {
+		_ = b.close()
+		return nil, err
+	}
+	for i := range s.shards {
+		s.shards[i] = newShard()
+	}
+	s.fill(users, time.Time{})
 	return s, nil
 }
 
-// init builds base storage's structures.
-func (s *Storage) init(users []*user) {
-	s.Lock()
-	n := len(users)
-	s.users = make(map[string]*user, n)
-	s.userIdx = make(map[string][]*userEvent, n)
-	s.items = make([]*userEvent, 0, n) // n is only minimal hint
+// SetEvents replaces storage's events and rebuilds all users' items.
+// It returns messages about changed and cancelled events.
+func (s *Storage) SetEvents(events []*Event) []EventChange {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	for _, sh := range s.shards {
+		sh.Lock()
+		defer sh.Unlock()
+	}
+	s.sched.Lock()
+	defer s.sched.Unlock()
+
+	now := time.Now()
+	changes := diffEvents(s.events, events, now)
+	s.events = events
+	allItems := make([]*userEvent, 0, len(s.items))
+	for _, sh := range s.shards {
+		for name, u := range sh.users {
+			items := u.init(s.eventsOf(name))
+			for _, item := range items {
+				item.created = now
+			}
+			sh.userIdx[name] = items
+			allItems = append(allItems, items...)
+		}
+	}
+	s.items.reset(allItems)
+	s.schedule()
+	return changes
+}
+
+// fill builds base storage's structures from users.
+// The caller should hold all storage locks if it is used concurrently.
+func (s *Storage) fill(users []*user, created time.Time) {
+	for _, sh := range s.shards {
+		sh.users = make(map[string]*user)
+		sh.removed = make(map[string]*user)
+		sh.userIdx = make(map[string][]*userEvent)
+	}
+	s.groupEvents, s.groupMembers = make(map[groupKey]*Event), make(map[string][]string)
+	for _, u := range users {
+		if len(u.groupEvents) > 0 {
+			s.indexGroup(u.name, u)
+		}
+	}
+	allItems := make([]*userEvent, 0, len(users)) // it is only minimal hint
 	for i, u := range users {
-		items := users[i].init(s.events)
-		s.users[u.name] = users[i]
-		s.userIdx[u.name] = items
-		s.items = append(s.items, items...)
+		sh := s.shard(u.name)
+		if !u.deleted.IsZero() {
+			sh.removed[u.name] = users[i]
+			continue
+		}
+		items := users[i].init(s.eventsOf(u.name))
+		for _, item := range items {
+			item.created = created
+		}
+		sh.users[u.name] = users[i]
+		sh.userIdx[u.name] = items
+		allItems = append(allItems, items...)
 	}
-	sort.Slice(s.items, func(i, j int) bool {
-		return s.items[i].timestamp.Before(s.items[j].timestamp)
-	})
-	s.Unlock()
+	s.items.reset(allItems)
 }
 
-// Start creates new user's notifications scheduler.
-func (s *Storage) Start(userName string) error {
-	s.Lock()
-	defer s.Unlock()
+// Reload re-reads users from usersSource and rebuilds their notifications without restart.
+// If usersSource differs from the current one, it replaces the storage's backend.
+func (s *Storage) Reload(ctx context.Context, usersSource string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b := s.backend
+	if usersSource != s.source {
+		nb, err := openSealedBackend(usersSource, s.aead)
+		if err != nil {
+			return fmt.Errorf("reload users: %w", err)
+		}
+		b = nb
+	}
+	users, err := migrate(b)
+	if err != nil {
+		if b != s.backend {
+			_ = b.close()
+		}
+		return fmt.Errorf("reload users: %w", err)
+	}
+	for _, sh := range s.shards {
+		sh.Lock()
+		defer sh.Unlock()
+	}
+	s.sched.Lock()
+	defer s.sched.Unlock()
+
+	s.fill(users, time.Now())
+	s.updates = 0
+	s.dropped = make(map[string]time.Time)
+	s.schedule()
+	if b != s.backend {
+		prev := s.backend
+		s.backend, s.source = b, usersSource
+		if err = prev.close(); err != nil {
+			return fmt.Errorf("close previous users storage: %w", err)
+		}
+	}
+	return nil
+}
 
-	if n := len(s.users); n >= s.limits.Users {
-		return fmt.Errorf("too many users %d > %d", n, s.limits.Users)
+// Start creates new user's notifications scheduler, paused or soft deleted users' settings are restored.
+func (s *Storage) Start(ctx context.Context, userName string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if u, ok := s.user(userName); ok {
+		if u.paused.IsZero() {
+			// already know user
+			return ErrKnownUser
+		}
+		return s.resume(ctx, u, AuditStart)
+	}
+	if n := s.usersCount(); n >= s.limits.Users {
+		return fmt.Errorf("too many users %d > %d: %w", n, s.limits.Users, ErrLimit)
 	}
-	if _, ok := s.users[userName]; ok {
-		// already know user
-		return ErrKnownUser
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.removed[userName]
+	old := u.auditState()
+	if ok {
+		// restore soft deleted user's settings
+		delete(sh.removed, userName)
+		u.deleted, u.updated = time.Time{}, time.Now()
+		sh.users[userName] = u
+		sh.userIdx[userName] = s.schedItems(u, nil)
+	} else {
+		u = &user{name: userName, delays: s.limits.defaultDelays(), updated: time.Now()}
+		sh.users[userName] = u
+		sh.userIdx[userName] = s.schedItems(u, nil)
 	}
-	u := &user{name: userName}
-	s.users[userName] = u
-	s.userIdx[userName] = make([]*userEvent, 0)
-	// no new s.items for new user
-	err := s.flush()
+	err := s.flushUsers(ctx, userName)
 	if err != nil {
 		return fmt.Errorf("start user=%s: %w", userName, err)
 	}
-	return nil
+	return s.audit(u, AuditStart, old)
 }
 
-// Stop removes user from the storage.
-func (s *Storage) Stop(userName string) error {
-	s.Lock()
-	defer s.Unlock()
+// Stop pauses user's notifications, the user's settings are kept for /resume.
+func (s *Storage) Stop(ctx context.Context, userName string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
 
-	_, ok := s.users[userName]
+	u, ok := sh.users[userName]
 	if !ok {
 		return ErrUnknownUser
 	}
-	delete(s.users, userName)
-	delete(s.userIdx, userName)
-
-	storageItems := make([]*userEvent, 0, len(s.items))
-	for j, i := range s.items {
-		if i.user != userName {
-			// save other users' items
-			storageItems = append(storageItems, s.items[j])
-		}
+	if !u.paused.IsZero() {
+		return ErrPaused
 	}
-	s.items = storageItems
-	sort.Slice(s.items, func(i, j int) bool {
-		return s.items[i].timestamp.Before(s.items[j].timestamp)
-	})
-	err := s.flush()
+	old := u.auditState()
+	s.unschedItems(sh.userIdx[userName])
+	u.updated = time.Now()
+	u.paused = u.updated
+	sh.userIdx[userName] = make([]*userEvent, 0)
+	err := s.flushUsers(ctx, userName)
 	if err != nil {
 		return fmt.Errorf("stop user=%s: %w", userName, err)
 	}
-	return nil
+	return s.audit(u, AuditStop, old)
 }
 
 // Get returns user's delays.
-func (s *Storage) Get(userName string) (string, error) {
-	s.RLock()
-	defer s.RUnlock()
+func (s *Storage) Get(ctx context.Context, userName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
 
-	u, ok := s.users[userName]
+	u, ok := sh.users[userName]
 	if !ok {
 		return "", ErrUnknownUser
 	}
-	if len(u.delays) == 0 {
+	if !u.paused.IsZero() {
+		return fmt.Sprintf("Your parameters: %s\n\nNotifications are paused, use /resume", u.stringDelays()), nil
+	}
+	if (len(u.delays) == 0) && (len(u.eventDelays) == 0) {
 		return "You have not notifications", nil
 	}
-	result := fmt.Sprintf("Your parameters: %s\n\nNotifications:", u.stringDelays())
-	for _, ue := range s.userIdx[userName] {
+	result := fmt.Sprintf("Your parameters: %s", u.stringDelays())
+	for _, title := range u.eventTitles() {
+		result += fmt.Sprintf("\n%s: %s", title, joinDelays(u.eventDelays[title]))
+	}
+	if u.zone != nil {
+		result += fmt.Sprintf("\nTime zone: %s", u.zoneName())
+	}
+	if (u.vacation != nil) && u.vacation.To.After(time.Now()) {
+		result += fmt.Sprintf("\nVacation: %s", u.vacation)
+	}
+	if n := len(u.reminders); n > 0 {
+		result += fmt.Sprintf("\nPersonal reminders: %d", n)
+	}
+	result += "\n\nNotifications:"
+	s.sched.RLock()
+	for _, ue := range sh.userIdx[userName] {
 		result += fmt.Sprintf("\n%s", ue.String())
 	}
+	s.sched.RUnlock()
 	return result, nil
 }
 
-// Set changes user's delay values
-func (s *Storage) Set(userName, values string) error {
+// Set changes user's delay values, values started by event's title change only the event's delays,
+// "default" value after the title restores common delays for the event.
+func (s *Storage) Set(ctx context.Context, userName, values string) error {
 	if values == "" {
 		return ErrSetUser
 	}
-	s.Lock()
-	defer s.Unlock()
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
 
-	u, ok := s.users[userName]
+	u, ok := sh.users[userName]
 	if !ok {
 		return ErrUnknownUser
 	}
-	_, delays, err := parseUserRow([]string{userName, values}, s.limits.MaxDelay, s.limits.MinDelay, s.limits.Delays)
-	if err != nil {
-		return fmt.Errorf("set user: %w", err)
+	s.sched.RLock()
+	title, values := splitEventParams(values, s.events)
+	s.sched.RUnlock()
+
+	var (
+		delays []time.Duration
+		err    error
+	)
+	if (title == "") || (strings.ToLower(values) != commonDelays) {
+		_, delays, err = parseUserRow([]string{userName, values}, s.limits.MinDelay, s.limits.MaxDelay, s.limits.MaxAfter, s.limits.Delays)
+		if err != nil {
+			return fmt.Errorf("set user: %w", err)
+		}
 	}
-	u.delays = delays
-	items := u.init(s.events)
-	s.users[u.name] = u
-	s.userIdx[u.name] = items
+	old := u.auditState()
+	if title == "" {
+		u.delays = delays
+	} else {
+		// the map is replaced, so its previous copies are not changed
+		eventDelays := make(map[string][]time.Duration, len(u.eventDelays)+1)
+		for t, d := range u.eventDelays {
+			eventDelays[t] = d
+		}
+		if delays == nil {
+			delete(eventDelays, title)
+		} else {
+			eventDelays[title] = delays
+		}
+		if len(eventDelays) == 0 {
+			eventDelays = nil
+		}
+		u.eventDelays = eventDelays
+	}
+	u.updated = time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
 	// save persistent data
-	if err = s.flush(); err != nil {
+	if err = s.flushUsers(ctx, userName); err != nil {
 		return fmt.Errorf("save updated user=%s: %w", userName, err)
 	}
-	storageItems := make([]*userEvent, 0, len(s.items))
-	for j, i := range s.items {
-		if i.user != u.name {
-			// save other users' items
-			storageItems = append(storageItems, s.items[j])
-		}
+	return s.audit(u, AuditSet, old)
+}
+
+// schedule signals that items were changed, so the nearest notification time can be different.
+func (s *Storage) schedule() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// already signaled
 	}
-	storageItems = append(storageItems, items...)
-	s.items = storageItems
-	sort.Slice(s.items, func(i, j int) bool {
-		return s.items[i].timestamp.Before(s.items[j].timestamp)
-	})
-	return nil
+}
+
+// wait returns a duration until the nearest item.
+func (s *Storage) wait(now time.Time) time.Duration {
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+
+	next := s.quota.next()
+	if item := s.items.first(); (item != nil) && (next.IsZero() || item.due().Before(next)) {
+		next = item.due()
+	}
+	if next.IsZero() {
+		return maxWait
+	}
+	d := next.Sub(now)
+	if d < 0 {
+		return 0
+	}
+	if d > maxWait {
+		return maxWait
+	}
+	return d
 }
 
 // Close does operations to safety save any data.
 func (s *Storage) Close() error {
-	s.Lock()
-	defer s.Unlock()
-	return s.flush()
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := s.auditLog.close(); err != nil {
+		_ = s.backend.close()
+		return fmt.Errorf("close audit file: %w", err)
+	}
+	if err := s.saveQueues(); err != nil {
+		_ = s.backend.close()
+		return err
+	}
+	if err := s.flush(context.Background()); err != nil {
+		_ = s.backend.close()
+		return err
+	}
+	return s.backend.close()
+}
+
+// event returns an event by its title. The caller should hold sched lock.
+func (s *Storage) event(title string) *Event {
+	for _, e := range s.events {
+		if e.Title == title {
+			return e
+		}
+	}
+	return nil
+}
+
+// restoredNotifications returns not sent notifications from the previous run.
+func (s *Storage) restoredNotifications(b *botgolang.Bot) []userMsg {
+	s.sched.Lock()
+	restored := s.restored
+	s.restored = nil
+	s.sched.Unlock()
+
+	notifications := make([]userMsg, 0, len(restored))
+	for _, p := range restored {
+		if !s.active(p.User) {
+			continue
+		}
+		s.sched.RLock()
+		e := s.event(p.Event)
+		s.sched.RUnlock()
+		if e == nil {
+			continue
+		}
+		ue := &userEvent{user: p.User, event: e, delay: p.Delay.Duration(), timestamp: p.Start.Add(-p.Delay.Duration())}
+		if m := ue.Message(b); s.ledger.claim(m.pending()) {
+			notifications = append(notifications, m)
+		}
+	}
+	return notifications
+}
+
+// markPending saves the notification as not sent if the backend supports it.
+func (s *Storage) markPending(m *userMsg) error {
+	if pb, ok := s.backend.(pendingBackend); ok {
+		return pb.addPending(m.pending())
+	}
+	return nil
+}
+
+// markDone removes the notification from not sent ones if the backend supports it.
+func (s *Storage) markDone(m *userMsg) error {
+	if pb, ok := s.backend.(pendingBackend); ok {
+		return pb.removePending(m.pending())
+	}
+	return nil
 }
 
 // notifications checks new applied users' messages.
@@ -368,123 +1408,138 @@ func (s *Storage) notifications(b *botgolang.Bot) []userMsg {
 		now           = time.Now()
 		notifications = make([]userMsg, 0)
 	)
-	s.Lock()
-	defer s.Unlock()
-
-	for j := range s.items {
-		i := s.items[j]
-		if i.timestamp.Before(now) {
-			notifications = append(notifications, i.Message(b))
-			i.timestamp = i.timestamp.Add(i.event.offset)
-		} else {
-			break
-		}
+	notifications = append(notifications, s.restoredNotifications(b)...)
+	for _, m := range s.quota.due(now) {
+		// restored deferred notifications have no bot
+		m.bot = b
+		notifications = append(notifications, m)
 	}
-	sort.Slice(s.items, func(i, j int) bool {
-		return s.items[i].timestamp.Before(s.items[j].timestamp)
-	})
-	return notifications
-}
 
-// flush rewrites users CSV file. The caller should use storage locking.
-func (s *Storage) flush() error {
-	f, err := os.OpenFile(s.usersFile, os.O_WRONLY|os.O_TRUNC, 0660)
-	if err != nil {
-		return fmt.Errorf("users log open to save: %w", err)
+	s.sched.Lock()
+	defer s.sched.Unlock()
+	for i := s.items.first(); (i != nil) && i.due().Before(now); i = s.items.first() {
+		if m := i.Message(b); s.ledger.claim(m.pending()) {
+			notifications = append(notifications, m)
+		}
+		// skip missed occurrences, if the delay is greater than the event's period
+		after := i.timestamp.Add(i.delay).Add(time.Nanosecond)
+		if minAfter := now.Add(i.delay); after.Before(minAfter) {
+			after = minAfter
+		}
+		start := i.event.nextIn(after, i.zone)
+		if isNever(start) {
+			// one-shot event's item is not scheduled anymore
+			heap.Remove(&s.items, i.index)
+			continue
+		}
+		i.timestamp = start.Add(-i.delay)
+		heap.Fix(&s.items, i.index)
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-	rows := make([][]string, 0, len(s.users))
-	for userName, u := range s.users {
-		rows = append(rows, []string{userName, u.stringDelays()})
+	if s.ledger != nil {
+		notifications = append(notifications, s.missed(b, now)...)
 	}
-	// sort by username
-	sort.Slice(rows, func(i, j int) bool {
-		return rows[i][0] < rows[j][0]
-	})
-	w := csv.NewWriter(f)
-	if err = w.WriteAll(rows); err != nil {
-		return fmt.Errorf("users log write: %w", err)
+	return s.merger.dedup(notifications, now)
+}
+
+// flush saves all users' data including soft deleted ones.
+// The caller should hold persist lock, so users are not modified.
+func (s *Storage) flush(ctx context.Context) error {
+	groups := make([]map[string]*user, 0, 2*shardsCount)
+	for _, sh := range s.shards {
+		groups = append(groups, sh.users, sh.removed)
 	}
-	w.Flush()
-	if err = w.Error(); err != nil {
-		return fmt.Errorf("users log flush: %w", err)
+	s.updates = 0
+	users := sortedUsers(groups...)
+	if _, ok := s.backend.(leaseBackend); ok {
+		// users of other bot instances are kept in shared data
+		if err := s.backend.update(ctx, users, nil); err != nil {
+			return err
+		}
+	} else if err := s.backend.save(ctx, users); err != nil {
+		return err
 	}
+	s.dropped = make(map[string]time.Time)
 	return nil
 }
 
-// Show prints items info using logger l.
-func (s *Storage) Show(l *log.Logger) {
-	l.Println("show items info")
-	for i, x := range s.items {
-		l.Printf(
-			"[%d]: user=%s, delay=%d, event=%v, alarm=%v\n",
-			i, x.user, x.delay, x.event.Title, x.timestamp,
-		)
+// flushUsers saves only changed or removed users, the full data is saved
+// after every compactUpdates calls. The caller should hold persist lock.
+func (s *Storage) flushUsers(ctx context.Context, names ...string) error {
+	changed := make([]*user, 0, len(names))
+	removed := make([]string, 0)
+	for _, name := range names {
+		sh := s.shard(name)
+		if u, ok := sh.users[name]; ok {
+			changed = append(changed, u)
+		} else if u, ok = sh.removed[name]; ok {
+			changed = append(changed, u)
+		} else {
+			removed = append(removed, name)
+		}
+	}
+	if s.updates++; s.updates >= compactUpdates {
+		// full saving of shared backends doesn't delete unknown users
+		if len(removed) > 0 {
+			if err := s.backend.update(ctx, nil, removed); err != nil {
+				return err
+			}
+		}
+		return s.flush(ctx)
 	}
+	return s.backend.update(ctx, changed, removed)
 }
 
-func parseUserRow(userItem []string, minD, maxD, maxDelays int) (string, []int, error) {
-	const userValues = 2
-	if n := len(userItem); n != userValues {
-		return "", nil, fmt.Errorf("failed parse user data, len=%d: %v", n, userItem)
-	}
-	strDelays := strings.Split(strings.Trim(userItem[1], " "), " ")
-	uniqDelays := make(map[int]struct{}, len(strDelays))
-	for _, d := range strDelays {
-		j, err := strconv.Atoi(d)
-		if err != nil {
-			return "", nil, fmt.Errorf("failed parse user delays=%s, %v: %w", d, userItem, err)
-		}
-		if (minD > 0) && (j < minD) {
-			return "", nil, fmt.Errorf("too small delay %d < %d", j, minD)
-		}
-		if (maxD > 0) && (j > maxD) {
-			return "", nil, fmt.Errorf("too large delay %d > %d", j, maxD)
+// purge removes soft deleted users after the grace period.
+func (s *Storage) purge(ctx context.Context, now time.Time) (int, error) {
+	grace := time.Duration(s.limits.Grace) * time.Hour
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	names := make([]string, 0)
+	for _, sh := range s.shards {
+		sh.Lock()
+		for name, u := range sh.removed {
+			if now.Sub(u.deleted) >= grace {
+				delete(sh.removed, name)
+				s.dropped[name] = now
+				names = append(names, name)
+			}
 		}
-		uniqDelays[j] = struct{}{}
+		sh.Unlock()
 	}
-	lenDelays := len(uniqDelays)
-	if (maxDelays > 0) && (lenDelays > maxDelays) {
-		return "", nil, fmt.Errorf("too many user's delays %d > %d", lenDelays, maxDelays)
+	n := len(names)
+	if n == 0 {
+		return 0, nil
 	}
-	delays := make([]int, 0, lenDelays)
-	for d := range uniqDelays {
-		delays = append(delays, d)
+	if err := s.flushUsers(ctx, names...); err != nil {
+		return 0, fmt.Errorf("purge users: %w", err)
 	}
-	sort.Ints(delays)
-	return strings.Trim(userItem[0], " "), delays, nil
+	return n, nil
 }
 
-// loadUsers loads users' names and delays form a source CSV file.
-func loadUsers(usersFile string) ([]*user, string, error) {
-	fullPath, err := filepath.Abs(strings.Trim(usersFile, " "))
-	if err != nil {
-		return nil, "", fmt.Errorf("users log file: %w", err)
-	}
-	f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_RDONLY, 0640)
-	if err != nil {
-		return nil, "", fmt.Errorf("users log open: %w", err)
+// Show prints items info using logger l.
+func (s *Storage) Show(l *log.Logger) {
+	l.Println("show items info")
+	for i, x := range s.UpcomingItems(0) {
+		l.Printf(
+			"[%d]: user=%s, delay=%v, event=%v, alarm=%v\n",
+			i, x.User, x.Delay, x.Event, x.Timestamp,
+		)
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-	r := csv.NewReader(f)
-	records, err := r.ReadAll()
-	if err != nil {
-		return nil, "", fmt.Errorf("users log parse: %w", err)
+}
+
+// loadLocation returns the time zone by its IANA name,
+// ErrZoneInfo is returned if the time zones database is not available at all.
+func loadLocation(name string) (*time.Location, error) {
+	const knownZone = "Europe/London"
+	location, err := time.LoadLocation(name)
+	if err == nil {
+		return location, nil
 	}
-	userRecords := make([]*user, 0, len(records))
-	for _, userItem := range records {
-		// ignore delay limit during reading file data
-		name, delays, err := parseUserRow(userItem, 0, 0, 0)
-		if err != nil {
-			return nil, "", fmt.Errorf("users row parse: %w", err)
-		}
-		userRecords = append(userRecords, &user{name: name, delays: delays})
+	if _, e := time.LoadLocation(knownZone); e != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrZoneInfo)
 	}
-	return userRecords, fullPath, nil
+	return nil, err
 }
 
 // nextAlarm returns next alarm time after dt, offset is a repeatable alarm's period.
@@ -503,52 +1558,3 @@ func nextAlarm(alarm, dt time.Time, offset time.Duration) time.Time {
 	_, offsetAfter := next.Zone()
 	return next.Add(time.Second * time.Duration(offsetBefore-offsetAfter))
 }
-
-// Settings is a serve settings.
-type Settings struct {
-	*Logger
-	TickPeriod time.Duration
-	Workers    int
-	Bot        *botgolang.Bot
-}
-
-// Serve runs users' notifications handling monitoring.
-func Serve(ctx context.Context, s *Storage, st Settings) *sync.WaitGroup {
-	var (
-		wg       sync.WaitGroup
-		notifier = make(chan userMsg)
-	)
-	go func() {
-		ticker := time.NewTicker(st.TickPeriod)
-		defer func() {
-			ticker.Stop()
-			close(notifier)
-		}()
-		for {
-			select {
-			case <-ctx.Done():
-				st.Info.Println("db serve ctx done")
-				return
-			case <-ticker.C:
-				items := s.notifications(st.Bot)
-				st.Info.Printf("found for notifications %d items", len(items))
-				for i := range items {
-					notifier <- items[i]
-				}
-			}
-		}
-	}()
-	wg.Add(st.Workers)
-	for i := 0; i < st.Workers; i++ {
-		go func(j int) {
-			for m := range notifier {
-				st.Debug.Printf("handle notification [worker=%d]: %v", j, m.user)
-				if err := m.Send(); err != nil {
-					st.Error.Printf("failed send message worker=%d [%v]: %v", j, m, err)
-				}
-			}
-			wg.Done()
-		}(i)
-	}
-	return &wg
-}
@@ -1,10 +1,39 @@
 package db
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
 )
 
+// minutes returns delays from numbers of minutes.
+func minutes(values ...int) []time.Duration {
+	delays := make([]time.Duration, len(values))
+	for i, v := range values {
+		delays[i] = time.Duration(v) * time.Minute
+	}
+	return delays
+}
+
 func TestNextAlarm(t *testing.T) {
 	tz, err := time.LoadLocation("Europe/Berlin")
 	if err != nil {
@@ -97,3 +126,4264 @@ func TestNextAlarm(t *testing.T) {
 		})
 	}
 }
+
+func TestEventLink(t *testing.T) {
+	start := time.Date(2021, 10, 5, 15, 0, 0, 0, time.UTC)
+	cases := []struct {
+		url      string
+		expected string
+	}{
+		{url: "https://mysite", expected: "https://mysite"},
+		{url: "https://mysite/{{.Date}}", expected: "https://mysite/2021-10-05"},
+		{url: "https://mysite/?d={{.Date}}&t={{.Time}}", expected: "https://mysite/?d=2021-10-05&t=15:00"},
+	}
+	for i, c := range cases {
+		e := &Event{Title: "test", URL: c.url, Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+		if err := e.Init(); err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		link, err := e.link(start)
+		if err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		if link != c.expected {
+			t.Errorf("case [%d]: failed compare %v != %v", i, c.expected, link)
+		}
+	}
+	e := &Event{Title: "test", URL: "https://mysite/{{.Unknown}}", Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for unknown template field")
+	}
+	e = &Event{Title: "test", Button: "Join (starts {{.Time}})", Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if label, err := e.label(start); err != nil || label != "Join (starts 15:00)" {
+		t.Errorf("failed label %q: %v", label, err)
+	}
+}
+
+func TestUserMsgAllowed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	cases := []struct {
+		url      string
+		expected bool
+	}{
+		{url: "", expected: true},
+		{url: ts.URL + "/ok", expected: true},
+		{url: ts.URL + "/empty", expected: true},
+		{url: ts.URL + "/cancelled", expected: false},
+	}
+	for i, c := range cases {
+		m := &userMsg{checkURL: c.url}
+		allowed, err := m.Allowed()
+		if err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		if allowed != c.expected {
+			t.Errorf("case [%d]: failed compare %v != %v", i, c.expected, allowed)
+		}
+	}
+}
+
+func TestEventYearly(t *testing.T) {
+	e := &Event{Title: "Birthday", Date: "1990-03-15", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		dt       time.Time
+		expected time.Time
+	}{
+		{
+			name:     "before",
+			dt:       time.Date(2021, 1, 10, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2021, 3, 15, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "equal",
+			dt:       time.Date(2021, 3, 15, 10, 0, 0, 0, time.UTC),
+			expected: time.Date(2021, 3, 15, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "after",
+			dt:       time.Date(2021, 3, 15, 10, 0, 1, 0, time.UTC),
+			expected: time.Date(2022, 3, 15, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			a := e.next(c.dt)
+			if !a.Equal(c.expected) {
+				tt.Errorf("failed compare %v != %v", c.expected, a)
+			}
+		})
+	}
+	expected := "Birthday\n\n\n\n31 years"
+	if text := e.text(time.Date(2021, 3, 15, 10, 0, 0, 0, time.UTC)); text != expected {
+		t.Errorf("failed compare %q != %q", expected, text)
+	}
+}
+
+func TestErrThrottle(t *testing.T) {
+	var (
+		throttle = newErrThrottle()
+		errSend  = errors.New("send error")
+	)
+	if !throttle.add("user1", errSend) {
+		t.Error("first error should be logged")
+	}
+	for i := 0; i < 3; i++ {
+		if throttle.add("user1", errSend) {
+			t.Error("repeated error should be suppressed")
+		}
+	}
+	if !throttle.add("user2", errSend) {
+		t.Error("first error of another user should be logged")
+	}
+	summary := throttle.summary()
+	if n := len(summary); n != 1 {
+		t.Fatalf("unexpected summary length %d", n)
+	}
+	if s := summary[0]; s.user != "user1" || s.count != 3 {
+		t.Errorf("unexpected summary %v", s)
+	}
+	if !throttle.add("user1", errSend) {
+		t.Error("first error after summary should be logged")
+	}
+}
+
+func TestBoltBackend(t *testing.T) {
+	ctx := context.Background()
+	b, err := openBackend(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []*user{{name: "user1", delays: minutes(5, 30)}, {name: "user2", delays: minutes(10)}}
+	if err = b.save(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loaded); n != len(users) {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	for i, u := range loaded {
+		if u.name != users[i].name || u.stringDelays() != users[i].stringDelays() {
+			t.Errorf("failed compare user %v != %v", users[i], u)
+		}
+	}
+	pb, ok := b.(pendingBackend)
+	if !ok {
+		t.Fatal("bolt backend should keep pending notifications")
+	}
+	p := pendingMsg{User: "user1", Event: "test", Delay: 5, Start: time.Now()}
+	if err = pb.addPending(p); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := pb.loadPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].key() != p.key() {
+		t.Errorf("unexpected pending notifications %v", pending)
+	}
+	if err = pb.removePending(p); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = pb.loadPending(); err != nil || len(pending) != 0 {
+		t.Errorf("unexpected pending notifications %v: %v", pending, err)
+	}
+	if err = b.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSVBackendRoles(t *testing.T) {
+	ctx := context.Background()
+	b, err := openBackend(filepath.Join(t.TempDir(), "users.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.load(); err != nil {
+		t.Fatal(err)
+	}
+	users := []*user{
+		{name: "user1", delays: minutes(5, 30), role: RoleAdmin},
+		{name: "user2", role: RoleEditor},
+		{name: "user3", delays: minutes(10)},
+	}
+	if err = b.save(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loaded); n != len(users) {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	for i, u := range loaded {
+		if u.name != users[i].name || u.stringDelays() != users[i].stringDelays() || u.role != users[i].role {
+			t.Errorf("failed compare user %v != %v", users[i], u)
+		}
+	}
+	if _, err = ParseRole("superuser"); err != ErrRole {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStorageSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	// users were soft deleted by /stop before the pause support
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	deleted := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	data := fmt.Sprintf("user1,10 5,user,%s\nuser2,5,user,%s\n", deleted, deleted)
+	if err := os.WriteFile(fileName, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100, Grace: 1}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(ctx, "user1"); err != ErrUnknownUser {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if delays := s.shard("user1").users["user1"].stringDelays(); delays != "5 10" {
+		t.Errorf("failed restore delays: %q", delays)
+	}
+	n, err := s.purge(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected purged users %d", n)
+	}
+	if err = s.Start(ctx, "user2"); err != nil {
+		t.Fatal(err)
+	}
+	if delays := s.shard("user2").users["user2"].stringDelays(); delays != "" {
+		t.Errorf("unexpected delays after purge: %q", delays)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageDefaultDelays(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 2, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{60, 15, 60}}
+	s, err := New(filepath.Join(t.TempDir(), "users.json"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if delays := s.shard("user1").users["user1"].stringDelays(); delays != "15 60" {
+		t.Errorf("unexpected delays %q", delays)
+	}
+	if n := len(s.items); n != 2 {
+		t.Errorf("unexpected items length %d", n)
+	}
+}
+
+func TestStorageBounce(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 2, Delays: 5, MinDelay: 1, MaxDelay: 100, Bounce: 1, BounceAction: BounceRemove}
+	s, err := New(filepath.Join(t.TempDir(), "users.json"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, name := range []string{"user1", "user2"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var (
+		now     = time.Now()
+		sendErr = errors.New("chat not found")
+		week    = 7 * 24 * time.Hour
+	)
+	cases := []struct {
+		user     string
+		err      error
+		now      time.Time
+		expected string
+	}{
+		{user: "user1", err: sendErr, now: now},
+		{user: "user2", err: sendErr, now: now},
+		{user: "user2", now: now.Add(time.Hour)}, // successful sending resets errors
+		{user: "user2", err: sendErr, now: now.Add(week)},
+		{user: "user1", err: sendErr, now: now.Add(week), expected: BounceRemove},
+		{user: "user1", err: sendErr, now: now.Add(2 * week)},
+	}
+	for i, c := range cases {
+		action, err := s.bounce(ctx, &userMsg{user: c.user}, c.err, c.now)
+		if err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		if action != c.expected {
+			t.Errorf("case [%d]: unexpected action %q", i, action)
+		}
+	}
+	if n := s.usersCount(); n != 1 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if _, ok := s.shard("user1").removed["user1"]; !ok {
+		t.Error("bounced user is not removed")
+	}
+}
+
+func TestStoragePause(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.json")
+	l := Limits{Users: 1, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user1", "10 5"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Resume(ctx, "user1"); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Stop(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Stop(ctx, "user1"); !errors.Is(err, ErrPaused) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := len(s.items); n != 0 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	if result, err := s.Get(ctx, "user1"); err != nil || !strings.Contains(result, "paused") {
+		t.Errorf("unexpected result %q: %v", result, err)
+	}
+	// delays can be changed during the pause
+	if err = s.Set(ctx, "user1", "15 5"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.items); n != 0 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the pause is saved and the user still counts in the users' limit
+	if s, err = New(fileName, events, l, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user2"); !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Resume(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.items); n != 2 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	// start also resumes paused notifications
+	if err = s.Stop(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if delays := s.shard("user1").users["user1"].stringDelays(); delays != "5 15" || len(s.items) != 2 {
+		t.Errorf("unexpected delays %q or items %d", delays, len(s.items))
+	}
+	if err = s.Start(ctx, "user1"); !errors.Is(err, ErrKnownUser) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	var (
+		l        = Limits{Users: 50, Delays: 3, MinDelay: 5, MaxDelay: 7}
+		fileName = filepath.Join(t.TempDir(), "users.csv")
+	)
+	if err := Seed(fileName, l.Users+1, l); err == nil {
+		t.Error("expected error for too many users")
+	}
+	if err := Seed(fileName, l.Users, l); err != nil {
+		t.Fatal(err)
+	}
+	users, err := (&csvBackend{fileName: fileName}).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(users); n != l.Users {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	for _, u := range users {
+		if _, _, err = parseUserRow([]string{u.name, u.stringDelays()}, l.MinDelay, l.MaxDelay, l.MaxAfter, l.Delays); err != nil {
+			t.Errorf("invalid user %s: %v", u.name, err)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	const lastModified = "Tue, 05 Oct 2021 15:00:00 GMT"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte(`[{"title": "remote", "period": "168h", "time": "15h", "timezone": "UTC", "weekday": 2}]`))
+	}))
+	defer ts.Close()
+
+	hs := &httpSource{url: ts.URL}
+	events, ok, err := hs.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(events) != 1 || events[0].Title != "remote" || events[0].Weekday != time.Tuesday {
+		t.Errorf("unexpected events %v", events)
+	}
+	if _, ok, err = hs.Fetch(context.Background()); err != nil || ok {
+		t.Errorf("expected not modified events: %v", err)
+	}
+}
+
+func TestCSVBackendUpdate(t *testing.T) {
+	ctx := context.Background()
+	b := &csvBackend{fileName: filepath.Join(t.TempDir(), "users.csv")}
+	users := []*user{{name: "user1", delays: minutes(5)}, {name: "user2", delays: minutes(10)}}
+	if err := b.save(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	changed := []*user{{name: "user1", delays: minutes(15, 20)}, {name: "user3", role: RoleEditor}}
+	if err := b.update(ctx, changed, []string{"user2"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*user{changed[0], changed[1]}
+	if n := len(loaded); n != len(expected) {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	for i, u := range loaded {
+		if u.name != expected[i].name || u.stringDelays() != expected[i].stringDelays() || u.role != expected[i].role {
+			t.Errorf("failed compare user %v != %v", expected[i], u)
+		}
+	}
+	if _, err = os.Stat(b.logName()); !os.IsNotExist(err) {
+		t.Errorf("changes log should be compacted: %v", err)
+	}
+}
+
+func TestStorageNotifications(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{
+		{Title: "e1", Period: "24h", StartHour: "10h", TimeZone: "UTC"},
+		{Title: "e2", Period: "48h", StartHour: "15h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 3000}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2", "user3"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Set(ctx, name, "5 60 2880"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Stop(ctx, "user2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.items); n != 12 {
+		t.Fatalf("unexpected items length %d", n)
+	}
+	// items with 2880 minutes delay are always in the past
+	n := 0
+	for _, m := range s.notifications(nil) {
+		if m.delay == 2880*time.Minute {
+			n++
+		}
+	}
+	if n != 4 {
+		t.Errorf("unexpected notifications number %d", n)
+	}
+	for i := 1; i < len(s.items); i++ {
+		if s.items[i].timestamp.Before(s.items[(i-1)/2].timestamp) {
+			t.Fatalf("broken heap order at %d", i)
+		}
+	}
+	if first := s.items.first(); !first.timestamp.After(time.Now()) {
+		t.Errorf("unexpected first item %v", first)
+	}
+	select {
+	case <-s.wake:
+	default:
+		t.Error("no wake signal after items changes")
+	}
+	now := time.Now()
+	if d := s.wait(now); d != s.items.first().timestamp.Sub(now) && d != maxWait {
+		t.Errorf("unexpected wait duration %v", d)
+	}
+	if d := s.wait(now.Add(72 * time.Hour)); d != 0 {
+		t.Errorf("unexpected wait duration for the past item %v", d)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageConcurrency(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "e1", Period: "1h", StartHour: "0h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 100, Delays: 5, MinDelay: 1, MaxDelay: 3000}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := s.Start(ctx, name); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := s.Set(ctx, name, "120 5"); err != nil {
+				t.Error(err)
+			}
+			if _, err := s.Get(ctx, name); err != nil {
+				t.Error(err)
+			}
+			_ = s.notifications(nil)
+		}(fmt.Sprintf("user%d", i))
+	}
+	wg.Wait()
+	if n := s.usersCount(); n != 20 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = s.Set(canceled, "user1", "10"); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error for canceled context: %v", err)
+	}
+	if n := len(s.items); n != 40 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "e1", Period: "24h", StartHour: "10h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 3000, Grace: 1}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user2", "user1"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Set(ctx, name, "5 60"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Stop(ctx, "user2"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := s.Snapshot()
+	if n := len(snapshot.Users); n != 2 {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	if u := snapshot.Users[0]; u.Name != "user1" || u.Deleted != nil || u.Role != "user" {
+		t.Errorf("unexpected user %v", u)
+	}
+	if u := snapshot.Users[1]; u.Name != "user2" || u.Paused == nil || u.Deleted != nil {
+		t.Errorf("unexpected user %v", u)
+	}
+	if n := len(snapshot.Items); n != 2 {
+		t.Fatalf("unexpected items length %d", n)
+	}
+	if item := snapshot.Items[0]; item.Delay != time.Hour || !item.Start.Equal(item.Timestamp.Add(time.Hour)) {
+		t.Errorf("unexpected item %v", item)
+	}
+	if err = s.Set(ctx, "user1", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if delays := snapshot.Users[0].Delays; len(delays) != 2 {
+		t.Errorf("snapshot was modified %v", delays)
+	}
+	if _, err = json.Marshal(snapshot); err != nil {
+		t.Error(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageLedger(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "e1", Period: "1h", StartHour: "0h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.db")
+	b, err := openBackend(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.save(ctx, []*user{{name: "user1", delays: minutes(5)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.close(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 3000, Lookback: 120}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missed := s.notifications(nil)
+	if n := len(missed); n != 2 {
+		t.Fatalf("unexpected missed notifications number %d", n)
+	}
+	if n := len(s.notifications(nil)); n != 0 {
+		t.Errorf("unexpected repeated notifications number %d", n)
+	}
+	if err = s.markSent(&missed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// only not sent notification is repeated after restart
+	s, err = New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := s.notifications(nil); len(result) != 1 || result[0].start != missed[1].start {
+		t.Errorf("unexpected notifications after restart %v", result)
+	}
+	if err = s.cleanLedger(time.Now().Add(3 * time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if n := len(s.ledger.keys); n != 0 {
+		t.Errorf("unexpected ledger size %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageReload(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "e1", Period: "24h", StartHour: "10h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 3000}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user1", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// manual file changes
+	if err = os.WriteFile(fileName, []byte("user1,10 20\nuser2,30\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(ctx, fileName); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.usersCount(); n != 2 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if n := len(s.items); n != 3 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	if delays := s.shard("user1").users["user1"].stringDelays(); delays != "10 20" {
+		t.Errorf("unexpected delays %q", delays)
+	}
+	// another source replaces the backend
+	if err = s.Reload(ctx, filepath.Join(t.TempDir(), "users.db")); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.usersCount(); n != 0 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageMerge(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "e1", Period: "24h", StartHour: "10h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(fileName, []byte("user1,5\nuser3,15\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 3000}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now()
+	if err = s.Set(ctx, "user1", "10"); err != nil {
+		t.Fatal(err)
+	}
+	users := []*user{{name: "user1", delays: minutes(20)}, {name: "user2", delays: minutes(30)}}
+	ms, err := s.merge(ctx, users, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "added=[user2], updated=[], removed=[user3], kept in-memory=[user1]"; ms.String() != expected {
+		t.Errorf("failed compare %q != %q", expected, ms.String())
+	}
+	if delays := s.shard("user1").users["user1"].stringDelays(); delays != "10" {
+		t.Errorf("unexpected delays %q", delays)
+	}
+	if n := len(s.items); n != 2 {
+		t.Errorf("unexpected items length %d", n)
+	}
+	// merged data is saved, so the next merge has no changes
+	if ms, err = s.mergeFile(ctx, fileName); err != nil {
+		t.Fatal(err)
+	}
+	if ms.changed() || len(ms.conflicts) > 0 {
+		t.Errorf("unexpected changes %v", ms)
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSVBackendLock(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	b, err := openBackend(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = openBackend(fileName); !errors.Is(err, ErrLocked) {
+		t.Errorf("unexpected error for locked file: %v", err)
+	}
+	if err = b.close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = openBackend(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONBackend(t *testing.T) {
+	ctx := context.Background()
+	b, err := openBackend(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("unexpected users %v", users)
+	}
+	users = []*user{
+		{name: "user1", delays: minutes(5, 30), role: RoleAdmin},
+		{name: "user2", delays: minutes(10), deleted: time.Date(2021, 10, 5, 15, 0, 0, 0, time.UTC)},
+	}
+	if err = b.save(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.update(ctx, []*user{{name: "user3"}}, []string{"user1"}); err != nil {
+		t.Fatal(err)
+	}
+	if users, err = b.load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(users); n != 2 {
+		t.Fatalf("unexpected users length %d", n)
+	}
+	if u := users[0]; u.name != "user2" || u.stringDelays() != "10" || !u.deleted.Equal(time.Date(2021, 10, 5, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected user %v", u)
+	}
+	if u := users[1]; u.name != "user3" || len(u.delays) != 0 || u.role != RoleUser {
+		t.Errorf("unexpected user %v", u)
+	}
+	if err = b.close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"users.csv":  "user1,5 30\nuser2,10,admin\n",
+		"users.json": `[{"chat_id":"user1","delays":[5,30]},{"chat_id":"user2","delays":[10],"role":"admin"}]`,
+	}
+	for name, content := range files {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		b, err := openBackend(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := b.version(); err != nil || v != 0 {
+			t.Errorf("%s: unexpected version %d, error %v", name, v, err)
+		}
+		users, err := migrate(b)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := len(users); n != 2 || users[1].role != RoleAdmin {
+			t.Errorf("%s: unexpected users %v", name, users)
+		}
+		if v, err := b.version(); err != nil || v != schemaVersion {
+			t.Errorf("%s: unexpected version %d, error %v", name, v, err)
+		}
+		if users, err = b.load(); err != nil || len(users) != 2 {
+			t.Errorf("%s: unexpected users %v, error %v", name, users, err)
+		}
+		if err = b.close(); err != nil {
+			t.Error(err)
+		}
+	}
+	fileName := filepath.Join(dir, "newer.csv")
+	if err := os.WriteFile(fileName, []byte("#version,100\nuser1,5\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(fileName, nil, Limits{}, Access{})
+	if !errors.Is(err, ErrSchema) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestLoggerTrace(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = NewLogger(false)
+	)
+	logger.trace = log.New(&buf, "", 0)
+	logger.Trace("chat1", "not traced")
+	logger.SetTrace("chat1", true)
+	logger.Trace("chat1", "traced %d", 1)
+	logger.Trace("chat2", "not traced")
+	logger.SetTrace("chat1", false)
+	logger.Trace("chat1", "not traced")
+	if expected := "[chat1] traced 1\n"; buf.String() != expected {
+		t.Errorf("failed compare %q != %q", expected, buf.String())
+	}
+}
+
+func TestEventExpiry(t *testing.T) {
+	for i, value := range []string{"abc", "-10m"} {
+		e := &Event{Title: "test", Period: "24h", StartHour: "15h", TimeZone: "UTC", Expire: value}
+		if err := e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error for %q", i, value)
+		}
+	}
+	start := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	cases := []struct {
+		expire  string
+		now     time.Time
+		expired bool
+		late    bool
+	}{
+		{now: start.Add(time.Hour)},
+		{expire: "0s", now: start.Add(-time.Minute)},
+		{expire: "0s", now: start.Add(time.Second), expired: true},
+		{expire: "10m", now: start.Add(5 * time.Minute), late: true},
+		{expire: "10m", now: start.Add(11 * time.Minute), expired: true},
+	}
+	for i, c := range cases {
+		e := &Event{Title: "test", Period: "24h", StartHour: "15h", TimeZone: "UTC", Expire: c.expire}
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+		ue := &userEvent{user: "user1", event: e, delay: 30 * time.Minute, timestamp: start.Add(-30 * time.Minute)}
+		m := ue.Message(nil)
+		if expired := m.checkExpiry(c.now); expired != c.expired {
+			t.Errorf("case [%d]: unexpected expired=%v", i, expired)
+		}
+		if late := strings.Contains(m.text, "Already started"); late != c.late {
+			t.Errorf("case [%d]: unexpected text %q", i, m.text)
+		}
+	}
+}
+
+func TestEventBlackouts(t *testing.T) {
+	for i, b := range []Blackout{{From: "2030-01-05", To: "2030-01-03"}, {From: "12-20", To: "2030-01-05"}, {From: "13-01", To: "01-05"}} {
+		e := &Event{Title: "test", Period: "24h", StartHour: "15h", TimeZone: "UTC", Blackouts: []Blackout{b}}
+		if err := e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error", i)
+		}
+	}
+	e := &Event{
+		Title: "test", Period: "24h", StartHour: "15h", TimeZone: "UTC",
+		Blackouts: []Blackout{{From: "2030-01-03", To: "2030-01-05"}, {From: "12-31", To: "01-01"}},
+	}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		dt       time.Time
+		expected time.Time
+	}{
+		{dt: time.Date(2030, 1, 2, 14, 0, 0, 0, time.UTC), expected: time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{dt: time.Date(2030, 1, 2, 16, 0, 0, 0, time.UTC), expected: time.Date(2030, 1, 6, 15, 0, 0, 0, time.UTC)},
+		{dt: time.Date(2030, 12, 30, 16, 0, 0, 0, time.UTC), expected: time.Date(2031, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{dt: time.Date(2031, 1, 1, 10, 0, 0, 0, time.UTC), expected: time.Date(2031, 1, 2, 15, 0, 0, 0, time.UTC)},
+	}
+	for i, c := range cases {
+		if start := e.since(c.dt); !start.Equal(c.expected) {
+			t.Errorf("case [%d]: unexpected start %v", i, start)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	var q *quota
+	if ok, inform := q.take("user1", time.Now(), false); !ok || inform {
+		t.Error("disabled quota limits notifications")
+	}
+	q = newQuota(2)
+	now := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	cases := []struct {
+		chat   string
+		now    time.Time
+		urgent bool
+		ok     bool
+		inform bool
+	}{
+		{chat: "user1", now: now, ok: true},
+		{chat: "user1", now: now, ok: true},
+		{chat: "user2", now: now, ok: true},
+		{chat: "user1", now: now, inform: true},
+		{chat: "user1", now: now},
+		{chat: "user1", now: now, urgent: true, ok: true},
+		{chat: "user1", now: now.Add(9 * time.Hour), ok: true}, // the next UTC day
+	}
+	for i, c := range cases {
+		if ok, inform := q.take(c.chat, c.now, c.urgent); (ok != c.ok) || (inform != c.inform) {
+			t.Errorf("case [%d]: unexpected ok=%v inform=%v", i, ok, inform)
+		}
+	}
+	q.postpone(userMsg{user: "user1", event: "test"}, now)
+	if next := q.next(); !next.Equal(time.Date(2030, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next %v", next)
+	}
+	if n := len(q.due(now)); n != 0 {
+		t.Errorf("unexpected due %d", n)
+	}
+	if items := q.due(now.Add(9 * time.Hour)); (len(items) != 1) || (items[0].event != "test") {
+		t.Errorf("unexpected due %v", items)
+	}
+	if next := q.next(); !next.IsZero() {
+		t.Errorf("unexpected next %v", next)
+	}
+}
+
+func TestMergerDedup(t *testing.T) {
+	start := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	notifications := []userMsg{
+		{user: "user1", event: "test", delay: 15 * time.Minute, start: start},
+		{user: "user1", event: "test", delay: 14 * time.Minute, start: start},
+		{user: "user1", event: "test", delay: 5 * time.Minute, start: start},
+		{user: "user2", event: "test", delay: 14 * time.Minute, start: start},
+		{user: "user1", event: "other", delay: 14 * time.Minute, start: start},
+	}
+	var mr *merger
+	if n := len(mr.dedup(notifications[:2], start)); n != 2 {
+		t.Errorf("disabled merger drops notifications: %d", n)
+	}
+	mr = newMerger(2)
+	result := mr.dedup(notifications, start.Add(-14*time.Minute))
+	expected := []time.Duration{15 * time.Minute, 5 * time.Minute, 14 * time.Minute, 14 * time.Minute}
+	if n := len(result); n != len(expected) {
+		t.Fatalf("unexpected notifications %d", n)
+	}
+	for i, m := range result {
+		if m.delay != expected[i] {
+			t.Errorf("case [%d]: unexpected delay %v", i, m.delay)
+		}
+	}
+	// deferred or restored notification is not merged with itself
+	if n := len(mr.dedup([]userMsg{notifications[0]}, start.Add(-14*time.Minute))); n != 1 {
+		t.Errorf("unexpected notifications %d", n)
+	}
+}
+
+func TestDiffEvents(t *testing.T) {
+	events := []*Event{
+		{Title: "standup", Period: "24h", StartHour: "10h", TimeZone: "UTC"},
+		{Title: "retro", Period: "168h", StartHour: "15h", TimeZone: "UTC", Weekday: 5},
+		{Title: "demo", Period: "168h", StartHour: "16h", TimeZone: "UTC"},
+	}
+	updated := []*Event{
+		{Title: "standup", Period: "24h", StartHour: "11h", TimeZone: "UTC"},
+		{Title: "retro", Period: "168h", StartHour: "15h", TimeZone: "UTC", Weekday: 5},
+		{Title: "planning", Period: "168h", StartHour: "12h", TimeZone: "UTC"},
+	}
+	for _, e := range append(events, updated...) {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2030, 1, 2, 12, 0, 0, 0, time.UTC)
+	changes := diffEvents(events, updated, now)
+	expected := []EventChange{
+		{Title: "standup", Text: "standup is changed: time 10:00 -> 11:00\n\nNext: Thu, 03 Jan 2030 11:00 UTC"},
+		{Title: "demo", Text: "demo is cancelled"},
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("failed compare %q != %q", expected, changes)
+	}
+}
+
+func TestCSVEncryption(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(fileName, []byte("user1,5 10\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(fileName, nil, Limits{Users: 10}, Access{Secret: "secret"}); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error for not base64 key: %v", err)
+	}
+	a := Access{Secret: "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="}
+	s, err := New(fileName, nil, Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile := func(name string) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte(sealPrefix)) || bytes.Contains(data, []byte("user")) {
+			t.Errorf("not encrypted file %s: %q", name, data)
+		}
+	}
+	if err = s.Start(ctx, "user2"); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(fileName + csvLogSuffix)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(fileName)
+	if _, err = New(fileName, nil, Limits{Users: 10}, Access{Secret: "b29vb29vb29vb29vb29vb29vb29vb29vb29vb29vb28="}); !errors.Is(err, ErrSecret) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s, err = New(fileName, nil, Limits{Users: 10}, a); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.usersCount(); n != 2 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New(filepath.Join(t.TempDir(), "users.db"), nil, Limits{Users: 10}, a); err == nil {
+		t.Error("expected error for BoltDB file")
+	}
+}
+
+func TestUserSimulate(t *testing.T) {
+	events := []*Event{
+		{Title: "standup", Period: "24h", StartHour: "10h", TimeZone: "UTC"},
+		{Title: "retro", Period: "24h", StartHour: "15h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u := &user{
+		name:        "user1",
+		delays:      []time.Duration{15 * time.Minute, 2 * time.Hour},
+		eventDelays: map[string][]time.Duration{"retro": {30 * time.Minute}},
+	}
+	at := time.Date(2030, 1, 2, 9, 40, 0, 0, time.UTC)
+	items := u.simulate(events, at, time.Hour)
+	expected := []ItemSnapshot{
+		{User: "user1", Event: "standup", Delay: 15 * time.Minute, Timestamp: at.Add(5 * time.Minute), Start: at.Add(20 * time.Minute)},
+	}
+	if fmt.Sprint(items) != fmt.Sprint(expected) {
+		t.Errorf("failed compare %v != %v", expected, items)
+	}
+	at = time.Date(2030, 1, 2, 14, 0, 0, 0, time.UTC)
+	if items = u.simulate(events, at, time.Hour); (len(items) != 1) || (items[0].Event != "retro") {
+		t.Errorf("unexpected items %v", items)
+	}
+}
+
+func TestPostEventDelays(t *testing.T) {
+	delays, err := parseDelays("-30 -1h 5 -45s", 0, 120, 60, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &user{name: "user1", delays: delays}
+	if d := u.stringDelays(); d != "-60 -30 -45s 5" {
+		t.Errorf("unexpected delays %q", d)
+	}
+	if _, err = parseDelays("-61", 0, 120, 60, 5); !errors.Is(err, ErrDelay) {
+		t.Errorf("unexpected error %v", err)
+	}
+	e := &Event{Title: "test", Period: "1h", StartHour: "0h", TimeZone: "UTC"}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	u.delays = []time.Duration{-90 * time.Minute}
+	now := time.Now()
+	items := u.init([]*Event{e})
+	if n := len(items); n != 1 {
+		t.Fatalf("unexpected items %d", n)
+	}
+	// the reminder of the occurrence started less than 90 minutes ago is ahead
+	if ts := items[0].timestamp; ts.Before(now) || !ts.Before(now.Add(time.Hour+time.Second)) {
+		t.Errorf("unexpected timestamp %v, now=%v", ts, now)
+	}
+	start := time.Date(2030, 1, 2, 15, 0, 0, 0, time.UTC)
+	ue := &userEvent{user: "user1", event: e, delay: -30 * time.Minute, timestamp: start.Add(30 * time.Minute)}
+	m := ue.Message(nil)
+	if m.checkExpiry(start.Add(31 * time.Minute)) {
+		t.Error("unexpected expired reminder")
+	}
+	if !strings.HasSuffix(m.text, "Started 31 minutes ago") {
+		t.Errorf("unexpected text %q", m.text)
+	}
+}
+
+func TestEventKeyboard(t *testing.T) {
+	start := time.Date(2021, 10, 5, 15, 0, 0, 0, time.UTC)
+	e := &Event{
+		Title: "test", URL: "https://mysite", Period: "168h", StartHour: "15h", TimeZone: "UTC",
+		Keyboard: [][]KeyButton{
+			{{Label: "Join {{.Time}}"}, {Label: "Agenda", URL: "https://mysite/{{.Date}}"}},
+			{{Label: "Stop", Callback: "/stop"}},
+		},
+	}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	m := userMsg{url: "https://fetched", keyboard: e.keyboard(start)}
+	expected := [][]msgButton{
+		{{label: "Join 15:00", url: "https://fetched"}, {label: "Agenda", url: "https://mysite/2021-10-05"}},
+		{{label: "Stop", callback: "/stop"}},
+	}
+	rows := m.buttons()
+	if fmt.Sprint(rows) != fmt.Sprint(expected) {
+		t.Errorf("failed compare %v != %v", expected, rows)
+	}
+	if _, ok := buildKeyboard(rows); !ok {
+		t.Error("expected not empty keyboard")
+	}
+	m = userMsg{label: "URL"}
+	if _, ok := buildKeyboard(m.buttons()); ok {
+		t.Error("expected empty keyboard without URLs")
+	}
+	invalid := [][][]KeyButton{
+		{{}},
+		{{{Label: ""}}},
+		{{{Label: "Both", URL: "https://mysite", Callback: "/stop"}}},
+		{{{Label: "{{.Unknown}}"}}},
+	}
+	for i, k := range invalid {
+		e = &Event{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC", Keyboard: k}
+		if err := e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error", i)
+		}
+	}
+}
+
+func TestStorageSubscriptions(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		events = make([]*Event, 3)
+		l      = Limits{Users: 10, Delays: 3, MaxDelay: 60}
+	)
+	for i := range events {
+		events[i] = &Event{Title: fmt.Sprintf("event%d", i+1), Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+		if err := events[i].Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"users.csv", "users.json", "users.db"} {
+		fileName := filepath.Join(t.TempDir(), name)
+		s, err := New(fileName, events, l, Access{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Start(ctx, "user1"); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Set(ctx, "user1", "5 10"); err != nil {
+			t.Fatal(err)
+		}
+		for _, values := range []string{"", "0", "4", "x"} {
+			if err = s.Subscribe(ctx, "user1", values); !errors.Is(err, ErrSubscription) {
+				t.Errorf("%s: unexpected error for %q: %v", name, values, err)
+			}
+		}
+		if err = s.Subscribe(ctx, "user1", "3 1 3"); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.items); n != 4 {
+			t.Errorf("%s: unexpected items length %d", name, n)
+		}
+		list, err := s.Subscriptions(ctx, "user1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "Events:\n1. [x] event1\n2. [ ] event2\n3. [x] event3"; list != expected {
+			t.Errorf("%s: failed compare %q != %q", name, expected, list)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// subscriptions are persisted
+		if s, err = New(fileName, events, l, Access{}); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.items); n != 4 {
+			t.Errorf("%s: unexpected items length %d after restart", name, n)
+		}
+		if err = s.Subscribe(ctx, "user1", "all"); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s.items); n != 6 {
+			t.Errorf("%s: unexpected items length %d", name, n)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEventNextIn(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Event{Title: "test", Period: "168h", StartHour: "15h", Weekday: time.Tuesday, TimeZone: "Europe/Berlin"}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		dt       time.Time
+		loc      *time.Location
+		expected time.Time
+	}{
+		{
+			name:     "event_zone",
+			dt:       time.Date(2021, 10, 5, 12, 0, 0, 0, berlin),
+			expected: time.Date(2021, 10, 5, 15, 0, 0, 0, berlin),
+		},
+		{
+			name:     "east",
+			dt:       time.Date(2021, 10, 5, 12, 0, 0, 0, berlin),
+			loc:      tokyo,
+			expected: time.Date(2021, 10, 12, 15, 0, 0, 0, tokyo),
+		},
+		{
+			name:     "east_before",
+			dt:       time.Date(2021, 10, 5, 12, 0, 0, 0, tokyo),
+			loc:      tokyo,
+			expected: time.Date(2021, 10, 5, 15, 0, 0, 0, tokyo),
+		},
+		{
+			name:     "west_after_event",
+			dt:       time.Date(2021, 10, 5, 16, 0, 0, 0, berlin),
+			loc:      newYork,
+			expected: time.Date(2021, 10, 5, 15, 0, 0, 0, newYork),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(tt *testing.T) {
+			a := e.nextIn(c.dt, c.loc)
+			if !a.Equal(c.expected) {
+				tt.Errorf("failed compare %v != %v", c.expected, a)
+			}
+		})
+	}
+}
+
+func TestStorageTimeZone(t *testing.T) {
+	ctx := context.Background()
+	e := &Event{Title: "test", Period: "24h", StartHour: "15h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	s, err := New(fileName, []*Event{e}, Limits{Users: 10, Delays: 3, MaxDelay: 60}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user1", "10"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "Unknown/Zone"} {
+		if err = s.SetTimeZone(ctx, "user1", name); !errors.Is(err, ErrTimeZone) {
+			t.Errorf("unexpected error for %q: %v", name, err)
+		}
+	}
+	if err = s.SetTimeZone(ctx, "user1", "Asia/Tokyo"); err != nil {
+		t.Fatal(err)
+	}
+	checkItem := func(zone string) {
+		item := s.items.first()
+		if item == nil {
+			t.Fatal("no items")
+		}
+		start := item.timestamp.Add(item.delay)
+		if name := start.Location().String(); name != zone {
+			t.Errorf("unexpected location %s", name)
+		}
+		if h, m, _ := start.Clock(); h != 15 || m != 0 {
+			t.Errorf("unexpected start %v", start)
+		}
+	}
+	checkItem("Asia/Tokyo")
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = New(fileName, []*Event{e}, Limits{Users: 10}, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	checkItem("Asia/Tokyo")
+	if err = s.SetTimeZone(ctx, "user1", "event"); err != nil {
+		t.Fatal(err)
+	}
+	checkItem("UTC")
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		text, name, params string
+	}{
+		{text: "/start", name: "/start"},
+		{text: "  /Set\t5  60 \n", name: "/set", params: "5 60"},
+		{text: "\u200b/set\u00a05\u3000\u300060", name: "/set", params: "5 60"},
+		{text: "/role user1 admin", name: "/role", params: "user1 admin"},
+		{text: "start"},
+		{text: "/"},
+		{text: "/ set"},
+		{text: ""},
+	}
+	for i, c := range cases {
+		name, params := ParseCommand(c.text)
+		if (name != c.name) || (params != c.params) {
+			t.Errorf("case [%d]: failed compare %q %q != %q %q", i, c.name, c.params, name, params)
+		}
+	}
+}
+
+func TestParseDelays(t *testing.T) {
+	cases := []struct {
+		values   string
+		expected string
+		err      error
+	}{
+		{values: "5 60", expected: "5 60"},
+		{values: "60,5;;5 ,\t 30", expected: "5 30 60"},
+		{values: "", expected: ""},
+		{values: "\u00a0 5\u2003", expected: "5"},
+		{values: "-5", err: ErrDelay},
+		{values: "+5", err: ErrDelay},
+		{values: "5.5", err: ErrDelay},
+		{values: "99999999999999999999", err: ErrDelay},
+		{values: "\uff15", err: ErrDelay}, // full width digit
+		{values: "1 2 3 4", err: ErrLimit},
+		{values: "200", err: ErrDelay},
+		{values: "1h30m 45m, 30s", expected: "30s 45 90"},
+		{values: "1h 60", expected: "60"},
+		{values: "-1m", err: ErrDelay},
+		{values: "2h30m", err: ErrDelay},
+		{values: "5 minutes", err: ErrDelay},
+	}
+	for i, c := range cases {
+		delays, err := parseDelays(c.values, 0, 120, 0, 3)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("case [%d]: unexpected error %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case [%d]: %v", i, err)
+			continue
+		}
+		u := &user{delays: delays}
+		if d := u.stringDelays(); d != c.expected {
+			t.Errorf("case [%d]: failed compare %q != %q", i, c.expected, d)
+		}
+	}
+}
+
+func TestDelayValue(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected time.Duration
+		saved    string
+	}{
+		{data: `{"delay":5}`, expected: 5 * time.Minute, saved: `{"delay":5}`},
+		{data: `{"delay":"1h30m"}`, expected: 90 * time.Minute, saved: `{"delay":90}`},
+		{data: `{"delay":"45s"}`, expected: 45 * time.Second, saved: `{"delay":"45s"}`},
+		{data: `{"delay":5.5}`},
+		{data: `{"delay":"-5m"}`, expected: -5 * time.Minute, saved: `{"delay":-5}`},
+		{data: `{"delay":"--5"}`},
+		{data: `{"delay":true}`},
+	}
+	for i, c := range cases {
+		var v struct {
+			Delay delayValue `json:"delay"`
+		}
+		err := json.Unmarshal([]byte(c.data), &v)
+		if c.saved == "" {
+			if !errors.Is(err, ErrDelay) {
+				t.Errorf("case [%d]: unexpected error %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		if d := v.Delay.Duration(); d != c.expected {
+			t.Errorf("case [%d]: unexpected delay %v", i, d)
+		}
+		data, err := json.Marshal(v)
+		if err != nil || string(data) != c.saved {
+			t.Errorf("case [%d]: unexpected saved data %s: %v", i, data, err)
+		}
+	}
+}
+
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{"/start", " /set 5  60", "/role\tuser admin", "\u200b/get", "text", "/", "\xff/set"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		name, params := ParseCommand(text)
+		if name == "" {
+			if params != "" {
+				t.Errorf("params %q without command", params)
+			}
+			return
+		}
+		if !strings.HasPrefix(name, "/") || (len(name) < 2) || strings.ContainsAny(name, " \t\n") {
+			t.Errorf("invalid command %q", name)
+		}
+		if normalize(params) != params {
+			t.Errorf("not normalized params %q", params)
+		}
+		if n, p := ParseCommand(name + " " + params); (n != name) || (p != params) {
+			t.Errorf("not stable result %q %q != %q %q", name, params, n, p)
+		}
+	})
+}
+
+func FuzzParseDelays(f *testing.F) {
+	for _, seed := range []string{"5 60", "60,5;5", "", "-1", "1e3", "99999999999999999999", "\u00a05", "1h30m 45s"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, values string) {
+		delays, err := parseDelays(values, 1, 1440, 0, 10)
+		if err != nil {
+			if !errors.Is(err, ErrDelay) && !errors.Is(err, ErrLimit) {
+				t.Errorf("unexpected error type: %v", err)
+			}
+			return
+		}
+		for i, d := range delays {
+			if (d < time.Minute) || (d > 1440*time.Minute) || ((i > 0) && (d <= delays[i-1])) {
+				t.Fatalf("invalid delays %v", delays)
+			}
+		}
+		u := &user{delays: delays}
+		again, err := parseDelays(u.stringDelays(), 1, 1440, 0, 10)
+		if err != nil || fmt.Sprint(again) != fmt.Sprint(delays) {
+			t.Errorf("not stable result %v != %v: %v", delays, again, err)
+		}
+	})
+}
+
+func FuzzCSVRow(f *testing.F) {
+	f.Add("user1", "5 60", "", "", "", "")
+	f.Add("user2", "10", "admin", "2021-10-05T15:00:00Z", "event1|event2", "Europe/Berlin")
+	f.Add(" user3\u00a0", "5,,5", "EDITOR", "", "|", "UTC")
+	f.Fuzz(func(t *testing.T, name, delays, role, deleted, subscriptions, zone string) {
+		row := []string{name, delays, role, deleted, subscriptions, zone}
+		u, err := parseCSVRow(row)
+		if err != nil {
+			return
+		}
+		x, err := parseCSVRow(csvRow(u))
+		if err != nil {
+			t.Fatalf("failed parse saved row %q: %v", csvRow(u), err)
+		}
+		if (u.name != x.name) || !u.equal(x) {
+			t.Errorf("not equal users %q != %q", csvRow(u), csvRow(x))
+		}
+	})
+}
+
+func TestStorageStats(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		events = make([]*Event, 2)
+	)
+	for i := range events {
+		events[i] = &Event{Title: fmt.Sprintf("event \"%d\"", i+1), Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+		if err := events[i].Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, Limits{Users: 10, Delays: 3, Grace: 1}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2", "user3", "user4"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, delays := range map[string]string{"user1": "5 30", "user2": "5", "user4": "10"} {
+		if err = s.Set(ctx, name, delays); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Subscribe(ctx, "user2", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Stop(ctx, "user4"); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = s.Stats().WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`mtbot_users{state="active"} 3`,
+		`mtbot_users{state="paused"} 1`,
+		`mtbot_users{state="removed"} 0`,
+		`mtbot_event_subscribers{event="event \"1\""} 1`,
+		`mtbot_event_subscribers{event="event \"2\""} 2`,
+		`mtbot_event_delay_users{event="event \"1\"",delay="5"} 1`,
+		`mtbot_event_delay_users{event="event \"1\"",delay="30"} 1`,
+		`mtbot_event_delay_users{event="event \"2\"",delay="5"} 2`,
+		`mtbot_event_delay_users{event="event \"2\"",delay="30"} 1`,
+	}
+	metrics := b.String()
+	for _, line := range expected {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("not found %q in metrics:\n%s", line, metrics)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageProbe(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), nil, Limits{Users: 10}, Access{Admins: []string{"admin1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2", "user3"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.SetRole(ctx, "user3", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	check := func(chatID string) error {
+		if chatID == "user2" {
+			return errors.New("chat not found")
+		}
+		return nil
+	}
+	checked, unreachable, err := s.probe(ctx, check, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (checked != 3) || (fmt.Sprint(unreachable) != "[user2]") {
+		t.Errorf("unexpected probe result %d %v", checked, unreachable)
+	}
+	if n := s.Stats().Unreachable; n != 1 {
+		t.Errorf("unexpected unreachable users %d", n)
+	}
+	if admins := s.adminNames(); fmt.Sprint(admins) != "[admin1 user3]" {
+		t.Errorf("unexpected admins %v", admins)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err = s.probe(canceled, check, time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageHistory(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), nil, Limits{Users: 10, History: 3}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := s.History("", 0); len(h) != 0 {
+		t.Errorf("unexpected history %v", h)
+	}
+	for i := 1; i <= 5; i++ {
+		m := &userMsg{user: fmt.Sprintf("user%d", i%2), event: "test", delay: time.Duration(i) * time.Minute}
+		if i == 4 {
+			s.record(m, DeliveryFailed, errors.New("send error"))
+		} else {
+			s.record(m, DeliverySent, nil)
+		}
+	}
+	delays := func(records []Delivery) string {
+		values := make([]string, len(records))
+		for i, d := range records {
+			values[i] = fmt.Sprintf("%s/%s/%s", d.User, formatDelay(d.Delay), d.Result)
+		}
+		return strings.Join(values, " ")
+	}
+	cases := []struct {
+		user     string
+		limit    int
+		expected string
+	}{
+		{expected: "user1/5/sent user0/4/failed user1/3/sent"},
+		{limit: 2, expected: "user1/5/sent user0/4/failed"},
+		{user: "user1", expected: "user1/5/sent user1/3/sent"},
+		{user: "user0", limit: 5, expected: "user0/4/failed"},
+		{user: "unknown"},
+	}
+	for i, c := range cases {
+		if h := delays(s.History(c.user, c.limit)); h != c.expected {
+			t.Errorf("case [%d]: failed compare %q != %q", i, c.expected, h)
+		}
+	}
+	if h := s.History("user0", 1); h[0].Error != "send error" {
+		t.Errorf("unexpected error %q", h[0].Error)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// disabled history
+	var h *history
+	h.add(Delivery{User: "user1"})
+	if records := h.list(func(*Delivery) bool { return true }, 0); len(records) != 0 {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestStorageEventDelays(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{
+		{Title: "Daily standup", Period: "24h", StartHour: "10h", TimeZone: "UTC"},
+		{Title: "training", Period: "168h", StartHour: "18h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	for _, name := range []string{"users.csv", "users.json", "users.db"} {
+		fileName := filepath.Join(t.TempDir(), name)
+		s, err := New(fileName, events, l, Access{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Start(ctx, "user1"); err != nil {
+			t.Fatal(err)
+		}
+		for _, values := range []string{"10", "TRAINING 60, 15", "daily standup 5"} {
+			if err = s.Set(ctx, "user1", values); err != nil {
+				t.Fatalf("%s: failed set %q: %v", name, values, err)
+			}
+		}
+		// unknown event's title is parsed as delays
+		if err = s.Set(ctx, "user1", "yoga 15"); !errors.Is(err, ErrDelay) {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if err = s.Set(ctx, "user1", "training 500"); !errors.Is(err, ErrDelay) {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if s, err = New(fileName, events, l, Access{}); err != nil {
+			t.Fatal(err)
+		}
+		if ed := fmt.Sprint(s.Snapshot().Users[0].EventDelays); ed != "map[Daily standup:[5m0s] training:[15m0s 1h0m0s]]" {
+			t.Errorf("%s: unexpected event delays %v", name, ed)
+		}
+		if n := len(s.items); n != 3 {
+			t.Errorf("%s: unexpected items length %d", name, n)
+		}
+		result, err := s.Get(ctx, "user1")
+		if err != nil || !strings.Contains(result, "training: 15 60") {
+			t.Errorf("%s: unexpected result %q: %v", name, result, err)
+		}
+		// common delays for the event again
+		if err = s.Set(ctx, "user1", "Daily Standup default"); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Set(ctx, "user1", "training default"); err != nil {
+			t.Fatal(err)
+		}
+		if ed := s.Snapshot().Users[0].EventDelays; ed != nil {
+			t.Errorf("%s: unexpected event delays %v", name, ed)
+		}
+		if n := len(s.items); n != 2 {
+			t.Errorf("%s: unexpected items length %d", name, n)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseMention(t *testing.T) {
+	cases := []struct {
+		text    string
+		query   string
+		mention bool
+	}{
+		{text: "@[100500] standup", query: "standup", mention: true},
+		{text: "\u00a0@mtbot  Daily\tstandup ", query: "Daily standup", mention: true},
+		{text: "@mtbot", mention: true},
+		{text: "@mtbots standup"},
+		{text: "hi @mtbot standup"},
+		{text: "/find standup"},
+	}
+	for i, c := range cases {
+		query, ok := ParseMention(c.text, "100500", "", "mtbot")
+		if (query != c.query) || (ok != c.mention) {
+			t.Errorf("case [%d]: unexpected result %q %v", i, query, ok)
+		}
+	}
+}
+
+func TestStorageFindJoin(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{
+		{Title: "Daily standup", Period: "24h", StartHour: "10h", TimeZone: "UTC", Location: "Room 1"},
+		{Title: "training", Period: "168h", StartHour: "18h", TimeZone: "UTC"},
+		{Title: "Retro", Period: "336h", StartHour: "16h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, Limits{Users: 10}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := s.FindEvent("STANDUP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (card.Number != 1) || !strings.Contains(card.Text, "Room 1") || !strings.Contains(card.Text, "Next: ") {
+		t.Errorf("unexpected card %v", card)
+	}
+	for _, query := range []string{"", "yoga"} {
+		if _, err = s.FindEvent(query); !errors.Is(err, ErrEvent) {
+			t.Errorf("unexpected error for %q: %v", query, err)
+		}
+	}
+	if err = s.Join(ctx, "user1", "1"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	// all events are already subscribed
+	if err = s.Join(ctx, "user1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Subscribe(ctx, "user1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Join(ctx, "user1", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Join(ctx, "user1", "4"); !errors.Is(err, ErrSubscription) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	result, err := s.Subscriptions(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Events:\n1. [x] Daily standup\n2. [ ] training\n3. [x] Retro"; result != expected {
+		t.Errorf("failed compare %q != %q", expected, result)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageAudit(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName, auditName := filepath.Join(dir, "users.csv"), filepath.Join(dir, "audit.log")
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	s, err := New(fileName, nil, l, Access{Audit: auditName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []func() error{
+		func() error { return s.Start(ctx, "user1") },
+		func() error { return s.Set(ctx, "user1", "10 5") },
+		func() error { return s.Start(ctx, "user2") },
+		func() error { return s.Stop(ctx, "user1") },
+		func() error { return s.Start(ctx, "user1") },
+	} {
+		if err = f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// failed changes are not audited
+	if err = s.Set(ctx, "user1", "abc"); !errors.Is(err, ErrDelay) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the file is appended after restart
+	if s, err = New(fileName, nil, l, Access{Audit: auditName}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user1", "15"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := s.Audit("user1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]string, len(records))
+	for i, r := range records {
+		values[i] = fmt.Sprintf("%s: %s -> %s", r.Action, r.Old, r.New)
+	}
+	expected := []string{
+		"set: delays=5 10 -> delays=15",
+		"start: paused delays=5 10 -> delays=5 10",
+		"stop: delays=5 10 -> paused delays=5 10",
+		"set: delays= -> delays=5 10",
+		"start: none -> delays=",
+	}
+	if v, e := strings.Join(values, "\n"), strings.Join(expected, "\n"); v != e {
+		t.Errorf("failed compare:\n%s\n!=\n%s", e, v)
+	}
+	if records, err = s.Audit("user2", 1); err != nil || len(records) != 1 || records[0].Action != AuditStart {
+		t.Errorf("unexpected records %v: %v", records, err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// disabled audit
+	if s, err = New(fileName, nil, l, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = s.Audit("user1", 0); err != nil || len(records) != 0 {
+		t.Errorf("unexpected records %v: %v", records, err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoggerOpenFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "mtbot.log")
+	l := NewLogger(false)
+	if err := l.OpenFile(""); err != nil {
+		t.Fatal(err) // standard outputs
+	}
+	if err := l.OpenFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	l.Info.Println("first")
+	l.Debug.Println("hidden")
+	// rotation
+	rotated := filepath.Join(dir, "mtbot.log.1")
+	if err := os.Rename(fileName, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.OpenFile(""); err != nil {
+		t.Fatal(err)
+	}
+	l.Error.Println("second")
+	for name, expected := range map[string]string{rotated: "first", fileName: "second"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := string(data); !strings.Contains(text, expected) || strings.Contains(text, "hidden") {
+			t.Errorf("unexpected %s content %q", name, text)
+		}
+	}
+	if err := l.OpenFile(filepath.Join(dir, "unknown", "mtbot.log")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	if _, err := loadLocation("Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadLocation("Unknown/Zone")
+	if err == nil {
+		t.Fatal("expected error for unknown zone")
+	}
+	if errors.Is(err, ErrZoneInfo) {
+		t.Errorf("unexpected database error: %v", err)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	const skew = time.Hour
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-skew).UTC().Format(http.TimeFormat))
+	}))
+	defer ts.Close()
+
+	d, err := clockSkew(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := absDuration(d - skew); diff > 2*time.Second {
+		t.Errorf("unexpected clock skew %v", d)
+	}
+}
+
+func TestStorageBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "users.csv")
+	if err := os.WriteFile(fileName, []byte("user1,5 10\nuser2,15\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(fileName, nil, Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := filepath.Join(dir, "backups")
+	if err = os.Mkdir(backups, 0700); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := make([]string, 3)
+	for i := range files {
+		if files[i], err = s.Backup(ctx, backups, now.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := rotateBackups(backups, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != files[0] {
+		t.Errorf("unexpected removed backups: %v", removed)
+	}
+	if removed, err = rotateBackups(backups, 2); err != nil || len(removed) != 0 {
+		t.Errorf("unexpected second rotation: %v, %v", removed, err)
+	}
+	restored := filepath.Join(dir, "restored.csv")
+	n, err := Restore(ctx, files[2], restored, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected restored users count %d", n)
+	}
+	if s, err = New(restored, nil, Limits{Users: 10}, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if n = s.usersCount(); n != 2 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Restore(ctx, filepath.Join(dir, "absent.csv"), restored, Access{}); err == nil {
+		t.Error("expected error for absent backup")
+	}
+}
+
+func TestStorageMigrate(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 3, Delays: 5, MinDelay: 1, MaxDelay: 100, History: 5}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Set(ctx, "user1", "5 10"); err != nil {
+		t.Fatal(err)
+	}
+	s.record(&userMsg{user: "user1", event: "test"}, DeliverySent, nil)
+
+	errCases := []struct {
+		oldName, newName string
+		expected         error
+	}{
+		{oldName: "user1", newName: "user1", expected: ErrKnownUser},
+		{oldName: "user1", newName: "user2", expected: ErrKnownUser},
+		{oldName: "unknown", newName: "user3", expected: ErrUnknownUser},
+	}
+	for i, c := range errCases {
+		if err = s.Migrate(ctx, c.oldName, c.newName); !errors.Is(err, c.expected) {
+			t.Errorf("case [%d]: unexpected error: %v", i, err)
+		}
+	}
+	if err = s.Migrate(ctx, "user1", "user3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(ctx, "user1"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("old user is not removed: %v", err)
+	}
+	if delays, err := s.Get(ctx, "user3"); err != nil || !strings.Contains(delays, "5 10") {
+		t.Errorf("unexpected migrated delays %q: %v", delays, err)
+	}
+	if n := len(s.shard("user3").userIdx["user3"]); n != 2 {
+		t.Errorf("unexpected migrated items %d", n)
+	}
+	if records := s.History("user3", 0); len(records) != 1 {
+		t.Errorf("unexpected migrated history %v", records)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = New(fileName, events, l, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.active("user3") || s.active("user1") || (s.usersCount() != 2) {
+		t.Error("migrated user is not saved")
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplateLint(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string // error's substring, empty - valid template
+	}{
+		{value: ""},
+		{value: "https://mysite/{{.Date}}?t={{.Start.Hour}}"},
+		{value: "{{if .Date}}{{.Time}}{{else}}none{{end}}"},
+		{value: "https://mysite/{{.Date", expected: "unclosed {{ at 1:16"},
+		{value: "https://mysite/.Date}}", expected: "unexpected }} at 1:21"},
+		{value: "{{.Date {{.Time}}", expected: "nested {{ at 1:9"},
+		{value: "line\n{{.Date}} {{.Day}}", expected: "unknown placeholder .Day at 2:13"},
+		{value: "{{if false}}{{.Unknown.Field}}{{end}}", expected: "unknown placeholder .Unknown.Field at 1:15"},
+	}
+	for i, c := range cases {
+		_, err := parseTemplate("test", c.value)
+		switch {
+		case c.expected == "" && err != nil:
+			t.Errorf("case [%d]: unexpected error: %v", i, err)
+		case c.expected != "" && (err == nil || !strings.Contains(err.Error(), c.expected)):
+			t.Errorf("case [%d]: unexpected error %v, expected %q", i, err, c.expected)
+		}
+	}
+	e := &Event{Title: "test", Message: "Starts at {{.Time}}", Period: "168h", StartHour: "15h", TimeZone: "UTC"}
+	if err := e.Init(); err == nil || !strings.Contains(err.Error(), "event=test") {
+		t.Errorf("unexpected message template error: %v", err)
+	}
+}
+
+func TestStorageInspection(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 3, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, name := range []string{"user2", "user1", "user3"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Set(ctx, name, "5 10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Stop(ctx, "user3"); err != nil {
+		t.Fatal(err)
+	}
+	users := s.Users()
+	if len(users) != 3 || users[0].Name != "user1" || users[2].Paused == nil {
+		t.Errorf("unexpected users %v", users)
+	}
+	users[0].Delays[0] = time.Hour
+	if delays := s.Users()[0].Delays; delays[0] == time.Hour {
+		t.Error("users are not copied")
+	}
+	if items := s.UpcomingItems(0); len(items) != 4 {
+		t.Errorf("unexpected items count %d", len(items))
+	}
+	items := s.UpcomingItems(3)
+	if len(items) != 3 {
+		t.Fatalf("unexpected limited items count %d", len(items))
+	}
+	for i := 1; i < len(items); i++ {
+		if items[i].Timestamp.Before(items[i-1].Timestamp) {
+			t.Errorf("items are not ordered: %v", items)
+		}
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.Chdir(wd); err != nil {
+			t.Error(err)
+		}
+	}()
+	l := Limits{Users: 3, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	s, err := New(MemorySource, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user1", "user2"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Set(ctx, "user1", "5 10"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reload(ctx, MemorySource); err != nil {
+		t.Fatal(err)
+	}
+	if delays, err := s.Get(ctx, "user1"); err != nil || !strings.Contains(delays, "5 10") {
+		t.Errorf("unexpected reloaded delays %q: %v", delays, err)
+	}
+	if n := s.usersCount(); n != 2 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("unexpected files %v", entries)
+	}
+	if s, err = New(MemorySource, nil, l, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.usersCount(); n != 0 {
+		t.Errorf("unexpected users count %d after restart", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageQueues(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	queues := filepath.Join(dir, "queues.json")
+	l := Limits{Users: 3, Delays: 5, MinDelay: 1, MaxDelay: 100, Quota: 1}
+	s, err := New(filepath.Join(dir, "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OpenQueues(queues); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	start := events[0].nextIn(now, nil)
+	m := userMsg{user: "user1", event: "test", delay: 5 * time.Minute, start: start}
+	s.quota.postpone(m, now)
+	s.KeepReplies([]QueuedReply{{ChatID: "user1", Text: "OK", Attempts: 2}})
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = New(filepath.Join(dir, "users.csv"), events, l, Access{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OpenQueues(queues); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(queues); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("queues file is not removed: %v", err)
+	}
+	replies := s.TakeReplies()
+	if len(replies) != 1 || replies[0].Attempts != 2 {
+		t.Errorf("unexpected replies %v", replies)
+	}
+	p, records := m.pending(), s.quota.records()
+	if len(records) != 1 || records[0].pendingMsg.key() != p.key() || !records[0].Until.After(now) {
+		t.Errorf("unexpected deferred notifications %v", records)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the deferred notification is kept again
+	data, err := os.ReadFile(queues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"until"`)) || bytes.Contains(data, []byte(`"replies"`)) {
+		t.Errorf("unexpected queues file %s", data)
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	tz, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := time.Date(2024, 3, 28, 20, 0, 30, 0, tz) // Thursday
+	cases := []struct {
+		expr     string
+		expected time.Time
+		err      bool
+	}{
+		{expr: "0 19 * * MON,THU", expected: time.Date(2024, 4, 1, 19, 0, 0, 0, tz)},
+		{expr: "*/15 20 * * *", expected: time.Date(2024, 3, 28, 20, 15, 0, 0, tz)},
+		{expr: "0 9 1-7 * 1", expected: time.Date(2024, 4, 1, 9, 0, 0, 0, tz)}, // the first week's days or Mondays
+		{expr: "0 9 1 jan-mar/2 *", expected: time.Date(2025, 1, 1, 9, 0, 0, 0, tz)},
+		{expr: "30 2 31 3 *", expected: time.Date(2024, 3, 31, 3, 30, 0, 0, tz)}, // DST gap
+		{expr: "0 12 * * 7", expected: time.Date(2024, 3, 31, 12, 0, 0, 0, tz)},
+		{expr: "0 19 * *", err: true},
+		{expr: "60 19 * * *", err: true},
+		{expr: "0 19 * * FOO", err: true},
+		{expr: "0 19 10-5 * *", err: true},
+		{expr: "0 19 */0 * *", err: true},
+		{expr: "0 0 30 2 *", err: true},
+	}
+	for i, c := range cases {
+		s, err := parseCron(c.expr)
+		if c.err {
+			if err == nil {
+				t.Errorf("case [%d]: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		if next := s.next(dt); !next.Equal(c.expected) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, c.expected, next)
+		}
+	}
+	e := &Event{Title: "test", Cron: "0 19 * * MON,THU", TimeZone: "Europe/Berlin"}
+	if err = e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if start := e.nextIn(dt, nil); !start.Equal(time.Date(2024, 4, 1, 19, 0, 0, 0, tz)) {
+		t.Errorf("unexpected event start %v", start)
+	}
+	e = &Event{Title: "test", Cron: "0 19 * * MON", Date: "03-15", TimeZone: "UTC"}
+	if err = e.Init(); err == nil {
+		t.Error("expected error for date and cron")
+	}
+}
+
+func TestStorageImport(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{
+		{Title: "test1", Period: "168h", StartHour: "15h", TimeZone: "UTC"},
+		{Title: "test2", Period: "168h", StartHour: "16h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{15}}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, name := range []string{"all", "one", "paused"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Subscribe(ctx, "one", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Stop(ctx, "paused"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Import(ctx, []string{"new1"}, "3"); !errors.Is(err, ErrSubscription) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	created, joined, err := s.Import(ctx, []string{"all", "one", "paused", "new1", "new2", "new3"}, "2")
+	if !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if (created != 2) || (joined != 1) {
+		t.Errorf("unexpected created=%d, joined=%d", created, joined)
+	}
+	expected := map[string][]string{"all": nil, "one": {"test1", "test2"}, "paused": nil, "new1": {"test2"}, "new2": {"test2"}}
+	users := s.Users()
+	if len(users) != len(expected) {
+		t.Fatalf("unexpected users %v", users)
+	}
+	for _, u := range users {
+		if titles := expected[u.Name]; strings.Join(titles, ",") != strings.Join(u.Subscriptions, ",") {
+			t.Errorf("unexpected user=%s subscriptions %v", u.Name, u.Subscriptions)
+		}
+	}
+	if n := len(s.shard("new1").userIdx["new1"]); n != 1 {
+		t.Errorf("unexpected new user's items %d", n)
+	}
+}
+
+func TestEventWeekdays(t *testing.T) {
+	e := &Event{Title: "test", Weekdays: []string{"thursday", "Mon", "Monday"}, Period: "168h", StartHour: "19h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if days := e.days(); days != "Thursday,Monday" {
+		t.Errorf("unexpected weekdays %q", days)
+	}
+	dt := time.Date(2024, 3, 26, 12, 0, 0, 0, time.UTC) // Tuesday
+	expected := []time.Time{
+		time.Date(2024, 3, 28, 19, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 1, 19, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 4, 19, 0, 0, 0, time.UTC),
+	}
+	for i, start := 0, e.nextIn(dt, nil); i < len(expected); i, start = i+1, e.nextIn(start.Add(time.Nanosecond), nil) {
+		if !start.Equal(expected[i]) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, expected[i], start)
+		}
+	}
+	e = &Event{Title: "test", Weekdays: []string{"Funday"}, Period: "168h", StartHour: "19h", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for unknown weekday")
+	}
+}
+
+func TestSmear(t *testing.T) {
+	var (
+		now    = time.Now()
+		start  = now.Add(time.Hour)
+		window = 30 * time.Second
+	)
+	notifications := []userMsg{
+		{user: "user3", event: "test", start: start},
+		{user: "user1", event: "test", start: start},
+		{user: "user1", event: "other", start: start},
+		{user: "user2", event: "test", start: start},
+	}
+	if result := smear(notifications, 0, now); !result[0].sendAt.IsZero() {
+		t.Error("disabled smear changed sending time")
+	}
+	result := smear(notifications, window, now)
+	expected := []struct {
+		user, event string
+		sendAt      time.Time
+	}{
+		{user: "user1", event: "other"},
+		{user: "user1", event: "test", sendAt: now},
+		{user: "user2", event: "test", sendAt: now.Add(window / 3)},
+		{user: "user3", event: "test", sendAt: now.Add(2 * window / 3)},
+	}
+	for i, e := range expected {
+		m := result[i]
+		if (m.user != e.user) || (m.event != e.event) || !m.sendAt.Equal(e.sendAt) {
+			t.Errorf("case [%d]: unexpected notification user=%s event=%s sendAt=%v", i, m.user, m.event, m.sendAt)
+		}
+	}
+}
+
+func TestEventTimes(t *testing.T) {
+	e := &Event{Title: "test", Times: []string{"18h", "9h30m", "18h0m"}, Period: "24h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if clock := e.clock(); clock != "18:00,09:30" {
+		t.Errorf("unexpected times %q", clock)
+	}
+	dt := time.Date(2024, 3, 26, 12, 0, 0, 0, time.UTC)
+	expected := []time.Time{
+		time.Date(2024, 3, 26, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 27, 9, 30, 0, 0, time.UTC),
+		time.Date(2024, 3, 27, 18, 0, 0, 0, time.UTC),
+	}
+	for i, start := 0, e.nextIn(dt, nil); i < len(expected); i, start = i+1, e.nextIn(start.Add(time.Nanosecond), nil) {
+		if !start.Equal(expected[i]) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, expected[i], start)
+		}
+	}
+	e = &Event{Title: "Birthday", Date: "03-15", Times: []string{"18h", "10h"}, TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []time.Time{
+		time.Date(2025, 3, 15, 10, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 15, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC),
+	}
+	dt = time.Date(2024, 3, 15, 20, 0, 0, 0, time.UTC)
+	for i, start := 0, e.nextIn(dt, nil); i < len(expected); i, start = i+1, e.nextIn(start.Add(time.Nanosecond), nil) {
+		if !start.Equal(expected[i]) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, expected[i], start)
+		}
+	}
+	e = &Event{Title: "test", Times: []string{"9h", "25h"}, Period: "24h", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for invalid time")
+	}
+}
+
+func TestEventMonthly(t *testing.T) {
+	cases := []struct {
+		monthly  string
+		dt       time.Time
+		expected []time.Time
+	}{
+		{
+			monthly: "31",
+			dt:      time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC),
+				time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			monthly: "first Monday",
+			dt:      time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC),
+				time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC),
+				time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			monthly: "last fri",
+			dt:      time.Date(2024, 12, 28, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2025, 1, 31, 10, 0, 0, 0, time.UTC),
+				time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 28, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			monthly: "last",
+			dt:      time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2023, 2, 28, 10, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 31, 10, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for i, c := range cases {
+		e := &Event{Title: "test", Monthly: c.monthly, StartHour: "10h", TimeZone: "UTC"}
+		if err := e.Init(); err != nil {
+			t.Fatalf("case [%d]: %v", i, err)
+		}
+		for j, start := 0, e.nextIn(c.dt, nil); j < len(c.expected); j, start = j+1, e.nextIn(start.Add(time.Nanosecond), nil) {
+			if !start.Equal(c.expected[j]) {
+				t.Errorf("case [%d/%d]: failed compare %v != %v", i, j, c.expected[j], start)
+			}
+		}
+	}
+	for i, monthly := range []string{"0", "32", "fifth Monday", "first Funday", "first Monday please"} {
+		e := &Event{Title: "test", Monthly: monthly, StartHour: "10h", TimeZone: "UTC"}
+		if err := e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error for monthly %q", i, monthly)
+		}
+	}
+	e := &Event{Title: "test", Monthly: "1", Date: "03-15", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for date and monthly")
+	}
+}
+
+func TestEventWindow(t *testing.T) {
+	e := &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Window: "9h-18h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ts, expected time.Time
+	}{
+		{ts: time.Date(2024, 3, 25, 7, 0, 0, 0, time.UTC), expected: time.Date(2024, 3, 25, 9, 0, 0, 0, time.UTC)},
+		{ts: time.Date(2024, 3, 25, 9, 0, 0, 0, time.UTC), expected: time.Date(2024, 3, 25, 9, 0, 0, 0, time.UTC)},
+		{ts: time.Date(2024, 3, 25, 17, 59, 0, 0, time.UTC), expected: time.Date(2024, 3, 25, 17, 59, 0, 0, time.UTC)},
+		{ts: time.Date(2024, 3, 25, 18, 0, 0, 0, time.UTC), expected: time.Date(2024, 3, 26, 9, 0, 0, 0, time.UTC)},
+		{ts: time.Date(2024, 3, 25, 23, 30, 0, 0, time.UTC), expected: time.Date(2024, 3, 26, 9, 0, 0, 0, time.UTC)},
+	}
+	for i, c := range cases {
+		if due := e.due(c.ts); !due.Equal(c.expected) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, c.expected, due)
+		}
+	}
+	// the item's start is kept, only its sending time is shifted
+	ue := &userEvent{user: "user", event: e, delay: 3 * time.Hour, timestamp: time.Date(2024, 3, 25, 7, 0, 0, 0, time.UTC)}
+	if item := ue.snapshot(); !item.Timestamp.Equal(cases[0].expected) || !item.Start.Equal(time.Date(2024, 3, 25, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected item %v", item)
+	}
+	for i, window := range []string{"18h-9h", "9h", "9h-25h", "-1h-9h", "9h-9h"} {
+		e = &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Window: window, TimeZone: "UTC"}
+		if err := e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error for window %q", i, window)
+		}
+	}
+}
+
+func TestEventVariants(t *testing.T) {
+	e := &Event{
+		Title: "test", Message: "main", Period: "168h", StartHour: "15h", TimeZone: "UTC", AckButton: "I'll be there",
+		Variants: []Variant{{Name: "a", Message: "short"}, {Name: "b", Message: "long", Weight: 3}},
+	}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "b", "b", "b"}
+	for n, name := range expected {
+		if v := e.variant(n); (v == nil) || (v.Name != name) {
+			t.Errorf("case [%d]: unexpected variant %v", n, v)
+		}
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), []*Event{e}, Limits{Users: 5, Delays: 5, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ue := &userEvent{user: "user", event: e, delay: time.Minute, timestamp: time.Now()}
+	m := ue.Message(nil)
+	if message := map[string]string{"a": "short", "b": "long"}[m.variant]; !strings.HasSuffix(m.text, "\n\n"+message) {
+		t.Errorf("unexpected text %q of variant %q", m.text, m.variant)
+	}
+	rows := m.buttons()
+	if ack := rows[len(rows)-1][0]; ack.callback != "/ack "+m.variant+" test" {
+		t.Errorf("unexpected ack button %v", ack)
+	}
+	if err = s.Ack("user", m.variant+" test"); !errors.Is(err, ErrAck) {
+		t.Errorf("unexpected ack of not sent notification: %v", err)
+	}
+	if d := s.record(&m, DeliverySent, nil); d.Variant != m.variant {
+		t.Errorf("unexpected delivery variant %q", d.Variant)
+	}
+	if err = s.Ack("user", m.variant+" test"); err != nil {
+		t.Errorf("failed ack: %v", err)
+	}
+	if err = s.Ack("user", m.variant+" test"); !errors.Is(err, ErrAck) {
+		t.Errorf("unexpected repeated ack: %v", err)
+	}
+	variants := s.Variants()
+	if (len(variants) != 1) || (variants[0] != VariantStats{Event: "test", Variant: m.variant, Sent: 1, Acked: 1}) {
+		t.Errorf("unexpected variants stats %v", variants)
+	}
+	var b strings.Builder
+	if err = s.Stats().WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	if line := `mtbot_variant_notifications{event="test",variant="` + m.variant + `",result="acked"} 1`; !strings.Contains(b.String(), line) {
+		t.Errorf("metrics without %q", line)
+	}
+	invalid := [][]Variant{
+		{{Name: "", Message: "x"}},
+		{{Name: "a b", Message: "x"}},
+		{{Name: "a", Message: "x"}, {Name: "a", Message: "y"}},
+		{{Name: "a", Message: "x", Weight: -1}},
+		{{Name: "a", Message: "{{.Date}}"}},
+	}
+	for i, variants := range invalid {
+		e = &Event{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC", Variants: variants}
+		if err = e.Init(); err == nil {
+			t.Errorf("case [%d]: expected error", i)
+		}
+	}
+	e = &Event{Title: "test", Period: "168h", StartHour: "15h", TimeZone: "UTC", AckButton: "OK"}
+	if err = e.Init(); err == nil {
+		t.Error("expected error for ack button without variants")
+	}
+}
+
+func TestEventOnce(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Minute)
+	once := now.Add(time.Minute)
+	e := &Event{Title: "once", Once: once.Format("2006-01-02T15:04"), TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if start := e.nextIn(now, nil); !start.Equal(once) {
+		t.Errorf("failed compare %v != %v", once, start)
+	}
+	if start := e.nextIn(once.Add(time.Second), time.FixedZone("UTC+3", 3*3600)); !isNever(start) {
+		t.Errorf("unexpected occurrence after the single one %v", start)
+	}
+	if e.done(now) || !e.done(once.Add(time.Second)) {
+		t.Error("unexpected done state")
+	}
+	past := &Event{Title: "past", Once: "2020-01-01T10:00", TimeZone: "UTC"}
+	if err := past.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), []*Event{e, past}, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if items := s.UpcomingItems(0); (len(items) != 1) || (items[0].Event != "once") {
+		t.Fatalf("unexpected items %v", items)
+	}
+	if result := s.notifications(nil); (len(result) != 1) || (result[0].event != "once") {
+		t.Fatalf("unexpected notifications %v", result)
+	}
+	if items := s.UpcomingItems(0); len(items) != 0 {
+		t.Errorf("one-shot event is scheduled again %v", items)
+	}
+	if card, err := s.FindEvent("past"); (err != nil) || !strings.HasSuffix(card.Text, "Next: done") {
+		t.Errorf("unexpected card %v: %v", card, err)
+	}
+	for i, invalid := range []*Event{
+		{Title: "test", Once: "2020-01-01", TimeZone: "UTC"},
+		{Title: "test", Once: "2020-01-01T10:00", Cron: "0 10 * * *", TimeZone: "UTC"},
+		{Title: "test", Once: "2020-01-01T10:00", Date: "01-01", TimeZone: "UTC"},
+	} {
+		if err = invalid.Init(); err == nil {
+			t.Errorf("case [%d]: expected error", i)
+		}
+	}
+}
+
+func TestPlainMode(t *testing.T) {
+	keyboard := botgolang.NewKeyboard()
+	keyboard.AddRow(botgolang.NewURLButton("🔗 Join", "https://mysite/a_b"), botgolang.NewCallbackButton("Stop", "/stop"))
+	text := "# Standup 🚀\n\n**Daily** __sync__ in `room_1`, see [agenda](https://mysite/agenda) 👍🏻"
+	expected := "Standup\n\nDaily sync in room_1, see agenda (https://mysite/agenda)\nJoin: https://mysite/a_b\nStop: /stop"
+	if result := PlainText(text, &keyboard); result != expected {
+		t.Errorf("unexpected plain text %q", result)
+	}
+	if result := PlainText("plain", nil); result != "plain" {
+		t.Errorf("unexpected plain text %q", result)
+	}
+	for value, expected := range map[string]bool{"on": true, "off": false} {
+		if plain, err := ParsePlain(value); (err != nil) || (plain != expected) {
+			t.Errorf("failed parse %q: %v %v", value, plain, err)
+		}
+	}
+	if _, err := ParsePlain("yes"); !errors.Is(err, ErrPlain) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}
+	s, err := New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetPlain(ctx, "user", true); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetPlain(ctx, "user", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if !s.Plain("user") || s.Plain("unknown") {
+		t.Error("unexpected plain mode after reopening")
+	}
+	if users := s.Users(); (len(users) != 1) || !users[0].Plain {
+		t.Errorf("unexpected users %v", users)
+	}
+}
+
+func TestEventUntil(t *testing.T) {
+	e := &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Until: "2024-04-08", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dt := time.Date(2024, 3, 26, 12, 0, 0, 0, time.UTC)
+	expected := []time.Time{
+		time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 8, 10, 0, 0, 0, time.UTC),
+	}
+	start := e.nextIn(dt, nil)
+	for i := range expected {
+		if !start.Equal(expected[i]) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, expected[i], start)
+		}
+		start = e.nextIn(start.Add(time.Nanosecond), nil)
+	}
+	if !isNever(start) {
+		t.Errorf("unexpected occurrence after the last date %v", start)
+	}
+	if !isNever(e.nextIn(dt.AddDate(0, 1, 0), time.FixedZone("UTC-5", -5*3600))) {
+		t.Error("unexpected occurrence in user's time zone after the last date")
+	}
+	if e.done(time.Date(2024, 4, 8, 23, 59, 0, 0, time.UTC)) || !e.done(time.Date(2024, 4, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Error("unexpected done state")
+	}
+	u := &user{name: "user", delays: minutes(5)}
+	if items := u.init([]*Event{e}); len(items) != 0 {
+		t.Errorf("ended event is scheduled %v", items)
+	}
+	e = &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Until: "04-08", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for invalid until")
+	}
+}
+
+func TestReconstructSchedule(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName, auditName := filepath.Join(dir, "users.csv"), filepath.Join(dir, "audit.log")
+	audit := `{"time":"2024-03-01T00:00:00Z","user":"user","action":"start","old":"none","new":"delays=30m"}
+{"time":"2024-03-12T00:00:00Z","user":"user","action":"set","old":"delays=30m","new":"delays=30m 1h"}
+`
+	if err := os.WriteFile(auditName, []byte(audit), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}, History: 100}
+	s, err := New(fileName, []*Event{e}, l, Access{Audit: auditName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if _, err = s.ReconstructSchedule("user", time.Now(), time.Now().Add(time.Hour)); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	monday := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	deliveries := []struct {
+		day    int
+		delay  time.Duration
+		result string
+	}{
+		{day: 4, delay: 30 * time.Minute, result: DeliverySent},
+		{day: 11, delay: 30 * time.Minute, result: DeliverySent},
+		{day: 18, delay: 30 * time.Minute, result: DeliverySent},
+		{day: 18, delay: time.Hour, result: DeliverySent},
+		{day: 25, delay: 30 * time.Minute, result: DeliveryFailed},
+		{day: 25, delay: 15 * time.Minute, result: DeliverySent},
+	}
+	for _, d := range deliveries {
+		s.history.add(Delivery{User: "user", Event: "test", Delay: d.delay, Start: monday(d.day, 10, 0), Result: d.result})
+	}
+	report, err := s.ReconstructSchedule("user", monday(1, 0, 0), monday(26, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (report.Expected != 6) || (report.Sent != 5) {
+		t.Errorf("unexpected report expected=%d sent=%d", report.Expected, report.Sent)
+	}
+	expected := []struct {
+		ts     time.Time
+		result string
+	}{
+		{ts: monday(25, 9, 0), result: DiscrepancyMissing},
+		{ts: monday(25, 9, 30), result: DeliveryFailed},
+		{ts: monday(25, 9, 45), result: DiscrepancyUnexpected},
+	}
+	if n := len(report.Discrepancies); n != len(expected) {
+		t.Fatalf("unexpected discrepancies %v", report.Discrepancies)
+	}
+	for i, d := range report.Discrepancies {
+		if !d.Item.Timestamp.Equal(expected[i].ts) || (d.Result != expected[i].result) {
+			t.Errorf("case [%d]: unexpected discrepancy %v", i, d)
+		}
+	}
+}
+
+func TestEventHolidays(t *testing.T) {
+	e := &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Holidays: []string{"04-08"}, TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddHolidays([]string{"2024-04-22"}); err != nil {
+		t.Fatal(err)
+	}
+	dt := time.Date(2024, 3, 26, 12, 0, 0, 0, time.UTC)
+	expected := []time.Time{
+		time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 15, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 29, 10, 0, 0, 0, time.UTC),
+	}
+	start := e.nextIn(dt, nil)
+	for i := range expected {
+		if !start.Equal(expected[i]) {
+			t.Errorf("case [%d]: failed compare %v != %v", i, expected[i], start)
+		}
+		start = e.nextIn(start.Add(time.Nanosecond), nil)
+	}
+	// "MM-DD" holiday repeats every year
+	if start = e.nextIn(time.Date(2030, 4, 2, 12, 0, 0, 0, time.UTC), nil); !start.Equal(time.Date(2030, 4, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("failed yearly holiday %v", start)
+	}
+	if err := e.AddHolidays([]string{"2024-13-01"}); err == nil {
+		t.Error("expected error for invalid holiday")
+	}
+	e = &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Holidays: []string{"April 8"}, TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for invalid event's holiday")
+	}
+}
+
+func TestVacation(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	v, err := ParseVacation("2024-07-01 2024-07-14", time.UTC, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := v.String(); s != "2024-07-01 - 2024-07-14" {
+		t.Errorf("unexpected vacation %q", s)
+	}
+	if !v.contains(now) || !v.contains(time.Date(2024, 7, 14, 23, 0, 0, 0, time.UTC)) || v.contains(v.To) {
+		t.Error("unexpected vacation period")
+	}
+	if v, err = ParseVacation("off", time.UTC, now); (err != nil) || (v != nil) || v.contains(now) {
+		t.Errorf("failed vacation off: %v %v", v, err)
+	}
+	for _, value := range []string{"", "2024-07-01", "2024-07-14 2024-07-01", "2024-06-01 2024-06-30", "01.07.2024 14.07.2024"} {
+		if _, err = ParseVacation(value, time.UTC, now); !errors.Is(err, ErrVacation) {
+			t.Errorf("unexpected error for %q: %v", value, err)
+		}
+	}
+
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}
+	s, err := New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetVacation(ctx, "user", "off"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Now().UTC().AddDate(0, 0, 1)
+	value := from.Format("2006-01-02") + " " + from.AddDate(0, 0, 6).Format("2006-01-02")
+	if err = s.SetVacation(ctx, "user", value); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if s.onVacation("user", time.Now()) || !s.onVacation("user", from) || s.onVacation("user", from.AddDate(0, 0, 7)) {
+		t.Error("unexpected vacation after reopening")
+	}
+	if users := s.Users(); (len(users) != 1) || (users[0].Vacation == nil) {
+		t.Errorf("unexpected users %v", users)
+	}
+	if text, err := s.Get(ctx, "user"); (err != nil) || !strings.Contains(text, "Vacation: ") {
+		t.Errorf("unexpected parameters %q: %v", text, err)
+	}
+	if err = s.SetVacation(ctx, "user", "off"); err != nil {
+		t.Fatal(err)
+	}
+	if s.onVacation("user", from) {
+		t.Error("vacation is not cancelled")
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "mtbot.log")
+	l := NewLogger(true)
+	if err := l.OpenFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	l.Info.Printf("not redacted [%s]: %s", "user@example.com", LogContent("hello"))
+	if err := l.SetRedaction("secret-token", RedactHash, "info"); err != nil {
+		t.Fatal(err)
+	}
+	m := &userMsg{user: "user@example.com", event: "standup", text: "private text"}
+	l.Info.Printf("token=secret-token chat=123456 group=42@chat.agent [%v]", m)
+	l.Debug.Printf("debug [%v]", m)
+	if err := l.SetRedaction("secret-token", RedactMask, "none"); err != nil {
+		t.Fatal(err)
+	}
+	l.Error.Printf("masked user=user@example.com: %s", LogContent("hidden"))
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	userHash := (&redaction{chats: RedactHash}).chat("user@example.com")
+	expected := []string{
+		"not redacted [user@example.com]: hello",
+		"token=*** chat=#",
+		"[user=" + userHash + ` event="standup" start=0001-01-01T00:00:00Z text=private text]`,
+		"debug [user=" + userHash + ` event="standup" start=0001-01-01T00:00:00Z text=[redacted]]`,
+		"masked user=***: [redacted]",
+	}
+	for _, s := range expected {
+		if !strings.Contains(text, s) {
+			t.Errorf("not found %q in %q", s, text)
+		}
+	}
+	for _, s := range []string{"secret-token", "123456", "42@chat.agent", "\x02"} {
+		if strings.Contains(text, s) {
+			t.Errorf("unexpected %q in %q", s, text)
+		}
+	}
+	if err = l.SetRedaction("", "unknown", ""); err == nil {
+		t.Error("expected error for unknown chats mode")
+	}
+	if err = l.SetRedaction("", "", "trace"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestAnonymizedMode(t *testing.T) {
+	a := newAnonymizer("key")
+	if id := a.id("user@example.com"); (id == "user@example.com") || !strings.HasPrefix(id, anonymousPrefix) || (id != a.id("user@example.com")) {
+		t.Errorf("unexpected anonymized id %q", id)
+	}
+	if newAnonymizer("other").id("user@example.com") == a.id("user@example.com") {
+		t.Error("the same hash for different keys")
+	}
+	if id := newAnonymizer("").id("user@example.com"); id != "user@example.com" {
+		t.Errorf("unexpected id %q without anonymization", id)
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName, auditName := filepath.Join(dir, "users.csv"), filepath.Join(dir, "audit.log")
+	e := &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}
+	s, err := New(fileName, []*Event{e}, l, Access{Audit: auditName, Anonymize: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(auditName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "user@example.com") {
+		t.Errorf("raw chat ID in audit %q", data)
+	}
+	records, err := s.Audit("user@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonID := a.id("user@example.com")
+	if (len(records) != 1) || (records[0].User != anonID) {
+		t.Errorf("unexpected audit records %v", records)
+	}
+	snapshot := s.Snapshot()
+	if (len(snapshot.Users) != 1) || (snapshot.Users[0].Name != anonID) {
+		t.Errorf("unexpected users %v", snapshot.Users)
+	}
+	if len(snapshot.Items) == 0 {
+		t.Fatal("no items")
+	}
+	for _, item := range snapshot.Items {
+		if item.User != anonID {
+			t.Errorf("unexpected item %v", item)
+		}
+	}
+	if users := s.Users(); (len(users) != 1) || (users[0].Name != anonID) {
+		t.Errorf("unexpected users %v", users)
+	}
+	// the users database keeps raw chat IDs to send messages
+	if _, err = s.Get(ctx, "user@example.com"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestICalendar(t *testing.T) {
+	rules := []struct {
+		rule     string
+		weekdays []string
+		monthly  string
+		date     string
+		until    string
+	}{
+		{rule: "FREQ=WEEKLY;BYDAY=MO,WE", weekdays: []string{"Monday", "Wednesday"}},
+		{rule: "RRULE:FREQ=DAILY;UNTIL=20240630T235959Z", weekdays: []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}, until: "2024-06-30"},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=-1", monthly: "last"},
+		{rule: "FREQ=MONTHLY;BYDAY=2TU", monthly: "second Tuesday"},
+		{rule: "FREQ=MONTHLY;BYDAY=FR;BYSETPOS=-1", monthly: "last Friday"},
+		{rule: "FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=8;UNTIL=20300308", date: "03-08", until: "2030-03-08"},
+	}
+	for i, c := range rules {
+		r, err := parseRRule(c.rule, time.Time{}, time.UTC)
+		if err != nil {
+			t.Errorf("case [%d]: %v", i, err)
+			continue
+		}
+		if (strings.Join(r.weekdays, ",") != strings.Join(c.weekdays, ",")) || (r.monthly != c.monthly) || (r.date != c.date) || (r.until != c.until) {
+			t.Errorf("case [%d]: unexpected rule %+v", i, r)
+		}
+	}
+	for _, rule := range []string{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", "FREQ=DAILY;COUNT=5", "FREQ=WEEKLY", "FREQ=HOURLY", "FREQ=MONTHLY;BYDAY=5MO", "WEEKLY"} {
+		if _, err := parseRRule(rule, time.Time{}, time.UTC); !errors.Is(err, ErrRRule) {
+			t.Errorf("unexpected error for %q: %v", rule, err)
+		}
+	}
+
+	e := &Event{Title: "test", RRule: "FREQ=WEEKLY;BYDAY=TU,TH", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dt := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	if start := e.nextIn(dt, nil); !start.Equal(time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected rrule start %v", start)
+	}
+	e = &Event{Title: "test", RRule: "FREQ=WEEKLY;BYDAY=TU", Cron: "0 10 * * 2", TimeZone: "UTC"}
+	if err := e.Init(); err == nil {
+		t.Error("expected error for rrule with cron")
+	}
+
+	calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Standup\r\nDESCRIPTION:Daily sync\\, bring\r\n  updates\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20240401T093000\r\nRRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR\r\n" +
+		"EXDATE;TZID=Europe/Berlin:20240501T093000,20240509T093000\r\n" +
+		"BEGIN:VALARM\r\nACTION:DISPLAY\r\nDESCRIPTION:Reminder\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Launch\r\nDTSTART:20300601T080000Z\r\nLOCATION:Room 1\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Cancelled\r\nSTATUS:CANCELLED\r\nDTSTART:20300601T080000Z\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Standup\r\nRECURRENCE-ID;TZID=Europe/Berlin:20240402T093000\r\nDTSTART;TZID=Europe/Berlin:20240402T100000\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := ParseICalendar(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected events %v", events)
+	}
+	for _, e := range events {
+		if err = e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	standup, launch := events[0], events[1]
+	if (standup.Title != "Standup") || (standup.Message != "Daily sync, bring updates") || (standup.TimeZone != "Europe/Berlin") {
+		t.Errorf("unexpected event %+v", standup)
+	}
+	if strings.Join(standup.Holidays, ",") != "2024-05-01,2024-05-09" {
+		t.Errorf("unexpected holidays %v", standup.Holidays)
+	}
+	start := standup.nextIn(time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC), nil)
+	if expected := time.Date(2024, 5, 2, 9, 30, 0, 0, standup.zone); !start.Equal(expected) {
+		t.Errorf("failed compare %v != %v", expected, start)
+	}
+	if (launch.Once != "2030-06-01T08:00") || (launch.Location != "Room 1") || !launch.once.Equal(time.Date(2030, 6, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected event %+v", launch)
+	}
+	if _, err = ParseICalendar(strings.NewReader("BEGIN:VEVENT\nDTSTART:20240101T100000Z\nEND:VEVENT\n")); err == nil {
+		t.Error("expected error for event without summary")
+	}
+}
+
+func TestEventInvite(t *testing.T) {
+	e := &Event{
+		Title: "Standup", Message: "Daily sync", Location: "Room 1", Weekday: time.Monday, Period: "168h", StartHour: "10h",
+		TimeZone: "Europe/Berlin", CalendarButton: "Add to calendar", Duration: "30m",
+	}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 4, 1, 10, 0, 0, 0, e.zone)
+	ue := &userEvent{user: "user", event: e, delay: 5 * time.Minute, timestamp: start.Add(-5 * time.Minute)}
+	m := ue.Message(nil)
+	rows := m.buttons()
+	if len(rows) != 2 {
+		t.Fatalf("unexpected buttons %v", rows)
+	}
+	invite := rows[1][0]
+	if invite.label != "Add to calendar" {
+		t.Errorf("unexpected label %q", invite.label)
+	}
+	link, err := url.Parse(invite.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := link.Query()
+	if dates := values.Get("dates"); dates != "20240401T080000Z/20240401T083000Z" {
+		t.Errorf("unexpected dates %q", dates)
+	}
+	if (values.Get("text") != "Standup") || (values.Get("details") != "Daily sync") || (values.Get("location") != "Room 1") {
+		t.Errorf("unexpected link %q", invite.url)
+	}
+	e.CalendarButton = ""
+	if m = ue.Message(nil); len(m.buttons()) != 1 {
+		t.Errorf("unexpected buttons without calendar %v", m.buttons())
+	}
+	for _, d := range []string{"-1h", "1 hour"} {
+		e = &Event{Title: "test", Weekday: time.Monday, Period: "168h", StartHour: "10h", Duration: d}
+		if err = e.Init(); err == nil {
+			t.Errorf("expected error for duration %q", d)
+		}
+	}
+}
+
+func TestEventOwner(t *testing.T) {
+	ctx := context.Background()
+	events := []*Event{
+		{Title: "Standup", Weekday: time.Monday, Period: "168h", StartHour: "10h", TimeZone: "UTC", Owner: "lead@example.com"},
+		{Title: "Retro", Weekday: time.Friday, Period: "168h", StartHour: "17h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	text, err := s.Subscriptions(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "1. [x] Standup (owner lead@example.com)") || strings.Contains(text, "Retro (owner") {
+		t.Errorf("unexpected events %q", text)
+	}
+	card, err := s.FindEvent("standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(card.Text, "Contact: lead@example.com") {
+		t.Errorf("unexpected card %q", card.Text)
+	}
+	owner, text, err := s.Feedback("user", "1  the room is too small ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (owner != "lead@example.com") || (text != "Feedback on \"Standup\" from user:\n\nthe room is too small") {
+		t.Errorf("unexpected feedback %q to %q", text, owner)
+	}
+	errCases := map[string]error{"": ErrFeedback, "1": ErrFeedback, "one text": ErrFeedback, "3 text": ErrEvent, "2 text": ErrOwner}
+	for values, expected := range errCases {
+		if _, _, err = s.Feedback("user", values); !errors.Is(err, expected) {
+			t.Errorf("unexpected error for %q: %v", values, err)
+		}
+	}
+	ue := &userEvent{user: "user", event: events[0], delay: 5 * time.Minute, timestamp: events[0].alarm.Add(-5 * time.Minute)}
+	if m := ue.Message(nil); (m.owner != "lead@example.com") || (m.alertOwner(errors.New("failed")) != nil) {
+		t.Errorf("unexpected message owner %q", m.owner)
+	}
+}
+
+func TestLatencyBudget(t *testing.T) {
+	e := &Event{Title: "Standup", Weekday: time.Monday, Period: "168h", StartHour: "10h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, Budget: 60}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), []*Event{e}, l, Access{Admins: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ue := &userEvent{user: "user", event: e, delay: 5 * time.Minute, timestamp: e.alarm.Add(-5 * time.Minute)}
+	m := ue.Message(nil)
+	if !m.due.Equal(ue.timestamp) {
+		t.Errorf("unexpected due %v", m.due)
+	}
+	if _, ok := s.breach(&m, m.due.Add(30*time.Second)); ok {
+		t.Error("unexpected breach within the budget")
+	}
+	late, ok := s.breach(&m, m.due.Add(90*time.Second))
+	if !ok || (late != 30*time.Second) {
+		t.Errorf("unexpected breach %v %v", ok, late)
+	}
+	if err = s.escalate(&m, late); err != nil {
+		t.Errorf("unexpected escalation error %v", err)
+	}
+	stats := s.Stats()
+	if stats.Breaches != 1 {
+		t.Errorf("unexpected breaches %d", stats.Breaches)
+	}
+	var b strings.Builder
+	if err = stats.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "mtbot_latency_budget_breaches 1\n") {
+		t.Errorf("unexpected metrics %q", b.String())
+	}
+	// deferred by quota notifications have no deadline
+	q := newQuota(1)
+	q.postpone(m, m.due)
+	deferred := q.due(m.due.Add(48 * time.Hour))
+	if len(deferred) != 1 {
+		t.Fatalf("unexpected deferred %d", len(deferred))
+	}
+	if _, ok = s.breach(&deferred[0], m.due.Add(48*time.Hour)); ok {
+		t.Error("unexpected breach of deferred notification")
+	}
+	s.limits.Budget = 0
+	if _, ok = s.breach(&m, m.due.Add(time.Hour)); ok {
+		t.Error("unexpected breach with disabled budget")
+	}
+}
+
+func TestGoogleSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens, lists int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			if (r.FormValue("grant_type") != googleGrantType) || (strings.Count(r.FormValue("assertion"), ".") != 2) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"secret","expires_in":3600}`)
+		case "/calendars/team@example.com/events":
+			lists++
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("pageToken") == "" {
+				fmt.Fprint(w, `{"etag":"v1","timeZone":"Europe/Berlin","nextPageToken":"p2","items":[
+					{"summary":"Standup","description":"Daily sync\\n","start":{"dateTime":"2024-04-01T09:30:00+02:00","timeZone":"Europe/Berlin"},
+					 "recurrence":["RRULE:FREQ=WEEKLY;BYDAY=MO,WE","EXDATE;TZID=Europe/Berlin:20240501T093000"]},
+					{"summary":"Standup","recurringEventId":"standup","start":{"dateTime":"2024-04-03T10:00:00+02:00"}},
+					{"summary":"Removed","status":"cancelled","start":{"date":"2030-06-01"}}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"etag":"v1-2","items":[{"summary":"Launch","location":"Room 1","start":{"date":"2030-06-01"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	data, err := json.Marshal(map[string]string{
+		"client_email": "bot@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    ts.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(credentials, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := NewGoogleSource(credentials, "team@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.apiURL = ts.URL + "/calendars/"
+	events, ok, err := gs.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || (len(events) != 2) {
+		t.Fatalf("unexpected events %v %v", ok, events)
+	}
+	standup, launch := events[0], events[1]
+	if (standup.Message != `Daily sync\n`) || (standup.TimeZone != "Europe/Berlin") || (standup.StartHour != "9h30m0s") {
+		t.Errorf("unexpected event %+v", standup)
+	}
+	if (strings.Join(standup.Holidays, ",") != "2024-05-01") || (strings.Join(standup.Weekdays, ",") != "Monday,Wednesday") {
+		t.Errorf("unexpected schedule %v %v", standup.Weekdays, standup.Holidays)
+	}
+	if (launch.Once != "2030-06-01T00:00") || (launch.Location != "Room 1") || (launch.TimeZone != "Europe/Berlin") {
+		t.Errorf("unexpected event %+v", launch)
+	}
+	if _, ok, err = gs.Fetch(context.Background()); err != nil || ok {
+		t.Errorf("unexpected modified events %v: %v", ok, err)
+	}
+	if (tokens != 1) || (lists != 4) {
+		t.Errorf("unexpected requests tokens=%d lists=%d", tokens, lists)
+	}
+	if err = os.WriteFile(credentials, []byte(`{"client_email":"bot"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewGoogleSource(credentials, "team@example.com"); !errors.Is(err, ErrGoogleCredentials) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCalDAVSource(t *testing.T) {
+	standup := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:standup\r\nSUMMARY:Standup\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20240401T093000\r\nRRULE:FREQ=WEEKLY;BYDAY=MO,WE\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nRECURRENCE-ID;TZID=Europe/Berlin:20300603T093000\r\nSTATUS:CANCELLED\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20300603T093000\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	var (
+		mu      sync.Mutex
+		starts  = "093000"
+		reports int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if (r.Method != "REPORT") || (r.Header.Get("Depth") != "1") || !ok || (user != "bot") || (password != "secret") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		reports++
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav">
+			<d:response><d:href>/calendars/team/standup.ics</d:href>
+			<d:propstat><d:prop><cal:calendar-data>%s</cal:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+			</d:response>
+			<d:response><d:href>/calendars/team/empty.ics</d:href>
+			<d:propstat><d:prop><cal:calendar-data/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+			</d:response></d:multistatus>`, strings.ReplaceAll(standup, "093000", starts))
+	}))
+	defer ts.Close()
+
+	cs := NewCalDAVSource(ts.URL+"/calendars/team/", "bot", "secret")
+	events, ok, err := cs.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || (len(events) != 1) {
+		t.Fatalf("unexpected events %v %v", ok, events)
+	}
+	if e := events[0]; (e.Title != "Standup") || (e.StartHour != "9h30m0s") || (strings.Join(e.Holidays, ",") != "2030-06-03") {
+		t.Errorf("unexpected event %+v", e)
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if _, ok, err = cs.Fetch(context.Background()); err != nil || ok {
+		t.Errorf("unexpected modified events %v: %v", ok, err)
+	}
+	mu.Lock()
+	starts = "110000"
+	mu.Unlock()
+	if events, ok, err = cs.Fetch(context.Background()); err != nil || !ok {
+		t.Fatalf("unexpected not modified events %v: %v", ok, err)
+	}
+	changes := s.SetEvents(events)
+	if (len(changes) != 1) || (changes[0].Title != "Standup") || (s.events[0].StartHour != "11h0m0s") {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if reports != 3 {
+		t.Errorf("unexpected reports %d", reports)
+	}
+	cs = NewCalDAVSource(ts.URL, "bot", "wrong")
+	if _, _, err = cs.Fetch(context.Background()); err == nil {
+		t.Error("expected error for wrong password")
+	}
+}
+
+func TestTOMLEventsSource(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		document = "[[events]]\ntitle = \"remote\"\nperiod = \"168h\"\ntime = \"15h\"\ntimezone = \"UTC\"\nweekday = 2\n"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", TOMLType)
+		_, _ = w.Write([]byte(document))
+	}))
+	defer ts.Close()
+
+	static := &Event{Title: "static", Period: "24h", StartHour: "10h", TimeZone: "UTC"}
+	if err := static.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), []*Event{static}, Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	titles := func() string {
+		s.sched.RLock()
+		defer s.sched.RUnlock()
+		values := make([]string, len(s.events))
+		for i, e := range s.events {
+			values[i] = e.Title + "@" + e.StartHour
+		}
+		return strings.Join(values, ",")
+	}
+	wait := func(expected string) {
+		for i := 0; (i < 100) && (titles() != expected); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if value := titles(); value != expected {
+			t.Errorf("unexpected events %q", value)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	WatchEvents(ctx, s, EventsSource{Logger: NewLogger(false), URL: ts.URL, Period: 20 * time.Millisecond})
+	wait("static@10h,remote@15h")
+
+	// duplicate titles keep the current events
+	mu.Lock()
+	document = "[[events]]\ntitle = \"static\"\nperiod = \"24h\"\ntime = \"11h\"\ntimezone = \"UTC\"\n"
+	mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	wait("static@10h,remote@15h")
+
+	mu.Lock()
+	document = strings.ReplaceAll(document, "static", "remote")
+	mu.Unlock()
+	wait("static@10h,remote@11h")
+
+	events, err := decodeEvents(strings.NewReader(`[[events]]`+"\ntitle = 1\n"), "", "/events.toml")
+	if err == nil {
+		t.Errorf("expected error for invalid document, events %v", events)
+	}
+}
+
+func TestStaticEvents(t *testing.T) {
+	newEvent := func(title, start string) *Event {
+		e := &Event{Title: title, Period: "24h", StartHour: start, TimeZone: "UTC"}
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), []*Event{newEvent("static", "10h")}, Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ctx := context.Background()
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user", "30"); err != nil {
+		t.Fatal(err)
+	}
+	titles := func() string {
+		values := make([]string, len(s.events))
+		for i, e := range s.events {
+			values[i] = e.Title + "@" + e.StartHour
+		}
+		return strings.Join(values, ",")
+	}
+	if _, err = s.SetRemoteEvents([]*Event{newEvent("remote", "12h")}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := s.SetStaticEvents([]*Event{newEvent("static", "11h")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(changes) != 1) || (titles() != "static@11h,remote@12h") {
+		t.Errorf("unexpected events %q, changes %v", titles(), changes)
+	}
+	for _, ue := range s.shard("user").userIdx["user"] {
+		if (ue.event.Title == "static") && (ue.timestamp.Add(ue.delay).UTC().Hour() != 11) {
+			t.Errorf("not rebuilt item %v", ue.timestamp)
+		}
+	}
+	if _, err = s.SetStaticEvents([]*Event{newEvent("remote", "9h")}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err = s.SetRemoteEvents([]*Event{newEvent("static", "9h")}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if titles() != "static@11h,remote@12h" {
+		t.Errorf("unexpected events %q", titles())
+	}
+}
+
+func TestDisableEvent(t *testing.T) {
+	events := []*Event{
+		{Title: "Training", Period: "24h", StartHour: "10h", TimeZone: "Europe/Berlin"},
+		{Title: "Standup", Period: "24h", StartHour: "11h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ctx := context.Background()
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2030, 6, 10, 12, 0, 0, 0, time.UTC)
+	title, err := s.DisableEvent("1 2030-06-30", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "Training" {
+		t.Errorf("unexpected title %q", title)
+	}
+	if _, err = s.DisableEvent("2", now); err != nil {
+		t.Fatal(err)
+	}
+	last := time.Date(2030, 6, 30, 23, 0, 0, 0, time.UTC) // 2030-07-01T01:00 in Berlin
+	if !s.eventDisabled("Training", now) || !s.eventDisabled("Training", last.Add(-2*time.Hour)) || s.eventDisabled("Training", last) {
+		t.Error("unexpected disabling period")
+	}
+	if !s.eventDisabled("Standup", now.AddDate(1, 0, 0)) {
+		t.Error("event should be disabled until enabling")
+	}
+	text, err := s.Subscriptions(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Training (disabled until 2030-06-30)") || !strings.Contains(text, "Standup (disabled)") {
+		t.Errorf("unexpected events %q", text)
+	}
+	if title, err = s.EnableEvent("2"); (err != nil) || (title != "Standup") || s.eventDisabled("Standup", now) {
+		t.Errorf("unexpected enabling %q: %v", title, err)
+	}
+	errCases := map[string]error{"": ErrDisable, "one": ErrDisable, "1 30.06.2030": ErrDisable, "1 2030-06-01": ErrDisable, "3": ErrEvent, "1 2 3": ErrDisable}
+	for values, expected := range errCases {
+		if _, err = s.DisableEvent(values, now); !errors.Is(err, expected) {
+			t.Errorf("unexpected error for %q: %v", values, err)
+		}
+	}
+}
+
+func TestPersonalReminders(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2030, 6, 10, 12, 0, 0, 0, time.UTC) // Monday
+	cases := map[string]string{
+		"Thursday 19:00 weekly Running": "Thursday 19:00 weekly Running",
+		"thu 7:30 Morning run":          "Thursday 07:30 weekly Morning run",
+		"daily 08:30 Pills":             "daily 08:30 daily Pills",
+		"2030-07-01 09:00 Dentist":      "2030-07-01 09:00 once Dentist",
+		"Monday 15:00 once Lunch":       "2030-06-10 15:00 once Lunch",
+		"Monday 11:00 once Call":        "2030-06-17 11:00 once Call",
+	}
+	for value, expected := range cases {
+		r, err := parseReminder(value, 20, berlin, now)
+		if err != nil {
+			t.Errorf("failed reminder %q: %v", value, err)
+			continue
+		}
+		if v := r.value(); v != expected {
+			t.Errorf("unexpected reminder %q for %q", v, value)
+		}
+	}
+	for _, value := range []string{"", "daily 08:30", "daily 8h Pills", "Funday 10:00 Fun", "daily 08:30 once Pills",
+		"2030-07-01 09:00 weekly Dentist", "daily 08:30 a|b", "daily 08:30 Very long reminder's title"} {
+		if _, err = parseReminder(value, 20, berlin, now); !errors.Is(err, ErrReminder) {
+			t.Errorf("unexpected error for %q: %v", value, err)
+		}
+	}
+
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, Reminders: 2, ReminderLength: 20}
+	s, err := New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddReminder(ctx, "user", "daily 08:30 Pills"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"daily 08:30 Pills", "Thursday 19:00 Running"} {
+		if err = s.AddReminder(ctx, "user", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.AddReminder(ctx, "user", "daily 09:00 Walk"); !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if items := s.shard("user").userIdx["user"]; len(items) != 2 {
+		t.Errorf("unexpected items %v", items)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(fileName, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	text, err := s.Reminders(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Reminders:\n1. daily 08:30 daily Pills\n2. Thursday 19:00 weekly Running"; text != expected {
+		t.Errorf("unexpected reminders %q", text)
+	}
+	if users := s.Users(); (len(users) != 1) || (len(users[0].Reminders) != 2) {
+		t.Errorf("unexpected users %v", users)
+	}
+	for _, value := range []string{"0", "3", "one"} {
+		if err = s.RemoveReminder(ctx, "user", value); !errors.Is(err, ErrReminder) {
+			t.Errorf("unexpected error for %q: %v", value, err)
+		}
+	}
+	if err = s.RemoveReminder(ctx, "user", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if text, err = s.Reminders(ctx, "user"); (err != nil) || (text != "Reminders:\n1. Thursday 19:00 weekly Running") {
+		t.Errorf("unexpected reminders %q: %v", text, err)
+	}
+}
+
+func TestGroupEvents(t *testing.T) {
+	events := []*Event{{Title: "Standup", Period: "24h", StartHour: "10h", TimeZone: "UTC"}}
+	if err := events[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	l := Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}, GroupEvents: 1}
+	s, err := New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const group = "42@chat.agent"
+	for _, name := range []string{group, "alice", "bob"} {
+		if err = s.Start(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.AddGroupEvent(ctx, "alice", nil, "daily 08:30 Running"); !errors.Is(err, ErrGroupEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.AddGroupEvent(ctx, "1@chat.agent", nil, "daily 08:30 Running"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.AddGroupEvent(ctx, group, nil, "daily 8h30 Running"); !errors.Is(err, ErrGroupEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+	groupItems := func(name string) int {
+		sh := s.shard(name)
+		sh.RLock()
+		defer sh.RUnlock()
+		n := 0
+		for _, item := range sh.userIdx[name] {
+			if item.event.group == group {
+				n++
+			}
+		}
+		return n
+	}
+	if err = s.AddGroupEvent(ctx, group, []string{"carol", "alice"}, "daily 08:30 Running"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddGroupEvent(ctx, group, []string{"alice"}, "daily 09:30 Walking"); !errors.Is(err, ErrLimit) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if n := groupItems("alice"); n != 1 {
+		t.Errorf("unexpected alice's group items %d", n)
+	}
+	if n := groupItems("bob") + groupItems(group); n != 0 {
+		t.Errorf("unexpected group items %d", n)
+	}
+	if err = s.Start(ctx, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if n := groupItems("carol"); n != 1 {
+		t.Errorf("unexpected carol's group items %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(fileName, events, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if n := groupItems("alice"); n != 1 {
+		t.Errorf("unexpected alice's group items %d after reopening", n)
+	}
+	text, err := s.GroupEvents(ctx, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Group events for 2 members:\n1. daily 08:30 daily Running"; text != expected {
+		t.Errorf("unexpected group events %q", text)
+	}
+	if err = s.RemoveGroupEvent(ctx, group, "2"); !errors.Is(err, ErrGroupEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.RemoveGroupEvent(ctx, group, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := groupItems("alice") + groupItems("carol"); n != 0 {
+		t.Errorf("unexpected group items %d after removing", n)
+	}
+}
+
+func TestEventTags(t *testing.T) {
+	events := []*Event{
+		{Title: "Match", Period: "168h", StartHour: "18h", TimeZone: "UTC", Tags: []string{"Football", "#sport"}},
+		{Title: "Standup", Period: "24h", StartHour: "10h", TimeZone: "UTC"},
+	}
+	for _, e := range events {
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !events[0].hasTag("football") || !events[0].hasTag("sport") || events[1].hasTag("sport") {
+		t.Errorf("unexpected tags %v", events[0].tags)
+	}
+	for _, tags := range [][]string{{""}, {"all"}, {"12"}, {"two words"}, {"a|b"}} {
+		e := &Event{Title: "Bad", Period: "24h", StartHour: "10h", TimeZone: "UTC", Tags: tags}
+		if err := e.Init(); err == nil {
+			t.Errorf("expected error for tags %q", tags)
+		}
+	}
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "users.csv"), events, Limits{Users: 5, Delays: 5, MinDelay: 1, MaxDelay: 100, DefaultDelays: []int{5}}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Subscribe(ctx, "user", "chess"); !errors.Is(err, ErrSubscription) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = s.Subscribe(ctx, "user", "FOOTBALL"); err != nil {
+		t.Fatal(err)
+	}
+	text, err := s.Subscriptions(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Events:\n1. [x] Match #football #sport\n2. [ ] Standup"; text != expected {
+		t.Errorf("unexpected events %q", text)
+	}
+	// a new event with the tag reaches the existing subscriber
+	cup := &Event{Title: "Cup", Period: "168h", StartHour: "20h", TimeZone: "UTC", Tags: []string{"football"}}
+	if err = cup.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetEvents(append(events, cup))
+	titles := make(map[string]bool)
+	for _, item := range s.shard("user").userIdx["user"] {
+		titles[item.event.Title] = true
+	}
+	if !titles["Match"] || !titles["Cup"] || titles["Standup"] {
+		t.Errorf("unexpected subscribed events %v", titles)
+	}
+	if names := s.subscribers("Cup", cup.tags); (len(names) != 1) || (names[0] != "user") {
+		t.Errorf("unexpected subscribers %v", names)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	var (
+		now    = time.Now()
+		window = 10 * time.Second
+		delays = []int64{int64(7 * time.Second), 0, int64(3 * time.Second)}
+	)
+	random := func(n int64) int64 {
+		if n != int64(window) {
+			t.Errorf("unexpected jitter window %v", time.Duration(n))
+		}
+		d := delays[0]
+		delays = delays[1:]
+		return d
+	}
+	notifications := []userMsg{
+		{user: "user1", event: "test"},
+		{user: "user2", event: "test", sendAt: now.Add(5 * time.Second)},
+		{user: "user3", event: "test"},
+	}
+	if result := jitter(notifications, 0, now, random); !result[0].sendAt.IsZero() {
+		t.Error("disabled jitter changed sending time")
+	}
+	result := jitter(notifications, window, now, random)
+	expected := []struct {
+		user   string
+		sendAt time.Time
+	}{
+		{user: "user3", sendAt: now.Add(3 * time.Second)},
+		{user: "user2", sendAt: now.Add(5 * time.Second)},
+		{user: "user1", sendAt: now.Add(7 * time.Second)},
+	}
+	for i, e := range expected {
+		if m := result[i]; (m.user != e.user) || !m.sendAt.Equal(e.sendAt) {
+			t.Errorf("case [%d]: unexpected notification user=%s sendAt=%v", i, m.user, m.sendAt)
+		}
+	}
+}
+
+func TestUserMsgFetchURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/link" {
+			_, _ = w.Write([]byte("https://example.com/actual\n"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	cases := []struct {
+		source   string
+		expected string
+		fail     bool
+	}{
+		{source: "", expected: "https://example.com/template"},
+		{source: ts.URL + "/link", expected: "https://example.com/actual"},
+		{source: ts.URL + "/broken", expected: "https://example.com/template", fail: true},
+	}
+	for i, c := range cases {
+		m := &userMsg{url: "https://example.com/template", urlSource: c.source}
+		if err := m.fetchURL(); (err != nil) != c.fail {
+			t.Errorf("case [%d]: unexpected error: %v", i, err)
+		}
+		if m.url != c.expected {
+			t.Errorf("case [%d]: failed compare %q != %q", i, c.expected, m.url)
+		}
+	}
+}
+
+func TestEventTitleSeparators(t *testing.T) {
+	for _, title := range []string{"a|b", "a=b", "x=1|y"} {
+		e := &Event{Title: title, Period: "1h", StartHour: "0h", TimeZone: "UTC"}
+		if err := e.Init(); err == nil {
+			t.Errorf("expected error for title %q", title)
+		}
+	}
+	e := &Event{Title: "a-b: c", Period: "1h", StartHour: "0h", TimeZone: "UTC"}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	u := &user{name: "user1", eventDelays: map[string][]time.Duration{e.Title: {5 * time.Minute}}}
+	delays, err := parseEventDelays(u.stringEventDelays())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := delays[e.Title]; (len(d) != 1) || (d[0] != 5*time.Minute) {
+		t.Errorf("unexpected delays %v", delays)
+	}
+}
+
+func TestSetDelaysLimits(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	s, err := New(fileName, nil, Limits{Users: 10, Delays: 5, MinDelay: 5, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(ctx, "user1", "5 100"); err != nil {
+		t.Errorf("delays in limits are not set: %v", err)
+	}
+	for _, values := range []string{"4", "101", "10 200"} {
+		if err = s.Set(ctx, "user1", values); !errors.Is(err, ErrDelay) {
+			t.Errorf("unexpected error for %q: %v", values, err)
+		}
+	}
+}
+
+func TestLoadUsersWithoutDelays(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(fileName, []byte("user1,5 10\nuser2,\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(fileName, nil, Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if n := s.usersCount(); n != 2 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	sh := s.shard("user2")
+	if u, ok := sh.users["user2"]; !ok || (len(u.delays) != 0) {
+		t.Errorf("unexpected started user without delays %v", u)
+	}
+}
+
+func TestEventYearlyLeapDay(t *testing.T) {
+	for _, date := range []string{"2000-02-29", "02-29"} {
+		e := &Event{Title: "Leap", Date: date, StartHour: "10h", TimeZone: "UTC"}
+		if err := e.Init(); err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			dt       time.Time
+			expected time.Time
+		}{
+			{dt: time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC), expected: time.Date(2023, 2, 28, 10, 0, 0, 0, time.UTC)},
+			{dt: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), expected: time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)},
+			{dt: time.Date(2024, 2, 29, 11, 0, 0, 0, time.UTC), expected: time.Date(2025, 2, 28, 10, 0, 0, 0, time.UTC)},
+		}
+		for i, c := range cases {
+			if a := e.next(c.dt); !a.Equal(c.expected) {
+				t.Errorf("date=%s case [%d]: failed compare %v != %v", date, i, c.expected, a)
+			}
+		}
+	}
+}
+
+func TestRestoreReplacesUsers(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backupFile := filepath.Join(dir, "backup.csv")
+	l := Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}
+	s, err := New(backupFile, nil, l, Access{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sources := []string{
+		filepath.Join(dir, "users.csv"),
+		filepath.Join(dir, "users.json"),
+		filepath.Join(dir, "users.db"),
+	}
+	// the Redis database is cleared by the test, so a dedicated one should be used
+	if source := os.Getenv("MTBOT_TEST_REDIS"); source != "" {
+		sources = append(sources, source)
+	}
+	for _, source := range sources {
+		if s, err = New(source, nil, l, Access{}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"user2", "user3"} {
+			if err = s.Start(ctx, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := Restore(ctx, backupFile, source, Access{}); (err != nil) || (n != 1) {
+			t.Fatalf("source=%s: restored %d users: %v", source, n, err)
+		}
+		b, err := openBackend(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := b.load()
+		if errClose := b.close(); errClose != nil {
+			t.Error(errClose)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(users) != 1) || (users[0].name != "user1") {
+			t.Errorf("source=%s: unexpected restored users %v", source, users)
+		}
+	}
+}
+
+func TestSealedFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	a := Access{Secret: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), secretSize))}
+	fileName, queuesFile := filepath.Join(dir, "users.json"), filepath.Join(dir, "queues.json")
+	s, err := New(fileName, nil, Limits{Users: 10, Delays: 5, MinDelay: 1, MaxDelay: 100}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OpenQueues(queuesFile); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	backupFile, err := s.Backup(ctx, dir, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{fileName, queuesFile, backupFile} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte(sealPrefix)) || bytes.Contains(data, []byte("user")) || bytes.Contains(data, []byte("{")) {
+			t.Errorf("not encrypted file %s: %q", name, data)
+		}
+	}
+	if s, err = New(fileName, nil, Limits{Users: 10}, a); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.OpenQueues(queuesFile); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.usersCount(); n != 1 {
+		t.Errorf("unexpected users count %d", n)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"users.db", "users.bolt", "redis://localhost:6379/0", MemorySource} {
+		if err = ValidSealed(source); err == nil {
+			t.Errorf("expected error for source=%s", source)
+		}
+	}
+	for _, source := range []string{"users.csv", "users.json"} {
+		if err = ValidSealed(source); err != nil {
+			t.Errorf("unexpected error for source=%s: %v", source, err)
+		}
+	}
+}
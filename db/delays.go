@@ -0,0 +1,213 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventDelaysSeparator separates events' delays in CSV column.
+	eventDelaysSeparator = "|"
+	// eventDelaysAssign separates event's title and its delays in CSV column.
+	eventDelaysAssign = "="
+	// commonDelays is a parameter to use common delays for the event.
+	commonDelays = "default"
+	// maxDurationLen is a maximum length of delay's duration syntax value.
+	maxDurationLen = 20
+	// maxMinutes is a maximum delay in minutes which fits time.Duration.
+	maxMinutes = int(math.MaxInt64 / int64(time.Minute))
+)
+
+// delaysOf returns user's delays of the event, they are common ones if the event has not own delays.
+func (u *user) delaysOf(title string) []time.Duration {
+	if delays, ok := u.eventDelays[title]; ok {
+		return delays
+	}
+	return u.delays
+}
+
+// eventTitles returns sorted titles of events with own user's delays.
+func (u *user) eventTitles() []string {
+	titles := make([]string, 0, len(u.eventDelays))
+	for title := range u.eventDelays {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// stringEventDelays returns events' own delays as a string, for example "training=15 60|standup=5".
+func (u *user) stringEventDelays() string {
+	titles := u.eventTitles()
+	values := make([]string, len(titles))
+	for i, title := range titles {
+		values[i] = title + eventDelaysAssign + joinDelays(u.eventDelays[title])
+	}
+	return strings.Join(values, eventDelaysSeparator)
+}
+
+// joinDelays returns space-separated delays.
+func joinDelays(delays []time.Duration) string {
+	values := make([]string, len(delays))
+	for i, d := range delays {
+		values[i] = formatDelay(d)
+	}
+	return strings.Join(values, " ")
+}
+
+// formatDelay returns whole minutes delay as a number of minutes, other ones in duration syntax.
+func formatDelay(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return strconv.FormatInt(int64(d/time.Minute), 10)
+	}
+	return d.String()
+}
+
+// parseDelay returns a delay from a number of minutes or a duration, for example "90" or "1h30m".
+// Negative delays like "-30" are reminders after the event's start.
+func parseDelay(value string) (time.Duration, error) {
+	digits := strings.TrimPrefix(value, "-")
+	if minutes, err := parseNumber(digits); err == nil {
+		if minutes > maxMinutes {
+			return 0, fmt.Errorf("too large delay %d minutes", minutes)
+		}
+		d := time.Duration(minutes) * time.Minute
+		if digits != value {
+			d = -d
+		}
+		return d, nil
+	}
+	if len(value) > maxDurationLen {
+		return 0, errNumber
+	}
+	return time.ParseDuration(value)
+}
+
+// delayValue is a delay in JSON data, it is a number of minutes or a duration string.
+type delayValue time.Duration
+
+// MarshalJSON returns whole minutes as a number and other delays as a duration string.
+func (dv delayValue) MarshalJSON() ([]byte, error) {
+	d := time.Duration(dv)
+	if d%time.Minute == 0 {
+		return json.Marshal(int64(d / time.Minute))
+	}
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON parses a delay from a number of minutes or a duration string.
+func (dv *delayValue) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	var s string
+	switch v := value.(type) {
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("delay value %s: %w", data, ErrDelay)
+	}
+	d, err := parseDelay(s)
+	if err != nil {
+		return fmt.Errorf("delay value %s: %v: %w", data, err, ErrDelay)
+	}
+	*dv = delayValue(d)
+	return nil
+}
+
+// Duration returns the delay as time.Duration.
+func (dv delayValue) Duration() time.Duration {
+	return time.Duration(dv)
+}
+
+// toDelayValues converts delays to their JSON values.
+func toDelayValues(delays []time.Duration) []delayValue {
+	values := make([]delayValue, len(delays))
+	for i, d := range delays {
+		values[i] = delayValue(d)
+	}
+	return values
+}
+
+// fromDelayValues converts JSON values to sorted unique delays.
+func fromDelayValues(values []delayValue) []time.Duration {
+	uniq := make(map[time.Duration]struct{}, len(values))
+	for _, v := range values {
+		uniq[v.Duration()] = struct{}{}
+	}
+	delays := make([]time.Duration, 0, len(uniq))
+	for d := range uniq {
+		delays = append(delays, d)
+	}
+	sortDelays(delays)
+	return delays
+}
+
+// sortDelays sorts delays in increasing order.
+func sortDelays(delays []time.Duration) {
+	sort.Slice(delays, func(i, j int) bool {
+		return delays[i] < delays[j]
+	})
+}
+
+// parseEventDelays returns events' own delays from the string formatted by stringEventDelays.
+// Delays' limits are not checked, nil result means that there are no events' own delays.
+func parseEventDelays(value string) (map[string][]time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	result := make(map[string][]time.Duration)
+	for _, item := range strings.Split(value, eventDelaysSeparator) {
+		i := strings.LastIndex(item, eventDelaysAssign)
+		if i < 1 {
+			return nil, fmt.Errorf("event delays %q: %w", item, ErrDelay)
+		}
+		delays, err := parseDelays(item[i+1:], 0, 0, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("event delays %q: %w", item, err)
+		}
+		result[item[:i]] = delays
+	}
+	return result, nil
+}
+
+// copyEventDelays returns a deep copy of events' own delays, nil for empty ones.
+func copyEventDelays(eventDelays map[string][]time.Duration) map[string][]time.Duration {
+	if len(eventDelays) == 0 {
+		return nil
+	}
+	result := make(map[string][]time.Duration, len(eventDelays))
+	for title, delays := range eventDelays {
+		result[title] = append([]time.Duration{}, delays...)
+	}
+	return result
+}
+
+// splitEventParams returns event's title and delays' values if values start with the known event's title,
+// the title is case-insensitive. Empty title means common delays.
+func splitEventParams(values string, events []*Event) (string, string) {
+	fields := strings.Fields(values)
+	if len(fields) < 2 {
+		return "", values
+	}
+	if _, err := parseNumber(fields[0]); err == nil {
+		return "", values
+	}
+	for k := len(fields) - 1; k > 0; k-- {
+		prefix := strings.Join(fields[:k], " ")
+		for _, e := range events {
+			if strings.EqualFold(normalize(e.Title), prefix) {
+				return e.Title, strings.Join(fields[k:], " ")
+			}
+		}
+	}
+	return "", values
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDisable is an error when event's disabling parameters are invalid.
+var ErrDisable = errors.New("invalid event disabling")
+
+// eventByNumber returns the event by its number from /events.
+// The caller should hold sched lock.
+func (s *Storage) eventByNumber(value string) (*Event, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: event number %q", ErrDisable, value)
+	}
+	if (number < 1) || (number > len(s.events)) {
+		return nil, fmt.Errorf("event number %d: %w", number, ErrEvent)
+	}
+	return s.events[number-1], nil
+}
+
+// DisableEvent disables event's notifications by values "<event number> [last date]",
+// the event is disabled until enabling if the date is not set. It returns the event's title.
+func (s *Storage) DisableEvent(values string, now time.Time) (string, error) {
+	fields := strings.Fields(values)
+	if (len(fields) < 1) || (len(fields) > 2) {
+		return "", ErrDisable
+	}
+	s.sched.Lock()
+	defer s.sched.Unlock()
+
+	e, err := s.eventByNumber(fields[0])
+	if err != nil {
+		return "", err
+	}
+	var until time.Time
+	if len(fields) == 2 {
+		last, err := time.ParseInLocation("2006-01-02", fields[1], e.zone)
+		if err != nil {
+			return "", fmt.Errorf("%w: date %q", ErrDisable, fields[1])
+		}
+		if until = last.AddDate(0, 0, 1); !until.After(now) {
+			return "", fmt.Errorf("%w: past date %s", ErrDisable, fields[1])
+		}
+	}
+	s.disabled[e.Title] = until
+	return e.Title, nil
+}
+
+// EnableEvent enables disabled event's notifications by its number, it returns the event's title.
+func (s *Storage) EnableEvent(values string) (string, error) {
+	fields := strings.Fields(values)
+	if len(fields) != 1 {
+		return "", ErrDisable
+	}
+	s.sched.Lock()
+	defer s.sched.Unlock()
+
+	e, err := s.eventByNumber(fields[0])
+	if err != nil {
+		return "", err
+	}
+	delete(s.disabled, e.Title)
+	return e.Title, nil
+}
+
+// disabledAt returns the end of event's disabling and true if the event is disabled at t time,
+// the zero end means disabling until enabling. The caller should hold sched lock.
+func (s *Storage) disabledAt(title string, t time.Time) (time.Time, bool) {
+	until, ok := s.disabled[title]
+	if !ok || (!until.IsZero() && !t.Before(until)) {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// eventDisabled returns true if event's occurrence at t time is disabled.
+func (s *Storage) eventDisabled(title string, t time.Time) bool {
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+	_, ok := s.disabledAt(title, t)
+	return ok
+}
+
+// disabledText returns event's disabling mark for events' list, it is empty for enabled events.
+// The caller should hold sched lock.
+func (s *Storage) disabledText(title string, now time.Time) string {
+	until, ok := s.disabledAt(title, now)
+	switch {
+	case !ok:
+		return ""
+	case until.IsZero():
+		return " (disabled)"
+	}
+	return fmt.Sprintf(" (disabled until %s)", until.AddDate(0, 0, -1).Format("2006-01-02"))
+}
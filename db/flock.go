@@ -0,0 +1,11 @@
+package db
+
+import "errors"
+
+// lockSuffix is a suffix of users' file lock.
+// The lock is a separate file, because backends replace the users file by renaming a new one,
+// so a lock on the replaced file would not protect the next one.
+const lockSuffix = ".lock"
+
+// ErrLocked is an error when the users file is already used by another process.
+var ErrLocked = errors.New("users file is locked by another process")
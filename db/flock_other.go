@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package db
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile opens the lock file, other platforms have no advisory locks, so concurrent instances are not detected.
+func lockFile(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("users lock open: %w", err)
+	}
+	return f, nil
+}
+
+// unlockFile closes the lock file.
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
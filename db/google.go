@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// googleAPIURL is Google Calendar API's calendars endpoint.
+	googleAPIURL = "https://www.googleapis.com/calendar/v3/calendars/"
+	// googleScope is a read-only access to calendars.
+	googleScope = "https://www.googleapis.com/auth/calendar.readonly"
+	// googleGrantType is OAuth grant type of service accounts' signed assertions.
+	googleGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	// googleTokenTTL is a lifetime of the signed assertion.
+	googleTokenTTL = time.Hour
+	// googlePageSize is a number of events per API's response.
+	googlePageSize = "250"
+)
+
+// ErrGoogleCredentials is an error when Google service account's credentials are not valid.
+var ErrGoogleCredentials = errors.New("invalid google credentials")
+
+// icalEscaper escapes text values, so iCalendar unescaping returns them as is.
+var icalEscaper = strings.NewReplacer(`\`, `\\`)
+
+// googleCredentials is a service account's key file.
+type googleCredentials struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// googleTime is a start of Google Calendar's event, all-day events have only a date.
+type googleTime struct {
+	Date     string `json:"date"`
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+// property returns iCalendar's DTSTART property, loc is the calendar's time zone.
+func (gt *googleTime) property(loc *time.Location) (icalProperty, error) {
+	if gt.TimeZone != "" {
+		l, err := loadLocation(gt.TimeZone)
+		if err != nil {
+			return icalProperty{}, fmt.Errorf("time zone %q: %w", gt.TimeZone, err)
+		}
+		loc = l
+	}
+	p := icalProperty{params: make(map[string]string)}
+	if loc != time.UTC {
+		p.params["TZID"] = loc.String()
+	}
+	if gt.DateTime == "" {
+		p.value = strings.ReplaceAll(gt.Date, "-", "")
+		return p, nil
+	}
+	t, err := time.Parse(time.RFC3339, gt.DateTime)
+	if err != nil {
+		return icalProperty{}, err
+	}
+	p.value = t.In(loc).Format("20060102T150405")
+	return p, nil
+}
+
+// googleEvent is Google Calendar's event, recurrence contains iCalendar's RRULE and EXDATE lines.
+type googleEvent struct {
+	Status           string     `json:"status"`
+	Summary          string     `json:"summary"`
+	Description      string     `json:"description"`
+	Location         string     `json:"location"`
+	HTMLLink         string     `json:"htmlLink"`
+	Start            googleTime `json:"start"`
+	Recurrence       []string   `json:"recurrence"`
+	RecurringEventID string     `json:"recurringEventId"`
+}
+
+// event returns not initialized event, it is converted the same way as iCalendar's VEVENT.
+func (ge *googleEvent) event(loc *time.Location) (*Event, error) {
+	dtstart, err := ge.Start.property(loc)
+	if err != nil {
+		return nil, fmt.Errorf("start of event=%s: %w", ge.Summary, err)
+	}
+	props := map[string][]icalProperty{
+		"SUMMARY":     {{value: icalEscaper.Replace(ge.Summary)}},
+		"DESCRIPTION": {{value: icalEscaper.Replace(ge.Description)}},
+		"LOCATION":    {{value: icalEscaper.Replace(ge.Location)}},
+		"URL":         {{value: icalEscaper.Replace(ge.HTMLLink)}},
+		"DTSTART":     {dtstart},
+	}
+	for _, line := range ge.Recurrence {
+		name, p, err := parseICalProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("recurrence of event=%s: %w", ge.Summary, err)
+		}
+		props[name] = append(props[name], p)
+	}
+	return icalEvent(props)
+}
+
+// googleEvents is a page of Google Calendar's events.
+type googleEvents struct {
+	ETag          string        `json:"etag"`
+	TimeZone      string        `json:"timeZone"`
+	NextPageToken string        `json:"nextPageToken"`
+	Items         []googleEvent `json:"items"`
+}
+
+// GoogleSource is a remote events source of Google Calendar read by a service account.
+// Its upcoming events are converted like iCalendar ones, changed occurrences are skipped.
+type GoogleSource struct {
+	calendar string
+	apiURL   string
+	email    string
+	tokenURI string
+	key      *rsa.PrivateKey
+	token    string    // cached access token
+	expire   time.Time // access token's expiration time
+	etag     string    // events' version of the latest fetch
+}
+
+// NewGoogleSource returns a source of calendarID events by the service account's credentials file.
+// The calendar should be shared with the service account's email.
+func NewGoogleSource(credentials, calendarID string) (*GoogleSource, error) {
+	data, err := os.ReadFile(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("read google credentials: %w", err)
+	}
+	var gc googleCredentials
+	if err = json.Unmarshal(data, &gc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGoogleCredentials, err)
+	}
+	if (gc.ClientEmail == "") || (gc.PrivateKey == "") || (gc.TokenURI == "") {
+		return nil, fmt.Errorf("%w: client_email, private_key and token_uri are required", ErrGoogleCredentials)
+	}
+	block, _ := pem.Decode([]byte(gc.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%w: private key is not PEM", ErrGoogleCredentials)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrGoogleCredentials, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: private key is not RSA", ErrGoogleCredentials)
+	}
+	gs := &GoogleSource{
+		calendar: calendarID,
+		apiURL:   googleAPIURL,
+		email:    gc.ClientEmail,
+		tokenURI: gc.TokenURI,
+		key:      rsaKey,
+	}
+	return gs, nil
+}
+
+// assertion returns the service account's signed JWT for access token request.
+func (gs *GoogleSource) assertion(now time.Time) (string, error) {
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   gs.email,
+		"scope": googleScope,
+		"aud":   gs.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(googleTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, gs.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign assertion: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// accessToken returns the cached access token or requests a new one if it is expired.
+func (gs *GoogleSource) accessToken(ctx context.Context, now time.Time) (string, error) {
+	if (gs.token != "") && now.Before(gs.expire) {
+		return gs.token, nil
+	}
+	assertion, err := gs.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {googleGrantType}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gs.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token status: %d", resp.StatusCode)
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxSourceSize)).Decode(&result); err != nil {
+		return "", fmt.Errorf("token decode: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("empty access token")
+	}
+	// the token is refreshed a bit earlier than it expires
+	gs.token, gs.expire = result.AccessToken, now.Add(time.Duration(result.ExpiresIn)*time.Second-time.Minute)
+	return gs.token, nil
+}
+
+// list requests a page of events which are not finished before now time.
+func (gs *GoogleSource) list(ctx context.Context, token, page string, now time.Time) (*googleEvents, error) {
+	values := url.Values{
+		"timeMin":      {now.UTC().Format(time.RFC3339)},
+		"maxResults":   {googlePageSize},
+		"singleEvents": {"false"},
+	}
+	if page != "" {
+		values.Set("pageToken", page)
+	}
+	endpoint := gs.apiURL + url.PathEscape(gs.calendar) + "/events?" + values.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("events request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("events response: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			// the token was revoked, a new one is requested next time
+			gs.token = ""
+		}
+		return nil, fmt.Errorf("events status: %d", resp.StatusCode)
+	}
+	var result googleEvents
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxSourceSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("events decode: %w", err)
+	}
+	return &result, nil
+}
+
+// Fetch requests upcoming calendar's events, it returns false if they were not modified.
+func (gs *GoogleSource) Fetch(ctx context.Context) ([]*Event, bool, error) {
+	now := time.Now()
+	token, err := gs.accessToken(ctx, now)
+	if err != nil {
+		return nil, false, fmt.Errorf("google calendar: %w", err)
+	}
+	var (
+		etag, zone, page string
+		items            []googleEvent
+	)
+	for {
+		result, err := gs.list(ctx, token, page, now)
+		if err != nil {
+			return nil, false, fmt.Errorf("google calendar: %w", err)
+		}
+		if page == "" {
+			etag, zone = result.ETag, result.TimeZone
+		}
+		items = append(items, result.Items...)
+		if page = result.NextPageToken; page == "" {
+			break
+		}
+	}
+	if (etag != "") && (etag == gs.etag) {
+		return nil, false, nil
+	}
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return nil, false, fmt.Errorf("google calendar time zone %q: %w", zone, err)
+	}
+	events := make([]*Event, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		if strings.EqualFold(item.Status, "cancelled") || (item.RecurringEventID != "") {
+			continue
+		}
+		e, err := item.event(loc)
+		if err != nil {
+			return nil, false, fmt.Errorf("google calendar event [%d]: %w", i, err)
+		}
+		if err = e.Init(); err != nil {
+			return nil, false, fmt.Errorf("google calendar event [%d]: %w", i, err)
+		}
+		events = append(events, e)
+	}
+	gs.etag = etag
+	return events, true, nil
+}
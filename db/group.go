@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupEventMessage is a message template of group chats' events notifications.
+const groupEventMessage = "Event of group chat %s"
+
+// ErrGroupEvent is an error when group chat's event parameters are invalid.
+var ErrGroupEvent = errors.New("invalid group event")
+
+// groupKey is an identifier of group chat's event.
+type groupKey struct {
+	group string
+	title string
+}
+
+// ownGroupEvents returns copies of reminders as group chat's events, so their messages contain the group.
+func ownGroupEvents(group string, reminders []*reminder) []*reminder {
+	if len(reminders) == 0 {
+		return nil
+	}
+	result := make([]*reminder, len(reminders))
+	for i, r := range reminders {
+		e := *r.event
+		e.Message, e.group = fmt.Sprintf(groupEventMessage, group), group
+		c := *r
+		c.event = &e
+		result[i] = &c
+	}
+	return result
+}
+
+// groupEventValues returns group chat's events' normalized values, nil if there are no events.
+func (u *user) groupEventValues() []string {
+	if len(u.groupEvents) == 0 {
+		return nil
+	}
+	values := make([]string, len(u.groupEvents))
+	for i, r := range u.groupEvents {
+		values[i] = r.value()
+	}
+	return values
+}
+
+// groupEventsColumn returns group chat's events as CSV column value.
+func (u *user) groupEventsColumn() string {
+	return strings.Join(u.groupEventValues(), remindersSeparator)
+}
+
+// isMember returns true if name is in sorted members.
+func isMember(members []string, name string) bool {
+	i := sort.SearchStrings(members, name)
+	return (i < len(members)) && (members[i] == name)
+}
+
+// indexGroup replaces group chat's events and members in the index by g user, nil or soft deleted one
+// only removes them. It returns the group's previous and new members. The caller should hold sched lock.
+func (s *Storage) indexGroup(name string, g *user) []string {
+	members := s.groupMembers[name]
+	for key := range s.groupEvents {
+		if key.group == name {
+			delete(s.groupEvents, key)
+		}
+	}
+	delete(s.groupMembers, name)
+	if (g == nil) || !g.deleted.IsZero() || (len(g.groupEvents) == 0) {
+		return members
+	}
+	for _, r := range g.groupEvents {
+		s.groupEvents[groupKey{group: name, title: r.title}] = r.event
+	}
+	s.groupMembers[name] = g.members
+	return sortedTitles(append(append(make([]string, 0, len(members)+len(g.members)), members...), g.members...))
+}
+
+// eventsOf returns events of the user including ones of its group chats. The caller should hold sched lock.
+func (s *Storage) eventsOf(name string) []*Event {
+	if len(s.groupEvents) == 0 {
+		return s.events
+	}
+	// the capacity is limited, so appending doesn't change storage's events
+	events := s.events[:len(s.events):len(s.events)]
+	for key, e := range s.groupEvents {
+		if isMember(s.groupMembers[key.group], name) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// reschedMembers rebuilds items of group chats' members. The caller should hold their shards and sched locks.
+func (s *Storage) reschedMembers(members []string, created time.Time) {
+	for _, name := range members {
+		sh := s.shard(name)
+		u, ok := sh.users[name]
+		if !ok {
+			continue
+		}
+		s.items.remove(sh.userIdx[name])
+		items := u.init(s.eventsOf(name))
+		for _, item := range items {
+			item.created = created
+		}
+		sh.userIdx[name] = items
+		s.items.add(items)
+	}
+}
+
+// regroup updates group chat's events in the index and reschedules its members' items.
+// The caller should hold persist lock, but not users' shards locks.
+func (s *Storage) regroup(name string, g *user) {
+	s.sched.Lock()
+	members := s.indexGroup(name, g)
+	s.sched.Unlock()
+
+	for _, member := range members {
+		sh := s.shard(member)
+		sh.Lock()
+		if u, ok := sh.users[member]; ok {
+			sh.userIdx[member] = s.schedItems(u, sh.userIdx[member])
+		}
+		sh.Unlock()
+	}
+}
+
+// AddGroupEvent adds group chat's event by value "<weekday|daily|date> <15:04> [once|daily|weekly] <title>"
+// and updates the group's members. Active members get the event with their delays and time zones,
+// independently of their subscriptions. The group chat should be a known user.
+func (s *Storage) AddGroupEvent(ctx context.Context, group string, members []string, value string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !isGroupChat(group) {
+		return fmt.Errorf("%w: chat=%s is not a group", ErrGroupEvent, group)
+	}
+	sh := s.shard(group)
+	sh.Lock()
+	g, ok := sh.users[group]
+	if !ok {
+		sh.Unlock()
+		return ErrUnknownUser
+	}
+	if len(g.groupEvents) >= s.limits.GroupEvents {
+		sh.Unlock()
+		return fmt.Errorf("group events %d: %w", s.limits.GroupEvents, ErrLimit)
+	}
+	loc := time.UTC
+	if g.zone != nil {
+		loc = g.zone
+	}
+	r, err := parseReminder(value, s.limits.ReminderLength, loc, time.Now())
+	if err != nil {
+		sh.Unlock()
+		return fmt.Errorf("%w: %v", ErrGroupEvent, err)
+	}
+	for _, item := range g.groupEvents {
+		if item.title == r.title {
+			sh.Unlock()
+			return fmt.Errorf("%w: duplicate title %q", ErrGroupEvent, r.title)
+		}
+	}
+	old := g.auditState()
+	// the slice is replaced, so its previous copies are not changed
+	events := make([]*reminder, 0, len(g.groupEvents)+1)
+	events = append(append(events, g.groupEvents...), r)
+	g.groupEvents, g.members, g.updated = ownGroupEvents(group, events), sortedTitles(members), time.Now()
+	sh.Unlock()
+
+	s.regroup(group, g)
+	if err = s.flushUsers(ctx, group); err != nil {
+		return fmt.Errorf("save group event chat=%s: %w", group, err)
+	}
+	return s.audit(g, AuditSet, old)
+}
+
+// RemoveGroupEvent removes group chat's event by its number from the group events' list.
+func (s *Storage) RemoveGroupEvent(ctx context.Context, group, value string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(group)
+	sh.Lock()
+	g, ok := sh.users[group]
+	if !ok {
+		sh.Unlock()
+		return ErrUnknownUser
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if (err != nil) || (number < 1) || (number > len(g.groupEvents)) {
+		sh.Unlock()
+		return fmt.Errorf("%w number %q", ErrGroupEvent, value)
+	}
+	old := g.auditState()
+	events := make([]*reminder, 0, len(g.groupEvents)-1)
+	events = append(events, g.groupEvents[:number-1]...)
+	events = append(events, g.groupEvents[number:]...)
+	if len(events) == 0 {
+		events = nil
+		g.members = nil
+	}
+	g.groupEvents, g.updated = events, time.Now()
+	sh.Unlock()
+
+	s.regroup(group, g)
+	if err = s.flushUsers(ctx, group); err != nil {
+		return fmt.Errorf("save group event chat=%s: %w", group, err)
+	}
+	return s.audit(g, AuditSet, old)
+}
+
+// GroupEvents returns a list of group chat's events with their numbers.
+func (s *Storage) GroupEvents(ctx context.Context, group string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sh := s.shard(group)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	g, ok := sh.users[group]
+	if !ok {
+		return "", ErrUnknownUser
+	}
+	if len(g.groupEvents) == 0 {
+		return "There are no group events", nil
+	}
+	lines := make([]string, len(g.groupEvents))
+	now := time.Now()
+	for i, r := range g.groupEvents {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, r.value())
+		if r.event.done(now) {
+			lines[i] += " (done)"
+		}
+	}
+	return fmt.Sprintf("Group events for %d members:\n%s", len(g.members), strings.Join(lines, "\n")), nil
+}
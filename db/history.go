@@ -0,0 +1,107 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// Delivery results.
+const (
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+	DeliverySkipped  = "skipped"  // the occurrence was disabled by event's check URL
+	DeliveryExpired  = "expired"  // the notification was too late after the event's start
+	DeliveryDeferred = "deferred" // the chat's daily quota is exceeded, the notification is postponed
+	DeliveryVacation = "vacation" // the user is on vacation during the event's start
+	DeliveryDisabled = "disabled" // the event was disabled by administrator
+)
+
+// Delivery is a handled notification's record.
+type Delivery struct {
+	User   string        `json:"user"`
+	Event  string        `json:"event"`
+	Delay  time.Duration `json:"delay"`
+	Start  time.Time     `json:"start"`  // event start time
+	Time   time.Time     `json:"time"`   // handling time
+	Result string        `json:"result"` // one of Delivery* constants
+	Error  string        `json:"error,omitempty"`
+	// Variant is event's message variant, empty for the main message
+	Variant string `json:"variant,omitempty"`
+}
+
+// history is a rolling log of the latest deliveries, the oldest records are overwritten.
+type history struct {
+	sync.Mutex
+	records []Delivery
+	next    int // position of the next record
+	full    bool
+}
+
+// newHistory returns history with size records capacity or nil if it is disabled.
+func newHistory(size int) *history {
+	if size <= 0 {
+		return nil
+	}
+	return &history{records: make([]Delivery, size)}
+}
+
+// add saves the delivery record, nil history ignores it.
+func (h *history) add(d Delivery) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	h.records[h.next] = d
+	if h.next++; h.next == len(h.records) {
+		h.next, h.full = 0, true
+	}
+}
+
+// list returns up to limit the latest records accepted by filter, newest first.
+// Zero limit means all records.
+func (h *history) list(filter func(d *Delivery) bool, limit int) []Delivery {
+	result := make([]Delivery, 0)
+	if h == nil {
+		return result
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	n := h.next
+	if h.full {
+		n = len(h.records)
+	}
+	for i := 0; i < n; i++ {
+		j := (h.next - 1 - i + len(h.records)) % len(h.records)
+		if d := &h.records[j]; filter(d) {
+			result = append(result, *d)
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// record saves the notification handling result to the storage's history and returns it.
+func (s *Storage) record(m *userMsg, result string, err error) Delivery {
+	d := Delivery{User: m.user, Event: m.event, Delay: m.delay, Start: m.start, Time: time.Now(), Result: result, Variant: m.variant}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if (m.variant != "") && (result == DeliverySent) {
+		s.variants.sent(m.user, m.event, m.variant)
+	}
+	s.history.add(d)
+	return d
+}
+
+// History returns up to limit the latest deliveries of the user, newest first.
+// Empty userName means all users, zero limit means all records.
+func (s *Storage) History(userName string, limit int) []Delivery {
+	return s.history.list(func(d *Delivery) bool {
+		return (userName == "") || (d.User == userName)
+	}, limit)
+}
@@ -0,0 +1,398 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ICalendarType is a content type of iCalendar documents.
+const ICalendarType = "text/calendar"
+
+// ErrRRule is an error when iCalendar recurrence rule can't be mapped to event's schedule.
+var ErrRRule = errors.New("unsupported recurrence rule")
+
+// icalWeekdays are iCalendar weekdays' names.
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// icalWeeks are monthly rule's weeks by iCalendar ordinal numbers.
+var icalWeeks = map[int]string{1: "first", 2: "second", 3: "third", 4: "fourth", -1: "last"}
+
+// rrule is event's schedule fields of iCalendar recurrence rule.
+type rrule struct {
+	weekdays []string
+	period   string
+	monthly  string
+	date     string
+	until    string
+}
+
+// icalWeekday returns a weekday and its ordinal number by iCalendar value, for example "MO" or "-1FR".
+func icalWeekday(value string) (time.Weekday, int, error) {
+	if len(value) < 2 {
+		return 0, 0, fmt.Errorf("%w: weekday %q", ErrRRule, value)
+	}
+	weekday, ok := icalWeekdays[value[len(value)-2:]]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: weekday %q", ErrRRule, value)
+	}
+	var n int
+	if prefix := value[:len(value)-2]; prefix != "" {
+		var err error
+		if n, err = strconv.Atoi(prefix); err != nil {
+			return 0, 0, fmt.Errorf("%w: weekday %q", ErrRRule, value)
+		}
+	}
+	return weekday, n, nil
+}
+
+// icalUntil returns the last date "2006-01-02" by iCalendar UNTIL value in the location.
+func icalUntil(value string, loc *time.Location) (string, error) {
+	const (
+		dateLayout = "20060102"
+		utcLayout  = "20060102T150405Z"
+	)
+	if t, err := time.Parse(utcLayout, value); err == nil {
+		return t.In(loc).Format("2006-01-02"), nil
+	}
+	if len(value) >= len(dateLayout) {
+		if t, err := time.Parse(dateLayout, value[:len(dateLayout)]); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("%w: until %q", ErrRRule, value)
+}
+
+// parseRRule maps iCalendar recurrence rule, for example "FREQ=WEEKLY;BYDAY=MO,WE", to event's schedule.
+// The first occurrence dtstart is used for missing days, it can be zero if the rule has them.
+// Only rules without intervals and counts are supported, because the schedule has no first occurrence.
+func parseRRule(value string, dtstart time.Time, loc *time.Location) (*rrule, error) {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(value), "RRULE:"), ";") {
+		i := strings.Index(part, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%w: invalid part %q", ErrRRule, part)
+		}
+		parts[strings.ToUpper(part[:i])] = strings.ToUpper(part[i+1:])
+	}
+	if interval, ok := parts["INTERVAL"]; ok && (interval != "1") {
+		return nil, fmt.Errorf("%w: interval %s", ErrRRule, interval)
+	}
+	if count, ok := parts["COUNT"]; ok {
+		return nil, fmt.Errorf("%w: count %s, use until", ErrRRule, count)
+	}
+	for _, name := range []string{"BYHOUR", "BYMINUTE", "BYSECOND", "BYWEEKNO", "BYYEARDAY"} {
+		if _, ok := parts[name]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrRRule, strings.ToLower(name))
+		}
+	}
+	var days []string
+	if parts["BYDAY"] != "" {
+		days = strings.Split(parts["BYDAY"], ",")
+	}
+	r := &rrule{}
+	switch freq := parts["FREQ"]; freq {
+	case "DAILY", "WEEKLY":
+		if (len(days) == 0) && (freq == "DAILY") {
+			days = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+		}
+		if len(days) == 0 {
+			if dtstart.IsZero() {
+				return nil, fmt.Errorf("%w: weekly without byday", ErrRRule)
+			}
+			days = []string{strings.ToUpper(dtstart.Weekday().String()[:2])}
+		}
+		for _, day := range days {
+			weekday, n, err := icalWeekday(day)
+			if err != nil {
+				return nil, err
+			}
+			if n != 0 {
+				return nil, fmt.Errorf("%w: %s weekday %q", ErrRRule, strings.ToLower(freq), day)
+			}
+			r.weekdays = append(r.weekdays, weekday.String())
+		}
+		r.period = (7 * 24 * time.Hour).String()
+	case "MONTHLY":
+		switch {
+		case parts["BYMONTHDAY"] != "":
+			day, err := strconv.Atoi(parts["BYMONTHDAY"])
+			switch {
+			case (err != nil) || (day == 0) || (day > 31) || (day < -1):
+				return nil, fmt.Errorf("%w: monthday %q", ErrRRule, parts["BYMONTHDAY"])
+			case day == -1:
+				r.monthly = "last"
+			default:
+				r.monthly = strconv.Itoa(day)
+			}
+		case len(days) == 1:
+			weekday, n, err := icalWeekday(days[0])
+			if err != nil {
+				return nil, err
+			}
+			if pos := parts["BYSETPOS"]; pos != "" {
+				if n, err = strconv.Atoi(pos); (err != nil) || (len(days[0]) != 2) {
+					return nil, fmt.Errorf("%w: setpos %q", ErrRRule, pos)
+				}
+			}
+			week, ok := icalWeeks[n]
+			if !ok {
+				return nil, fmt.Errorf("%w: monthly weekday %q", ErrRRule, days[0])
+			}
+			r.monthly = fmt.Sprintf("%s %s", week, weekday)
+		case (len(days) == 0) && !dtstart.IsZero():
+			r.monthly = strconv.Itoa(dtstart.Day())
+		default:
+			return nil, fmt.Errorf("%w: monthly days %q", ErrRRule, parts["BYDAY"])
+		}
+	case "YEARLY":
+		month, day := parts["BYMONTH"], parts["BYMONTHDAY"]
+		switch {
+		case (month != "") && (day != ""):
+			m, errMonth := strconv.Atoi(month)
+			d, errDay := strconv.Atoi(day)
+			if (errMonth != nil) || (errDay != nil) {
+				return nil, fmt.Errorf("%w: yearly date %s-%s", ErrRRule, month, day)
+			}
+			r.date = fmt.Sprintf("%02d-%02d", m, d)
+		case (month == "") && (day == "") && (len(days) == 0) && !dtstart.IsZero():
+			r.date = dtstart.Format("01-02")
+		default:
+			return nil, fmt.Errorf("%w: yearly days", ErrRRule)
+		}
+	default:
+		return nil, fmt.Errorf("%w: frequency %q", ErrRRule, freq)
+	}
+	if until := parts["UNTIL"]; until != "" {
+		var err error
+		if r.until, err = icalUntil(until, loc); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// apply sets the event's schedule fields by the rule.
+func (r *rrule) apply(e *Event) {
+	e.Weekdays, e.Period, e.Monthly, e.Date = r.weekdays, r.period, r.monthly, r.date
+	if r.until != "" {
+		e.Until = r.until
+	}
+}
+
+// applyRRule sets event's schedule by its recurrence rule in the location if it is not empty.
+func (e *Event) applyRRule(loc *time.Location) error {
+	if e.RRule == "" {
+		return nil
+	}
+	if (e.Cron != "") || (e.Once != "") {
+		return fmt.Errorf("event=%s has rrule with cron or once", e.Title)
+	}
+	r, err := parseRRule(e.RRule, time.Time{}, loc)
+	if err != nil {
+		return fmt.Errorf("rrule of event=%s: %w", e.Title, err)
+	}
+	r.apply(e)
+	return nil
+}
+
+// icalProperty is a content line of iCalendar component.
+type icalProperty struct {
+	params map[string]string
+	value  string
+}
+
+// icalText returns unescaped iCalendar text value.
+func icalText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// icalLines returns unfolded content lines of iCalendar document.
+func icalLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(r, maxSourceSize))
+	scanner.Buffer(make([]byte, 0, 4096), maxSourceSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (len(lines) > 0) && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read icalendar: %w", err)
+	}
+	return lines, nil
+}
+
+// parseICalProperty returns property's name and its parameters and value.
+func parseICalProperty(line string) (string, icalProperty, error) {
+	i := strings.Index(line, ":")
+	if i < 1 {
+		return "", icalProperty{}, fmt.Errorf("invalid icalendar line %q", line)
+	}
+	fields := strings.Split(line[:i], ";")
+	p := icalProperty{params: make(map[string]string, len(fields)-1), value: line[i+1:]}
+	for _, param := range fields[1:] {
+		if j := strings.Index(param, "="); j > 0 {
+			p.params[strings.ToUpper(param[:j])] = strings.Trim(param[j+1:], `"`)
+		}
+	}
+	return strings.ToUpper(fields[0]), p, nil
+}
+
+// icalTime returns iCalendar date or time value in the location of its TZID, UTC or floating times are UTC.
+// The result's zone name is empty for UTC.
+func icalTime(p icalProperty) (time.Time, string, error) {
+	var (
+		zone string
+		loc  = time.UTC
+	)
+	if tzid := p.params["TZID"]; tzid != "" {
+		l, err := loadLocation(tzid)
+		if err != nil {
+			return time.Time{}, "", fmt.Errorf("time zone %q: %w", tzid, err)
+		}
+		zone, loc = tzid, l
+	}
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, p.value, loc); err == nil {
+			return t, zone, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("invalid icalendar time %q", p.value)
+}
+
+// icalValue returns the first property's raw value, it is empty if the property is not set.
+func icalValue(props map[string][]icalProperty, name string) string {
+	if values := props[name]; len(values) > 0 {
+		return values[0].value
+	}
+	return ""
+}
+
+// icalEvent returns an event by VEVENT component's properties, it is not initialized.
+func icalEvent(props map[string][]icalProperty) (*Event, error) {
+	first := func(name string) string {
+		if values := props[name]; len(values) > 0 {
+			return icalText(values[0].value)
+		}
+		return ""
+	}
+	e := &Event{Title: first("SUMMARY"), Message: first("DESCRIPTION"), Location: first("LOCATION"), URL: first("URL")}
+	if e.Title == "" {
+		return nil, errors.New("event without summary")
+	}
+	if len(props["DTSTART"]) == 0 {
+		return nil, fmt.Errorf("event=%s without dtstart", e.Title)
+	}
+	dtstart, zone, err := icalTime(props["DTSTART"][0])
+	if err != nil {
+		return nil, fmt.Errorf("dtstart of event=%s: %w", e.Title, err)
+	}
+	e.TimeZone = zone
+	e.StartHour = (time.Duration(dtstart.Hour())*time.Hour + time.Duration(dtstart.Minute())*time.Minute).String()
+	if rules := props["RRULE"]; len(rules) > 0 {
+		r, err := parseRRule(rules[0].value, dtstart, dtstart.Location())
+		if err != nil {
+			return nil, fmt.Errorf("rrule of event=%s: %w", e.Title, err)
+		}
+		r.apply(e)
+	} else {
+		e.Once = dtstart.Format("2006-01-02T15:04")
+	}
+	for _, p := range props["EXDATE"] {
+		for _, value := range strings.Split(p.value, ",") {
+			t, _, err := icalTime(icalProperty{params: p.params, value: value})
+			if err != nil {
+				return nil, fmt.Errorf("exdate of event=%s: %w", e.Title, err)
+			}
+			e.Holidays = append(e.Holidays, t.In(dtstart.Location()).Format("2006-01-02"))
+		}
+	}
+	return e, nil
+}
+
+// ParseICalendar returns not initialized events of iCalendar document's VEVENT components.
+// Recurrence rules are mapped to events' schedules, events without them occur once,
+// excluded dates and cancelled occurrences are events' holidays. Cancelled events and changed occurrences are skipped.
+func ParseICalendar(r io.Reader) ([]*Event, error) {
+	lines, err := icalLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		events    []*Event
+		uids      []string // events' UIDs by their indexes
+		props     map[string][]icalProperty
+		nested    int                            // depth of components inside the event, for example, VALARM
+		cancelled = make(map[string][]time.Time) // cancelled occurrences by their events' UIDs
+	)
+	for _, line := range lines {
+		name, p, err := parseICalProperty(line)
+		if err != nil {
+			return nil, err
+		}
+		isEvent := strings.EqualFold(p.value, "VEVENT")
+		switch {
+		case (name == "BEGIN") && isEvent:
+			props, nested = make(map[string][]icalProperty), 0
+		case props == nil:
+			// calendar's property
+		case name == "BEGIN":
+			nested++
+		case (name == "END") && !isEvent:
+			nested--
+		case nested > 0:
+			// nested component's property
+		case name == "END":
+			uid, rid := icalValue(props, "UID"), props["RECURRENCE-ID"]
+			isCancelled := strings.EqualFold(icalValue(props, "STATUS"), "CANCELLED")
+			switch {
+			case (len(rid) > 0) && isCancelled && (uid != ""):
+				t, _, err := icalTime(rid[0])
+				if err != nil {
+					return nil, fmt.Errorf("recurrence-id of event uid=%s: %w", uid, err)
+				}
+				cancelled[uid] = append(cancelled[uid], t)
+			case (len(rid) == 0) && !isCancelled:
+				e, err := icalEvent(props)
+				if err != nil {
+					return nil, fmt.Errorf("icalendar event [%d]: %w", len(events), err)
+				}
+				events, uids = append(events, e), append(uids, uid)
+			}
+			props = nil
+		default:
+			props[name] = append(props[name], p)
+		}
+	}
+	for i, e := range events {
+		if len(cancelled[uids[i]]) == 0 {
+			continue
+		}
+		loc, err := loadLocation(e.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("time zone of event=%s: %w", e.Title, err)
+		}
+		for _, t := range cancelled[uids[i]] {
+			e.Holidays = append(e.Holidays, t.In(loc).Format("2006-01-02"))
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Import subscribes users to the event by its number from values, it is used for group chat's members.
+// Unknown users are created with default delays and only this subscription,
+// active users get the event like by Join, paused and soft deleted users are not changed.
+// It returns a number of created and changed users.
+func (s *Storage) Import(ctx context.Context, userNames []string, values string) (int, int, error) {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	s.sched.RLock()
+	titles, err := parseSubscriptions(values, s.events)
+	s.sched.RUnlock()
+	if err != nil {
+		return 0, 0, fmt.Errorf("import users: %w", err)
+	}
+	var (
+		created, joined int
+		now             = time.Now()
+		changed         = make([]*user, 0, len(userNames))
+		states          = make([]string, 0, len(userNames)) // changed users' audit states before the import
+		count           = s.usersCount()
+	)
+	for _, name := range userNames {
+		sh := s.shard(name)
+		sh.Lock()
+		u, ok := sh.users[name]
+		_, removed := sh.removed[name]
+		switch {
+		case removed || (ok && (!u.paused.IsZero() || (len(u.subscriptions) == 0))):
+			// stopped users or ones which already get all events
+		case ok:
+			states = append(states, u.auditState())
+			if titles != nil {
+				u.subscriptions = sortedTitles(append(u.subscriptions, titles...))
+			} else {
+				u.subscriptions = nil
+			}
+			u.updated = now
+			sh.userIdx[name] = s.schedItems(u, sh.userIdx[name])
+			changed = append(changed, u)
+			joined++
+		case count >= s.limits.Users:
+			err = fmt.Errorf("too many users %d > %d: %w", count, s.limits.Users, ErrLimit)
+		default:
+			states = append(states, u.auditState())
+			u = &user{name: name, delays: s.limits.defaultDelays(), subscriptions: titles, updated: now}
+			sh.users[name] = u
+			sh.userIdx[name] = s.schedItems(u, nil)
+			changed = append(changed, u)
+			created++
+			count++
+		}
+		sh.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	names := make([]string, len(changed))
+	for i, u := range changed {
+		names[i] = u.name
+	}
+	if errFlush := s.flushUsers(ctx, names...); errFlush != nil {
+		return created, joined, fmt.Errorf("import users: %w", errFlush)
+	}
+	for i, u := range changed {
+		if errAudit := s.audit(u, AuditImport, states[i]); errAudit != nil {
+			return created, joined, errAudit
+		}
+	}
+	return created, joined, err
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNumberLen is a maximum number of digits in users' numeric input.
+const maxNumberLen = 9
+
+// errNumber is an error when users' input is not a valid number.
+var errNumber = errors.New("invalid number")
+
+// normalize replaces all unicode spaces by single ASCII space, removes invisible format
+// characters and broken UTF-8 bytes, also leading and trailing spaces are trimmed.
+func normalize(s string) string {
+	var (
+		b     strings.Builder
+		space bool
+	)
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+		case (r == utf8.RuneError) || unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+			// skip invisible and broken characters
+		default:
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitValues returns normalized values separated by spaces, commas or semicolons.
+func splitValues(s string) []string {
+	return strings.FieldsFunc(normalize(s), func(r rune) bool {
+		return (r == ' ') || (r == ',') || (r == ';')
+	})
+}
+
+// parseNumber returns not negative number from ASCII digits only,
+// so signs, fractions and too large values are rejected.
+func parseNumber(s string) (int, error) {
+	if (s == "") || (len(s) > maxNumberLen) {
+		return 0, fmt.Errorf("%w %q, expected 1-%d digits", errNumber, s, maxNumberLen)
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0') || (s[i] > '9') {
+			return 0, fmt.Errorf("%w %q", errNumber, s)
+		}
+	}
+	return strconv.Atoi(s)
+}
+
+// ParseCommand returns bot command in lower case and its normalized parameters.
+// The first result is empty if the text is not a command.
+func ParseCommand(text string) (string, string) {
+	text = normalize(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	name, params := text, ""
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		name, params = text[:i], text[i+1:]
+	}
+	if name == "/" {
+		return "", ""
+	}
+	return strings.ToLower(name), params
+}
+
+// ParseMention returns normalized query after the leading mention of one of names, for example,
+// "standup" for "@[bot_id] standup" or "@mtbot standup". The second result is false if there is no mention.
+func ParseMention(text string, names ...string) (string, bool) {
+	text = normalize(text)
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, mention := range []string{"@[" + name + "]", "@" + name} {
+			if rest := strings.TrimPrefix(text, mention); (len(rest) < len(text)) && ((rest == "") || (rest[0] == ' ')) {
+				return strings.TrimSpace(rest), true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseDelays returns sorted unique delays from values in minutes or duration syntax,
+// minD, maxD and maxAfter limits are in minutes, they are not checked if maxD is zero.
+// Negative delays are reminders after the event's start, they are allowed up to maxAfter.
+func parseDelays(values string, minD, maxD, maxAfter, maxDelays int) ([]time.Duration, error) {
+	var (
+		fields     = splitValues(values)
+		uniqDelays = make(map[time.Duration]struct{}, len(fields))
+		minDelay   = time.Duration(minD) * time.Minute
+		maxDelay   = time.Duration(maxD) * time.Minute
+		afterDelay = time.Duration(maxAfter) * time.Minute
+	)
+	for _, field := range fields {
+		d, err := parseDelay(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed parse delay: %v: %w", err, ErrDelay)
+		}
+		if (maxD > 0) && (d < 0) && (-d > afterDelay) {
+			return nil, fmt.Errorf("too late delay %v after the start > %v: %w", -d, afterDelay, ErrDelay)
+		}
+		if (maxD > 0) && (d >= 0) && (d < minDelay) {
+			return nil, fmt.Errorf("too small delay %v < %v: %w", d, minDelay, ErrDelay)
+		}
+		if (maxD > 0) && (d > maxDelay) {
+			return nil, fmt.Errorf("too large delay %v > %v: %w", d, maxDelay, ErrDelay)
+		}
+		uniqDelays[d] = struct{}{}
+	}
+	lenDelays := len(uniqDelays)
+	if (maxDelays > 0) && (lenDelays > maxDelays) {
+		return nil, fmt.Errorf("too many user's delays %d > %d: %w", lenDelays, maxDelays, ErrLimit)
+	}
+	delays := make([]time.Duration, 0, lenDelays)
+	for d := range uniqDelays {
+		delays = append(delays, d)
+	}
+	sortDelays(delays)
+	return delays, nil
+}
+
+// parseUserRow returns user's name and delays from the row of name and delays values.
+func parseUserRow(userItem []string, minD, maxD, maxAfter, maxDelays int) (string, []time.Duration, error) {
+	const userValues = 2
+	if n := len(userItem); n != userValues {
+		return "", nil, fmt.Errorf("failed parse user data, len=%d: %q", n, userItem)
+	}
+	name := normalize(userItem[0])
+	if name == "" {
+		return "", nil, fmt.Errorf("empty user name: %q", userItem)
+	}
+	delays, err := parseDelays(userItem[1], minD, maxD, maxAfter, maxDelays)
+	if err != nil {
+		return "", nil, fmt.Errorf("user=%q: %w", name, err)
+	}
+	return name, delays, nil
+}
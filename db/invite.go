@@ -0,0 +1,56 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// inviteURL is a deep link which opens a new calendar event's form.
+	inviteURL = "https://calendar.google.com/calendar/render"
+	// inviteLayout is a layout of UTC times in calendar links.
+	inviteLayout = "20060102T150405Z"
+	// defaultDuration is a default occurrence's duration in calendar links.
+	defaultDuration = time.Hour
+)
+
+// validateInvite checks the occurrence's duration of event's calendar button.
+func (e *Event) validateInvite() error {
+	e.duration = defaultDuration
+	if e.Duration == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(e.Duration)
+	if err != nil {
+		return fmt.Errorf("duration of event=%s: %w", e.Title, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("not positive duration of event=%s: %v", e.Title, d)
+	}
+	e.duration = d
+	return nil
+}
+
+// inviteLink returns a deep link which adds the occurrence started at start time to user's calendar.
+func (e *Event) inviteLink(start time.Time) string {
+	end := start.Add(e.duration)
+	values := url.Values{
+		"action":  {"TEMPLATE"},
+		"text":    {e.Title},
+		"dates":   {start.UTC().Format(inviteLayout) + "/" + end.UTC().Format(inviteLayout)},
+		"details": {e.Message},
+	}
+	if e.Location != "" {
+		values.Set("location", e.Location)
+	}
+	return inviteURL + "?" + values.Encode()
+}
+
+// inviteButton returns "add to calendar" button of the occurrence, it is empty if the button is not configured.
+func (e *Event) inviteButton(start time.Time) msgButton {
+	if e.CalendarButton == "" {
+		return msgButton{}
+	}
+	return msgButton{label: e.CalendarButton, url: e.inviteLink(start)}
+}
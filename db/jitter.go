@@ -0,0 +1,26 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// jitter delays every notification by a random duration up to window after its smeared sending time,
+// so users of the same event and delay don't get them in a single burst. The random function returns
+// a number in [0, n). Notifications are returned ordered by sending time.
+func jitter(notifications []userMsg, window time.Duration, now time.Time, random func(n int64) int64) []userMsg {
+	if (window <= 0) || (len(notifications) == 0) {
+		return notifications
+	}
+	for i := range notifications {
+		m := &notifications[i]
+		if m.sendAt.IsZero() {
+			m.sendAt = now
+		}
+		m.sendAt = m.sendAt.Add(time.Duration(random(int64(window))))
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].sendAt.Before(notifications[j].sendAt)
+	})
+	return notifications
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// jsonUser is a user's record in JSON users file.
+type jsonUser struct {
+	ChatID        string                  `json:"chat_id"`
+	Delays        []delayValue            `json:"delays"`
+	Role          string                  `json:"role,omitempty"`
+	Subscriptions []string                `json:"subscriptions,omitempty"`
+	TimeZone      string                  `json:"timezone,omitempty"`
+	EventDelays   map[string][]delayValue `json:"event_delays,omitempty"`
+	Paused        *time.Time              `json:"paused,omitempty"`
+	Deleted       *time.Time              `json:"deleted,omitempty"`
+	Plain         bool                    `json:"plain,omitempty"`
+	Vacation      *Vacation               `json:"vacation,omitempty"`
+	Reminders     []string                `json:"reminders,omitempty"`
+	GroupEvents   []string                `json:"group_events,omitempty"`
+	Members       []string                `json:"members,omitempty"`
+}
+
+// jsonData is a content of JSON users file.
+// Files without version are arrays of users' records.
+type jsonData struct {
+	Version int        `json:"version"`
+	Users   []jsonUser `json:"users"`
+}
+
+// jsonBackend is a users' storage in JSON file.
+// Every change rewrites the whole file.
+type jsonBackend struct {
+	fileName string
+	lock     *os.File    // exclusive lock until the backend closing
+	aead     cipher.AEAD // file's encryption, nil - plain file
+}
+
+// newJSONBackend returns JSON file storage, it is locked, so other processes can't use the same file.
+func newJSONBackend(fileName string) (*jsonBackend, error) {
+	lock, err := lockFile(fileName + lockSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonBackend{fileName: fileName, lock: lock}, nil
+}
+
+// read parses JSON users file, a missing file has no users and version 0.
+func (b *jsonBackend) read() (*jsonData, error) {
+	data, err := os.ReadFile(b.fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &jsonData{}, nil
+		}
+		return nil, fmt.Errorf("users json read: %w", err)
+	}
+	if data, err = unseal(b.aead, data); err != nil {
+		return nil, err
+	}
+	content := &jsonData{}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		// not versioned data
+		err = json.Unmarshal(data, &content.Users)
+	} else {
+		err = json.Unmarshal(data, content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("users json parse: %w", err)
+	}
+	return content, nil
+}
+
+// version returns data version of JSON file.
+func (b *jsonBackend) version() (int, error) {
+	content, err := b.read()
+	if err != nil {
+		return 0, err
+	}
+	return content.Version, nil
+}
+
+// load reads users from JSON file.
+func (b *jsonBackend) load() ([]*user, error) {
+	content, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*user, len(content.Users))
+	for i, r := range content.Users {
+		role := RoleUser
+		if r.Role != "" {
+			if role, err = ParseRole(r.Role); err != nil {
+				return nil, fmt.Errorf("users json record [%d]: %w", i, err)
+			}
+		}
+		zone, err := parseZone(r.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("users json record [%d]: %w", i, err)
+		}
+		u := &user{
+			name:          r.ChatID,
+			role:          role,
+			delays:        fromDelayValues(r.Delays),
+			subscriptions: sortedTitles(r.Subscriptions),
+			zone:          zone,
+			plain:         r.Plain,
+			vacation:      r.Vacation,
+		}
+		if u.reminders, err = parseReminders(r.Reminders); err != nil {
+			return nil, fmt.Errorf("users json record [%d]: %w", i, err)
+		}
+		groupEvents, err := parseReminders(r.GroupEvents)
+		if err != nil {
+			return nil, fmt.Errorf("users json record [%d] group events: %w", i, err)
+		}
+		u.groupEvents, u.members = ownGroupEvents(u.name, groupEvents), sortedTitles(r.Members)
+		for title, values := range r.EventDelays {
+			if u.eventDelays == nil {
+				u.eventDelays = make(map[string][]time.Duration, len(r.EventDelays))
+			}
+			u.eventDelays[title] = fromDelayValues(values)
+		}
+		if r.Paused != nil {
+			u.paused = *r.Paused
+		}
+		if r.Deleted != nil {
+			u.deleted = *r.Deleted
+		}
+		users[u.name] = u
+	}
+	return sortedUsers(users), nil
+}
+
+// save rewrites users JSON file.
+func (b *jsonBackend) save(ctx context.Context, users []*user) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("users json save: %w", err)
+	}
+	records := make([]jsonUser, len(users))
+	for i, u := range users {
+		r := jsonUser{
+			ChatID: u.name, Delays: toDelayValues(u.delays), Subscriptions: u.subscriptions,
+			TimeZone: u.zoneName(), Plain: u.plain, Vacation: u.vacation, Reminders: u.reminderValues(),
+			GroupEvents: u.groupEventValues(), Members: u.members,
+		}
+		for title, delays := range u.eventDelays {
+			if r.EventDelays == nil {
+				r.EventDelays = make(map[string][]delayValue, len(u.eventDelays))
+			}
+			r.EventDelays[title] = toDelayValues(delays)
+		}
+		if u.role != RoleUser {
+			r.Role = u.role.String()
+		}
+		if !u.paused.IsZero() {
+			paused := u.paused
+			r.Paused = &paused
+		}
+		if !u.deleted.IsZero() {
+			deleted := u.deleted
+			r.Deleted = &deleted
+		}
+		records[i] = r
+	}
+	data, err := json.MarshalIndent(jsonData{Version: schemaVersion, Users: records}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("users json marshal: %w", err)
+	}
+	if data, err = seal(b.aead, data); err != nil {
+		return err
+	}
+	return writeFile(b.fileName, func(f *os.File) error {
+		if _, err := f.Write(data); err != nil {
+			return fmt.Errorf("users json write: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("users json sync: %w", err)
+		}
+		return nil
+	})
+}
+
+// update rewrites users JSON file with changed and without removed users.
+func (b *jsonBackend) update(ctx context.Context, changed []*user, removed []string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("users json update: %w", err)
+	}
+	users, err := b.load()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]*user, len(users)+len(changed))
+	for _, u := range users {
+		index[u.name] = u
+	}
+	for _, u := range changed {
+		index[u.name] = u
+	}
+	for _, name := range removed {
+		delete(index, name)
+	}
+	return b.save(ctx, sortedUsers(index))
+}
+
+// close releases the file lock.
+func (b *jsonBackend) close() error {
+	if b.lock == nil {
+		return nil
+	}
+	err := unlockFile(b.lock)
+	b.lock = nil
+	return err
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// mapButton is a label of event's map URL button.
+const mapButton = "Map"
+
+// KeyButton is a button of event's custom inline keyboard.
+// It opens URL or sends callback data, for example a bot command,
+// a button without both of them opens event's URL.
+type KeyButton struct {
+	Label     string `toml:"label" json:"label"` // label template
+	URL       string `toml:"url" json:"url"`     // URL template
+	Callback  string `toml:"callback" json:"callback"`
+	labelTmpl *template.Template
+	urlTmpl   *template.Template
+}
+
+// msgButton is a notification message's button.
+type msgButton struct {
+	label    string
+	url      string
+	callback string
+}
+
+// validate checks button's parameters and parses its templates.
+func (b *KeyButton) validate() error {
+	var err error
+	if b.Label == "" {
+		return fmt.Errorf("empty label of button url=%q callback=%q", b.URL, b.Callback)
+	}
+	if (b.URL != "") && (b.Callback != "") {
+		return fmt.Errorf("button=%s has both url and callback", b.Label)
+	}
+	if b.labelTmpl, err = parseTemplate(b.Label, b.Label); err != nil {
+		return fmt.Errorf("label of button=%s: %w", b.Label, err)
+	}
+	if b.urlTmpl, err = parseTemplate(b.Label, b.URL); err != nil {
+		return fmt.Errorf("url of button=%s: %w", b.Label, err)
+	}
+	return nil
+}
+
+// render returns message's button for the occurrence started at start time.
+// Event's URL is set later, because it can be requested before the sending.
+func (b *KeyButton) render(start time.Time) msgButton {
+	// templates are checked during event init
+	label, err := executeTemplate(b.labelTmpl, b.Label, start)
+	if err != nil {
+		label = b.Label
+	}
+	url, err := executeTemplate(b.urlTmpl, b.URL, start)
+	if err != nil {
+		url = b.URL
+	}
+	return msgButton{label: label, url: url, callback: b.Callback}
+}
+
+// validateKeyboard checks all buttons of event's custom keyboard.
+func (e *Event) validateKeyboard() error {
+	for i, row := range e.Keyboard {
+		if len(row) == 0 {
+			return fmt.Errorf("empty keyboard row [%d] of event=%s", i, e.Title)
+		}
+		for j := range row {
+			if err := row[j].validate(); err != nil {
+				return fmt.Errorf("keyboard of event=%s: %w", e.Title, err)
+			}
+		}
+	}
+	return nil
+}
+
+// keyboard returns rendered rows of event's custom keyboard or nil if it is not configured.
+func (e *Event) keyboard(start time.Time) [][]msgButton {
+	if len(e.Keyboard) == 0 {
+		return nil
+	}
+	rows := make([][]msgButton, len(e.Keyboard))
+	for i, row := range e.Keyboard {
+		rows[i] = make([]msgButton, len(row))
+		for j := range row {
+			rows[i][j] = row[j].render(start)
+		}
+	}
+	return rows
+}
+
+// buttons returns message's buttons rows. Without custom keyboard there is one row
+// with URL and map buttons, custom buttons without URL and callback open event's URL.
+// Calendar and message variant's acknowledgement buttons are the last rows.
+func (m *userMsg) buttons() [][]msgButton {
+	var extra [][]msgButton
+	if m.invite.url != "" {
+		extra = append(extra, []msgButton{m.invite})
+	}
+	if m.ack.callback != "" {
+		extra = append(extra, []msgButton{m.ack})
+	}
+	if m.keyboard == nil {
+		return append([][]msgButton{{{label: m.label, url: m.url}, {label: mapButton, url: m.mapURL}}}, extra...)
+	}
+	rows := make([][]msgButton, len(m.keyboard), len(m.keyboard)+len(extra))
+	for i, row := range m.keyboard {
+		rows[i] = make([]msgButton, len(row))
+		for j, b := range row {
+			if (b.url == "") && (b.callback == "") {
+				b.url = m.url
+			}
+			rows[i][j] = b
+		}
+	}
+	return append(rows, extra...)
+}
+
+// buildKeyboard returns inline keyboard from buttons' rows, buttons without URL
+// and callback are skipped. The last result is false if there are no buttons.
+func buildKeyboard(rows [][]msgButton) (botgolang.Keyboard, bool) {
+	keyboard := botgolang.NewKeyboard()
+	for _, row := range rows {
+		buttons := make([]botgolang.Button, 0, len(row))
+		for _, b := range row {
+			switch {
+			case b.url != "":
+				buttons = append(buttons, botgolang.NewURLButton(b.label, b.url))
+			case b.callback != "":
+				buttons = append(buttons, botgolang.NewCallbackButton(b.label, b.callback))
+			}
+		}
+		if len(buttons) > 0 {
+			keyboard.AddRow(buttons...)
+		}
+	}
+	return keyboard, keyboard.RowsCount() > 0
+}
@@ -0,0 +1,21 @@
+package db
+
+import "time"
+
+// leaseBackend is a backend shared by several bot instances, only the holder of its lease sends notifications.
+// Its users can be changed by other instances, so Storage saves them only by updates.
+type leaseBackend interface {
+	lease(ttl time.Duration) (bool, error)
+}
+
+// sender takes or extends the notifications sending lease for ttl, it returns true if this instance
+// should send notifications. Not shared backends always allow sending.
+func (s *Storage) sender(ttl time.Duration) (bool, error) {
+	s.persist.Lock()
+	lb, ok := s.backend.(leaseBackend)
+	s.persist.Unlock()
+	if !ok {
+		return true, nil
+	}
+	return lb.lease(ttl)
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// ledgerBackend is a backend which keeps sent notifications' keys with events' start time.
+type ledgerBackend interface {
+	loadLedger(since time.Time) (map[string]time.Time, error)
+	addLedger(p pendingMsg) error
+	purgeLedger(before time.Time) error
+}
+
+// ledger is a set of already handled occurrences, it is nil if the lookback is disabled.
+type ledger struct {
+	sync.Mutex
+	keys map[string]time.Time // notification key -> event start time
+}
+
+// newLedger returns a new ledger with known keys.
+func newLedger(keys map[string]time.Time) *ledger {
+	if keys == nil {
+		keys = make(map[string]time.Time)
+	}
+	return &ledger{keys: keys}
+}
+
+// claim marks the notification and returns false if it was already marked.
+func (l *ledger) claim(p pendingMsg) bool {
+	if l == nil {
+		return true
+	}
+	key := p.key()
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.keys[key]; ok {
+		return false
+	}
+	l.keys[key] = p.Start
+	return true
+}
+
+// cleanup forgets notifications of events started before the time.
+func (l *ledger) cleanup(before time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	for key, start := range l.keys {
+		if start.Before(before) {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// missed returns not marked notifications of the lookback window. The caller should hold sched lock.
+func (s *Storage) missed(b *botgolang.Bot, now time.Time) []userMsg {
+	notifications := make([]userMsg, 0)
+	for _, item := range s.items {
+		from := now.Add(-s.lookback)
+		if item.created.After(from) {
+			// don't notify about occurrences before the user's settings
+			from = item.created
+		}
+		since := from.Add(item.delay)
+		for start := item.event.nextIn(since, item.zone); ; start = item.event.nextIn(start.Add(time.Nanosecond), item.zone) {
+			ue := &userEvent{
+				user: item.user, event: item.event, delay: item.delay,
+				timestamp: start.Add(-item.delay), zone: item.zone,
+			}
+			if !ue.due().Before(now) {
+				break
+			}
+			m := ue.Message(b)
+			if s.ledger.claim(m.pending()) {
+				notifications = append(notifications, m)
+			}
+		}
+	}
+	return notifications
+}
+
+// markSent persistently saves the notification as handled if the backend supports it.
+func (s *Storage) markSent(m *userMsg) error {
+	if lb, ok := s.backend.(ledgerBackend); ok && (s.ledger != nil) {
+		return lb.addLedger(m.pending())
+	}
+	return nil
+}
+
+// cleanLedger removes handled notifications which are out of the lookback window.
+func (s *Storage) cleanLedger(now time.Time) error {
+	if s.ledger == nil {
+		return nil
+	}
+	before := now.Add(-s.lookback)
+	s.ledger.cleanup(before)
+	if lb, ok := s.backend.(ledgerBackend); ok {
+		return lb.purgeLedger(before)
+	}
+	return nil
+}
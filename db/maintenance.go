@@ -0,0 +1,24 @@
+package db
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrMaintenance is an error when users' commands are not handled in maintenance mode.
+var ErrMaintenance = errors.New("maintenance mode")
+
+// SetMaintenance enables or disables maintenance mode, notifications' dispatch is suspended during it.
+// Suspended notifications are sent after the mode end if they are not expired.
+func (s *Storage) SetMaintenance(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&s.maintenance, value)
+}
+
+// Maintenance returns true if maintenance mode is enabled.
+func (s *Storage) Maintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) != 0
+}
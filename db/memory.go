@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemorySource is a users' source without persistence, the data is lost after the process end.
+const MemorySource = ":memory:"
+
+// memoryBackend is a users' storage without any file I/O, it is useful for tests and dry runs.
+// Users' records are kept serialized, so loaded users don't share data with saved ones.
+type memoryBackend struct {
+	sync.Mutex
+	records map[string][]byte
+}
+
+// newMemoryBackend returns an empty in-memory storage.
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{records: make(map[string][]byte)}
+}
+
+// version returns the current data version, the in-memory data never needs migrations.
+func (b *memoryBackend) version() (int, error) {
+	return schemaVersion, nil
+}
+
+// load returns copies of saved users.
+func (b *memoryBackend) load() ([]*user, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	users := make([]*user, 0, len(b.records))
+	for name, value := range b.records {
+		u, err := decodeUser(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("memory load users: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// save replaces all users' records.
+func (b *memoryBackend) save(_ context.Context, users []*user) error {
+	records := make(map[string][]byte, len(users))
+	for _, u := range users {
+		value, err := encodeUser(u)
+		if err != nil {
+			return fmt.Errorf("memory encode user: %w", err)
+		}
+		records[u.name] = value
+	}
+	b.Lock()
+	b.records = records
+	b.Unlock()
+	return nil
+}
+
+// update sets changed users and deletes removed ones.
+func (b *memoryBackend) update(_ context.Context, changed []*user, removed []string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, name := range removed {
+		delete(b.records, name)
+	}
+	for _, u := range changed {
+		value, err := encodeUser(u)
+		if err != nil {
+			return fmt.Errorf("memory encode user: %w", err)
+		}
+		b.records[u.name] = value
+	}
+	return nil
+}
+
+// close does nothing, the data is available until the backend is used.
+func (b *memoryBackend) close() error {
+	return nil
+}
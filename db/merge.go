@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// mergedMsg is the first notification of the user's event occurrence.
+type mergedMsg struct {
+	timestamp time.Time
+	delay     time.Duration
+}
+
+// merger drops notifications of the same user's event occurrence which are close to the first one.
+type merger struct {
+	sync.Mutex
+	window time.Duration
+	first  map[string]mergedMsg // by user, event and start time
+}
+
+// newMerger returns merger with window in minutes or nil if it is disabled.
+func newMerger(window int) *merger {
+	if window <= 0 {
+		return nil
+	}
+	return &merger{window: time.Duration(window) * time.Minute, first: make(map[string]mergedMsg)}
+}
+
+// dedup returns notifications without ones which are in the window after the first notification
+// of the same occurrence, the first one is the single message for them. Nil merger keeps all notifications.
+func (mr *merger) dedup(notifications []userMsg, now time.Time) []userMsg {
+	if mr == nil {
+		return notifications
+	}
+	mr.Lock()
+	defer mr.Unlock()
+
+	for key, m := range mr.first {
+		if now.Sub(m.timestamp) > mr.window {
+			delete(mr.first, key)
+		}
+	}
+	result := notifications[:0]
+	for _, m := range notifications {
+		key := fmt.Sprintf("%s/%s/%d", m.user, m.event, m.start.Unix())
+		timestamp := m.start.Add(-m.delay)
+		first, ok := mr.first[key]
+		if !ok {
+			mr.first[key] = mergedMsg{timestamp: timestamp, delay: m.delay}
+		} else if (first.delay != m.delay) && (timestamp.Sub(first.timestamp) <= mr.window) {
+			// the same notification can be deferred or restored, other delays are merged
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// metricsTimeout is a timeout of metrics server's requests and shutdown.
+const metricsTimeout = 5 * time.Second
+
+// labelEscaper escapes metrics' label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// EventStats is event's popularity info.
+type EventStats struct {
+	Title       string
+	Subscribers int                   // active users with notifications of the event
+	Delays      map[time.Duration]int // number of users by their delays
+}
+
+// Stats is users' and events' popularity info.
+type Stats struct {
+	Users       int // active not paused users
+	Paused      int // users with paused notifications
+	Removed     int // soft deleted users
+	Unreachable int // active users which chats were not available during the last probe
+	Events      []EventStats
+	Variants    []VariantStats // events' message variants since the start
+	Breaches    int64          // notifications sent after their latency budget since the start
+}
+
+// Stats returns current users' and events' counters.
+func (s *Storage) Stats() *Stats {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	s.sched.RLock()
+	events := s.events
+	stats := &Stats{Events: make([]EventStats, len(events))}
+	for i, e := range events {
+		stats.Events[i] = EventStats{Title: e.Title, Delays: make(map[time.Duration]int)}
+	}
+	s.sched.RUnlock()
+
+	// users are not modified without persist lock
+	for _, sh := range s.shards {
+		sh.RLock()
+		stats.Users += len(sh.users)
+		stats.Removed += len(sh.removed)
+		for _, u := range sh.users {
+			if !u.paused.IsZero() {
+				stats.Paused++
+				continue
+			}
+			for i := range stats.Events {
+				es := &stats.Events[i]
+				delays := u.delaysOf(es.Title)
+				if (len(delays) == 0) || !u.subscribed(es.Title, events[i].tags) {
+					continue
+				}
+				es.Subscribers++
+				for _, d := range delays {
+					es.Delays[d]++
+				}
+			}
+		}
+		sh.RUnlock()
+	}
+	stats.Users -= stats.Paused
+	stats.Variants = s.variants.list()
+	stats.Breaches = atomic.LoadInt64(&s.breaches)
+	for name := range s.unreachable {
+		if s.active(name) {
+			stats.Unreachable++
+		}
+	}
+	return stats
+}
+
+// WriteMetrics writes stats as gauges in Prometheus text format.
+func (stats *Stats) WriteMetrics(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# HELP mtbot_users Number of users by their state.\n# TYPE mtbot_users gauge\n")
+	fmt.Fprintf(&b, "mtbot_users{state=\"active\"} %d\n", stats.Users)
+	fmt.Fprintf(&b, "mtbot_users{state=\"paused\"} %d\n", stats.Paused)
+	fmt.Fprintf(&b, "mtbot_users{state=\"removed\"} %d\n", stats.Removed)
+	fmt.Fprintf(&b, "mtbot_users{state=\"unreachable\"} %d\n", stats.Unreachable)
+
+	b.WriteString("# HELP mtbot_event_subscribers Number of users with notifications of the event.\n")
+	b.WriteString("# TYPE mtbot_event_subscribers gauge\n")
+	for _, es := range stats.Events {
+		fmt.Fprintf(&b, "mtbot_event_subscribers{event=\"%s\"} %d\n", labelEscaper.Replace(es.Title), es.Subscribers)
+	}
+
+	b.WriteString("# HELP mtbot_event_delay_users Number of users with the delay of the event's notifications.\n")
+	b.WriteString("# TYPE mtbot_event_delay_users gauge\n")
+	for _, es := range stats.Events {
+		delays := make([]time.Duration, 0, len(es.Delays))
+		for d := range es.Delays {
+			delays = append(delays, d)
+		}
+		sortDelays(delays)
+		title := labelEscaper.Replace(es.Title)
+		for _, d := range delays {
+			fmt.Fprintf(&b, "mtbot_event_delay_users{event=\"%s\",delay=\"%s\"} %d\n", title, formatDelay(d), es.Delays[d])
+		}
+	}
+
+	b.WriteString("# HELP mtbot_variant_notifications Number of event's message variant notifications since the start.\n")
+	b.WriteString("# TYPE mtbot_variant_notifications counter\n")
+	for _, vs := range stats.Variants {
+		title, variant := labelEscaper.Replace(vs.Event), labelEscaper.Replace(vs.Variant)
+		fmt.Fprintf(&b, "mtbot_variant_notifications{event=\"%s\",variant=\"%s\",result=\"sent\"} %d\n", title, variant, vs.Sent)
+		fmt.Fprintf(&b, "mtbot_variant_notifications{event=\"%s\",variant=\"%s\",result=\"acked\"} %d\n", title, variant, vs.Acked)
+	}
+
+	b.WriteString("# HELP mtbot_latency_budget_breaches Number of notifications sent after their latency budget since the start.\n")
+	b.WriteString("# TYPE mtbot_latency_budget_breaches counter\n")
+	fmt.Fprintf(&b, "mtbot_latency_budget_breaches %d\n", stats.Breaches)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeMetrics runs HTTP server with storage's metrics on /metrics path until ctx is done.
+func ServeMetrics(ctx context.Context, s *Storage, addr string, l *Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.Stats().WriteMetrics(w); err != nil {
+			l.Error.Printf("failed write metrics: %v", err)
+		}
+	})
+	server := &http.Server{Handler: mux, ReadTimeout: metricsTimeout, WriteTimeout: metricsTimeout}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			l.Error.Printf("metrics server: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			l.Error.Printf("metrics server shutdown: %v", err)
+		}
+		l.Info.Println("metrics server ctx done")
+	}()
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// lockShards locks the users' data parts of both names in the same order, it returns the unlock function.
+func (s *Storage) lockShards(a, b string) func() {
+	i, j := shardIndex(a), shardIndex(b)
+	if i == j {
+		s.shards[i].Lock()
+		return s.shards[i].Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	s.shards[i].Lock()
+	s.shards[j].Lock()
+	return func() {
+		s.shards[j].Unlock()
+		s.shards[i].Unlock()
+	}
+}
+
+// rename moves the delivery records of oldName to newName, nil history ignores it.
+func (h *history) rename(oldName, newName string) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	for i := range h.records {
+		if h.records[i].User == oldName {
+			h.records[i].User = newName
+		}
+	}
+}
+
+// Migrate moves the user's delays, preferences and deliveries history to the new chat ID,
+// for example, after the user re-registration or the group chat upgrade.
+// The new chat ID must not be an active user, its soft deleted settings are replaced.
+func (s *Storage) Migrate(ctx context.Context, oldName, newName string) error {
+	if oldName == newName {
+		return fmt.Errorf("migrate the same user=%s: %w", oldName, ErrKnownUser)
+	}
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock := s.lockShards(oldName, newName)
+	oldSh, newSh := s.shard(oldName), s.shard(newName)
+
+	u, ok := oldSh.users[oldName]
+	if !ok {
+		unlock()
+		return ErrUnknownUser
+	}
+	if _, ok = newSh.users[newName]; ok {
+		unlock()
+		return fmt.Errorf("migrate to user=%s: %w", newName, ErrKnownUser)
+	}
+	s.unschedItems(oldSh.userIdx[oldName])
+	delete(oldSh.users, oldName)
+	delete(oldSh.userIdx, oldName)
+	delete(oldSh.removed, oldName)
+	delete(newSh.removed, newName)
+
+	u.name, u.updated = newName, time.Now()
+	u.groupEvents = ownGroupEvents(newName, u.groupEvents)
+	newSh.users[newName] = u
+	if u.paused.IsZero() {
+		newSh.userIdx[newName] = s.schedItems(u, nil)
+	} else {
+		newSh.userIdx[newName] = make([]*userEvent, 0)
+	}
+	s.history.rename(oldName, newName)
+	s.bounces.forget(oldName)
+	err := s.flushUsers(ctx, oldName, newName)
+	unlock()
+
+	if len(u.groupEvents) > 0 {
+		s.regroup(oldName, nil)
+		s.regroup(newName, u)
+	}
+
+	if err != nil {
+		return fmt.Errorf("migrate user=%s to %s: %w", oldName, newName, err)
+	}
+	return s.audit(u, AuditMigrate, "chat="+s.anon.id(oldName))
+}
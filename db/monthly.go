@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastWeek is a monthly rule's week of the last weekday in the month.
+const lastWeek = -1
+
+// monthWeeks are ordinal names of weekdays' weeks in the month.
+var monthWeeks = map[string]int{
+	"first":  1,
+	"second": 2,
+	"third":  3,
+	"fourth": 4,
+	"last":   lastWeek,
+}
+
+// monthlyRule is a day of every month, it is set by a day number or by an ordinal weekday.
+type monthlyRule struct {
+	day     int // day of the month, -1 is the last one, 0 if the weekday is used
+	week    int // week of the weekday, 1-4 or lastWeek
+	weekday time.Weekday
+}
+
+// parseMonthly parses a monthly rule, for example "1", "31", "last", "first Monday" or "last fri".
+// The days after the month end are moved to its last day.
+func parseMonthly(value string) (*monthlyRule, error) {
+	fields := strings.Fields(strings.ToLower(value))
+	switch len(fields) {
+	case 1:
+		if fields[0] == "last" {
+			return &monthlyRule{day: -1}, nil
+		}
+		day, err := strconv.Atoi(fields[0])
+		if err != nil || (day < 1) || (day > 31) {
+			return nil, fmt.Errorf("invalid monthly day %q", value)
+		}
+		return &monthlyRule{day: day}, nil
+	case 2:
+		week, ok := monthWeeks[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown monthly week %q", fields[0])
+		}
+		weekdays, err := parseWeekdays(fields[1:], 0)
+		if err != nil {
+			return nil, fmt.Errorf("monthly %q: %w", value, err)
+		}
+		return &monthlyRule{week: week, weekday: weekdays[0]}, nil
+	}
+	return nil, fmt.Errorf("invalid monthly rule %q", value)
+}
+
+// date returns the rule's day in the month of the year.
+func (r *monthlyRule) date(year int, month time.Month, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	last := first.AddDate(0, 1, -1).Day()
+	switch {
+	case r.week == lastWeek:
+		d := last - (int(time.Date(year, month, last, 0, 0, 0, 0, loc).Weekday())-int(r.weekday)+7)%7
+		return time.Date(year, month, d, 0, 0, 0, 0, loc)
+	case r.week > 0:
+		d := 1 + (int(r.weekday)-int(first.Weekday())+7)%7 + (r.week-1)*7
+		return time.Date(year, month, d, 0, 0, 0, 0, loc)
+	case (r.day < 0) || (r.day > last):
+		return time.Date(year, month, last, 0, 0, 0, 0, loc)
+	}
+	return time.Date(year, month, r.day, 0, 0, 0, 0, loc)
+}
+
+// next returns the first occurrence of the rule's day and starts at or after dt.
+func (r *monthlyRule) next(dt time.Time, starts []time.Duration) time.Time {
+	var result time.Time
+	// the current month's occurrences can be already passed, so the next one is checked too
+	for i := 0; i < 2; i++ {
+		month := time.Date(dt.Year(), dt.Month()+time.Month(i), 1, 0, 0, 0, 0, dt.Location())
+		day := r.date(month.Year(), month.Month(), dt.Location())
+		for _, start := range starts {
+			alarm := day.Add(start)
+			if !alarm.Before(dt) && (result.IsZero() || alarm.Before(result)) {
+				result = alarm
+			}
+		}
+		if !result.IsZero() {
+			break
+		}
+	}
+	return result
+}
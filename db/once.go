@@ -0,0 +1,55 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// neverYear is a year of the time after one-shot events' occurrence, it is later than any real one.
+const neverYear = 9999
+
+// never is the next occurrence of already fired one-shot events.
+var never = time.Date(neverYear, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// isNever returns true if the time is not a real occurrence, time zones can shift it a little.
+func isNever(t time.Time) bool {
+	return t.Year() >= neverYear-1
+}
+
+// parseOnce sets one-shot event's occurrence by its date and time in event's time zone.
+func (e *Event) parseOnce(location *time.Location) error {
+	const layout = "2006-01-02T15:04"
+	t, err := time.ParseInLocation(layout, e.Once, location)
+	if err != nil {
+		return fmt.Errorf("parse once=%s: %w", e.Once, err)
+	}
+	e.once = t
+	return nil
+}
+
+// parseUntil sets the end of event's last date in event's time zone.
+func (e *Event) parseUntil(location *time.Location) error {
+	if e.Until == "" {
+		return nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", e.Until, location)
+	if err != nil {
+		return fmt.Errorf("parse until=%s: %w", e.Until, err)
+	}
+	e.until = t.AddDate(0, 0, 1)
+	return nil
+}
+
+// done returns true if one-shot event's occurrence is before now or the event's last date is passed,
+// other recurring events are never done.
+func (e *Event) done(now time.Time) bool {
+	return (!e.once.IsZero() && e.once.Before(now)) || (!e.until.IsZero() && !e.until.After(now))
+}
+
+// nextText returns the next occurrence's time for users, fired one-shot events are done.
+func nextText(start time.Time) string {
+	if isNever(start) {
+		return "done"
+	}
+	return start.Format(cardLayout)
+}
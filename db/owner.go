@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrOwner is an error when the event has no owner to send feedback.
+	ErrOwner = errors.New("event without owner")
+	// ErrFeedback is an error when feedback has no event number or text.
+	ErrFeedback = errors.New("invalid feedback")
+)
+
+// Feedback returns the owner's chat ID and a message of user's feedback by values "<event number> <text>".
+func (s *Storage) Feedback(userName, values string) (string, string, error) {
+	fields := strings.SplitN(strings.TrimSpace(values), " ", 2)
+	if (len(fields) != 2) || (strings.TrimSpace(fields[1]) == "") {
+		return "", "", ErrFeedback
+	}
+	number, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: event number %q", ErrFeedback, fields[0])
+	}
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+
+	if (number < 1) || (number > len(s.events)) {
+		return "", "", fmt.Errorf("event number %d: %w", number, ErrEvent)
+	}
+	e := s.events[number-1]
+	if e.Owner == "" {
+		return "", "", fmt.Errorf("event=%s: %w", e.Title, ErrOwner)
+	}
+	return e.Owner, fmt.Sprintf("Feedback on %q from %s:\n\n%s", e.Title, userName, strings.TrimSpace(fields[1])), nil
+}
+
+// alertOwner sends the notification's delivery failure to the event's owner.
+func (m *userMsg) alertOwner(sendErr error) error {
+	if m.bot == nil {
+		return nil
+	}
+	text := fmt.Sprintf("Failed notification of %q for %s: %v", m.event, m.user, sendErr)
+	if err := m.bot.NewTextMessage(m.owner, text).Send(); err != nil {
+		return fmt.Errorf("alert owner=%s: %w", m.owner, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrPaused is an error when user's notifications are already paused.
+	ErrPaused = errors.New("paused user")
+	// ErrNotPaused is an error when not paused user's notifications are resumed.
+	ErrNotPaused = errors.New("not paused user")
+)
+
+// user returns active or paused user by its name. The caller should hold persist lock,
+// so the user is not modified after the shard unlocking.
+func (s *Storage) user(userName string) (*user, bool) {
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	u, ok := sh.users[userName]
+	return u, ok
+}
+
+// Resume restarts paused user's notifications with the kept settings.
+func (s *Storage) Resume(ctx context.Context, userName string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	u, ok := s.user(userName)
+	if !ok {
+		return ErrUnknownUser
+	}
+	if u.paused.IsZero() {
+		return ErrNotPaused
+	}
+	return s.resume(ctx, u, AuditResume)
+}
+
+// resume clears user's pause and schedules its notifications, action is the audited command.
+// The caller should hold persist lock.
+func (s *Storage) resume(ctx context.Context, u *user, action string) error {
+	sh := s.shard(u.name)
+	sh.Lock()
+	old := u.auditState()
+	u.paused, u.updated = time.Time{}, time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	sh.Unlock()
+
+	if err := s.flushUsers(ctx, u.name); err != nil {
+		return fmt.Errorf("resume user=%s: %w", u.name, err)
+	}
+	return s.audit(u, action, old)
+}
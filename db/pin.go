@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// groupChatSuffix is a suffix of group chats' IDs.
+const groupChatSuffix = "@chat.agent"
+
+// pinKey is an identifier of pinned event message in the chat.
+type pinKey struct {
+	chat  string
+	event string
+}
+
+// pinnedMsg is a pinned message info.
+type pinnedMsg struct {
+	chat    string
+	msgID   string
+	expires time.Time // event start time
+}
+
+// unpin removes the message pin.
+func (p *pinnedMsg) unpin(b *botgolang.Bot) error {
+	message := b.NewMessage(p.chat)
+	message.ID = p.msgID
+	return message.Unpin()
+}
+
+// isGroupChat returns true if chatID is a group chat identifier.
+func isGroupChat(chatID string) bool {
+	return strings.HasSuffix(chatID, groupChatSuffix)
+}
+
+// pinMessage pins sent group chat message if it is required by event,
+// a previous pinned message of the same event is replaced. Post-event reminders are not pinned.
+func (s *Storage) pinMessage(m *userMsg) error {
+	if !m.pin || m.msgID == "" || !isGroupChat(m.user) || (m.delay < 0) {
+		return nil
+	}
+	p := pinnedMsg{chat: m.user, msgID: m.msgID, expires: m.start}
+	message := m.bot.NewMessage(p.chat)
+	message.ID = p.msgID
+	if err := message.Pin(); err != nil {
+		return fmt.Errorf("pin message=%s: %w", p.msgID, err)
+	}
+	key := pinKey{chat: m.user, event: m.event}
+
+	s.pinMu.Lock()
+	prev, ok := s.pins[key]
+	s.pins[key] = p
+	s.pinMu.Unlock()
+
+	if ok && prev.msgID != p.msgID {
+		if err := prev.unpin(m.bot); err != nil {
+			return fmt.Errorf("unpin replaced message=%s: %w", prev.msgID, err)
+		}
+	}
+	return nil
+}
+
+// expiredPins returns and forgets pinned messages of passed events.
+func (s *Storage) expiredPins(now time.Time) []pinnedMsg {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	result := make([]pinnedMsg, 0)
+	for key, p := range s.pins {
+		if p.expires.Before(now) {
+			result = append(result, p)
+			delete(s.pins, key)
+		}
+	}
+	return result
+}
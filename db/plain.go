@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// plainColumn is a value of the plain mode in users' CSV column.
+const plainColumn = "plain"
+
+// ErrPlain is an error when unknown plain mode's value is used.
+var ErrPlain = errors.New("unknown plain mode")
+
+var (
+	// markdownLink is a markdown link "[text](url)".
+	markdownLink = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	// markdownHeader is a header's or quote's prefix of the line.
+	markdownHeader = regexp.MustCompile(`(?m)^[ \t]*(#{1,6}|>+)[ \t]+`)
+	// markdownMarks are emphasis, strike and code marks, single underscores are kept for names and URLs.
+	markdownMarks = strings.NewReplacer("__", "", "*", "", "`", "", "~", "")
+	// spaces are repeated spaces left after removed symbols.
+	spaces = regexp.MustCompile(`[ \t]{2,}`)
+)
+
+// emoji returns true if the rune is a pictographic symbol or an emoji's modifier.
+func emoji(r rune) bool {
+	switch {
+	case unicode.Is(unicode.So, r):
+		return true
+	case (r == 0x200d) || (r == 0x20e3) || ((r >= 0xfe00) && (r <= 0xfe0f)):
+		// zero width joiner, keycap and variation selectors
+		return true
+	case (r >= 0x1f3fb) && (r <= 0x1f3ff):
+		// skin tone modifiers
+		return true
+	}
+	return false
+}
+
+// stripEmoji returns the text without emoji.
+func stripEmoji(text string) string {
+	return strings.Map(func(r rune) rune {
+		if emoji(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// PlainText returns the text without markdown and emoji, URL and callback buttons
+// of the keyboard are added as text lines. The keyboard can be nil.
+func PlainText(text string, keyboard *botgolang.Keyboard) string {
+	text = markdownLink.ReplaceAllString(text, "$1 ($2)")
+	text = markdownHeader.ReplaceAllString(text, "")
+	text = markdownMarks.Replace(text)
+	text = stripEmoji(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+	if keyboard != nil {
+		for _, row := range keyboard.Rows {
+			for _, b := range row {
+				label := strings.TrimSpace(stripEmoji(b.Text))
+				switch {
+				case b.URL != "":
+					lines = append(lines, fmt.Sprintf("%s: %s", label, b.URL))
+				case b.CallbackData != "":
+					lines = append(lines, fmt.Sprintf("%s: %s", label, b.CallbackData))
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// ParsePlain returns plain mode by "on" or "off" value.
+func ParsePlain(value string) (bool, error) {
+	switch strings.TrimSpace(value) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w %q", ErrPlain, value)
+}
+
+// SetPlain sets user's plain mode, messages are sent without markdown, emoji and keyboards then.
+func (s *Storage) SetPlain(ctx context.Context, userName string, plain bool) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	u, ok := sh.users[userName]
+	if !ok {
+		sh.Unlock()
+		return ErrUnknownUser
+	}
+	old := u.auditState()
+	u.plain, u.updated = plain, time.Now()
+	err := s.flushUsers(ctx, userName)
+	sh.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("save plain mode user=%s: %w", userName, err)
+	}
+	return s.audit(u, AuditSet, old)
+}
+
+// Plain returns true if the user has plain mode, unknown users have rich messages.
+func (s *Storage) Plain(userName string) bool {
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+	u, ok := sh.users[userName]
+	return ok && u.plain
+}
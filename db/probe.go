@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// probePause is a pause between users' checks to not overload the bot API.
+const probePause = 200 * time.Millisecond
+
+// ReachabilityProbe is a settings of periodic users' reachability checks.
+type ReachabilityProbe struct {
+	*Logger
+	Bot    *botgolang.Bot
+	Period time.Duration
+}
+
+// ProbeUsers silently checks that active users' chats are still available every period,
+// unreachable users are flagged and reported to administrators. It stops when ctx is done.
+func ProbeUsers(ctx context.Context, s *Storage, rp ReachabilityProbe) {
+	check := func(chatID string) error {
+		_, err := rp.Bot.GetChatInfo(chatID)
+		return err
+	}
+	run := func() {
+		if _, err := rp.Bot.GetInfo(); err != nil {
+			// the bot API is not available, so users' checks make no sense
+			rp.Error.Printf("skip reachability probe: %v", err)
+			return
+		}
+		checked, unreachable, err := s.probe(ctx, check, probePause)
+		if err != nil {
+			rp.Error.Printf("failed reachability probe: %v", err)
+			return
+		}
+		rp.Info.Printf("reachability probe: checked=%d, unreachable=%v", checked, unreachable)
+		if len(unreachable) == 0 {
+			return
+		}
+		report := fmt.Sprintf(
+			"Reachability probe: %d users are checked, %d are unreachable:\n%s",
+			checked, len(unreachable), strings.Join(unreachable, "\n"),
+		)
+		for _, admin := range s.adminNames() {
+			if err = rp.Bot.NewTextMessage(admin, report).Send(); err != nil {
+				rp.Error.Printf("failed send reachability report to admin=%s: %v", admin, err)
+			}
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(rp.Period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				rp.Info.Println("reachability probe ctx done")
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+// probe checks active users by check function and replaces flagged unreachable ones.
+// It returns a number of checked users and sorted names of unreachable ones.
+func (s *Storage) probe(ctx context.Context, check func(string) error, pause time.Duration) (int, []string, error) {
+	names := make([]string, 0)
+	for _, sh := range s.shards {
+		sh.RLock()
+		for name := range sh.users {
+			names = append(names, name)
+		}
+		sh.RUnlock()
+	}
+	sort.Strings(names)
+	unreachable := make(map[string]time.Time)
+	for i, name := range names {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return 0, nil, ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+		if err := check(name); err != nil {
+			unreachable[name] = time.Now()
+		}
+	}
+	s.persist.Lock()
+	s.unreachable = unreachable
+	s.persist.Unlock()
+
+	result := make([]string, 0, len(unreachable))
+	for name := range unreachable {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return len(names), result, nil
+}
+
+// adminNames returns sorted chat IDs of permanent and assigned administrators.
+func (s *Storage) adminNames() []string {
+	known := make(map[string]bool, len(s.admins))
+	for name := range s.admins {
+		known[name] = true
+	}
+	for _, sh := range s.shards {
+		sh.RLock()
+		for name, u := range sh.users {
+			if u.role == RoleAdmin {
+				known[name] = true
+			}
+		}
+		sh.RUnlock()
+	}
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
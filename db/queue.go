@@ -0,0 +1,75 @@
+package db
+
+import "container/heap"
+
+// itemsQueue is a priority queue of users' items ordered by sending time.
+// It implements heap.Interface, so the nearest item is always the first one.
+type itemsQueue []*userEvent
+
+// Len implements sort.Interface.
+func (q itemsQueue) Len() int {
+	return len(q)
+}
+
+// Less implements sort.Interface.
+func (q itemsQueue) Less(i, j int) bool {
+	return q[i].due().Before(q[j].due())
+}
+
+// Swap implements sort.Interface.
+func (q itemsQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Push implements heap.Interface.
+func (q *itemsQueue) Push(x interface{}) {
+	item := x.(*userEvent)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+// Pop implements heap.Interface.
+func (q *itemsQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
+
+// first returns the nearest item or nil if the queue is empty.
+func (q itemsQueue) first() *userEvent {
+	if len(q) == 0 {
+		return nil
+	}
+	return q[0]
+}
+
+// add pushes items to the queue.
+func (q *itemsQueue) add(items []*userEvent) {
+	for _, item := range items {
+		heap.Push(q, item)
+	}
+}
+
+// remove deletes items from the queue.
+func (q *itemsQueue) remove(items []*userEvent) {
+	for _, item := range items {
+		if item.index >= 0 && item.index < len(*q) && (*q)[item.index] == item {
+			heap.Remove(q, item.index)
+		}
+	}
+}
+
+// reset replaces all queue items.
+func (q *itemsQueue) reset(items []*userEvent) {
+	*q = items
+	for i := range items {
+		items[i].index = i
+	}
+	heap.Init(q)
+}
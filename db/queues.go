@@ -0,0 +1,143 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// QueuedReply is a not sent command's reply waiting for retry.
+type QueuedReply struct {
+	ChatID   string `json:"chat_id"`
+	Text     string `json:"text"`
+	Attempts int    `json:"attempts"`
+}
+
+// deferredRecord is a persistent info about a notification deferred by the daily quota.
+type deferredRecord struct {
+	pendingMsg
+	Until time.Time `json:"until"`
+}
+
+// queuesState is a content of the queues file.
+type queuesState struct {
+	Replies  []QueuedReply    `json:"replies,omitempty"`
+	Deferred []deferredRecord `json:"deferred,omitempty"`
+}
+
+// records returns deferred notifications' persistent info, nil quota has no ones.
+func (q *quota) records() []deferredRecord {
+	if q == nil {
+		return nil
+	}
+	q.Lock()
+	defer q.Unlock()
+
+	result := make([]deferredRecord, len(q.deferred))
+	for i, d := range q.deferred {
+		result[i] = deferredRecord{pendingMsg: d.msg.pending(), Until: d.until}
+	}
+	return result
+}
+
+// restore defers the notification until the time.
+func (q *quota) restore(m userMsg, until time.Time) {
+	q.Lock()
+	q.deferred = append(q.deferred, deferredMsg{msg: m, until: until})
+	q.Unlock()
+}
+
+// OpenQueues loads not sent replies and deferred notifications of the previous run from fileName,
+// they are saved there again by the storage closing. The file is removed after reading,
+// so the queues are not repeated after a crash. A missing file has empty queues.
+func (s *Storage) OpenQueues(fileName string) error {
+	state := &queuesState{}
+	data, err := os.ReadFile(fileName)
+	switch {
+	case err == nil:
+		if data, err = unseal(s.aead, data); err != nil {
+			return fmt.Errorf("queues file: %w", err)
+		}
+		if err = json.Unmarshal(data, state); err != nil {
+			return fmt.Errorf("queues file parse: %w", err)
+		}
+		if err = os.Remove(fileName); err != nil {
+			return fmt.Errorf("queues file remove: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("queues file read: %w", err)
+	}
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	s.queues = fileName
+	s.replies = append(s.replies, state.Replies...)
+
+	s.sched.Lock()
+	defer s.sched.Unlock()
+	deferred := make(map[string]bool, len(state.Deferred))
+	for _, r := range state.Deferred {
+		e := s.event(r.Event)
+		if e == nil {
+			continue
+		}
+		if s.quota == nil {
+			// the quota was disabled, so the notification is sent as not handled one
+			s.restored = append(s.restored, r.pendingMsg)
+			continue
+		}
+		ue := &userEvent{user: r.User, event: e, delay: r.Delay.Duration(), timestamp: r.Start.Add(-r.Delay.Duration())}
+		// the bot is set during the dispatch
+		s.quota.restore(ue.Message(nil), r.Until)
+		deferred[r.key()] = true
+	}
+	// deferred notifications are also pending ones of backends which keep them
+	i := 0
+	for _, p := range s.restored {
+		if !deferred[p.key()] {
+			s.restored[i] = p
+			i++
+		}
+	}
+	s.restored = s.restored[:i]
+	return nil
+}
+
+// KeepReplies adds not sent commands' replies to the queues which are saved by the storage closing.
+func (s *Storage) KeepReplies(replies []QueuedReply) {
+	s.persist.Lock()
+	s.replies = append(s.replies, replies...)
+	s.persist.Unlock()
+}
+
+// TakeReplies returns and forgets not sent commands' replies of the previous run.
+func (s *Storage) TakeReplies() []QueuedReply {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	replies := s.replies
+	s.replies = nil
+	return replies
+}
+
+// saveQueues writes not sent replies and deferred notifications to the queues file if it is set.
+// The caller should hold persist lock.
+func (s *Storage) saveQueues() error {
+	if s.queues == "" {
+		return nil
+	}
+	state := &queuesState{Replies: s.replies, Deferred: s.quota.records()}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("queues marshal: %w", err)
+	}
+	if data, err = seal(s.aead, data); err != nil {
+		return fmt.Errorf("queues file: %w", err)
+	}
+	if err = os.WriteFile(s.queues, data, 0600); err != nil {
+		return fmt.Errorf("queues file write: %w", err)
+	}
+	return nil
+}
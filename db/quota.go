@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// deferredMsg is a notification postponed by the daily quota.
+type deferredMsg struct {
+	msg   userMsg
+	until time.Time
+}
+
+// quota counts chats' notifications per UTC day and keeps the deferred ones.
+type quota struct {
+	sync.Mutex
+	limit    int
+	day      time.Time       // current UTC day
+	sent     map[string]int  // chats' notifications of the current day
+	informed map[string]bool // chats which are informed about the exceeded quota
+	deferred []deferredMsg
+}
+
+// newQuota returns quota with limit notifications per chat and day or nil if it is disabled.
+func newQuota(limit int) *quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &quota{limit: limit, sent: make(map[string]int), informed: make(map[string]bool)}
+}
+
+// take counts the chat's notification at now time, the first result is false if the quota is exceeded,
+// urgent notifications are always allowed. The second result is true for the first exceeding of the day.
+// Nil quota allows everything.
+func (q *quota) take(chat string, now time.Time, urgent bool) (bool, bool) {
+	if q == nil {
+		return true, false
+	}
+	q.Lock()
+	defer q.Unlock()
+
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(q.day) {
+		q.day = day
+		q.sent = make(map[string]int)
+		q.informed = make(map[string]bool)
+	}
+	if !urgent && (q.sent[chat] >= q.limit) {
+		inform := !q.informed[chat]
+		q.informed[chat] = true
+		return false, inform
+	}
+	q.sent[chat]++
+	return true, false
+}
+
+// postpone defers the notification until the next UTC day after now.
+func (q *quota) postpone(m userMsg, now time.Time) {
+	q.Lock()
+	q.deferred = append(q.deferred, deferredMsg{msg: m, until: now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)})
+	q.Unlock()
+}
+
+// due returns deferred notifications which should be sent at now time.
+func (q *quota) due(now time.Time) []userMsg {
+	result := make([]userMsg, 0)
+	if q == nil {
+		return result
+	}
+	q.Lock()
+	defer q.Unlock()
+
+	i := 0
+	for _, d := range q.deferred {
+		if d.until.After(now) {
+			q.deferred[i] = d
+			i++
+		} else {
+			// deferred notifications are late on purpose
+			d.msg.due = time.Time{}
+			result = append(result, d.msg)
+		}
+	}
+	q.deferred = q.deferred[:i]
+	return result
+}
+
+// next returns the nearest time of deferred notifications, zero if there are no ones.
+func (q *quota) next() time.Time {
+	var result time.Time
+	if q == nil {
+		return result
+	}
+	q.Lock()
+	defer q.Unlock()
+
+	for _, d := range q.deferred {
+		if result.IsZero() || d.until.Before(result) {
+			result = d.until
+		}
+	}
+	return result
+}
+
+// notice returns the message about the exceeded quota.
+func (q *quota) notice() string {
+	return fmt.Sprintf("The daily limit of %d notifications is exceeded, the next ones are deferred until tomorrow (UTC)", q.limit)
+}
+
+// deferNotification postpones the notification by the daily quota and informs the user about it once a day.
+// The notification stays pending until it is handled.
+func (s *Storage) deferNotification(m *userMsg, now time.Time, inform bool) error {
+	s.quota.postpone(*m, now)
+	if !inform {
+		return nil
+	}
+	return m.bot.NewTextMessage(m.user, s.quota.notice()).Send()
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Discrepancy results.
+const (
+	DiscrepancyMissing    = "missing"    // an expected notification has no delivery record
+	DiscrepancyUnexpected = "unexpected" // a delivery record has no expected notification
+)
+
+// Discrepancy is a difference between expected notifications and the delivery log.
+// Result is a not sent delivery's result or one of Discrepancy* constants.
+type Discrepancy struct {
+	Item   ItemSnapshot `json:"item"`
+	Result string       `json:"result"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// ScheduleReport is a comparison of user's expected notifications during the period with the delivery log.
+type ScheduleReport struct {
+	User          string        `json:"user"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Expected      int           `json:"expected"`
+	Sent          int           `json:"sent"`
+	Discrepancies []Discrepancy `json:"discrepancies"` // ordered by notification time
+}
+
+// settingsPeriod is user's settings since the time, nil settings mean no notifications.
+type settingsPeriod struct {
+	since time.Time
+	u     *user
+}
+
+// parseAuditState returns user's settings by audit record's state, it is nil for removed or paused users.
+// The last result is false if the state is unknown, for example, migration's chat ID.
+func parseAuditState(name, state string) (*user, bool) {
+	switch {
+	case (state == "none") || (state == "deleted") || strings.HasPrefix(state, "paused "):
+		return nil, true
+	case !strings.HasPrefix(state, "delays="):
+		return nil, false
+	}
+	u := &user{name: name}
+	state = strings.TrimPrefix(state, "delays=")
+	if i := strings.Index(state, " group_events="); i >= 0 {
+		// group chats' events are not reconstructed
+		state = state[:i]
+	}
+	if i := strings.Index(state, " reminders="); i >= 0 {
+		// personal reminders are not reconstructed
+		state = state[:i]
+	}
+	if i := strings.Index(state, " vacation="); i >= 0 {
+		// skipped notifications have delivery records with vacation result
+		state = state[:i]
+	}
+	if strings.HasSuffix(state, " plain") {
+		state, u.plain = strings.TrimSuffix(state, " plain"), true
+	}
+	if i := strings.Index(state, " events="); i >= 0 {
+		eventDelays, err := parseEventDelays(state[i+len(" events="):])
+		if err != nil {
+			return nil, false
+		}
+		u.eventDelays, state = eventDelays, state[:i]
+	}
+	if state != "" {
+		delays, err := parseDelays(state, 0, 0, 0, 0)
+		if err != nil {
+			return nil, false
+		}
+		u.delays = delays
+	}
+	return u, true
+}
+
+// settingsHistory returns periods of user's settings by its audit records, oldest first.
+// The current settings are used if there are no records, subscriptions and time zone are always current.
+func settingsHistory(current *user, records []AuditRecord) []settingsPeriod {
+	withCurrent := func(u *user) *user {
+		if u != nil {
+			u.subscriptions, u.zone = current.subscriptions, current.zone
+		}
+		return u
+	}
+	if len(records) == 0 {
+		if !current.paused.IsZero() || !current.deleted.IsZero() {
+			return []settingsPeriod{{}}
+		}
+		return []settingsPeriod{{u: current}}
+	}
+	// records are newest first, the oldest previous state is used since the beginning,
+	// unknown state is a chat ID before the migration, the user had no settings then
+	first, _ := parseAuditState(current.name, records[len(records)-1].Old)
+	result := make([]settingsPeriod, 1, len(records)+1)
+	result[0] = settingsPeriod{u: withCurrent(first)}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := &records[i]
+		u, ok := parseAuditState(current.name, r.New)
+		if !ok {
+			continue
+		}
+		result = append(result, settingsPeriod{since: r.Time, u: withCurrent(u)})
+	}
+	return result
+}
+
+// expected returns user's notifications which the scheduler sends from the time until to.
+func (u *user) expected(events []*Event, from, to time.Time) []ItemSnapshot {
+	result := make([]ItemSnapshot, 0)
+	for _, e := range events {
+		if !u.subscribed(e.Title, e.tags) {
+			continue
+		}
+		for _, d := range u.delaysOf(e.Title) {
+			for start := e.nextIn(from.Add(d), u.zone); start.Add(-d).Before(to); start = e.nextIn(start.Add(time.Nanosecond), u.zone) {
+				result = append(result, ItemSnapshot{User: u.name, Event: e.Title, Delay: d, Timestamp: start.Add(-d), Start: start})
+			}
+		}
+	}
+	return result
+}
+
+// ReconstructSchedule returns user's notifications which should have been sent from the time until to
+// by the current events and user's settings history from the audit log, they are compared with the delivery log.
+// Users' settings changes are known only if the audit is enabled, deliveries only within the history size.
+func (s *Storage) ReconstructSchedule(userName string, from, to time.Time) (*ScheduleReport, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid period %v - %v", from, to)
+	}
+	records, err := s.Audit(userName, 0)
+	if err != nil {
+		return nil, err
+	}
+	sh := s.shard(userName)
+	sh.RLock()
+	current, ok := sh.users[userName]
+	if !ok {
+		current, ok = sh.removed[userName]
+	}
+	if ok {
+		current = &user{
+			name: current.name, subscriptions: current.subscriptions, zone: current.zone,
+			delays: current.delays, eventDelays: current.eventDelays, paused: current.paused, deleted: current.deleted,
+		}
+	}
+	sh.RUnlock()
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+
+	periods := settingsHistory(current, records)
+	expected := make([]ItemSnapshot, 0)
+	s.sched.RLock()
+	for i, p := range periods {
+		end := to
+		if i+1 < len(periods) && periods[i+1].since.Before(to) {
+			end = periods[i+1].since
+		}
+		begin := from
+		if p.since.After(from) {
+			begin = p.since
+		}
+		if (p.u == nil) || !begin.Before(end) {
+			continue
+		}
+		expected = append(expected, p.u.expected(s.events, begin, end)...)
+	}
+	s.sched.RUnlock()
+
+	type itemKey struct {
+		event string
+		delay time.Duration
+		start int64
+	}
+	deliveries := make(map[itemKey]Delivery)
+	for _, d := range s.History(userName, 0) {
+		if start := d.Start.Add(-d.Delay); start.Before(from) || !start.Before(to) {
+			continue
+		}
+		key := itemKey{event: d.Event, delay: d.Delay, start: d.Start.UnixNano()}
+		if _, ok := deliveries[key]; !ok {
+			// the newest record is the final result
+			deliveries[key] = d
+		}
+	}
+	report := &ScheduleReport{User: userName, From: from, To: to, Expected: len(expected), Discrepancies: make([]Discrepancy, 0)}
+	for _, item := range expected {
+		key := itemKey{event: item.Event, delay: item.Delay, start: item.Start.UnixNano()}
+		d, ok := deliveries[key]
+		delete(deliveries, key)
+		switch {
+		case !ok:
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{Item: item, Result: DiscrepancyMissing})
+		case d.Result == DeliverySent:
+			report.Sent++
+		default:
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{Item: item, Result: d.Result, Error: d.Error})
+		}
+	}
+	for _, d := range deliveries {
+		item := ItemSnapshot{User: d.User, Event: d.Event, Delay: d.Delay, Timestamp: d.Start.Add(-d.Delay), Start: d.Start}
+		if d.Result == DeliverySent {
+			report.Sent++
+		}
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{Item: item, Result: DiscrepancyUnexpected, Error: d.Error})
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].Item.Timestamp.Before(report.Discrepancies[j].Item.Timestamp)
+	})
+	report.User = s.anon.id(report.User)
+	for i := range report.Discrepancies {
+		report.Discrepancies[i].Item.User = report.User
+	}
+	return report, nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Chat IDs' redaction modes.
+const (
+	RedactMask = "mask" // chat IDs are replaced by asterisks
+	RedactHash = "hash" // chat IDs are replaced by their short stable hashes
+)
+
+// Logs' verbosity levels, a greater level is more verbose.
+const (
+	levelNone = iota - 1
+	levelError
+	levelInfo
+	levelDebug
+)
+
+// logLevels are verbosity levels by names, empty name is the most verbose level.
+var logLevels = map[string]int{
+	"":      levelDebug,
+	"none":  levelNone,
+	"error": levelError,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+const (
+	// contentStart and contentEnd are markers of message contents in log lines.
+	contentStart = "\x02"
+	contentEnd   = "\x03"
+	// redactedValue replaces redacted secrets.
+	redactedValue = "***"
+)
+
+var (
+	// logContent is a marked message content.
+	logContent = regexp.MustCompile(contentStart + "([^" + contentEnd + "]*)" + contentEnd)
+	// logChatKey is a chat ID of "chat=" or "user=" key.
+	logChatKey = regexp.MustCompile(`\b((?:chat|user)=)([^\s,:\]]+)`)
+	// logChatEmail is a chat ID like email, group chats' IDs have the same format.
+	logChatEmail = regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`)
+)
+
+// LogContent marks the message content, it is removed from logs by the redaction.
+func LogContent(text string) string {
+	// the markers inside the text would break the redaction
+	text = strings.NewReplacer(contentStart, "", contentEnd, "").Replace(text)
+	return contentStart + text + contentEnd
+}
+
+// redaction are rules of secrets' removing from logs.
+type redaction struct {
+	token    string // bot token
+	chats    string // chat IDs' redaction mode, empty - disabled
+	contents int    // the most verbose level with message contents
+}
+
+// chat returns redacted chat ID.
+func (r *redaction) chat(chatID string) string {
+	if r.chats == RedactHash {
+		h := sha256.Sum256([]byte(chatID))
+		return "#" + hex.EncodeToString(h[:4])
+	}
+	return redactedValue
+}
+
+// redact returns the line of the level without secrets, nil redaction only removes contents' markers.
+func (r *redaction) redact(line string, level int) string {
+	if r == nil {
+		return strings.NewReplacer(contentStart, "", contentEnd, "").Replace(line)
+	}
+	if level > r.contents {
+		line = logContent.ReplaceAllString(line, "[redacted]")
+	} else {
+		line = logContent.ReplaceAllString(line, "$1")
+	}
+	if r.token != "" {
+		line = strings.ReplaceAll(line, r.token, redactedValue)
+	}
+	if r.chats != "" {
+		line = logChatKey.ReplaceAllStringFunc(line, func(s string) string {
+			i := strings.Index(s, "=") + 1
+			return s[:i] + r.chat(s[i:])
+		})
+		line = logChatEmail.ReplaceAllStringFunc(line, r.chat)
+	}
+	return line
+}
+
+// redactWriter writes logs of the level without secrets.
+type redactWriter struct {
+	w     io.Writer
+	level int
+	l     *Logger
+}
+
+// Write writes a log line, the logger calls it once per line.
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	r, _ := rw.l.redaction.Load().(*redaction)
+	if (r == nil) && !strings.Contains(string(p), contentStart) {
+		return rw.w.Write(p)
+	}
+	if _, err := io.WriteString(rw.w, r.redact(string(p), rw.level)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writer returns the output of the level with secrets' redaction.
+func (l *Logger) writer(w io.Writer, level int) io.Writer {
+	return &redactWriter{w: w, level: level, l: l}
+}
+
+// SetRedaction enables removing of the bot token from all logs, chat IDs are redacted by chats mode
+// if it is not empty, message contents are kept only in logs up to the contents verbosity level:
+// "none", "error", "info" or "debug", empty one keeps them everywhere.
+func (l *Logger) SetRedaction(token, chats, contents string) error {
+	if (chats != "") && (chats != RedactMask) && (chats != RedactHash) {
+		return fmt.Errorf("unknown chats redaction %q", chats)
+	}
+	level, ok := logLevels[contents]
+	if !ok {
+		return fmt.Errorf("unknown contents redaction level %q", contents)
+	}
+	l.redaction.Store(&redaction{token: token, chats: chats, contents: level})
+	return nil
+}
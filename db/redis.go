@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisUsersKey is a hash of users' records keyed by chat ID.
+	redisUsersKey = "mtbot:users"
+	// redisPendingKey is a sorted set of not sent notifications scored by event start time.
+	redisPendingKey = "mtbot:notifications"
+	// redisLedgerKey is a sorted set of sent notifications' keys scored by event start time.
+	redisLedgerKey = "mtbot:ledger"
+	// redisVersionKey is a version of users' data.
+	redisVersionKey = "mtbot:version"
+	// redisLeaseKey is an identifier of the bot instance which sends notifications.
+	redisLeaseKey = "mtbot:lease"
+)
+
+var (
+	// redisLeaseScript extends the instance's lease or takes a free one, it returns 1 if the lease is held.
+	redisLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+	// redisReleaseScript deletes the lease only if it is held by the instance.
+	redisReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// redisBackend is a users' storage in Redis, it can be shared by several bot instances.
+// Their users' changes are re-read by WatchUsers, only the lease holder sends notifications.
+type redisBackend struct {
+	client *redis.Client
+	owner  string // random identifier of the bot instance
+}
+
+// newRedisBackend connects to Redis server by its URL.
+func newRedisBackend(source string) (*redisBackend, error) {
+	opts, err := redis.ParseURL(source)
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	owner := make([]byte, 16)
+	if _, err = rand.Read(owner); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis lease owner: %w", err)
+	}
+	return &redisBackend{client: client, owner: hex.EncodeToString(owner)}, nil
+}
+
+// version returns users' data version, it is 0 if it was not saved yet.
+func (b *redisBackend) version() (int, error) {
+	version, err := b.client.Get(context.Background(), redisVersionKey).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("redis users version: %w", err)
+	}
+	return version, nil
+}
+
+// load reads all users from Redis hash.
+func (b *redisBackend) load() ([]*user, error) {
+	values, err := b.client.HGetAll(context.Background(), redisUsersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis load users: %w", err)
+	}
+	users := make([]*user, 0, len(values))
+	for name, value := range values {
+		u, err := decodeUser(name, []byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("redis load users: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// save replaces all users' records and sets data version in one transaction.
+func (b *redisBackend) save(ctx context.Context, users []*user) error {
+	values := make(map[string]interface{}, len(users))
+	for _, u := range users {
+		value, err := encodeUser(u)
+		if err != nil {
+			return fmt.Errorf("redis encode user: %w", err)
+		}
+		values[u.name] = value
+	}
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisUsersKey)
+		if len(values) > 0 {
+			pipe.HSet(ctx, redisUsersKey, values)
+		}
+		pipe.Set(ctx, redisVersionKey, schemaVersion, 0)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis save users: %w", err)
+	}
+	return nil
+}
+
+// update sets changed users and deletes removed ones in one transaction.
+func (b *redisBackend) update(ctx context.Context, changed []*user, removed []string) error {
+	values := make(map[string]interface{}, len(changed))
+	for _, u := range changed {
+		value, err := encodeUser(u)
+		if err != nil {
+			return fmt.Errorf("redis encode user: %w", err)
+		}
+		values[u.name] = value
+	}
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(removed) > 0 {
+			pipe.HDel(ctx, redisUsersKey, removed...)
+		}
+		if len(values) > 0 {
+			pipe.HSet(ctx, redisUsersKey, values)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis update users: %w", err)
+	}
+	return nil
+}
+
+// close releases the instance's lease and closes Redis client.
+func (b *redisBackend) close() error {
+	err := redisReleaseScript.Run(context.Background(), b.client, []string{redisLeaseKey}, b.owner).Err()
+	if errClose := b.client.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// lease takes or extends the instance's notifications sending lease for ttl, it returns true if the lease is held.
+func (b *redisBackend) lease(ttl time.Duration) (bool, error) {
+	n, err := redisLeaseScript.Run(context.Background(), b.client, []string{redisLeaseKey}, b.owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("redis lease: %w", err)
+	}
+	return n == 1, nil
+}
+
+// loadPending returns all not sent notifications.
+func (b *redisBackend) loadPending() ([]pendingMsg, error) {
+	values, err := b.client.ZRange(context.Background(), redisPendingKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis load pending: %w", err)
+	}
+	result := make([]pendingMsg, len(values))
+	for i, value := range values {
+		if err = json.Unmarshal([]byte(value), &result[i]); err != nil {
+			return nil, fmt.Errorf("redis pending unmarshal: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// addPending saves not sent notification.
+func (b *redisBackend) addPending(p pendingMsg) error {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("redis pending marshal: %w", err)
+	}
+	z := &redis.Z{Score: float64(p.Start.Unix()), Member: value}
+	return b.client.ZAdd(context.Background(), redisPendingKey, z).Err()
+}
+
+// removePending deletes sent notification.
+func (b *redisBackend) removePending(p pendingMsg) error {
+	value, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("redis pending marshal: %w", err)
+	}
+	return b.client.ZRem(context.Background(), redisPendingKey, value).Err()
+}
+
+// loadLedger returns sent notifications' keys of events started since the time.
+func (b *redisBackend) loadLedger(since time.Time) (map[string]time.Time, error) {
+	opt := &redis.ZRangeBy{Min: strconv.FormatInt(since.Unix(), 10), Max: "+inf"}
+	values, err := b.client.ZRangeByScoreWithScores(context.Background(), redisLedgerKey, opt).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis load ledger: %w", err)
+	}
+	result := make(map[string]time.Time, len(values))
+	for _, z := range values {
+		if key, ok := z.Member.(string); ok {
+			result[key] = time.Unix(int64(z.Score), 0)
+		}
+	}
+	return result, nil
+}
+
+// addLedger saves sent notification's key.
+func (b *redisBackend) addLedger(p pendingMsg) error {
+	z := &redis.Z{Score: float64(p.Start.Unix()), Member: p.key()}
+	return b.client.ZAdd(context.Background(), redisLedgerKey, z).Err()
+}
+
+// purgeLedger deletes sent notifications' keys of events started before the time.
+func (b *redisBackend) purgeLedger(before time.Time) error {
+	maxScore := "(" + strconv.FormatInt(before.Unix(), 10)
+	return b.client.ZRemRangeByScore(context.Background(), redisLedgerKey, "-inf", maxScore).Err()
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Personal reminders' repeats.
+const (
+	RemindOnce   = "once"
+	RemindDaily  = "daily"
+	RemindWeekly = "weekly"
+)
+
+const (
+	// remindersSeparator separates user's reminders in CSV column.
+	remindersSeparator = "|"
+	// reminderMessage is a message of personal reminders' notifications.
+	reminderMessage = "Your personal reminder"
+)
+
+// ErrReminder is an error when personal reminder's parameters are invalid.
+var ErrReminder = errors.New("invalid reminder")
+
+// reminder is user's personal event, its clock is in user's time zone.
+type reminder struct {
+	day    string // weekday's name, date "2006-01-02" or "daily"
+	clock  string // start time "15:04"
+	repeat string
+	title  string
+	event  *Event // initialized personal event
+}
+
+// value returns the reminder's normalized string, for example "Thursday 19:00 weekly Running".
+func (r *reminder) value() string {
+	return strings.Join([]string{r.day, r.clock, r.repeat, r.title}, " ")
+}
+
+// parseReminder returns an initialized reminder by its value "<weekday|daily|date> <15:04> [repeat] <title>".
+// A weekday is repeated weekly by default and a date occurs once, a weekday occurring once is replaced
+// by its nearest date after now in loc location. The title's length is limited by maxLength if it is positive.
+func parseReminder(value string, maxLength int, loc *time.Location, now time.Time) (*reminder, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("%w %q", ErrReminder, value)
+	}
+	clock, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w time %q", ErrReminder, fields[1])
+	}
+	r := &reminder{day: fields[0], clock: clock.Format("15:04"), title: strings.Join(fields[2:], " ")}
+	switch strings.ToLower(fields[2]) {
+	case RemindOnce, RemindDaily, RemindWeekly:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%w without title %q", ErrReminder, value)
+		}
+		r.repeat, r.title = strings.ToLower(fields[2]), strings.Join(fields[3:], " ")
+	}
+	if strings.Contains(r.title, remindersSeparator) || ((maxLength > 0) && (len([]rune(r.title)) > maxLength)) {
+		return nil, fmt.Errorf("%w title %q", ErrReminder, r.title)
+	}
+	e := &Event{
+		Title: r.title, Message: reminderMessage, TimeZone: "UTC",
+		StartHour: (time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute).String(),
+	}
+	date, errDate := time.ParseInLocation("2006-01-02", r.day, loc)
+	weekdays, errWeekday := parseWeekdays([]string{r.day}, time.Sunday)
+	switch {
+	case strings.EqualFold(r.day, RemindDaily) && ((r.repeat == "") || (r.repeat == RemindDaily)):
+		r.day, r.repeat, e.Period = RemindDaily, RemindDaily, "24h"
+	case (errDate == nil) && ((r.repeat == "") || (r.repeat == RemindOnce)):
+		r.repeat, e.Once = RemindOnce, r.day+"T"+r.clock
+	case (errWeekday == nil) && ((r.repeat == "") || (r.repeat == RemindWeekly)):
+		r.day, r.repeat, e.Weekday, e.Period = weekdays[0].String(), RemindWeekly, weekdays[0], "168h"
+	case (errWeekday == nil) && (r.repeat == RemindOnce):
+		local := now.In(loc)
+		date = time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+		date = date.AddDate(0, 0, int(weekdays[0]-date.Weekday()+7)%7)
+		if !date.After(now) {
+			date = date.AddDate(0, 0, 7)
+		}
+		r.day = date.Format("2006-01-02")
+		e.Once = r.day + "T" + r.clock
+	default:
+		return nil, fmt.Errorf("%w day %q", ErrReminder, r.day)
+	}
+	if err = e.Init(); err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrReminder, value, err)
+	}
+	r.event = e
+	return r, nil
+}
+
+// parseReminders returns reminders by their normalized values without limits' checks.
+func parseReminders(values []string) ([]*reminder, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	reminders := make([]*reminder, len(values))
+	for i, value := range values {
+		// normalized values have only dates for one-shot reminders
+		r, err := parseReminder(value, 0, time.UTC, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		reminders[i] = r
+	}
+	return reminders, nil
+}
+
+// reminderValues returns user's reminders' normalized values, nil if there are no reminders.
+func (u *user) reminderValues() []string {
+	if len(u.reminders) == 0 {
+		return nil
+	}
+	values := make([]string, len(u.reminders))
+	for i, r := range u.reminders {
+		values[i] = r.value()
+	}
+	return values
+}
+
+// remindersColumn returns user's reminders as CSV column value.
+func (u *user) remindersColumn() string {
+	return strings.Join(u.reminderValues(), remindersSeparator)
+}
+
+// parseRemindersColumn returns reminders by CSV column value.
+func parseRemindersColumn(value string) ([]*reminder, error) {
+	if value == "" {
+		return nil, nil
+	}
+	return parseReminders(strings.Split(value, remindersSeparator))
+}
+
+// AddReminder adds user's personal reminder by value "<weekday|daily|date> <15:04> [once|daily|weekly] <title>"
+// in user's time zone or UTC. Reminders' notifications have no delay and ignore events' subscriptions.
+func (s *Storage) AddReminder(ctx context.Context, userName, value string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	if len(u.reminders) >= s.limits.Reminders {
+		return fmt.Errorf("reminders %d: %w", s.limits.Reminders, ErrLimit)
+	}
+	loc := time.UTC
+	if u.zone != nil {
+		loc = u.zone
+	}
+	r, err := parseReminder(value, s.limits.ReminderLength, loc, time.Now())
+	if err != nil {
+		return err
+	}
+	old := u.auditState()
+	// the slice is replaced, so its previous copies are not changed
+	reminders := make([]*reminder, 0, len(u.reminders)+1)
+	u.reminders, u.updated = append(append(reminders, u.reminders...), r), time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	if err = s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("save reminder user=%s: %w", userName, err)
+	}
+	return s.audit(u, AuditSet, old)
+}
+
+// RemoveReminder removes user's personal reminder by its number from the reminders' list.
+func (s *Storage) RemoveReminder(ctx context.Context, userName, value string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(value))
+	if (err != nil) || (number < 1) || (number > len(u.reminders)) {
+		return fmt.Errorf("%w number %q", ErrReminder, value)
+	}
+	old := u.auditState()
+	reminders := make([]*reminder, 0, len(u.reminders)-1)
+	reminders = append(reminders, u.reminders[:number-1]...)
+	reminders = append(reminders, u.reminders[number:]...)
+	if len(reminders) == 0 {
+		reminders = nil
+	}
+	u.reminders, u.updated = reminders, time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	if err = s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("save reminder user=%s: %w", userName, err)
+	}
+	return s.audit(u, AuditSet, old)
+}
+
+// Reminders returns a list of user's personal reminders with their numbers.
+func (s *Storage) Reminders(ctx context.Context, userName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return "", ErrUnknownUser
+	}
+	if len(u.reminders) == 0 {
+		return "There are no reminders", nil
+	}
+	lines := make([]string, len(u.reminders))
+	now := time.Now()
+	for i, r := range u.reminders {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, r.value())
+		if r.event.done(now) {
+			lines[i] += " (done)"
+		}
+	}
+	return "Reminders:\n" + strings.Join(lines, "\n"), nil
+}
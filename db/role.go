@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role is a user's permission level.
+type Role int
+
+// Users' roles, every next role includes permissions of the previous ones.
+const (
+	// RoleUser can manage only its own notifications.
+	RoleUser Role = iota
+	// RoleEditor can manage events, for example, disable, enable them or import their subscribers.
+	RoleEditor
+	// RoleAdmin can manage events, users and the bot.
+	RoleAdmin
+)
+
+var (
+	// ErrRole is an error when unknown role name is used.
+	ErrRole = errors.New("unknown role")
+	// ErrPermission is an error when a user has not enough permissions.
+	ErrPermission = errors.New("permission denied")
+
+	// roleNames are names of known roles.
+	roleNames = map[Role]string{
+		RoleUser:   "user",
+		RoleEditor: "editor",
+		RoleAdmin:  "admin",
+	}
+)
+
+// Access contains access settings.
+type Access struct {
+	Admins []string `toml:"admins"` // chat IDs of permanent administrators
+	Audit  string   `toml:"audit"`  // file of users' settings changes, empty - disabled
+	// Secret is a base64 32 bytes key to encrypt users CSV or JSON, backups and queues files by AES-GCM, empty - plain files
+	Secret string `toml:"secret"`
+	// Anonymize is a HMAC key of chat IDs' hashes in the audit log and exports, empty - raw chat IDs
+	Anonymize string `toml:"anonymize"`
+}
+
+// String returns the role name.
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// ParseRole returns a role by its name.
+func ParseRole(name string) (Role, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return RoleUser, ErrRole
+}
+
+// Role returns user's role, unknown users have RoleUser.
+func (s *Storage) Role(userName string) Role {
+	if s.admins[userName] {
+		return RoleAdmin
+	}
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+	if u, ok := sh.users[userName]; ok {
+		return u.role
+	}
+	return RoleUser
+}
+
+// SetRole assigns the role to the known user.
+func (s *Storage) SetRole(ctx context.Context, userName string, role Role) error {
+	if s.admins[userName] {
+		return fmt.Errorf("permanent admin=%s: %w", userName, ErrPermission)
+	}
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	u.role, u.updated = role, time.Now()
+	if err := s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("set role user=%s: %w", userName, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// schemaVersion is a current version of users' persistent data format.
+// Data saved before versioning has version 0.
+const schemaVersion = 10
+
+// ErrSchema is an error when users' data has a newer format than supported.
+var ErrSchema = errors.New("unsupported users data version")
+
+// migration converts users loaded from the previous data version.
+type migration func(users []*user) ([]*user, error)
+
+// migrations are users' data upgrades, an item with index i converts version i to i+1.
+// New fields should get their default values here.
+var migrations = []migration{
+	// 0 -> 1: the same fields, the data is only marked by its version
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 1 -> 2: users' subscriptions, users without them get all events
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 2 -> 3: users' time zones, users without them use events' time zones
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 3 -> 4: paused users, stopped users were soft deleted before, so all users are not paused
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 4 -> 5: events' own delays, users without them use common delays for all events
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 5 -> 6: delays in duration syntax, saved whole minutes delays are not changed
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 6 -> 7: users' plain mode, users without it get rich messages
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 7 -> 8: users' vacations, users without them get all notifications
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 8 -> 9: users' personal reminders, users don't have them
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+	// 9 -> 10: group chats' events, group chats don't have them
+	func(users []*user) ([]*user, error) {
+		return users, nil
+	},
+}
+
+// migrate loads users and upgrades them to the current data version.
+// Upgraded data is saved, so migrations run only once.
+func migrate(b backend) ([]*user, error) {
+	version, err := b.version()
+	if err != nil {
+		return nil, err
+	}
+	if version > schemaVersion {
+		return nil, fmt.Errorf("%w: %d, maximum is %d", ErrSchema, version, schemaVersion)
+	}
+	users, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	if version == schemaVersion {
+		return users, nil
+	}
+	for i := version; i < schemaVersion; i++ {
+		if users, err = migrations[i](users); err != nil {
+			return nil, fmt.Errorf("users data migration %d->%d: %w", i, i+1, err)
+		}
+	}
+	// save writes data with the current version
+	if err = b.save(context.Background(), users); err != nil {
+		return nil, fmt.Errorf("users data migration save: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Seed writes n synthetic users with random valid delays to usersSource storage.
+// It is used to prepare staging environments and load tests, existing data is replaced.
+func Seed(usersSource string, n int, l Limits) error {
+	if (n < 1) || (n > l.Users) {
+		return fmt.Errorf("invalid number of users %d, it should be in [1, %d]", n, l.Users)
+	}
+	b, err := openBackend(usersSource)
+	if err != nil {
+		return err
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	users := make([]*user, n)
+	for i := range users {
+		users[i] = &user{name: fmt.Sprintf("user%d@seed.local", i+1), delays: randomDelays(r, l)}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].name < users[j].name
+	})
+	if err = b.save(context.Background(), users); err != nil {
+		_ = b.close()
+		return fmt.Errorf("seed users: %w", err)
+	}
+	return b.close()
+}
+
+// randomDelays returns sorted unique random delays which satisfy the limits l.
+func randomDelays(r *rand.Rand, l Limits) []time.Duration {
+	values := l.MaxDelay - l.MinDelay + 1
+	n := r.Intn(l.Delays) + 1
+	if n > values {
+		n = values
+	}
+	uniq := make(map[int]struct{}, n)
+	for len(uniq) < n {
+		uniq[l.MinDelay+r.Intn(values)] = struct{}{}
+	}
+	delays := make([]time.Duration, 0, n)
+	for d := range uniq {
+		delays = append(delays, time.Duration(d)*time.Minute)
+	}
+	sortDelays(delays)
+	return delays
+}
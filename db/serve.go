@@ -0,0 +1,283 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+// maxWait is the maximum timer waiting if there are no scheduled items.
+const maxWait = time.Hour
+
+// Settings is a serve settings.
+type Settings struct {
+	*Logger
+	TickPeriod  time.Duration
+	ErrorPeriod time.Duration // period of suppressed identical errors summary
+	TimeURL     string        // URL to check the clock skew by its "Date" header, empty value disables the check
+	MaxSkew     time.Duration // maximum allowed clock skew
+	SkewPeriod  time.Duration // period of the clock skew check
+	Timer       bool          // wait the nearest item instead of checking them every TickPeriod
+	Smear       time.Duration // window to spread the same occurrence's notifications, 0 - disabled
+	Jitter      time.Duration // maximum random delay of every notification, 0 - disabled
+	Workers     int
+	Bot         *botgolang.Bot
+	OnDelivery  func(d Delivery) // optional handler of every notification's result, it is called by workers
+}
+
+// delivered passes the delivery record to the custom handler if it is set.
+func (st *Settings) delivered(d Delivery) {
+	if st.OnDelivery != nil {
+		st.OnDelivery(d)
+	}
+}
+
+// Serve runs users' notifications handling monitoring.
+func Serve(ctx context.Context, s *Storage, st Settings) *sync.WaitGroup {
+	var (
+		wg       sync.WaitGroup
+		notifier = make(chan userMsg)
+		throttle = newErrThrottle()
+		alerts   = newErrThrottle() // owners' alerts by events
+		breaches = newErrThrottle() // admins' latency budget escalations by events
+	)
+	go func() {
+		var (
+			ticker    = time.NewTicker(st.TickPeriod)
+			errTicker = time.NewTicker(st.ErrorPeriod)
+			timer     = time.NewTimer(0)
+			timerC    <-chan time.Time // nil channel if the timer mode is disabled
+			wakeC     <-chan struct{}  // nil channel if the timer mode is disabled
+			skewC     <-chan time.Time // nil channel if the clock check is disabled
+			clockOk   = true
+			// the lease outlives blocked dispatches, so it is not lost between the ticks
+			leaseTTL = 3*st.TickPeriod + st.Smear + st.Jitter
+		)
+		defer func() {
+			ticker.Stop()
+			errTicker.Stop()
+			timer.Stop()
+			close(notifier)
+		}()
+		// sender returns true if this bot instance holds the notifications sending lease
+		sender := func() bool {
+			ok, err := s.sender(leaseTTL)
+			if err != nil {
+				st.Error.Printf("failed take notifications lease: %v", err)
+			}
+			return ok
+		}
+		dispatch := func() bool {
+			if !clockOk {
+				st.Error.Println("notifications are paused due to clock skew")
+				return false
+			}
+			if s.Maintenance() {
+				st.Info.Println("notifications are suspended by maintenance mode")
+				return false
+			}
+			// smeared and jittered notifications block the dispatch up to their windows,
+			// not dispatched ones stay pending if the context is done
+			now, items := time.Now(), s.notifications(st.Bot)
+			if !sender() {
+				// the items are handled by other bot instance of the shared storage
+				st.Info.Printf("skipped %d items sent by another instance", len(items))
+				return true
+			}
+			items = jitter(smear(items, st.Smear, now), st.Jitter, now, rand.Int63n)
+			st.Info.Printf("found for notifications %d items", len(items))
+			for i := range items {
+				st.Trace(items[i].user, "scheduled notification event=%q delay=%v start=%v", items[i].event, items[i].delay, items[i].start)
+				if err := s.markPending(&items[i]); err != nil {
+					st.Error.Printf("failed save pending notification [%v]: %v", items[i].user, err)
+				}
+				select {
+				case <-ctx.Done():
+					return false
+				case notifier <- items[i]:
+				}
+			}
+			return true
+		}
+		if st.Timer {
+			timerC, wakeC = timer.C, s.wake
+		}
+		if st.TimeURL != "" {
+			skewTicker := time.NewTicker(st.SkewPeriod)
+			defer skewTicker.Stop()
+			skewC = skewTicker.C
+			clockOk = checkClock(ctx, &st)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				st.Info.Println("db serve ctx done")
+				return
+			case <-skewC:
+				clockOk = checkClock(ctx, &st)
+			case <-timerC:
+				if dispatch() {
+					timer.Reset(s.wait(time.Now()))
+				} else {
+					timer.Reset(st.TickPeriod)
+				}
+			case <-wakeC:
+				// storage items were changed
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(s.wait(time.Now()))
+			case <-errTicker.C:
+				for _, e := range throttle.summary() {
+					st.Error.Printf("suppressed %d identical send errors for user=%s: %s", e.count, e.user, e.msg)
+				}
+				for _, e := range alerts.summary() {
+					st.Info.Printf("suppressed %d identical owners' alerts of %s: %s", e.count, e.user, e.msg)
+				}
+				for _, e := range breaches.summary() {
+					st.Error.Printf("suppressed %d latency budget escalations of event=%q", e.count, e.user)
+				}
+			case <-ticker.C:
+				if n, err := s.purge(ctx, time.Now()); err != nil {
+					st.Error.Printf("failed purge users: %v", err)
+				} else if n > 0 {
+					st.Info.Printf("purged %d stopped users", n)
+				}
+				if err := s.cleanLedger(time.Now()); err != nil {
+					st.Error.Printf("failed clean notifications ledger: %v", err)
+				}
+				for _, p := range s.expiredPins(time.Now()) {
+					if err := p.unpin(st.Bot); err != nil {
+						st.Error.Printf("failed unpin message [%v]: %v", p, err)
+					}
+				}
+				if !st.Timer {
+					dispatch()
+				} else {
+					sender()
+				}
+			}
+		}
+	}()
+	wg.Add(st.Workers)
+	for i := 0; i < st.Workers; i++ {
+		go func(j int) {
+			for m := range notifier {
+				if wait := time.Until(m.sendAt); wait > 0 {
+					select {
+					case <-ctx.Done():
+						// the notification is not sent and stays pending
+						st.Info.Printf("postponed notification by stop worker=%d [%v]", j, m.user)
+						continue
+					case <-time.After(wait):
+					}
+				}
+				st.Debug.Printf("handle notification [worker=%d]: %v", j, m.user)
+				text := m.text // original text for deferred notifications
+				if m.checkExpiry(time.Now()) {
+					st.Info.Printf("dropped expired notification worker=%d [%v]", j, m.user)
+					st.Trace(m.user, "dropped expired notification event=%q start=%v", m.event, m.start)
+					st.delivered(s.record(&m, DeliveryExpired, nil))
+					if err := s.markDone(&m); err != nil {
+						st.Error.Printf("failed remove pending notification worker=%d [%v]: %v", j, m.user, err)
+					}
+					continue
+				}
+				if s.onVacation(m.user, m.start) {
+					st.Info.Printf("skipped notification by vacation worker=%d [%v]", j, m.user)
+					st.Trace(m.user, "skipped notification event=%q by vacation", m.event)
+					st.delivered(s.record(&m, DeliveryVacation, nil))
+					if err := s.markDone(&m); err != nil {
+						st.Error.Printf("failed remove pending notification worker=%d [%v]: %v", j, m.user, err)
+					}
+					continue
+				}
+				if s.eventDisabled(m.event, m.start) {
+					st.Info.Printf("skipped notification of disabled event worker=%d [%v]", j, m.user)
+					st.Trace(m.user, "skipped notification event=%q by disabled event", m.event)
+					st.delivered(s.record(&m, DeliveryDisabled, nil))
+					if err := s.markDone(&m); err != nil {
+						st.Error.Printf("failed remove pending notification worker=%d [%v]: %v", j, m.user, err)
+					}
+					continue
+				}
+				if ok, inform := s.quota.take(m.user, time.Now(), m.urgent); !ok {
+					st.Info.Printf("deferred notification by quota worker=%d [%v]", j, m.user)
+					st.Trace(m.user, "deferred notification event=%q by daily quota", m.event)
+					st.delivered(s.record(&m, DeliveryDeferred, nil))
+					m.text = text
+					if err := s.deferNotification(&m, time.Now(), inform); err != nil {
+						st.Error.Printf("failed send quota notice worker=%d [%v]: %v", j, m.user, err)
+					}
+					continue
+				}
+				var sendErr error
+				m.plain = s.Plain(m.user)
+				allowed, err := m.Allowed()
+				if err != nil {
+					// send the notification if the check is unavailable
+					st.Error.Printf("failed check message worker=%d [%v]: %v", j, &m, err)
+				}
+				if allowed {
+					if err = m.fetchURL(); err != nil {
+						// the event's templated URL is sent if the source is unavailable
+						st.Error.Printf("failed fetch url worker=%d [%v]: %v", j, &m, err)
+					}
+				}
+				if !allowed {
+					st.Info.Printf("skipped notification by check worker=%d [%v]", j, m.user)
+					st.Trace(m.user, "skipped notification event=%q by check url=%s", m.event, m.checkURL)
+					st.delivered(s.record(&m, DeliverySkipped, nil))
+				} else if err = m.Send(); err != nil {
+					sendErr = err
+					st.Trace(m.user, "failed send notification event=%q: %v", m.event, err)
+					st.delivered(s.record(&m, DeliveryFailed, err))
+					if throttle.add(m.user, err) {
+						st.Error.Printf("failed send message worker=%d [%v]: %v", j, &m, err)
+					}
+					if (m.owner != "") && alerts.add(m.owner+"/"+m.event, err) {
+						if errAlert := m.alertOwner(err); errAlert != nil {
+							st.Error.Printf("failed alert owner worker=%d [%v]: %v", j, &m, errAlert)
+						}
+					}
+				} else {
+					st.Trace(m.user, "sent notification event=%q msgID=%s", m.event, m.msgID)
+					st.delivered(s.record(&m, DeliverySent, nil))
+					if err = s.markSent(&m); err != nil {
+						st.Error.Printf("failed save sent notification worker=%d [%v]: %v", j, m.user, err)
+					}
+				}
+				if err = s.markDone(&m); err != nil {
+					st.Error.Printf("failed remove pending notification worker=%d [%v]: %v", j, m.user, err)
+				}
+				if !allowed {
+					continue
+				}
+				if late, ok := s.breach(&m, time.Now()); ok {
+					st.Error.Printf("latency budget breach worker=%d [%v]: late %v", j, &m, late)
+					if breaches.add(m.event, ErrBudget) {
+						if err = s.escalate(&m, late); err != nil {
+							st.Error.Printf("failed escalate breach worker=%d [%v]: %v", j, &m, err)
+						}
+					}
+				}
+				if action, err := s.bounce(ctx, &m, sendErr, time.Now()); err != nil {
+					st.Error.Printf("failed handle bounced user worker=%d [%v]: %v", j, m.user, err)
+				} else if action != "" {
+					st.Info.Printf("bounced user is handled by action=%s worker=%d [%v]", action, j, m.user)
+				}
+				if err = s.pinMessage(&m); err != nil {
+					st.Error.Printf("failed pin message worker=%d [%v]: %v", j, &m, err)
+				}
+			}
+			wg.Done()
+		}(i)
+	}
+	return &wg
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// shardsCount is a number of users' data parts with independent locks.
+const shardsCount = 16
+
+// shard is a part of users' data protected by its own lock.
+type shard struct {
+	sync.RWMutex
+	users   map[string]*user        // active users
+	removed map[string]*user        // soft deleted users
+	userIdx map[string][]*userEvent // user's items index
+}
+
+func newShard() *shard {
+	return &shard{
+		users:   make(map[string]*user),
+		removed: make(map[string]*user),
+		userIdx: make(map[string][]*userEvent),
+	}
+}
+
+// shardIndex returns the index of userName's data part.
+func shardIndex(userName string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userName))
+	return int(h.Sum32() % shardsCount)
+}
+
+// shard returns the users' data part of userName.
+func (s *Storage) shard(userName string) *shard {
+	return s.shards[shardIndex(userName)]
+}
+
+// active returns true if userName is a known not deleted user.
+func (s *Storage) active(userName string) bool {
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	_, ok := sh.users[userName]
+	return ok
+}
+
+// usersCount returns a number of active users.
+func (s *Storage) usersCount() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.RLock()
+		n += len(sh.users)
+		sh.RUnlock()
+	}
+	return n
+}
+
+// schedItems builds user's items and replaces old ones in the queue.
+func (s *Storage) schedItems(u *user, old []*userEvent) []*userEvent {
+	s.sched.Lock()
+	items := u.init(s.eventsOf(u.name))
+	now := time.Now()
+	for _, item := range items {
+		item.created = now
+	}
+	s.items.remove(old)
+	s.items.add(items)
+	s.sched.Unlock()
+
+	s.schedule()
+	return items
+}
+
+// unschedItems removes items from the queue.
+func (s *Storage) unschedItems(items []*userEvent) {
+	s.sched.Lock()
+	s.items.remove(items)
+	s.sched.Unlock()
+}
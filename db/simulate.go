@@ -0,0 +1,50 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// SimulateWindow is a period after the simulated time which notifications are reported.
+const SimulateWindow = time.Hour
+
+// simulate returns user's notifications which the scheduler sends during window after at time.
+// The user's pause is not checked.
+func (u *user) simulate(events []*Event, at time.Time, window time.Duration) []ItemSnapshot {
+	result := make([]ItemSnapshot, 0)
+	for _, e := range events {
+		if !u.subscribed(e.Title, e.tags) {
+			continue
+		}
+		for _, d := range u.delaysOf(e.Title) {
+			// the first occurrence which notification time is not before at
+			start := e.nextIn(at.Add(d), u.zone)
+			if ts := start.Add(-d); ts.Before(at.Add(window)) {
+				result = append(result, ItemSnapshot{User: u.name, Event: e.Title, Delay: d, Timestamp: ts, Start: start})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
+}
+
+// Simulate returns notifications which the scheduler would send to the user during SimulateWindow after at time.
+// It uses the current settings and events, so it answers why a notification was or was not sent.
+func (s *Storage) Simulate(userName string, at time.Time) ([]ItemSnapshot, error) {
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+	if !u.paused.IsZero() {
+		return nil, ErrPaused
+	}
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+	return u.simulate(s.events, at, SimulateWindow), nil
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// smear spreads sending times of the same occurrence's notifications over window in users' order,
+// so many subscribers don't get them in a single burst. Notifications are returned ordered by sending time.
+func smear(notifications []userMsg, window time.Duration, now time.Time) []userMsg {
+	if (window <= 0) || (len(notifications) < 2) {
+		return notifications
+	}
+	groups := make(map[string][]int)
+	for i := range notifications {
+		key := notifications[i].event + "/" + notifications[i].start.String()
+		groups[key] = append(groups[key], i)
+	}
+	for _, idx := range groups {
+		n := len(idx)
+		if n < 2 {
+			continue
+		}
+		sort.Slice(idx, func(i, j int) bool {
+			return notifications[idx[i]].user < notifications[idx[j]].user
+		})
+		for i, j := range idx {
+			notifications[j].sendAt = now.Add(window * time.Duration(i) / time.Duration(n))
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].sendAt.Before(notifications[j].sendAt)
+	})
+	return notifications
+}
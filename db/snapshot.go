@@ -0,0 +1,138 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// UserSnapshot is a copy of user's settings.
+type UserSnapshot struct {
+	Name          string                     `json:"name"`
+	Delays        []time.Duration            `json:"delays"`
+	Role          string                     `json:"role"`
+	Subscriptions []string                   `json:"subscriptions,omitempty"` // events' titles, empty for all events
+	TimeZone      string                     `json:"timezone,omitempty"`      // empty for events' time zones
+	EventDelays   map[string][]time.Duration `json:"event_delays,omitempty"`  // events' own delays by titles
+	Paused        *time.Time                 `json:"paused,omitempty"`        // notifications' pause time
+	Deleted       *time.Time                 `json:"deleted,omitempty"`       // soft deletion time
+	Plain         bool                       `json:"plain,omitempty"`         // messages without markdown, emoji and keyboards
+	Vacation      *Vacation                  `json:"vacation,omitempty"`      // period of skipped notifications
+	Reminders     []string                   `json:"reminders,omitempty"`     // personal reminders
+	GroupEvents   []string                   `json:"group_events,omitempty"`  // group chat's events for members
+	Members       []string                   `json:"members,omitempty"`       // group chat's members
+}
+
+// ItemSnapshot is a copy of a scheduled notification.
+type ItemSnapshot struct {
+	User      string        `json:"user"`
+	Event     string        `json:"event"`
+	Delay     time.Duration `json:"delay"`
+	Timestamp time.Time     `json:"timestamp"` // notification time
+	Start     time.Time     `json:"start"`     // event start time
+}
+
+// Snapshot is a copy of storage's data, it can be used without any locking.
+type Snapshot struct {
+	Created time.Time      `json:"created"`
+	Users   []UserSnapshot `json:"users"` // ordered by name
+	Items   []ItemSnapshot `json:"items"` // ordered by timestamp
+}
+
+// snapshot returns a deep copy of user's settings.
+func (u *user) snapshot() UserSnapshot {
+	us := UserSnapshot{Name: u.name, Delays: make([]time.Duration, len(u.delays)), Role: u.role.String()}
+	copy(us.Delays, u.delays)
+	us.Subscriptions, us.TimeZone = sortedTitles(u.subscriptions), u.zoneName()
+	us.EventDelays, us.Plain = copyEventDelays(u.eventDelays), u.plain
+	us.Reminders = u.reminderValues()
+	us.GroupEvents, us.Members = u.groupEventValues(), u.members
+	if u.vacation != nil {
+		vacation := *u.vacation
+		us.Vacation = &vacation
+	}
+	if !u.paused.IsZero() {
+		paused := u.paused
+		us.Paused = &paused
+	}
+	if !u.deleted.IsZero() {
+		deleted := u.deleted
+		us.Deleted = &deleted
+	}
+	return us
+}
+
+// snapshot returns a copy of the scheduled item.
+func (ue *userEvent) snapshot() ItemSnapshot {
+	return ItemSnapshot{
+		User:      ue.user,
+		Event:     ue.event.Title,
+		Delay:     ue.delay,
+		Timestamp: ue.due(),
+		Start:     ue.timestamp.Add(ue.delay),
+	}
+}
+
+// Snapshot returns a deep copy of users and their upcoming notifications.
+// Chat IDs are hashes in the anonymized mode.
+func (s *Storage) Snapshot() *Snapshot {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	groups := make([]map[string]*user, 0, 2*shardsCount)
+	for _, sh := range s.shards {
+		sh.RLock()
+		groups = append(groups, sh.users, sh.removed)
+		sh.RUnlock()
+	}
+	// users are not modified without persist lock
+	users := sortedUsers(groups...)
+	result := &Snapshot{Created: time.Now(), Users: make([]UserSnapshot, len(users))}
+	for i, u := range users {
+		result.Users[i] = u.snapshot()
+	}
+	s.anon.users(result.Users)
+	result.Items = s.UpcomingItems(0)
+	return result
+}
+
+// Users returns copies of active and paused users' settings ordered by name.
+// Soft deleted users are available only in Snapshot. Chat IDs are hashes in the anonymized mode.
+func (s *Storage) Users() []UserSnapshot {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+
+	groups := make([]map[string]*user, 0, shardsCount)
+	for _, sh := range s.shards {
+		sh.RLock()
+		groups = append(groups, sh.users)
+		sh.RUnlock()
+	}
+	// users are not modified without persist lock
+	users := sortedUsers(groups...)
+	result := make([]UserSnapshot, len(users))
+	for i, u := range users {
+		result[i] = u.snapshot()
+	}
+	s.anon.users(result)
+	return result
+}
+
+// UpcomingItems returns up to limit copies of the scheduled notifications ordered by timestamp.
+// Zero limit means all items. Chat IDs are hashes in the anonymized mode.
+func (s *Storage) UpcomingItems(limit int) []ItemSnapshot {
+	s.sched.RLock()
+	result := make([]ItemSnapshot, len(s.items))
+	for i, item := range s.items {
+		result[i] = item.snapshot()
+	}
+	s.sched.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	if (limit > 0) && (len(result) > limit) {
+		result = result[:limit]
+	}
+	s.anon.items(result)
+	return result
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	botgolang "github.com/mail-ru-im/bot-golang"
+)
+
+const (
+	// maxSourceSize is the maximum size of remote events document.
+	maxSourceSize = 4 << 20
+	// TOMLType is a media type of TOML events documents.
+	TOMLType = "application/toml"
+)
+
+// ErrDuplicateEvent is an error when several events have the same title.
+var ErrDuplicateEvent = errors.New("duplicate event")
+
+// EventsFetcher is a source of remote events.
+type EventsFetcher interface {
+	// Fetch returns initialized events, the second result is false if they were not modified.
+	Fetch(ctx context.Context) ([]*Event, bool, error)
+}
+
+// EventsSource is remote events source settings.
+type EventsSource struct {
+	*Logger
+	URL     string        // JSON events array, TOML document or iCalendar endpoint
+	Fetcher EventsFetcher // custom events source, URL is not used if it is set
+	Period  time.Duration // polling period
+	// Holidays are common dates without occurrences of remote events, static ones already have them.
+	Holidays []string
+	// Bot sends events' changes to subscribers, nil - changes are not sent.
+	Bot *botgolang.Bot
+}
+
+// httpSource is a remote events source which uses If-Modified-Since header.
+type httpSource struct {
+	url          string
+	lastModified string
+}
+
+// Fetch requests remote events, it returns false if they were not modified.
+func (hs *httpSource) Fetch(ctx context.Context) ([]*Event, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("events source request: %w", err)
+	}
+	if hs.lastModified != "" {
+		req.Header.Set("If-Modified-Since", hs.lastModified)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("events source response: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, false, nil
+	case http.StatusOK:
+	default:
+		return nil, false, fmt.Errorf("events source status: %d", resp.StatusCode)
+	}
+	events, err := decodeEvents(resp.Body, resp.Header.Get("Content-Type"), req.URL.Path)
+	if err != nil {
+		return nil, false, fmt.Errorf("events source %w", err)
+	}
+	for i, e := range events {
+		if err = e.Init(); err != nil {
+			return nil, false, fmt.Errorf("events source event [%d]: %w", i, err)
+		}
+	}
+	hs.lastModified = resp.Header.Get("Last-Modified")
+	return events, true, nil
+}
+
+// decodeEvents returns not initialized events of the document by its content type or path's extension.
+// TOML documents have the same "events" array of tables as the configuration file.
+func decodeEvents(r io.Reader, contentType, path string) ([]*Event, error) {
+	var (
+		events []*Event
+		err    error
+		body   = io.LimitReader(r, maxSourceSize)
+	)
+	switch {
+	case strings.HasPrefix(contentType, ICalendarType) || strings.HasSuffix(path, ".ics"):
+		if events, err = ParseICalendar(body); err != nil {
+			return nil, fmt.Errorf("icalendar: %w", err)
+		}
+	case strings.HasPrefix(contentType, TOMLType) || strings.HasSuffix(path, ".toml"):
+		var doc struct {
+			Events []*Event `toml:"events"`
+		}
+		if _, err = toml.NewDecoder(body).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("toml decode: %w", err)
+		}
+		events = doc.Events
+	default:
+		if err = json.NewDecoder(body).Decode(&events); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+	}
+	return events, nil
+}
+
+// uniqueTitles checks that events have different titles.
+func uniqueTitles(events []*Event) error {
+	titles := make(map[string]bool, len(events))
+	for _, e := range events {
+		if titles[e.Title] {
+			return fmt.Errorf("%w: %s", ErrDuplicateEvent, e.Title)
+		}
+		titles[e.Title] = true
+	}
+	return nil
+}
+
+// mergeEvents returns static events with remote ones, all of them should have different titles.
+func mergeEvents(static, remote []*Event) ([]*Event, error) {
+	all := make([]*Event, 0, len(static)+len(remote))
+	all = append(all, static...)
+	all = append(all, remote...)
+	if err := uniqueTitles(all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// SetRemoteEvents replaces remote events keeping static ones from the configuration file
+// and rebuilds all users' items. The current events are kept if the titles are not unique.
+func (s *Storage) SetRemoteEvents(events []*Event) ([]EventChange, error) {
+	s.sources.Lock()
+	defer s.sources.Unlock()
+	all, err := mergeEvents(s.static, events)
+	if err != nil {
+		return nil, err
+	}
+	s.remote = events
+	return s.SetEvents(all), nil
+}
+
+// SetStaticEvents replaces events of the configuration file keeping remote ones
+// and rebuilds all users' items. The current events are kept if the titles are not unique.
+func (s *Storage) SetStaticEvents(events []*Event) ([]EventChange, error) {
+	s.sources.Lock()
+	defer s.sources.Unlock()
+	all, err := mergeEvents(events, s.remote)
+	if err != nil {
+		return nil, err
+	}
+	s.static = events
+	return s.SetEvents(all), nil
+}
+
+// WatchEvents polls remote events source and updates the storage's events,
+// remote events are added to static ones. It stops when ctx is done.
+func WatchEvents(ctx context.Context, s *Storage, es EventsSource) {
+	fetcher := es.Fetcher
+	if fetcher == nil {
+		fetcher = &httpSource{url: es.URL}
+	}
+	update := func() {
+		events, ok, err := fetcher.Fetch(ctx)
+		if err != nil {
+			es.Error.Printf("failed fetch remote events: %v", err)
+			return
+		}
+		if !ok {
+			es.Debug.Println("remote events are not modified")
+			return
+		}
+		for _, e := range events {
+			if err = e.AddHolidays(es.Holidays); err != nil {
+				es.Error.Printf("failed add holidays to remote events: %v", err)
+				return
+			}
+		}
+		changes, err := s.SetRemoteEvents(events)
+		if err != nil {
+			// the current events are kept until the source is fixed
+			es.Error.Printf("failed validate remote events: %v", err)
+			return
+		}
+		es.Info.Printf("updated %d remote events, changed %d", len(events), len(changes))
+		if (es.Bot != nil) && (len(changes) > 0) {
+			n := s.NotifyChanges(es.Bot, changes, es.Logger)
+			es.Info.Printf("sent %d events' changes messages", n)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(es.Period)
+		defer ticker.Stop()
+		update()
+		for {
+			select {
+			case <-ctx.Done():
+				es.Info.Println("events watching ctx done")
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+}
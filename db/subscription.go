@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// subscriptionsSeparator separates events' titles of user's subscriptions in CSV column.
+	subscriptionsSeparator = "|"
+	// allEvents is a parameter to subscribe to all events.
+	allEvents = "all"
+)
+
+// ErrSubscription is an error when unknown events are used for subscription.
+var ErrSubscription = errors.New("invalid subscription")
+
+// subscribed returns true if the user gets notifications of the event by its title or any of its tags.
+// Users without subscriptions get all events.
+func (u *user) subscribed(title string, tags []string) bool {
+	if len(u.subscriptions) == 0 {
+		return true
+	}
+	if isMember(u.subscriptions, title) {
+		return true
+	}
+	for _, tag := range tags {
+		if isMember(u.subscriptions, tagPrefix+tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSubscriptions returns sorted events' titles by their numbers and tags by their names from values,
+// so new events with the tags are subscribed too. Nil result means all events.
+func parseSubscriptions(values string, events []*Event) ([]string, error) {
+	fields := splitValues(values)
+	if len(fields) == 0 {
+		return nil, ErrSubscription
+	}
+	if (len(fields) == 1) && (strings.ToLower(fields[0]) == allEvents) {
+		return nil, nil
+	}
+	titles := make([]string, len(fields))
+	for i, field := range fields {
+		n, err := parseNumber(field)
+		if err != nil {
+			tag, ok := parseTag(field, events)
+			if !ok {
+				return nil, fmt.Errorf("event tag %q: %w", field, ErrSubscription)
+			}
+			titles[i] = tag
+			continue
+		}
+		if (n < 1) || (n > len(events)) {
+			return nil, fmt.Errorf("event number %q: %w", field, ErrSubscription)
+		}
+		titles[i] = events[n-1].Title
+	}
+	return sortedTitles(titles), nil
+}
+
+// Subscribe sets user's events by their numbers from values or all of them by "all" value.
+func (s *Storage) Subscribe(ctx context.Context, userName, values string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	s.sched.RLock()
+	titles, err := parseSubscriptions(values, s.events)
+	s.sched.RUnlock()
+	if err != nil {
+		return fmt.Errorf("subscribe user: %w", err)
+	}
+	u.subscriptions, u.updated = titles, time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	if err = s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("save subscriptions user=%s: %w", userName, err)
+	}
+	return nil
+}
+
+// Subscriptions returns numbered events list with marked user's subscriptions.
+func (s *Storage) Subscriptions(ctx context.Context, userName string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return "", ErrUnknownUser
+	}
+	s.sched.RLock()
+	defer s.sched.RUnlock()
+	if len(s.events) == 0 {
+		return "There are no events", nil
+	}
+	lines := make([]string, len(s.events))
+	now := time.Now()
+	for i, e := range s.events {
+		mark := " "
+		if u.subscribed(e.Title, e.tags) {
+			mark = "x"
+		}
+		lines[i] = fmt.Sprintf("%d. [%s] %s", i+1, mark, e.Title) + e.tagText()
+		if e.done(now) {
+			lines[i] += " (done)"
+		}
+		lines[i] += s.disabledText(e.Title, now)
+		if e.Owner != "" {
+			lines[i] += fmt.Sprintf(" (owner %s)", e.Owner)
+		}
+	}
+	return "Events:\n" + strings.Join(lines, "\n"), nil
+}
+
+// Join adds events by their numbers from values to user's subscriptions.
+// Users without subscriptions already get all events, so they are not changed.
+func (s *Storage) Join(ctx context.Context, userName, values string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	s.sched.RLock()
+	titles, err := parseSubscriptions(values, s.events)
+	s.sched.RUnlock()
+	if err != nil {
+		return fmt.Errorf("join user: %w", err)
+	}
+	if len(u.subscriptions) == 0 {
+		// the user already gets all events
+		return nil
+	}
+	if titles != nil {
+		titles = sortedTitles(append(titles, u.subscriptions...))
+	}
+	u.subscriptions, u.updated = titles, time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	if err = s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("save subscriptions user=%s: %w", userName, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tagPrefix marks tags in user's subscriptions, other subscriptions are events' titles.
+const tagPrefix = "#"
+
+// validateTags checks event's tags and keeps them in lower case without duplicates.
+func (e *Event) validateTags() error {
+	e.tags = nil
+	if len(e.Tags) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(e.Tags))
+	for _, tag := range e.Tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), tagPrefix))
+		if _, err := parseNumber(tag); (err == nil) || (tag == "") || (tag == allEvents) ||
+			strings.ContainsAny(tag, " ,;"+subscriptionsSeparator) {
+			return fmt.Errorf("tag %q of event=%s: not empty word is expected", tag, e.Title)
+		}
+		tags = append(tags, tag)
+	}
+	e.tags = sortedTitles(tags)
+	return nil
+}
+
+// hasTag returns true if the event is marked by the tag in lower case.
+func (e *Event) hasTag(tag string) bool {
+	i := sort.SearchStrings(e.tags, tag)
+	return (i < len(e.tags)) && (e.tags[i] == tag)
+}
+
+// tagText returns event's tags for events' list, it is empty for events without tags.
+func (e *Event) tagText() string {
+	if len(e.tags) == 0 {
+		return ""
+	}
+	return " " + tagPrefix + strings.Join(e.tags, " "+tagPrefix)
+}
+
+// parseTag returns the subscription of the tag from value if any of events has it.
+func parseTag(value string, events []*Event) (string, bool) {
+	tag := strings.ToLower(strings.TrimPrefix(value, tagPrefix))
+	for _, e := range events {
+		if e.hasTag(tag) {
+			return tagPrefix + tag, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+)
+
+// templateFields are known placeholders of events' templates, they are fields of templateData.
+var templateFields = map[string]bool{"Date": true, "Time": true, "Start": true}
+
+// textPosition returns "line:column" of the byte offset in value, both numbers start from 1.
+func textPosition(value string, offset int) string {
+	line := 1 + strings.Count(value[:offset], "\n")
+	column := offset - strings.LastIndex(value[:offset], "\n")
+	return fmt.Sprintf("%d:%d", line, column)
+}
+
+// lintBraces checks that template's actions delimiters are balanced and not nested.
+func lintBraces(value string) error {
+	open := -1
+	for i := 0; i < len(value)-1; i++ {
+		switch value[i : i+2] {
+		case "{{":
+			if open >= 0 {
+				return fmt.Errorf("nested {{ at %s, previous one at %s", textPosition(value, i), textPosition(value, open))
+			}
+			open = i
+		case "}}":
+			if open < 0 {
+				return fmt.Errorf("unexpected }} at %s", textPosition(value, i))
+			}
+			open = -1
+		default:
+			continue
+		}
+		i++
+	}
+	if open >= 0 {
+		return fmt.Errorf("unclosed {{ at %s", textPosition(value, open))
+	}
+	return nil
+}
+
+// lintFields checks that template's placeholders are known, including ones of not executed branches.
+func lintFields(node parse.Node, value string) error {
+	var nodes []parse.Node
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			nodes = n.Nodes
+		}
+	case *parse.ActionNode:
+		nodes = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		if n != nil {
+			for _, c := range n.Cmds {
+				nodes = append(nodes, c.Args...)
+			}
+		}
+	case *parse.IfNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		nodes = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.TemplateNode:
+		nodes = []parse.Node{n.Pipe}
+	case *parse.ChainNode:
+		nodes = []parse.Node{n.Node}
+	case *parse.FieldNode:
+		if !templateFields[n.Ident[0]] {
+			name := "." + strings.Join(n.Ident, ".")
+			// the node's position is the last identifier's one
+			offset := int(n.Position()) + len(n.Ident[len(n.Ident)-1]) + 1 - len(name)
+			return fmt.Errorf("unknown placeholder %s at %s", name, textPosition(value, offset))
+		}
+	}
+	for _, child := range nodes {
+		if err := lintFields(child, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
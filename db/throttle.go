@@ -0,0 +1,58 @@
+package db
+
+import (
+	"sort"
+	"sync"
+)
+
+// errKey is an identifier of the repeated error.
+type errKey struct {
+	user string
+	msg  string
+}
+
+// errSummary is an aggregated info about suppressed identical errors.
+type errSummary struct {
+	user  string
+	msg   string
+	count int
+}
+
+// errThrottle deduplicates identical users' send errors.
+type errThrottle struct {
+	sync.Mutex
+	counts map[errKey]int
+}
+
+// newErrThrottle returns new errors throttle.
+func newErrThrottle() *errThrottle {
+	return &errThrottle{counts: make(map[errKey]int)}
+}
+
+// add registers user's error and returns true if it is the first one during the current period
+// and it should be logged.
+func (t *errThrottle) add(user string, err error) bool {
+	key := errKey{user: user, msg: err.Error()}
+	t.Lock()
+	defer t.Unlock()
+	t.counts[key]++
+	return t.counts[key] == 1
+}
+
+// summary returns suppressed errors info and resets the current period.
+func (t *errThrottle) summary() []errSummary {
+	t.Lock()
+	defer t.Unlock()
+	result := make([]errSummary, 0, len(t.counts))
+	for key, n := range t.counts {
+		if n > 1 {
+			// the first error was already logged
+			result = append(result, errSummary{user: key.user, msg: key.msg, count: n - 1})
+		}
+	}
+	t.counts = make(map[errKey]int)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].user < result[j].user
+	})
+	return result
+}
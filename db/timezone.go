@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// eventsZone is a parameter to use events' time zones for the user.
+const eventsZone = "event"
+
+// ErrTimeZone is an error when unknown user's time zone is used.
+var ErrTimeZone = errors.New("unknown time zone")
+
+// zoneName returns user's time zone name or empty string if events' zones are used.
+func (u *user) zoneName() string {
+	if u.zone == nil {
+		return ""
+	}
+	return u.zone.String()
+}
+
+// parseZone returns user's time zone by its IANA name, empty name means events' zones.
+func parseZone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := loadLocation(name)
+	if err != nil {
+		if errors.Is(err, ErrZoneInfo) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w %q", ErrTimeZone, name)
+	}
+	return loc, nil
+}
+
+// SetTimeZone sets user's time zone by IANA name, "event" value restores events' time zones.
+// User's notifications are scheduled by events' local time in this zone.
+func (s *Storage) SetTimeZone(ctx context.Context, userName, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrTimeZone
+	}
+	if name == eventsZone {
+		name = ""
+	}
+	loc, err := parseZone(name)
+	if err != nil {
+		return fmt.Errorf("set time zone: %w", err)
+	}
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	defer sh.Unlock()
+
+	u, ok := sh.users[userName]
+	if !ok {
+		return ErrUnknownUser
+	}
+	u.zone, u.updated = loc, time.Now()
+	sh.userIdx[u.name] = s.schedItems(u, sh.userIdx[u.name])
+	if err = s.flushUsers(ctx, userName); err != nil {
+		return fmt.Errorf("save time zone user=%s: %w", userName, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// vacationOff is a /vacation value which cancels user's vacation.
+	vacationOff = "off"
+	// vacationLayout is a layout of vacation dates.
+	vacationLayout = "2006-01-02"
+)
+
+// ErrVacation is an error when user's vacation dates are invalid.
+var ErrVacation = errors.New("invalid vacation")
+
+// Vacation is a period without user's notifications, they are resumed automatically after it.
+type Vacation struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"` // the next day's midnight after the last vacation date
+}
+
+// contains returns true if t is during the vacation, nil vacation contains nothing.
+func (v *Vacation) contains(t time.Time) bool {
+	return (v != nil) && !t.Before(v.From) && t.Before(v.To)
+}
+
+// String returns inclusive vacation dates.
+func (v *Vacation) String() string {
+	return fmt.Sprintf("%s - %s", v.From.Format(vacationLayout), v.To.AddDate(0, 0, -1).Format(vacationLayout))
+}
+
+// value returns the vacation as a stored value "<from>/<to>", it is empty for nil vacation.
+func (v *Vacation) value() string {
+	if v == nil {
+		return ""
+	}
+	return v.From.Format(time.RFC3339) + "/" + v.To.Format(time.RFC3339)
+}
+
+// parseVacationValue parses the stored vacation value, empty one is nil vacation.
+func parseVacationValue(value string) (*Vacation, error) {
+	if value == "" {
+		return nil, nil
+	}
+	i := strings.Index(value, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("%w value %q", ErrVacation, value)
+	}
+	from, err := time.Parse(time.RFC3339, value[:i])
+	if err != nil {
+		return nil, fmt.Errorf("vacation from %q: %w", value, err)
+	}
+	to, err := time.Parse(time.RFC3339, value[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("vacation to %q: %w", value, err)
+	}
+	return &Vacation{From: from, To: to}, nil
+}
+
+// ParseVacation parses inclusive vacation dates "2006-01-02 2006-01-02" in the location,
+// "off" value returns nil vacation. The vacation must not be already finished at now.
+func ParseVacation(value string, loc *time.Location, now time.Time) (*Vacation, error) {
+	fields := strings.Fields(value)
+	if (len(fields) == 1) && (fields[0] == vacationOff) {
+		return nil, nil
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%w %q", ErrVacation, value)
+	}
+	from, err := time.ParseInLocation(vacationLayout, fields[0], loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w from %q", ErrVacation, fields[0])
+	}
+	last, err := time.ParseInLocation(vacationLayout, fields[1], loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w to %q", ErrVacation, fields[1])
+	}
+	v := &Vacation{From: from, To: last.AddDate(0, 0, 1)}
+	if last.Before(from) || !v.To.After(now) {
+		return nil, fmt.Errorf("%w period %q", ErrVacation, value)
+	}
+	return v, nil
+}
+
+// SetVacation sets user's vacation by dates "<from> <to>" in user's time zone or UTC,
+// "off" value cancels it. Notifications during the vacation are skipped, the settings are kept.
+func (s *Storage) SetVacation(ctx context.Context, userName, value string) error {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sh := s.shard(userName)
+	sh.Lock()
+	u, ok := sh.users[userName]
+	if !ok {
+		sh.Unlock()
+		return ErrUnknownUser
+	}
+	loc := time.UTC
+	if u.zone != nil {
+		loc = u.zone
+	}
+	v, err := ParseVacation(value, loc, time.Now())
+	if err != nil {
+		sh.Unlock()
+		return err
+	}
+	old := u.auditState()
+	u.vacation, u.updated = v, time.Now()
+	err = s.flushUsers(ctx, userName)
+	sh.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("save vacation user=%s: %w", userName, err)
+	}
+	return s.audit(u, AuditSet, old)
+}
+
+// onVacation returns true if the user is on vacation at t.
+func (s *Storage) onVacation(userName string, t time.Time) bool {
+	sh := s.shard(userName)
+	sh.RLock()
+	defer sh.RUnlock()
+	u, ok := sh.users[userName]
+	return ok && u.vacation.contains(t)
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrAck is an error when there is no not acknowledged notification of the event's variant.
+var ErrAck = errors.New("nothing to acknowledge")
+
+// ackCommand is a callback command of the notifications' acknowledgement.
+const ackCommand = "/ack"
+
+// Variant is an alternative event's message, it is selected randomly by its weight for every notification.
+type Variant struct {
+	Name    string `toml:"name" json:"name"`
+	Message string `toml:"message" json:"message"`
+	Weight  int    `toml:"weight" json:"weight"` // relative selection weight, 0 means 1
+}
+
+// VariantStats is a number of sent and acknowledged notifications of the event's message variant.
+type VariantStats struct {
+	Event   string
+	Variant string
+	Sent    int
+	Acked   int
+}
+
+// variantKey is a user's notification of the event.
+type variantKey struct {
+	user, event string
+}
+
+// variantStats are message variants' counters since the start.
+type variantStats struct {
+	sync.Mutex
+	counters map[string]map[string]*VariantStats // by events' titles and variants' names
+	pending  map[variantKey]string               // sent not acknowledged variants
+}
+
+// newVariantStats returns empty variants' counters.
+func newVariantStats() *variantStats {
+	return &variantStats{
+		counters: make(map[string]map[string]*VariantStats),
+		pending:  make(map[variantKey]string),
+	}
+}
+
+// counter returns the variant's counters creating them if needed. The caller should hold the lock.
+func (vs *variantStats) counter(event, variant string) *VariantStats {
+	variants, ok := vs.counters[event]
+	if !ok {
+		variants = make(map[string]*VariantStats)
+		vs.counters[event] = variants
+	}
+	c, ok := variants[variant]
+	if !ok {
+		c = &VariantStats{Event: event, Variant: variant}
+		variants[variant] = c
+	}
+	return c
+}
+
+// sent counts the sent notification, only the latest one can be acknowledged by the user.
+func (vs *variantStats) sent(user, event, variant string) {
+	vs.Lock()
+	defer vs.Unlock()
+	vs.counter(event, variant).Sent++
+	vs.pending[variantKey{user: user, event: event}] = variant
+}
+
+// ack counts the acknowledgement of the latest user's notification of the event's variant.
+func (vs *variantStats) ack(user, event, variant string) error {
+	vs.Lock()
+	defer vs.Unlock()
+	key := variantKey{user: user, event: event}
+	if v, ok := vs.pending[key]; !ok || (v != variant) {
+		return ErrAck
+	}
+	delete(vs.pending, key)
+	vs.counter(event, variant).Acked++
+	return nil
+}
+
+// list returns copies of the counters ordered by events and variants.
+func (vs *variantStats) list() []VariantStats {
+	vs.Lock()
+	defer vs.Unlock()
+	result := make([]VariantStats, 0, len(vs.counters))
+	for _, variants := range vs.counters {
+		for _, c := range variants {
+			result = append(result, *c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Event != result[j].Event {
+			return result[i].Event < result[j].Event
+		}
+		return result[i].Variant < result[j].Variant
+	})
+	return result
+}
+
+// validateVariants checks event's message variants and sets their default weights.
+func (e *Event) validateVariants() error {
+	names := make(map[string]bool, len(e.Variants))
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		switch {
+		case (v.Name == "") || strings.ContainsAny(v.Name, " \t\n"):
+			return fmt.Errorf("variant [%d] of event=%s: invalid name %q", i, e.Title, v.Name)
+		case names[v.Name]:
+			return fmt.Errorf("variant [%d] of event=%s: duplicate name %q", i, e.Title, v.Name)
+		case v.Weight < 0:
+			return fmt.Errorf("variant=%s of event=%s: negative weight %d", v.Name, e.Title, v.Weight)
+		}
+		if j := strings.Index(v.Message, "{{"); j >= 0 {
+			return fmt.Errorf("variant=%s of event=%s: templates are not supported, {{ at %s", v.Name, e.Title, textPosition(v.Message, j))
+		}
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		names[v.Name] = true
+		e.variantsWeight += v.Weight
+	}
+	if (e.AckButton != "") && (len(e.Variants) == 0) {
+		return fmt.Errorf("ack_button of event=%s without variants", e.Title)
+	}
+	return nil
+}
+
+// variant returns event's message variant by n from 0 to the total weight of variants,
+// nil if there are no variants.
+func (e *Event) variant(n int) *Variant {
+	for i := range e.Variants {
+		if n -= e.Variants[i].Weight; n < 0 {
+			return &e.Variants[i]
+		}
+	}
+	return nil
+}
+
+// randomVariant returns a random event's message variant by their weights, nil if there are no variants.
+func (e *Event) randomVariant() *Variant {
+	if e.variantsWeight == 0 {
+		return nil
+	}
+	return e.variant(rand.Intn(e.variantsWeight))
+}
+
+// ackButton returns the acknowledgement button of the event's variant.
+func (e *Event) ackButton(variant string) msgButton {
+	return msgButton{label: e.AckButton, callback: fmt.Sprintf("%s %s %s", ackCommand, variant, e.Title)}
+}
+
+// Ack counts the acknowledgement of the latest user's notification by values "<variant> <event title>".
+func (s *Storage) Ack(userName, values string) error {
+	fields := strings.SplitN(strings.TrimSpace(values), " ", 2)
+	if len(fields) != 2 {
+		return ErrAck
+	}
+	return s.variants.ack(userName, fields[1], fields[0])
+}
+
+// Variants returns message variants' counters since the start ordered by events and variants.
+func (s *Storage) Variants() []VariantStats {
+	return s.variants.list()
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay is a delay to collect several file system events of one users' file change.
+const watchDelay = time.Second
+
+// watchPeriod is a period of shared users' polling.
+const watchPeriod = 30 * time.Second
+
+// mergeStats is a result of external users' changes merging.
+type mergeStats struct {
+	added     []string
+	updated   []string
+	removed   []string
+	conflicts []string // users with newer in-memory changes
+}
+
+// changed returns true if any user was changed.
+func (ms *mergeStats) changed() bool {
+	return len(ms.added)+len(ms.updated)+len(ms.removed) > 0
+}
+
+// String is a string representation of mergeStats.
+func (ms *mergeStats) String() string {
+	return fmt.Sprintf("added=%v, updated=%v, removed=%v, kept in-memory=%v", ms.added, ms.updated, ms.removed, ms.conflicts)
+}
+
+// equal returns true if users have the same saved values.
+func (u *user) equal(x *user) bool {
+	if (u.role != x.role) || (u.deleted.Unix() != x.deleted.Unix()) || (len(u.delays) != len(x.delays)) {
+		return false
+	}
+	if (u.paused.Unix() != x.paused.Unix()) || (u.plain != x.plain) || (u.vacation.value() != x.vacation.value()) {
+		return false
+	}
+	if (u.remindersColumn() != x.remindersColumn()) || (u.groupEventsColumn() != x.groupEventsColumn()) {
+		return false
+	}
+	if strings.Join(u.members, subscriptionsSeparator) != strings.Join(x.members, subscriptionsSeparator) {
+		return false
+	}
+	if (len(u.subscriptions) != len(x.subscriptions)) || (u.zoneName() != x.zoneName()) {
+		return false
+	}
+	if u.stringEventDelays() != x.stringEventDelays() {
+		return false
+	}
+	for i := range u.delays {
+		if u.delays[i] != x.delays[i] {
+			return false
+		}
+	}
+	for i := range u.subscriptions {
+		if u.subscriptions[i] != x.subscriptions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WatchUsers watches the users' CSV file or polls Redis users and merges their external changes into the storage.
+// A user's version with the latest change wins, the file modification or polling time is used for external ones.
+func WatchUsers(ctx context.Context, s *Storage, l *Logger) error {
+	s.persist.Lock()
+	backend := s.backend
+	s.persist.Unlock()
+	switch b := backend.(type) {
+	case *csvBackend:
+		return watchFile(ctx, s, l, b)
+	case *redisBackend:
+		go pollRedis(ctx, s, l, b)
+		return nil
+	}
+	return errors.New("users watching supports only CSV file and Redis")
+}
+
+// pollRedis merges Redis users every watchPeriod until the context is done.
+func pollRedis(ctx context.Context, s *Storage, l *Logger, b *redisBackend) {
+	ticker := time.NewTicker(watchPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Info.Println("users polling ctx done")
+			return
+		case <-ticker.C:
+			// changes after the reading start are newer than loaded ones
+			modified := time.Now()
+			users, err := b.load()
+			if err != nil {
+				l.Error.Printf("failed poll redis users: %v", err)
+				continue
+			}
+			ms, err := s.merge(ctx, users, modified)
+			if err != nil {
+				l.Error.Printf("failed merge redis users: %v", err)
+			} else if ms.changed() || (len(ms.conflicts) > 0) {
+				l.Info.Printf("merged redis users changes: %v", ms)
+			}
+		}
+	}
+}
+
+// watchFile watches the users' CSV file changes.
+func watchFile(ctx context.Context, s *Storage, l *Logger, b *csvBackend) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("users watcher: %w", err)
+	}
+	// the directory is watched, because the file is replaced during saving
+	if err = watcher.Add(filepath.Dir(b.fileName)); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("users watcher add: %w", err)
+	}
+	go func() {
+		timer := time.NewTimer(watchDelay)
+		timer.Stop()
+		defer func() {
+			timer.Stop()
+			_ = watcher.Close()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				l.Info.Println("users watcher ctx done")
+				return
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if (e.Name == b.fileName) && (e.Op&(fsnotify.Write|fsnotify.Create) != 0) {
+					timer.Reset(watchDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.Error.Printf("users watcher: %v", err)
+			case <-timer.C:
+				ms, err := s.mergeFile(ctx, b.fileName)
+				if err != nil {
+					l.Error.Printf("failed merge users file: %v", err)
+				} else if ms.changed() || (len(ms.conflicts) > 0) {
+					l.Info.Printf("merged users file changes: %v", ms)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// mergeFile reads users from CSV file and merges them into the storage.
+func (s *Storage) mergeFile(ctx context.Context, fileName string) (*mergeStats, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("users file stat: %w", err)
+	}
+	records, _, err := readRows(fileName, false, s.aead)
+	if err != nil {
+		return nil, err
+	}
+	if _, records, err = splitVersion(records); err != nil {
+		return nil, err
+	}
+	users := make([]*user, 0, len(records))
+	for _, userItem := range records {
+		u, err := parseCSVRow(userItem)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return s.merge(ctx, users, info.ModTime())
+}
+
+// merge applies externally changed users, modified is a time of their changes.
+// In-memory users' changes after modified time are kept, all data is saved if something was changed.
+func (s *Storage) merge(ctx context.Context, users []*user, modified time.Time) (*mergeStats, error) {
+	s.persist.Lock()
+	defer s.persist.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, sh := range s.shards {
+		sh.Lock()
+		defer sh.Unlock()
+	}
+	s.sched.Lock()
+
+	var (
+		ms    = &mergeStats{}
+		now   = time.Now()
+		known = make(map[string]bool, len(users))
+		// members of changed group chats with events
+		members []string
+	)
+	for _, u := range users {
+		known[u.name] = true
+		sh := s.shard(u.name)
+		current, ok := sh.users[u.name]
+		if !ok {
+			current, ok = sh.removed[u.name]
+		}
+		switch {
+		case ok && current.equal(u):
+			continue
+		case ok && current.updated.After(modified):
+			ms.conflicts = append(ms.conflicts, u.name)
+			continue
+		case !ok && s.dropped[u.name].After(modified):
+			ms.conflicts = append(ms.conflicts, u.name)
+			continue
+		case ok:
+			ms.updated = append(ms.updated, u.name)
+		default:
+			ms.added = append(ms.added, u.name)
+		}
+		members = append(members, s.dropUser(sh, u.name)...)
+		members = append(members, s.putUser(sh, u, now)...)
+	}
+	for _, sh := range s.shards {
+		for _, group := range []map[string]*user{sh.users, sh.removed} {
+			for name, u := range group {
+				if known[name] {
+					continue
+				}
+				if u.updated.After(modified) {
+					ms.conflicts = append(ms.conflicts, name)
+					continue
+				}
+				members = append(members, s.dropUser(sh, name)...)
+				ms.removed = append(ms.removed, name)
+			}
+		}
+	}
+	s.reschedMembers(sortedTitles(members), now)
+	s.sched.Unlock()
+
+	if ms.changed() {
+		s.schedule()
+	}
+	if ms.changed() || (len(ms.conflicts) > 0) {
+		// the file and in-memory data should be equal
+		if err := s.flush(ctx); err != nil {
+			return nil, fmt.Errorf("save merged users: %w", err)
+		}
+	}
+	return ms, nil
+}
+
+// dropUser removes the user and its items, it returns members of the removed group chat's events.
+// The caller should hold shard and sched locks.
+func (s *Storage) dropUser(sh *shard, name string) []string {
+	s.items.remove(sh.userIdx[name])
+	delete(sh.users, name)
+	delete(sh.removed, name)
+	delete(sh.userIdx, name)
+	return s.indexGroup(name, nil)
+}
+
+// putUser adds the user and its items, it returns members of the added group chat's events.
+// The caller should hold shard and sched locks.
+func (s *Storage) putUser(sh *shard, u *user, created time.Time) []string {
+	if !u.deleted.IsZero() {
+		sh.removed[u.name] = u
+		return nil
+	}
+	members := s.indexGroup(u.name, u)
+	items := u.init(s.eventsOf(u.name))
+	for _, item := range items {
+		item.created = created
+	}
+	sh.users[u.name] = u
+	sh.userIdx[u.name] = items
+	s.items.add(items)
+	return members
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// deliveryWindow is event's daily hours of notifications' sending, offsets are from the day beginning.
+type deliveryWindow struct {
+	from, to time.Duration
+}
+
+// parseWindow parses a delivery window like "9h-18h" or "9h30m-18h".
+func parseWindow(value string) (*deliveryWindow, error) {
+	const dayHours = time.Hour * 24
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid window %q, expected \"from-to\"", value)
+	}
+	from, err := time.ParseDuration(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("parse window from: %w", err)
+	}
+	to, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("parse window to: %w", err)
+	}
+	if (from < 0) || (to > dayHours) || (from >= to) {
+		return nil, fmt.Errorf("invalid window %v-%v", from, to)
+	}
+	return &deliveryWindow{from: from, to: to}, nil
+}
+
+// validateWindow parses event's delivery window.
+func (e *Event) validateWindow() error {
+	if e.Window == "" {
+		return nil
+	}
+	w, err := parseWindow(e.Window)
+	if err != nil {
+		return fmt.Errorf("window of event=%s: %w", e.Title, err)
+	}
+	e.window = w
+	return nil
+}
+
+// due returns the sending time of the notification computed for ts time.
+// The time out of event's delivery window is shifted to the nearest window start in event's time zone.
+func (e *Event) due(ts time.Time) time.Time {
+	if e.window == nil {
+		return ts
+	}
+	local := ts.In(e.zone)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, e.zone)
+	if from := day.Add(e.window.from); local.Before(from) {
+		return from
+	}
+	if local.Before(day.Add(e.window.to)) {
+		return ts
+	}
+	return day.AddDate(0, 0, 1).Add(e.window.from)
+}
+
+// due returns the item's sending time.
+func (ue *userEvent) due() time.Time {
+	return ue.event.due(ue.timestamp)
+}
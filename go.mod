@@ -1,16 +1,21 @@
 module github.com/z0rr0/mtbot
 
-go 1.17
+go 1.18
 
 require (
 	github.com/BurntSushi/toml v0.4.1
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/mail-ru-im/bot-golang v0.0.0-20210907151920-f8926c7e295d
+	go.etcd.io/bbolt v1.3.6
 )
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/hako/durafmt v0.0.0-20190612201238-650ed9f29a84 // indirect
 	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
 	github.com/mailru/easyjson v0.0.0-20190626092158-b2ccc519800e // indirect
 	github.com/sirupsen/logrus v1.4.2 // indirect
-	golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
+	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 )
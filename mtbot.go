@@ -1,15 +1,22 @@
-package main
+// Package mtbot is MyTeam event notification bot's engine.
+// It can be embedded by other programs, for example:
+//
+//	c, err := config.New("config.toml")
+//	...
+//	e, err := mtbot.New(c, mtbot.WithDeliveryHandler(func(d db.Delivery) { ... }))
+//	...
+//	err = e.Run(ctx)
+package mtbot
 
 import (
 	"context"
-	"flag"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"runtime"
-	"runtime/debug"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	botgolang "github.com/mail-ru-im/bot-golang"
 
@@ -18,99 +25,265 @@ import (
 	"github.com/z0rr0/mtbot/db"
 )
 
-const (
-	// Name is a program name.
-	Name = "MtBot"
-	// Config is default configuration file name.
-	Config = "config.toml"
-)
+// Name is a program name.
+const Name = "MtBot"
+
+// ErrRun is an error when the engine is run twice.
+var ErrRun = errors.New("engine is already run")
+
+// allowedBotEvents are bot events for handling
+var allowedBotEvents = map[botgolang.EventType]bool{
+	botgolang.NEW_MESSAGE:    true,
+	botgolang.EDITED_MESSAGE: true,
+}
 
-var (
-	// Version is git version
-	Version = ""
-	// Revision is revision number
-	Revision = ""
-	// BuildDate is build date
-	BuildDate = ""
-	// GoVersion is runtime Go language version
-	GoVersion = runtime.Version()
-	// allowedBotEvents are bot events for handling
-	allowedBotEvents = map[botgolang.EventType]bool{
-		botgolang.NEW_MESSAGE:    true,
-		botgolang.EDITED_MESSAGE: true,
+// Option is an engine's customization.
+type Option func(e *Engine)
+
+// WithEventsFetcher sets a custom source of remote events polled every period,
+// it is used instead of the configuration's events URL, Google Calendar or CalDAV collection.
+func WithEventsFetcher(f db.EventsFetcher, period time.Duration) Option {
+	return func(e *Engine) {
+		e.fetcher, e.fetchPeriod = f, period
 	}
-)
+}
 
-func main() {
-	defer func() {
-		if r := recover(); r != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "abnormal termination [%v]: %v\n%v", Version, r, string(debug.Stack()))
+// WithDeliveryHandler sets a handler of every notification's result.
+// It is called by notification workers, so it should not block.
+func WithDeliveryHandler(h func(d db.Delivery)) Option {
+	return func(e *Engine) {
+		e.onDelivery = h
+	}
+}
+
+// Engine is the bot's notifications scheduler and users' commands handler.
+type Engine struct {
+	sync.Mutex
+	cfg         *config.Config
+	storage     *db.Storage
+	fetcher     db.EventsFetcher
+	fetchPeriod time.Duration
+	onDelivery  func(d db.Delivery)
+	run         bool
+}
+
+// New returns a new engine with users' storage opened by the configuration.
+func New(c *config.Config, opts ...Option) (*Engine, error) {
+	s, err := db.New(c.M.Database, c.Events, c.L, c.A)
+	if err != nil {
+		return nil, fmt.Errorf("new engine: %w", err)
+	}
+	if c.M.QueuesFile != "" {
+		if err = s.OpenQueues(c.M.QueuesFile); err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("new engine: %w", err)
 		}
-	}()
-	version := flag.Bool("version", false, "show version")
-	cfg := flag.String("config", Config, "configuration file")
-	flag.Parse()
+	}
+	e := &Engine{cfg: c, storage: s}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.fetcher == nil {
+		switch {
+		case c.M.GoogleCalendar != "":
+			gs, err := db.NewGoogleSource(c.M.GoogleCredentials, c.M.GoogleCalendar)
+			if err != nil {
+				_ = s.Close()
+				return nil, fmt.Errorf("new engine: %w", err)
+			}
+			e.fetcher = gs
+		case c.M.CalDAVURL != "":
+			e.fetcher = db.NewCalDAVSource(c.M.CalDAVURL, c.M.CalDAVUser, c.M.CalDAVPassword)
+		}
+		e.fetchPeriod = time.Duration(c.M.EventsPeriod) * time.Second
+	}
+	return e, nil
+}
 
-	if *version {
-		fmt.Printf("%v: %v %v %v %v\n", Name, Version, Revision, GoVersion, BuildDate)
-		flag.PrintDefaults()
-		return
+// Storage returns engine's users and events storage.
+func (e *Engine) Storage() *db.Storage {
+	return e.storage
+}
+
+// Reload reads users from the configured database and events from the configuration file again.
+// Remote events are kept, subscribers get messages about changed events if it is enabled.
+func (e *Engine) Reload(ctx context.Context) error {
+	if err := e.storage.Reload(ctx, e.cfg.M.Database); err != nil {
+		return err
+	}
+	return e.ReloadEvents()
+}
+
+// ReloadEvents reads events from the configuration file and rebuilds all users' notifications.
+func (e *Engine) ReloadEvents() error {
+	c := e.cfg
+	if c.FileName == "" {
+		// the configuration was not read from a file
+		return nil
+	}
+	events, err := config.ReadEvents(c.FileName)
+	if err != nil {
+		return fmt.Errorf("reload events: %w", err)
 	}
-	c, err := config.New(*cfg)
+	changes, err := e.storage.SetStaticEvents(events)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("reload events: %w", err)
 	}
-	for i, e := range c.Events {
-		c.Debug.Printf("e [%d] = %v", i, e)
+	c.Info.Printf("reloaded %d events, changed %d", len(events), len(changes))
+	if c.M.EventChanges && (len(changes) > 0) {
+		n := e.storage.NotifyChanges(c.B, changes, c.Logger)
+		c.Info.Printf("sent %d events' changes messages", n)
 	}
+	return nil
+}
 
-	c.Debug.Println("build new db")
-	s, err := db.New(c.M.Database, c.Events, c.L)
+// Dump writes the scheduler's state snapshot to fileName as JSON.
+func (e *Engine) Dump(fileName string) error {
+	data, err := json.MarshalIndent(e.storage.Snapshot(), "", "  ")
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	if err = os.WriteFile(fileName, data, 0600); err != nil {
+		return fmt.Errorf("write state: %w", err)
 	}
+	return nil
+}
+
+// Run starts notifications and users' commands handling and blocks until ctx is done.
+// The storage is closed after all workers stopping, so the engine can be run only once.
+func (e *Engine) Run(ctx context.Context) error {
+	e.Lock()
+	if e.run {
+		e.Unlock()
+		return ErrRun
+	}
+	e.run = true
+	e.Unlock()
+
+	c, s := e.cfg, e.storage
 	s.Show(c.Debug)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	stDB := db.Settings{TickPeriod: c.Period, Workers: c.W.Notify, Logger: c.Logger, Bot: c.B}
+	stDB := db.Settings{
+		TickPeriod:  c.Period,
+		ErrorPeriod: c.ErrorLog,
+		MaxSkew:     time.Duration(c.M.MaxSkew) * time.Second,
+		SkewPeriod:  time.Duration(c.M.SkewPeriod) * time.Second,
+		Smear:       time.Duration(c.M.SmearWindow) * time.Second,
+		Jitter:      time.Duration(c.M.Jitter) * time.Second,
+		Timer:       c.M.Timer,
+		Workers:     c.W.Notify,
+		Logger:      c.Logger,
+		Bot:         c.B,
+		OnDelivery:  e.onDelivery,
+	}
+	if c.M.MaxSkew > 0 {
+		stDB.TimeURL = c.M.BotURL
+	}
 	wgDB := db.Serve(ctx, s, stDB)
+	if (c.M.EventsURL != "") || (e.fetcher != nil) {
+		es := db.EventsSource{
+			Logger:  c.Logger,
+			URL:     c.M.EventsURL,
+			Fetcher: e.fetcher,
+			Period:  time.Duration(c.M.EventsPeriod) * time.Second,
+			// static events already have common holidays
+			Holidays: c.M.Holidays,
+		}
+		if c.M.EventChanges {
+			es.Bot = c.B
+		}
+		if e.fetcher != nil {
+			es.Period = e.fetchPeriod
+		}
+		db.WatchEvents(ctx, s, es)
+	}
+
+	if c.M.WatchUsers {
+		if err := db.WatchUsers(ctx, s, c.Logger); err != nil {
+			c.Error.Printf("failed start users watching: %v", err)
+		}
+	}
+
+	if c.M.ProbePeriod > 0 {
+		rp := db.ReachabilityProbe{
+			Logger: c.Logger,
+			Bot:    c.B,
+			Period: time.Duration(c.M.ProbePeriod) * 24 * time.Hour,
+		}
+		db.ProbeUsers(ctx, s, rp)
+	}
+	if c.K.Dir != "" {
+		db.RunBackups(ctx, s, c.K, c.Logger)
+	}
+	if c.M.Metrics != "" {
+		if err := db.ServeMetrics(ctx, s, c.M.Metrics, c.Logger); err != nil {
+			c.Error.Printf("failed start metrics server: %v", err)
+		}
+	}
+	if c.M.ControlSocket != "" {
+		if err := e.serveControl(ctx, c.M.ControlSocket); err != nil {
+			c.Error.Printf("failed start control socket: %v", err)
+		}
+	}
 
 	commands := make(chan cmd.Package)
-	stCmd := cmd.Settings{Storage: s, Bot: c.B, Workers: c.W.User, Logger: c.Logger}
-	wgCmd := cmd.Serve(stCmd, commands)
+	stCmd := cmd.Settings{
+		Storage:  s,
+		Bot:      c.B,
+		Workers:  c.W.User,
+		DedupTTL: time.Duration(c.M.DedupTTL) * time.Second,
+		Presets:  c.Presets,
+		Logger:   c.Logger,
+	}
+	wgCmd := cmd.Serve(ctx, stCmd, commands)
 
-	go serve(ctx, cancel, c, commands)
+	e.consume(ctx, commands)
+	cancel()
 
 	wgDB.Wait()  // wait periodic notifications stopping
 	wgCmd.Wait() // wait user command handling stopping
-	if err = s.Close(); err != nil {
-		c.Error.Printf("failed close storage: %v", err)
+	if err := s.Close(); err != nil {
+		return fmt.Errorf("close storage: %w", err)
 	}
 	c.Info.Printf("stopped %s", Name)
+	return nil
 }
 
-func serve(ctx context.Context, cancel context.CancelFunc, c *config.Config, commands chan<- cmd.Package) {
+// consume passes bot's events to commands channel until ctx is done, then closes it.
+func (e *Engine) consume(ctx context.Context, commands chan<- cmd.Package) {
 	var (
-		sigint = make(chan os.Signal, 1)
-		events = c.B.GetUpdatesChannel(ctx)
+		c        = e.cfg
+		events   = c.B.GetUpdatesChannel(ctx)
+		botNames []string
 	)
-	defer func() {
-		close(sigint)
-		close(commands)
-		cancel()
-	}()
-	signal.Notify(sigint, os.Interrupt, os.Signal(syscall.SIGTERM), os.Signal(syscall.SIGQUIT))
+	defer close(commands)
+	if c.B.Info != nil {
+		botNames = []string{c.B.Info.ID, c.B.Info.Nick}
+	}
 	for {
 		select {
-		case s := <-sigint:
-			c.Info.Printf("taken signal %v", s)
+		case <-ctx.Done():
 			return
-		case e := <-events:
-			if allowedBotEvents[e.Type] {
-				message := e.Payload.Message()
+		case ev := <-events:
+			if ev.Type == botgolang.CALLBACK_QUERY {
+				query := ev.Payload.CallbackQuery()
+				if err := query.Send(); err != nil {
+					c.Error.Printf("failed answer callback query=%s: %v", query.QueryID, err)
+				}
+				message := ev.Payload.CallbackMessage()
+				c.Debug.Printf("gotten callback from %s: %s", message.Chat.ID, db.LogContent(query.CallbackData))
+				commands <- cmd.Package{ChatID: message.Chat.ID, MsgID: query.QueryID, Text: query.CallbackData, Callback: true}
+			} else if allowedBotEvents[ev.Type] {
+				message := ev.Payload.Message()
 				if strings.HasPrefix(message.Text, "/") {
-					c.Debug.Printf("gotten event type=%v from %s", e.Type, message.Chat.ID)
-					commands <- cmd.Package{ChatID: message.Chat.ID, Text: message.Text}
+					c.Debug.Printf("gotten event type=%v from %s", ev.Type, message.Chat.ID)
+					commands <- cmd.Package{ChatID: message.Chat.ID, UserID: ev.Payload.From.ID, MsgID: message.ID, Text: message.Text}
+				} else if query, ok := db.ParseMention(message.Text, botNames...); ok {
+					// the bot's mention is a quick event search
+					c.Debug.Printf("gotten mention type=%v from %s", ev.Type, message.Chat.ID)
+					commands <- cmd.Package{ChatID: message.Chat.ID, UserID: ev.Payload.From.ID, MsgID: message.ID, Text: "/find " + query}
 				}
 			}
 		}